@@ -0,0 +1,340 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// option describes a command line flag. The usage text, completion scripts
+// and short aliases are all generated from options so they can't drift from
+// the flags defineFlags registers
+type option struct {
+	long  string
+	short string
+	// arg is the kind of value the flag takes: file, host, port, duration,
+	// number or value. It is empty for boolean flags
+	arg   string
+	usage string
+}
+
+var options = []option{
+	{long: "aliases", arg: "file", usage: "redirect short paths to others, or serve them in their place, as listed in FILE"},
+	{long: "allow-force-list", usage: "let ?list show the listing of a directory even with --no-list"},
+	{long: "auto-index", arg: "value", usage: "with --no-list, answer directories without an index.html with their listing (list) or 403 (forbidden) rather than 404"},
+	{long: "block-dotfiles", usage: "refuse requests for paths with a part starting with a dot, such as /.env or /.git/config, with 403"},
+	{long: "bot-ua", arg: "value", usage: "with --no-list-bots, the comma separated User-Agents of crawlers in place of the built in list, may be repeated"},
+	{long: "cache-dir", arg: "dir", usage: "keep --precompress copies under DIR (default: the user cache directory)"},
+	{long: "canonical-paths", usage: "redirect paths with repeated slashes or . parts, such as /docs//./guide, or the wrong trailing slash, to their clean form with 301"},
+	{long: "cert", arg: "file", usage: "TLS certificate for --listen ADDRESS,tls, in PEM format"},
+	{long: "cgi", arg: "value", usage: "run scripts under /PREFIX/ or with an .EXTENSION as CGI, a comma separated list, may be repeated"},
+	{long: "cgi-timeout", arg: "duration", usage: "kill CGI scripts that run for longer than this duration, 0 for no limit (default: 30s)"},
+	{long: "check", usage: "validate the configuration, print it and exit"},
+	{long: "config", arg: "file", usage: "read options from a file (default: ./serve.toml or ./.serve.yaml if present)"},
+	{long: "copy", usage: "copy the URL to the clipboard, the network address if there is one"},
+	{long: "cors", arg: "value", usage: "allow cross-origin requests from a comma separated list of origins, or *, may be repeated"},
+	{long: "cors-credentials", usage: "allow --cors origins to send cookies and authorization"},
+	{long: "daemon", usage: "run in the background, needs --log-file or --syslog"},
+	{long: "default-lang", arg: "tag", usage: "language of the --lang-negotiate variant served when none the client accepts exists, e.g. en"},
+	{long: "default-type", arg: "type", usage: "Content-Type of files without an extension, instead of sniffing it, e.g. text/plain"},
+	{long: "delay", arg: "duration", usage: "wait this long before answering each request, or a random time in a range such as 100ms-2s"},
+	{long: "digest-header", usage: "send the SHA-256 of files in Repr-Digest and Digest headers, so clients can check downloads"},
+	{long: "error-page", arg: "value", usage: "serve FILE for errors with STATUS, as STATUS=FILE, may be repeated"},
+	{long: "exec", arg: "command", usage: "run COMMAND once serving, with the URL in $SERVE_URL, then exit with its exit code"},
+	{long: "expvar", arg: "port", usage: "serve counters on localhost:PORT/debug/vars"},
+	{long: "fail-path", arg: "pattern", usage: "only inject --fail-rate faults into paths matching PATTERN, such as /api/*, may be repeated"},
+	{long: "fail-rate", arg: "fraction", usage: "for testing, answer this fraction of requests with --fail-status, e.g. 0.1"},
+	{long: "fail-status", arg: "status", usage: "status of the faults injected by --fail-rate (default: 500)"},
+	{long: "favicon", arg: "file", usage: "icon to serve for /favicon.ico if none is found"},
+	{long: "follow", usage: "allow ?follow on a file to stream what is appended to it"},
+	{long: "fs-timeout", arg: "duration", usage: "respond 504 if a file or directory takes longer than this duration to read, e.g. 10s"},
+	{long: "group-by", arg: "value", usage: "group listings by type: directories, then images, media, code, documents, archives and other files"},
+	{long: "headers-file", arg: "file", usage: "send the headers of a Netlify style _headers FILE for the paths matching its patterns, alongside any _headers at the root of the directories"},
+	{long: "hits", usage: "count file downloads, reported at /_hits"},
+	{long: "host", arg: "host", usage: "bind to host (default: localhost)"},
+	{long: "http2", usage: "accept HTTP/2 without TLS (h2c) from clients that support it, alongside HTTP/1.1"},
+	{long: "index", short: "i", arg: "file", usage: "serve all paths to index if file not found, the first that exists of a comma separated list of files"},
+	{long: "index-priority", arg: "order", usage: "what a directory that can be listed gets: its index.html (dir), the --index page (global) or its listing (list, the default)"},
+	{long: "key", arg: "file", usage: "TLS private key for --cert, in PEM format"},
+	{long: "lan", usage: "bind to this machine's address on the local network, to be reached from other devices"},
+	{long: "lang-negotiate", usage: "serve index.LANG.html and other variants by the client's Accept-Language"},
+	{long: "large-threshold", arg: "size", usage: "size over which a file counts against --max-large-transfers, e.g. 1GiB (default: 100MiB)"},
+	{long: "large-transfer-wait", arg: "duration", usage: "wait this long for a --max-large-transfers slot before answering 503, e.g. 5s"},
+	{long: "listen", arg: "value", usage: "serve on [HOST]:PORT instead of --host and --port, with ,tls for HTTPS, may be repeated"},
+	{long: "live-reload", usage: "reload pages in the browser when files change"},
+	{long: "log-file", arg: "file", usage: "append the log to a file instead of stderr"},
+	{long: "manifest", usage: "serve the SHA-256 and size of every file at /__manifest.json"},
+	{long: "max-entries", arg: "number", usage: "list at most this many entries of a directory, 0 for no limit"},
+	{long: "max-large-transfers", arg: "number", usage: "send at most this many files over --large-threshold at once, answering others with 503"},
+	{long: "merge-listings", usage: "list a directory found in several DIRs once, showing which DIR each entry is from"},
+	{long: "mirror-cache", arg: "dir", usage: "keep the files fetched from a URL given as a DIR under this directory, revalidating them each time"},
+	{long: "mirror-max-size", arg: "size", usage: "largest file --mirror-cache keeps, larger ones are passed through (default: 32MiB)"},
+	{long: "mirror-stale", usage: "serve the --mirror-cache copy of a file when its mirror can't be reached"},
+	{long: "mirror-timeout", arg: "duration", usage: "how long a mirror has to start answering, 0 for no limit (default: 10s)"},
+	{long: "mock", arg: "dir", usage: "answer requests under --mock-prefix with the JSON fixtures in DIR, such as users/[id].GET.json"},
+	{long: "mock-fallthrough", usage: "serve files for requests under --mock-prefix that have no fixture, instead of 404"},
+	{long: "mock-prefix", arg: "prefix", usage: "URL prefix answered by --mock (default: /api)"},
+	{long: "mount", arg: "value", usage: "serve DIR under /PREFIX, as /PREFIX=DIR with optional ,auth=USER:PASS ,nolist=true or ,cache=DURATION, may be repeated"},
+	{long: "mtime", arg: "value", usage: "Last-Modified sent for files: real, build=TIME for a fixed RFC 3339 or unix time, or none (default: real)"},
+	{long: "no-favicon", usage: "disable the built in favicon"},
+	{long: "no-index", usage: "don't serve index.html for directory requests"},
+	{long: "no-keepalive", usage: "close the connection after every response"},
+	{long: "no-keynav", usage: "disable keyboard navigation of listings"},
+	{long: "no-list", usage: "disable directory listings"},
+	{long: "no-list-bots", usage: "disable directory listings for search engines and other crawlers, which still get files"},
+	{long: "no-miss-cache", usage: "with --index, look for every path in each directory rather than remembering misses for a few seconds"},
+	{long: "no-sniff", usage: "serve unknown file types as application/octet-stream"},
+	{long: "noindex-listings", usage: "ask search engines not to index directory listings with X-Robots-Tag: noindex, nofollow, --noindex-listings=false to let them (default: true)"},
+	{long: "otel", usage: "export traces to OTEL_EXPORTER_OTLP_ENDPOINT"},
+	{long: "pidfile", arg: "file", usage: "write the process id to a file, removed on shutdown"},
+	{long: "port", short: "p", arg: "port", usage: "bind to port (default: 8080)"},
+	{long: "precompress", usage: "serve gzipped copies of text files, compressed once and cached on disk"},
+	{long: "precompress-eager", usage: "compress files for --precompress at startup, rather than when first requested"},
+	{long: "proxy", arg: "value", usage: "forward requests under /PREFIX to a server, as /PREFIX=URL or /PREFIX=>URL to strip the prefix, may be repeated"},
+	{long: "qr", usage: "with share, also print the link as a QR code"},
+	{long: "read-header-timeout", arg: "duration", usage: "close connections that take longer than this to send their request headers, 0 to disable (default: 10s)"},
+	{long: "recent-requests", arg: "number", usage: "number of requests listed at /_requests, 0 to disable (default: 500)"},
+	{long: "redirect", arg: "value", usage: "redirect paths matching FROM to TO, as FROM=TO[:STATUS] where * carries over and STATUS is 302 by default, may be repeated"},
+	{long: "redirect-file", arg: "file", usage: "read --redirect rules from FILE, one a line"},
+	{long: "redirects-file", arg: "file", usage: "redirect or rewrite the paths in a Netlify style _redirects FILE, alongside any _redirects at the root of the directories"},
+	{long: "rewrite", arg: "value", usage: "serve paths matching the regular expression PATTERN as REPLACEMENT, as PATTERN=REPLACEMENT with $1 for groups, may be repeated"},
+	{long: "reuse-port", usage: "let other processes listen on the same port, the kernel spreads connections between them (Linux, BSD and macOS)"},
+	{long: "share-secret", arg: "secret", usage: "sign links from /_sign?path=PATH&ttl=DURATION with SECRET, they skip --mount auth until they expire"},
+	{long: "slow-threshold", arg: "duration", usage: "warn about requests that take longer than this duration, e.g. 5s"},
+	{long: "slow-ttfb", usage: "only warn if the first byte was slow, so large downloads aren't reported"},
+	{long: "ssi", usage: "process server-side includes in .shtml files"},
+	{long: "ssi-ext", arg: "value", usage: "comma separated extensions of the files --ssi processes (default: .shtml)"},
+	{long: "stats-file", arg: "file", usage: "save download counts to a file, implies --hits"},
+	{long: "stop", usage: "stop the serve running in the background, by --pidfile"},
+	{long: "strict", usage: "fail instead of warning if a directory can't be read"},
+	{long: "syslog", usage: "send the log to the local syslog instead of stderr"},
+	{long: "syslog-addr", arg: "value", usage: "send the log to a remote syslog at [udp://|tcp://]HOST:PORT, implies --syslog"},
+	{long: "tcp-keepalive", arg: "duration", usage: "TCP keep-alive period of connections, negative to disable (default: 15s)"},
+	{long: "throttle", arg: "rate", usage: "for testing, send file bodies no faster than RATE, e.g. 256kbps, 10Mbps or 500KB/s"},
+	{long: "title", arg: "value", usage: "listing page title prefix (default: Index of)"},
+	{long: "track-404s", usage: "report requests that were not found at /_404s, and on shutdown with --verbose"},
+	{long: "type", arg: "value", usage: "serve files named PATTERN as TYPE, as PATTERN=TYPE such as Dockerfile=text/plain or *.lock=application/json, may be repeated"},
+	{long: "ttl", arg: "duration", usage: "with share, stop sharing after this duration even if it wasn't downloaded"},
+	{long: "trust-proxy", usage: "use the client address and scheme forwarded by proxies, from loopback or --trust-proxy=CIDR,..."},
+	{long: "verbose", short: "v", usage: "display requests and responses"},
+	{long: "version", short: "V", usage: "print version information and exit"},
+	{long: "watch-config", usage: "reload the configuration when the config file changes, as on SIGHUP"},
+	{long: "zip", usage: "with share, allow sharing a directory as a zip archive"},
+}
+
+// usageWidth is the column the usage text is wrapped at
+const usageWidth = 80
+
+// lookupOption returns the option with the given long name, or short name if
+// short is set
+func lookupOption(name string, short bool) *option {
+	for i := range options {
+		if !short && options[i].long == name || short && options[i].short == name {
+			return &options[i]
+		}
+	}
+	return nil
+}
+
+// checkOptions panics if the flags registered don't match the options table
+func checkOptions(flags *flag.FlagSet) {
+	registered := 0
+	flags.VisitAll(func(f *flag.Flag) {
+		registered++
+		if lookupOption(f.Name, false) == nil {
+			panic("flag --" + f.Name + " is missing from options")
+		}
+	})
+	if registered != len(options) {
+		panic("options lists flags that aren't registered")
+	}
+}
+
+// optionsUsage formats the OPTIONS section of the usage text, wrapping each
+// description to usageWidth
+func optionsUsage() string {
+	name := func(opt option) string {
+		if opt.short != "" {
+			return "   -" + opt.short + ", --" + opt.long
+		}
+		return "       --" + opt.long
+	}
+	indent := 0
+	for _, opt := range options {
+		if n := len(name(opt)) + 2; n > indent {
+			indent = n
+		}
+	}
+	indent += len("--  ")
+
+	var b strings.Builder
+	for _, opt := range options {
+		line := name(opt)
+		line += strings.Repeat(" ", indent-len(line)-len("--  ")) + "--  "
+		start := true
+		for _, word := range strings.Fields(opt.usage) {
+			if !start && len(line)+1+len(word) > usageWidth {
+				b.WriteString(line + "\n")
+				line = strings.Repeat(" ", indent-1)
+			}
+			if start {
+				line += word
+			} else {
+				line += " " + word
+			}
+			start = false
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// parseArgs sets the flags in args, returning the remaining directories.
+// Flags and directories may be mixed, and an argument of -- ends the flags so
+// that directories starting with - can be served. It accepts:
+//
+//	--name value, --name=value, --bool, --bool=false
+//	-n value, -nvalue, -n=value and groups of boolean shorts such as -vV
+//
+// A single dash followed by a long name, e.g. -port 80, is also accepted for
+// compatibility with the standard flag package
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	dirs := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(dirs, args[i+1:]...), nil
+		case len(arg) < 2 || arg[0] != '-':
+			dirs = append(dirs, arg)
+			continue
+		}
+
+		// next consumes the following argument as a flag's value
+		next := func(name string) (string, error) {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("flag %s needs a value", name)
+			}
+			i++
+			return args[i], nil
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "--") || len(name) > 1 && flags.Lookup(name) != nil {
+			if err := setLong(flags, name, value, hasValue, next); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		group := arg[1:]
+		for j := 0; j < len(group); j++ {
+			short := group[j : j+1]
+			if short == "h" {
+				return nil, flag.ErrHelp
+			}
+			opt := lookupOption(short, true)
+			if opt == nil {
+				return nil, unknownFlag("-" + short)
+			}
+			if isBoolFlag(flags.Lookup(opt.long)) {
+				if err := setValue(flags, "-"+short, opt.long, "true"); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			value := strings.TrimPrefix(group[j+1:], "=")
+			if j+1 == len(group) {
+				var err error
+				if value, err = next("-" + short); err != nil {
+					return nil, err
+				}
+			}
+			if err := setValue(flags, "-"+short, opt.long, value); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return dirs, nil
+}
+
+func setLong(flags *flag.FlagSet, name, value string, hasValue bool, next func(string) (string, error)) error {
+	if name == "help" || name == "h" {
+		return flag.ErrHelp
+	}
+	f := flags.Lookup(name)
+	if f == nil {
+		return unknownFlag("--" + name)
+	}
+	if !hasValue {
+		if isBoolFlag(f) {
+			value = "true"
+		} else {
+			var err error
+			if value, err = next("--" + name); err != nil {
+				return err
+			}
+		}
+	}
+	return setValue(flags, "--"+name, name, value)
+}
+
+func setValue(flags *flag.FlagSet, given, name, value string) error {
+	if err := flags.Set(name, value); err != nil {
+		return fmt.Errorf("invalid value %q for flag %s: %v", value, given, err)
+	}
+	return nil
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// unknownFlag returns an error for an unrecognised flag, suggesting the
+// closest long flag if there is one that's likely to be a typo. A name of 2
+// characters or fewer is within 2 edits of every short long flag, so nothing
+// is suggested for it
+func unknownFlag(given string) error {
+	name := strings.TrimLeft(given, "-")
+	candidates := []string{}
+	for _, opt := range options {
+		if len(name) <= 2 {
+			break
+		}
+		if distance(name, opt.long) <= 2 || strings.HasPrefix(opt.long, name) {
+			candidates = append(candidates, opt.long)
+		}
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("unknown flag %s", given)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return distance(name, candidates[i]) < distance(name, candidates[j])
+	})
+	return fmt.Errorf("unknown flag %s (did you mean --%s?)", given, candidates[0])
+}
+
+// distance is the Levenshtein edit distance between a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Alexendoo/serve/server"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		dirs    []string
+		port    string
+		verbose bool
+		noList  bool
+		index   string
+	}{
+		{args: []string{"site"}, dirs: []string{"site"}, port: "8080"},
+		{args: []string{"--port", "9000"}, port: "9000"},
+		{args: []string{"--port=9000"}, port: "9000"},
+		{args: []string{"-p", "9000"}, port: "9000"},
+		{args: []string{"-p9000"}, port: "9000"},
+		{args: []string{"-p=9000"}, port: "9000"},
+		// the standard flag package's single dash long names
+		{args: []string{"-port", "9000"}, port: "9000"},
+		{args: []string{"--verbose", "--no-list"}, port: "8080", verbose: true, noList: true},
+		{args: []string{"--verbose=false"}, port: "8080"},
+		// boolean shorts can be grouped, and end with one taking a value
+		{args: []string{"-vp", "9000"}, port: "9000", verbose: true},
+		{args: []string{"-vp9000"}, port: "9000", verbose: true},
+		{args: []string{"-vi", "app.html", "dist"}, dirs: []string{"dist"}, port: "8080", verbose: true, index: "app.html"},
+		// flags and directories can be mixed
+		{args: []string{"a", "-v", "b", "--port", "1"}, dirs: []string{"a", "b"}, port: "1", verbose: true},
+		// -- ends the flags, so directories can start with -
+		{args: []string{"-v", "--", "-site", "--port"}, dirs: []string{"-site", "--port"}, port: "8080", verbose: true},
+		{args: []string{"-"}, dirs: []string{"-"}, port: "8080"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			conf := newConfig()
+			dirs, err := parseArgs(defineFlags(conf, &cliFlags{}), tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(dirs, tt.dirs) {
+				t.Errorf("dirs = %q, want %q", dirs, tt.dirs)
+			}
+			if conf.Port != tt.port || conf.Verbose != tt.verbose || conf.NoList != tt.noList || conf.Index != tt.index {
+				t.Errorf("port %q, verbose %v, no-list %v, index %q; want %q, %v, %v, %q",
+					conf.Port, conf.Verbose, conf.NoList, conf.Index, tt.port, tt.verbose, tt.noList, tt.index)
+			}
+		})
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--port"}, "flag --port needs a value"},
+		{[]string{"-p"}, "flag -p needs a value"},
+		{[]string{"-vp"}, "flag -p needs a value"},
+		{[]string{"--verbos"}, "unknown flag --verbos (did you mean --verbose?)"},
+		{[]string{"--no-lis"}, "unknown flag --no-lis (did you mean --no-list?)"},
+		{[]string{"--zzzzzzzz"}, "unknown flag --zzzzzzzz"},
+		{[]string{"-x"}, "unknown flag -x"},
+		{[]string{"--qq"}, "unknown flag --qq"},
+		{[]string{"--prot"}, "unknown flag --prot (did you mean --port?)"},
+		{[]string{"--qrr"}, "unknown flag --qrr (did you mean --qr?)"},
+		{[]string{"--verbose=maybe"}, `invalid value "maybe" for flag --verbose: parse error`},
+		{[]string{"--recent-requests", "lots"}, `invalid value "lots" for flag --recent-requests: parse error`},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			_, err := parseArgs(defineFlags(newConfig(), &cliFlags{}), tt.args)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	for _, args := range [][]string{{"-h"}, {"--help"}, {"-vh"}} {
+		if _, err := parseArgs(defineFlags(newConfig(), &cliFlags{}), args); err != flag.ErrHelp {
+			t.Errorf("parseArgs(%q) = %v, want flag.ErrHelp", args, err)
+		}
+	}
+}
+
+func TestVersionFlag(t *testing.T) {
+	for _, args := range [][]string{{"--version"}, {"-V"}, {"-vV"}, {"--version", "--port", "not a port"}} {
+		if _, err := getFlags(args); err != errVersion {
+			t.Errorf("getFlags(%q) = %v, want errVersion", args, err)
+		}
+	}
+}
+
+func TestVersionInfo(t *testing.T) {
+	version, commit, date := server.Version, server.Commit, server.Date
+	t.Cleanup(func() { server.Version, server.Commit, server.Date = version, commit, date })
+
+	server.Version, server.Commit, server.Date = "v1.2.3", "0123456789abcdef0123", "2024-06-01T12:00:00Z"
+	want := fmt.Sprintf("serve v1.2.3\ncommit: 0123456789ab\nbuilt: 2024-06-01T12:00:00Z\ngo: %s\nplatform: %s/%s\n",
+		runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if got := versionInfo(); got != want {
+		t.Errorf("versionInfo() = %q, want %q", got, want)
+	}
+
+	server.Commit, server.Date = "", ""
+	if got := versionInfo(); !strings.Contains(got, "commit: unknown\nbuilt: unknown\n") {
+		t.Errorf("versionInfo() = %q, want an unknown commit and date", got)
+	}
+}
+
+// TestVersionLdflags builds serve as compile.sh does and checks that
+// --version prints what was set with -ldflags
+func TestVersionLdflags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the command")
+	}
+	bin := filepath.Join(t.TempDir(), "serve")
+	const pkg = "github.com/Alexendoo/serve/server"
+	build := exec.Command("go", "build", "-o", bin, "-ldflags",
+		"-X "+pkg+".Version=v9.8.7 -X "+pkg+".Commit=fedcba9876543210 -X "+pkg+".Date=2024-06-01T12:00:00Z", ".")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	out, err := exec.Command(bin, "--version").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"serve v9.8.7\n", "commit: fedcba987654\n", "built: 2024-06-01T12:00:00Z\n", "platform: " + runtime.GOOS + "/" + runtime.GOARCH + "\n"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("--version printed %q, want it to contain %q", out, want)
+		}
+	}
+}
@@ -2,13 +2,16 @@
 
 cd "$(dirname "$(readlink -f "$0")")"
 version="$(git describe --tags)"
+commit="$(git rev-parse HEAD)"
+date="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+pkg="github.com/Alexendoo/serve/server"
 
 while read GOOS GOARCH; do
   export GOOS GOARCH
   EXT=""
   [ "$GOOS" == "windows" ] && EXT=".exe"
   go build \
-    -ldflags "-X main.version=$version" \
+    -ldflags "-X $pkg.Version=$version -X $pkg.Commit=$commit -X $pkg.Date=$date" \
     -o "build/serve_${GOOS}_${GOARCH}${EXT}"
 done << EOF
   windows amd64
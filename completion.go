@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// printCompletion writes the completion script for shell to w
+func printCompletion(w io.Writer, shell string) error {
+	flags := options
+	switch shell {
+	case "bash":
+		bashCompletion(w, flags)
+	case "zsh":
+		zshCompletion(w, flags)
+	case "fish":
+		fishCompletion(w, flags)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+func bashCompletion(w io.Writer, flags []option) {
+	names := []string{}
+	byHint := map[string][]string{}
+	for _, f := range flags {
+		names = append(names, "--"+f.long)
+		if f.short != "" {
+			names = append(names, "-"+f.short)
+		}
+		if f.arg != "" {
+			byHint[f.arg] = append(byHint[f.arg], "--"+f.long)
+			if f.short != "" {
+				byHint[f.arg] = append(byHint[f.arg], "-"+f.short)
+			}
+		}
+	}
+	cases := func(hint string) string { return strings.Join(byHint[hint], "|") }
+	others := []string{}
+	for _, hint := range []string{"port", "duration", "number", "value"} {
+		others = append(others, byHint[hint]...)
+	}
+
+	fmt.Fprintf(w, `# bash completion for serve, load with: source <(serve completion bash)
+_serve() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local prev="${COMP_WORDS[COMP_CWORD-1]}"
+	case "$prev" in
+	%s)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	%s)
+		COMPREPLY=($(compgen -A hostname -- "$cur"))
+		return
+		;;
+	%s)
+		COMPREPLY=()
+		return
+		;;
+	esac
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -d -- "$cur"))
+}
+complete -o filenames -F _serve serve
+`, cases("file"), cases("host"), strings.Join(others, "|"), strings.Join(names, " "))
+}
+
+func zshCompletion(w io.Writer, flags []option) {
+	actions := map[string]string{
+		"file":     ":file:_files",
+		"host":     ":host:_hosts",
+		"port":     ":port: ",
+		"duration": ":duration (e.g. 5s): ",
+		"number":   ":number: ",
+		"value":    ":value: ",
+	}
+
+	fmt.Fprintln(w, "#compdef serve")
+	fmt.Fprintln(w, "# zsh completion for serve, load with: source <(serve completion zsh)")
+	fmt.Fprintln(w, "_serve() {")
+	fmt.Fprintln(w, "\t_arguments \\")
+	for _, f := range flags {
+		names := "--" + f.long
+		if f.short != "" {
+			names = fmt.Sprintf("(-%s --%s)'{-%s,--%s}'", f.short, f.long, f.short, f.long)
+		}
+		fmt.Fprintf(w, "\t\t'%s%s' \\\n", names, actions[f.arg])
+	}
+	fmt.Fprintln(w, "\t\t'*:directory:_files -/'")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "compdef _serve serve")
+}
+
+func fishCompletion(w io.Writer, flags []option) {
+	fmt.Fprintln(w, "# fish completion for serve, load with: serve completion fish | source")
+	fmt.Fprintln(w, "complete -c serve -a '(__fish_complete_directories)'")
+	for _, f := range flags {
+		line := "complete -c serve -l " + f.long
+		if f.short != "" {
+			line += " -s " + f.short
+		}
+		switch f.arg {
+		case "":
+		case "file":
+			line += " -r -F"
+		case "host":
+			line += " -x -a '(__fish_print_hostnames)'"
+		default:
+			line += " -x"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCompletionHasEveryFlag(t *testing.T) {
+	// the flags each script offers, as -- or - names
+	patterns := map[string]*regexp.Regexp{
+		"bash": regexp.MustCompile(`(?:^|[\s|"(])(--?[a-zA-Z0-9-]+)`),
+		"zsh":  regexp.MustCompile(`[{,'(](--?[a-zA-Z0-9-]+)`),
+		"fish": regexp.MustCompile(`-([ls]) ([a-zA-Z0-9-]+)`),
+	}
+	for shell, pattern := range patterns {
+		t.Run(shell, func(t *testing.T) {
+			var b strings.Builder
+			if err := printCompletion(&b, shell); err != nil {
+				t.Fatal(err)
+			}
+			offered := map[string]bool{}
+			for _, m := range pattern.FindAllStringSubmatch(b.String(), -1) {
+				switch {
+				case shell != "fish":
+					offered[m[1]] = true
+				case m[1] == "l":
+					offered["--"+m[2]] = true
+				default:
+					offered["-"+m[2]] = true
+				}
+			}
+			for _, opt := range options {
+				if !offered["--"+opt.long] {
+					t.Errorf("no --%s", opt.long)
+				}
+				if opt.short != "" && !offered["-"+opt.short] {
+					t.Errorf("no -%s for --%s", opt.short, opt.long)
+				}
+			}
+		})
+	}
+}
+
+func TestCompletionBashHints(t *testing.T) {
+	var b strings.Builder
+	if err := printCompletion(&b, "bash"); err != nil {
+		t.Fatal(err)
+	}
+	script := b.String()
+	// flags taking a file complete paths, --host completes host names, others
+	// complete nothing
+	for _, want := range []string{
+		"--config|",
+		"--host)\n\t\tCOMPREPLY=($(compgen -A hostname",
+		"--port|-p|",
+		"complete -o filenames -F _serve serve\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("bash script has no %q:\n%s", want, script)
+		}
+	}
+}
+
+func TestCompletionUnknownShell(t *testing.T) {
+	var b strings.Builder
+	if err := printCompletion(&b, "powershell"); err == nil || b.Len() != 0 {
+		t.Errorf("printCompletion(powershell) = %v, wrote %d bytes, want an error and nothing written", err, b.Len())
+	}
+}
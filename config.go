@@ -0,0 +1,411 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Alexendoo/serve/server"
+)
+
+// config holds the settings of the command, filled in from the environment,
+// the config file and then the command line flags by getFlags. It is not
+// modified once it has been stored in current
+type config struct {
+	server.Config
+	Daemon     bool
+	PidFile    string
+	LogFile    string
+	Syslog     bool
+	SyslogAddr string
+	Copy       bool
+	Exec       string
+	LAN        bool
+	ExpvarPort string
+	QR         bool
+	TTL        time.Duration
+	Zip        bool
+	// WatchConfig reloads the configuration when ConfigFile changes
+	WatchConfig bool
+	// ConfigFile is the config file that was read, empty if there was none
+	ConfigFile string
+}
+
+// current is the configuration being served, replaced as a whole when it is
+// reloaded
+var current atomic.Pointer[config]
+
+func newConfig() *config {
+	return &config{Config: server.DefaultConfig()}
+}
+
+// configFiles are looked for in the working directory if --config isn't
+// given
+var configFiles = []string{"serve.toml", ".serve.yaml"}
+
+// errCheck is returned by getFlags when --check is passed and the
+// configuration is valid, after printing it
+var errCheck = errors.New("check requested")
+
+// setting is a key and its values read from a config file
+type setting struct {
+	key    string
+	values []string
+	line   int
+}
+
+// syntaxError is a config file parse error on a given line
+type syntaxError struct {
+	line int
+	msg  string
+}
+
+func (e *syntaxError) Error() string { return fmt.Sprintf("%d: %s", e.line, e.msg) }
+
+// passedFlags returns the names of the flags given on the command line, these
+// take precedence over the environment and config file
+func passedFlags(flags *flag.FlagSet) map[string]bool {
+	passed := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+	return passed
+}
+
+// loadEnv applies SERVE_* environment variables to the flags that weren't
+// passed on the command line or set by the config file, e.g. SERVE_NO_LIST
+// for --no-list. SERVE_DIRS is a list of directories separated by the OS path
+// list separator. SERVE_CONFIG has already been used to find the config file.
+// The names of the variables used are returned
+func loadEnv(conf *config, flags *flag.FlagSet, set map[string]bool) ([]string, error) {
+	used := []string{}
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || f.Name == "check" || f.Name == "version" || f.Name == "config" {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if setErr := setFlag(flags, f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: invalid value %q: %v", name, value, setErr)
+			return
+		}
+		used = append(used, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if dirs := os.Getenv("SERVE_DIRS"); dirs != "" && !set["dirs"] {
+		conf.Dirs = filepath.SplitList(dirs)
+		used = append(used, "SERVE_DIRS")
+	}
+	return used, nil
+}
+
+func envName(flagName string) string {
+	return "SERVE_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// setFlag sets a flag from the environment or a config file, where booleans
+// may also be written as yes/no or on/off
+func setFlag(flags *flag.FlagSet, name, value string) error {
+	f := flags.Lookup(name)
+	if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && boolFlag.IsBoolFlag() {
+		switch strings.ToLower(value) {
+		case "yes", "y", "on":
+			value = "true"
+		case "no", "n", "off":
+			value = "false"
+		}
+	}
+	return flags.Set(name, value)
+}
+
+// loadConfig applies the settings in a config file to the flags that weren't
+// passed on the command line, so that flags always take precedence, adding
+// those it sets to set. Keys are the long flag names, plus dirs for the list
+// of directories to serve
+//
+// If name is empty serve.toml or .serve.yaml are used if present
+func loadConfig(conf *config, flags *flag.FlagSet, name string, set map[string]bool) error {
+	if name == "" {
+		for _, candidate := range configFiles {
+			if _, err := os.Stat(candidate); err == nil {
+				name = candidate
+				break
+			}
+		}
+		if name == "" {
+			return nil
+		}
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	conf.ConfigFile = name
+	var settings []setting
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		settings, err = parseYAML(string(data))
+	default:
+		settings, err = parseTOML(string(data))
+	}
+	if err != nil {
+		return fmt.Errorf("%s:%w", name, err)
+	}
+
+	applied := map[string]bool{}
+	for _, s := range settings {
+		if s.key == "dirs" {
+			if !set["dirs"] {
+				conf.Dirs = s.values
+				applied["dirs"] = true
+			}
+			continue
+		}
+		key := longName(s.key)
+		f := flags.Lookup(key)
+		if f == nil || key == "config" || key == "check" || key == "version" {
+			log.Printf("%s:%d: unknown option %q", name, s.line, s.key)
+			continue
+		}
+		if set[key] {
+			continue
+		}
+		for _, value := range s.values {
+			if err := setFlag(flags, key, value); err != nil {
+				return fmt.Errorf("%s:%d: invalid value %q for %s: %v", name, s.line, value, s.key, err)
+			}
+		}
+		applied[key] = true
+	}
+	maps.Copy(set, applied)
+	return nil
+}
+
+// longName returns the long name of a flag given by its short alias
+func longName(name string) string {
+	if opt := lookupOption(name, true); opt != nil {
+		return opt.long
+	}
+	return name
+}
+
+// parseTOML reads the subset of TOML used for config files: top level
+// key = value pairs where the value is a string, boolean, number or an array
+// of them, which may span several lines
+func parseTOML(data string) ([]setting, error) {
+	settings := []setting{}
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripComment(lines[i], false))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, &syntaxError{lineNo, "tables are not supported"}
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, &syntaxError{lineNo, "expected key = value"}
+		}
+		key, err := parseScalar(strings.TrimSpace(key))
+		if err != nil || key == "" {
+			return nil, &syntaxError{lineNo, "invalid key"}
+		}
+
+		value = strings.TrimSpace(value)
+		for strings.HasPrefix(value, "[") && !balanced(value) && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i], false))
+		}
+
+		var values []string
+		if strings.HasPrefix(value, "[") {
+			values, err = parseArray(value)
+		} else {
+			var scalar string
+			scalar, err = parseScalar(value)
+			values = []string{scalar}
+		}
+		if err != nil {
+			return nil, &syntaxError{lineNo, err.Error()}
+		}
+		settings = append(settings, setting{key, values, lineNo})
+	}
+	return settings, nil
+}
+
+// parseYAML reads the subset of YAML used for config files: top level
+// key: value pairs where the value is a scalar, a [flow, sequence] or a list
+// of "- item" lines following the key
+func parseYAML(data string) ([]setting, error) {
+	settings := []setting{}
+	list := -1
+	for i, line := range strings.Split(data, "\n") {
+		lineNo := i + 1
+		line = strings.TrimRight(stripComment(line, true), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "-") && (line != trimmed || list >= 0) {
+			if list < 0 || !strings.HasPrefix(trimmed, "- ") && trimmed != "-" {
+				return nil, &syntaxError{lineNo, "unexpected list item"}
+			}
+			item, err := parseScalar(strings.TrimSpace(trimmed[1:]))
+			if err != nil {
+				return nil, &syntaxError{lineNo, err.Error()}
+			}
+			settings[list].values = append(settings[list].values, item)
+			continue
+		}
+		if line != trimmed {
+			return nil, &syntaxError{lineNo, "nested mappings are not supported"}
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, &syntaxError{lineNo, "expected key: value"}
+		}
+		key, err := parseScalar(strings.TrimSpace(key))
+		if err != nil || key == "" {
+			return nil, &syntaxError{lineNo, "invalid key"}
+		}
+
+		value = strings.TrimSpace(value)
+		list = -1
+		var values []string
+		switch {
+		case value == "":
+			list = len(settings)
+		case strings.HasPrefix(value, "["):
+			values, err = parseArray(value)
+		default:
+			var scalar string
+			scalar, err = parseScalar(value)
+			values = []string{scalar}
+		}
+		if err != nil {
+			return nil, &syntaxError{lineNo, err.Error()}
+		}
+		settings = append(settings, setting{key, values, lineNo})
+	}
+	return settings, nil
+}
+
+// stripComment removes a # comment from a line, ignoring any inside quotes.
+// In YAML a # only starts a comment at the start of the line or after
+// whitespace
+func stripComment(line string, yaml bool) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (!yaml || i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// balanced reports whether the brackets outside of quotes in s are closed
+func balanced(s string) bool {
+	depth := 0
+	for _, field := range splitOutsideQuotes(s, 0) {
+		depth += strings.Count(field, "[") - strings.Count(field, "]")
+	}
+	return depth <= 0
+}
+
+// parseArray parses a single level ["array", "of", scalars]
+func parseArray(s string) ([]string, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, errors.New("unterminated array")
+	}
+	values := []string{}
+	for _, element := range splitOutsideQuotes(s[1:len(s)-1], ',') {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		value, err := parseScalar(element)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// splitOutsideQuotes splits s at each sep that isn't inside quotes. With a
+// sep of 0 it returns the unquoted parts of s
+func splitOutsideQuotes(s string, sep byte) []string {
+	fields := []string{}
+	start := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+				if sep == 0 {
+					start = i + 1
+				}
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			if sep == 0 {
+				fields = append(fields, s[start:i])
+			}
+		case sep != 0 && c == sep:
+			fields = append(fields, s[start:i])
+			start = i + 1
+		}
+	}
+	if quote == 0 || sep != 0 {
+		fields = append(fields, s[start:])
+	}
+	return fields
+}
+
+// parseScalar returns the value of a quoted or bare scalar
+func parseScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		value, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return value, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		settings []setting
+	}{
+		{"scalars", "port = 3000\nno-list = true\ntitle = \"My files\"\nindex = 'it''s.html'\n", []setting{
+			{"port", []string{"3000"}, 1},
+			{"no-list", []string{"true"}, 2},
+			{"title", []string{"My files"}, 3},
+			{"index", []string{"it's.html"}, 4},
+		}},
+		{"comments and blank lines", "# serve.toml\n\nport = 3000 # the port\ntitle = \"# not a comment\"\n", []setting{
+			{"port", []string{"3000"}, 3},
+			{"title", []string{"# not a comment"}, 4},
+		}},
+		{"array", `dirs = ["client", "node_modules"]`, []setting{
+			{"dirs", []string{"client", "node_modules"}, 1},
+		}},
+		{"multiline array", "mount = [\n  \"/a=./a\", # first\n  \"/b=./b\",\n]\nport = 1\n", []setting{
+			{"mount", []string{"/a=./a", "/b=./b"}, 1},
+			{"port", []string{"1"}, 5},
+		}},
+		{"quoted key and brackets in strings", "\"title\" = \"[draft]\"\ncors = [\"a,b\", \"]\"]\n", []setting{
+			{"title", []string{"[draft]"}, 1},
+			{"cors", []string{"a,b", "]"}, 2},
+		}},
+		{"escapes", `title = "tab\there \"quoted\""`, []setting{
+			{"title", []string{"tab\there \"quoted\""}, 1},
+		}},
+		{"empty", "", []setting{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := parseTOML(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(settings, tt.settings) {
+				t.Errorf("settings = %+v, want %+v", settings, tt.settings)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"[server]\nport = 1", "1: tables are not supported"},
+		{"port = 1\nport", "2: expected key = value"},
+		{" = 1", "1: invalid key"},
+		{`"port = 1`, "1: invalid key"},
+		{`title = "unterminated`, `1: invalid string "unterminated`},
+		{"title = 'unterminated", "1: invalid string 'unterminated"},
+		{"dirs = [\"a\",\n\"b\"", "1: unterminated array"},
+		{`dirs = ["a", "b]`, `1: invalid string "b`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			_, err := parseTOML(tt.data)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		settings []setting
+	}{
+		{"scalars", "---\nport: 3000\nno-list: yes\ntitle: \"My files\"\nindex: 'it''s.html'\n", []setting{
+			{"port", []string{"3000"}, 2},
+			{"no-list", []string{"yes"}, 3},
+			{"title", []string{"My files"}, 4},
+			{"index", []string{"it's.html"}, 5},
+		}},
+		{"comments", "# .serve.yaml\nport: 3000 # the port\ntitle: a#b\n", []setting{
+			{"port", []string{"3000"}, 2},
+			{"title", []string{"a#b"}, 3},
+		}},
+		{"list", "dirs:\n  - client\n  - \"node_modules\"\n# between\nport: 1\n", []setting{
+			{"dirs", []string{"client", "node_modules"}, 1},
+			{"port", []string{"1"}, 5},
+		}},
+		{"unindented list", "dirs:\n- client\n- public\n", []setting{
+			{"dirs", []string{"client", "public"}, 1},
+		}},
+		{"flow sequence", "cors: [\"https://a.example\", https://b.example]\n", []setting{
+			{"cors", []string{"https://a.example", "https://b.example"}, 1},
+		}},
+		{"key without a list", "index:\nport: 1\n", []setting{
+			{"index", nil, 1},
+			{"port", []string{"1"}, 2},
+		}},
+		{"empty", "", []setting{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := parseYAML(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(settings, tt.settings) {
+				t.Errorf("settings = %+v, want %+v", settings, tt.settings)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"- a", "1: expected key: value"},
+		{"port: 1\n  - a", "2: unexpected list item"},
+		{"dirs:\n  -a", "2: unexpected list item"},
+		{"server:\n  port: 1", "2: nested mappings are not supported"},
+		{"port 1", "1: expected key: value"},
+		{": 1", "1: invalid key"},
+		{`title: "unterminated`, `1: invalid string "unterminated`},
+		{"dirs:\n  - 'unterminated", "2: invalid string 'unterminated"},
+		{"dirs: [a, b", "1: unterminated array"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			_, err := parseYAML(tt.data)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// writeConfig writes a config file called name in a temporary directory,
+// returning its path
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// captureLog returns what is logged until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name, file, data string
+		port, title      string
+		dirs             []string
+		verbose          bool
+		err              string
+		warning          string
+	}{
+		{name: "toml", file: "serve.toml", data: "dirs = [\"a\", \"b\"]\nport = 3000\ntitle = \"Files\"\nv = on\n",
+			port: "3000", title: "Files", dirs: []string{"a", "b"}, verbose: true},
+		{name: "yaml", file: "serve.yaml", data: "dirs:\n  - a\nport: 3000\nverbose: no\n",
+			port: "3000", title: "Index of", dirs: []string{"a"}},
+		{name: "yml", file: "serve.yml", data: "port: 3000\n", port: "3000", title: "Index of", dirs: []string{"."}},
+		{name: "unknown key", file: "serve.toml", data: "colour = \"blue\"\nport = 3000\n",
+			port: "3000", title: "Index of", dirs: []string{"."}, warning: `serve.toml:1: unknown option "colour"`},
+		{name: "config key", file: "serve.toml", data: "config = \"other.toml\"\n",
+			port: "8080", title: "Index of", dirs: []string{"."}, warning: `serve.toml:1: unknown option "config"`},
+		{name: "malformed", file: "serve.toml", data: "port = 3000\n[server]\n", err: "serve.toml:2: tables are not supported"},
+		{name: "malformed yaml", file: "serve.yaml", data: "server:\n  port: 1\n", err: "serve.yaml:2: nested mappings are not supported"},
+		{name: "invalid value", file: "serve.toml", data: "\nmax-entries = \"lots\"\n", err: `serve.toml:2: invalid value "lots" for max-entries`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := captureLog(t)
+			file := writeConfig(t, tt.file, tt.data)
+			conf := newConfig()
+			flags := defineFlags(conf, &cliFlags{})
+			err := loadConfig(conf, flags, file, map[string]bool{})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.Port != tt.port || conf.Title != tt.title || conf.Verbose != tt.verbose || !reflect.DeepEqual(conf.Dirs, tt.dirs) {
+				t.Errorf("port %q, title %q, verbose %v, dirs %q", conf.Port, conf.Title, conf.Verbose, conf.Dirs)
+			}
+			if conf.ConfigFile != file {
+				t.Errorf("ConfigFile = %q, want %q", conf.ConfigFile, file)
+			}
+			if !strings.Contains(logged.String(), tt.warning) || tt.warning == "" && logged.Len() > 0 {
+				t.Errorf("logged %q, want %q", logged.String(), tt.warning)
+			}
+		})
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	file := writeConfig(t, "serve.toml", "port = 2000\ntitle = \"config\"\nmax-entries = 20\n")
+	dir := t.TempDir()
+	t.Setenv("SERVE_PORT", "1000")
+	t.Setenv("SERVE_TITLE", "env")
+	t.Setenv("SERVE_NO_SNIFF", "yes")
+	t.Setenv("SERVE_MAX_ENTRIES", "10")
+
+	tests := []struct {
+		name       string
+		args       []string
+		port       string
+		title      string
+		maxEntries int
+	}{
+		// the config file overrides the environment
+		{"config over env", []string{"--config", file, dir}, "2000", "config", 20},
+		// and flags override both
+		{"flags over config", []string{"--config", file, "--port", "3000", "--title=flag", dir}, "3000", "flag", 20},
+		{"short flags", []string{"--config", file, "-p", "3000", dir}, "3000", "config", 20},
+		{"no config", []string{dir}, "1000", "env", 10},
+		{"flags over env", []string{"--max-entries", "30", dir}, "1000", "env", 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			conf, err := getFlags(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.Port != tt.port || conf.Title != tt.title || conf.MaxEntries != tt.maxEntries {
+				t.Errorf("port %q, title %q, max-entries %d; want %q, %q, %d",
+					conf.Port, conf.Title, conf.MaxEntries, tt.port, tt.title, tt.maxEntries)
+			}
+			// set by the environment alone
+			if !conf.NoSniff {
+				t.Error("SERVE_NO_SNIFF wasn't applied")
+			}
+		})
+	}
+}
+
+func TestEnvPrecedence(t *testing.T) {
+	a, b, c := t.TempDir(), t.TempDir(), t.TempDir()
+	t.Setenv("SERVE_PORT", "1000")
+	t.Setenv("SERVE_DIRS", a+string(os.PathListSeparator)+b)
+	t.Setenv("SERVE_MOUNT", "/env="+a)
+	t.Setenv("SERVE_CORS", "https://env.example")
+
+	tests := []struct {
+		name   string
+		config string
+		args   []string
+		port   string
+		dirs   []string
+		mounts []string
+		cors   []string
+	}{
+		{"env", "", nil, "1000", []string{a, b}, []string{"/env"}, []string{"https://env.example"}},
+		{"config", "port = 2000\ndirs = [\"" + c + "\"]\nmount = [\"/config1=" + b + "\", \"/config2=" + c + "\"]\ncors = \"https://config.example\"\n",
+			nil, "2000", []string{c}, []string{"/config1", "/config2"}, []string{"https://config.example"}},
+		{"config without lists", "port = 2000\n", nil, "2000", []string{a, b}, []string{"/env"}, []string{"https://env.example"}},
+		{"flags", "mount = [\"/config=" + b + "\"]\n", []string{"--port", "3000", "--mount", "/flag1=" + c, "--mount=/flag2=" + c, "--cors", "https://flag.example", c},
+			"3000", []string{c}, []string{"/flag1", "/flag2"}, []string{"https://flag.example"}},
+		{"flags over env", "", []string{"--mount", "/flag=" + c, b}, "1000", []string{b}, []string{"/flag"}, []string{"https://env.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			args := tt.args
+			if tt.config != "" {
+				args = append([]string{"--config", writeConfig(t, "serve.toml", tt.config)}, args...)
+			}
+			conf, err := getFlags(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mounts []string
+			for _, m := range conf.Mounts {
+				mounts = append(mounts, m.Prefix)
+			}
+			if conf.Port != tt.port || !slices.Equal(conf.Dirs, tt.dirs) || !slices.Equal(mounts, tt.mounts) || conf.CORS.String() != strings.Join(tt.cors, ",") {
+				t.Errorf("port %q, dirs %q, mounts %q, cors %q; want %q, %q, %q, %q",
+					conf.Port, conf.Dirs, mounts, conf.CORS.String(), tt.port, tt.dirs, tt.mounts, tt.cors)
+			}
+		})
+	}
+}
+
+func TestEnvInvalid(t *testing.T) {
+	captureLog(t)
+	t.Setenv("SERVE_MAX_ENTRIES", "lots")
+	if _, err := getFlags([]string{t.TempDir()}); err == nil || !strings.Contains(err.Error(), `SERVE_MAX_ENTRIES: invalid value "lots"`) {
+		t.Errorf("error = %v, want SERVE_MAX_ENTRIES's invalid value", err)
+	}
+	// a flag given on the command line isn't read from the environment
+	if _, err := getFlags([]string{"--max-entries", "5", t.TempDir()}); err != nil {
+		t.Errorf("error = %v, want none", err)
+	}
+}
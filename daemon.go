@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// daemonChildEnv is set in the environment of the process started by
+// --daemon, so that it serves rather than daemonizing again
+const daemonChildEnv = "SERVE_DAEMON_CHILD"
+
+// errStop is returned by getFlags when --stop is passed
+var errStop = errors.New("stop requested")
+
+// openLog sends the log to syslog with --syslog or --syslog-addr, or to
+// --log-file, appending to it
+func openLog(conf *config) error {
+	if conf.Syslog || conf.SyslogAddr != "" {
+		return openSyslog(conf.SyslogAddr)
+	}
+	if conf.LogFile == "" {
+		return nil
+	}
+	file, err := os.OpenFile(conf.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(file)
+	return nil
+}
+
+// startDaemon runs serve again in the background with the same arguments,
+// writing the pid of the new process to --pidfile
+func startDaemon(conf *config) error {
+	pid, err := detach()
+	if err != nil {
+		return err
+	}
+	if conf.PidFile != "" {
+		if err := writePidFile(conf.PidFile, pid); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("serve started in the background (pid %d)\n", pid)
+	return nil
+}
+
+func isDaemonChild() bool {
+	return os.Getenv(daemonChildEnv) != ""
+}
+
+func writePidFile(name string, pid int) error {
+	return os.WriteFile(name, []byte(strconv.Itoa(pid)+"\n"), 0644)
+}
+
+// removePidFile removes --pidfile on shutdown if it still holds our pid
+func removePidFile(conf *config) {
+	if conf == nil || conf.PidFile == "" {
+		return
+	}
+	if pid, err := readPidFile(conf.PidFile); err == nil && pid == os.Getpid() {
+		os.Remove(conf.PidFile)
+	}
+}
+
+func readPidFile(name string) (int, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("%s: invalid pid %q", name, strings.TrimSpace(string(data)))
+	}
+	return pid, nil
+}
+
+// stopDaemon asks the process recorded in --pidfile to shut down, removing
+// the pidfile if the process is already gone
+func stopDaemon(conf *config) error {
+	if conf.PidFile == "" {
+		return errors.New("--stop needs --pidfile")
+	}
+	pid, err := readPidFile(conf.PidFile)
+	if err != nil {
+		return err
+	}
+	process, err := os.FindProcess(pid)
+	if err == nil {
+		err = stopProcess(process)
+	}
+	if errors.Is(err, os.ErrProcessDone) {
+		os.Remove(conf.PidFile)
+		return fmt.Errorf("serve is not running (stale pid %d in %s)", pid, conf.PidFile)
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func detach() (int, error) {
+	return 0, fmt.Errorf("--daemon is not supported on %s, use a service manager instead", runtime.GOOS)
+}
+
+func stopProcess(process *os.Process) error {
+	return process.Kill()
+}
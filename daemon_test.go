@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPidFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "serve.pid")
+	if err := writePidFile(file, 4321); err != nil {
+		t.Fatal(err)
+	}
+	if pid, err := readPidFile(file); err != nil || pid != 4321 {
+		t.Errorf("readPidFile = %d, %v, want 4321", pid, err)
+	}
+
+	// another process's pidfile is left alone on shutdown, ours is removed
+	conf := newConfig()
+	conf.PidFile = file
+	removePidFile(conf)
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("removed the pidfile of another process: %v", err)
+	}
+	if err := writePidFile(file, os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+	removePidFile(conf)
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("the pidfile was kept after shutdown: %v", err)
+	}
+	removePidFile(nil)
+	removePidFile(newConfig())
+
+	for _, data := range []string{"", "serve\n", "0\n", "-12\n"} {
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if pid, err := readPidFile(file); err == nil {
+			t.Errorf("readPidFile of %q = %d, want an error", data, pid)
+		}
+	}
+}
+
+func TestStopDaemonErrors(t *testing.T) {
+	conf := newConfig()
+	if err := stopDaemon(conf); err == nil || !strings.Contains(err.Error(), "--pidfile") {
+		t.Errorf("--stop without --pidfile = %v, want an error naming it", err)
+	}
+	conf.PidFile = filepath.Join(t.TempDir(), "missing.pid")
+	if err := stopDaemon(conf); !os.IsNotExist(err) {
+		t.Errorf("--stop with a missing pidfile = %v, want it not found", err)
+	}
+}
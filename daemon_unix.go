@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach starts a copy of serve in a new session, so it has no controlling
+// terminal and isn't killed along with the shell that started it
+func detach() (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonChildEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	// stdin, stdout and stderr are left nil, connecting them to /dev/null
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+func stopProcess(process *os.Process) error {
+	return process.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,68 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// pidFileOf writes the pid of cmd, which has been started, to a pidfile for
+// --stop
+func pidFileOf(t *testing.T, cmd *exec.Cmd) *config {
+	t.Helper()
+	conf := newConfig()
+	conf.PidFile = filepath.Join(t.TempDir(), "serve.pid")
+	if err := writePidFile(conf.PidFile, cmd.Process.Pid); err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestStopDaemon(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	conf := pidFileOf(t, cmd)
+	if err := stopDaemon(conf); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) || exit.Sys().(syscall.WaitStatus).Signal() != syscall.SIGTERM {
+			t.Errorf("process ended with %v, want SIGTERM", err)
+		}
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("the process wasn't stopped")
+	}
+	// the pidfile is the daemon's to remove as it shuts down
+	if _, err := os.Stat(conf.PidFile); err != nil {
+		t.Errorf("the pidfile of a running process was removed: %v", err)
+	}
+}
+
+func TestStopDaemonStale(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	conf := pidFileOf(t, cmd)
+	err := stopDaemon(conf)
+	if err == nil || !strings.Contains(err.Error(), "stale pid") {
+		t.Errorf("--stop of a process that has exited = %v, want a stale pid error", err)
+	}
+	if _, err := os.Stat(conf.PidFile); !os.IsNotExist(err) {
+		t.Errorf("the stale pidfile was kept: %v", err)
+	}
+}
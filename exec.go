@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/Alexendoo/serve/server"
+)
+
+// execCommand returns the command that runs line with the shell on goos
+func execCommand(goos, line string) []string {
+	if goos == "windows" {
+		return []string{"cmd", "/C", line}
+	}
+	return []string{"sh", "-c", line}
+}
+
+// runExec runs --exec once srv is accepting connections, with the URL in
+// SERVE_URL, and returns its exit code. The command is stopped if ctx is
+// cancelled first, so that stopping serve stops it too
+func runExec(ctx context.Context, conf *config, srv *server.Server) int {
+	select {
+	case <-srv.Ready():
+	case <-ctx.Done():
+		return 1
+	}
+	local, network := listenURLs(conf.Listeners()[0])
+	url := local
+	if url == "" {
+		url = network
+	}
+
+	args := execCommand(runtime.GOOS, conf.Exec)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "SERVE_URL="+url)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Cancel = func() error { return stopProcess(cmd.Process) }
+	cmd.WaitDelay = 5 * time.Second
+	err := cmd.Run()
+	if cmd.ProcessState == nil {
+		log.Printf("--exec: %s", err)
+		return 1
+	}
+	code := cmd.ProcessState.ExitCode()
+	if code < 0 {
+		// killed by a signal
+		code = 1
+	}
+	if conf.Verbose {
+		log.Printf("--exec exited with %d, stopping", code)
+	}
+	return code
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/Alexendoo/serve/server"
+)
+
+// serveExpvar publishes the request counters of srv and starts the debug
+// listener. It is kept off the main listener so the variables aren't exposed
+// to everyone that can browse the files
+func serveExpvar(conf *config, srv *server.Server) {
+	if len(conf.ExpvarPort) == 0 {
+		return
+	}
+
+	srv.Vars().Do(func(kv expvar.KeyValue) {
+		expvar.Publish(kv.Key, kv.Value)
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	address := net.JoinHostPort("localhost", conf.ExpvarPort)
+	log.Printf("serving expvar on: http://%s/debug/vars", address)
+	go func() {
+		log.Fatal(http.ListenAndServe(address, mux))
+	}()
+}
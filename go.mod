@@ -0,0 +1,5 @@
+module github.com/Alexendoo/serve
+
+go 1.25.0
+
+require golang.org/x/text v0.41.0
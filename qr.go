@@ -0,0 +1,445 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// A minimal QR code encoder for printing share links in the terminal. It only
+// implements byte mode at error correction level M, for versions 1 to 10,
+// which holds up to 213 bytes and is plenty for a URL
+
+// qrECCPerBlock and qrBlocks are the error correction codewords per block and
+// the number of blocks at level M, indexed by version
+var (
+	qrECCPerBlock = [...]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	qrBlocks      = [...]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+)
+
+const qrMaxVersion = 10
+
+var errQRTooLong = errors.New("too long for a QR code")
+
+// qrCode is a square grid of modules, true for dark
+type qrCode struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// encodeQR encodes data as the smallest QR code that will hold it
+func encodeQR(data []byte) (*qrCode, error) {
+	version := 1
+	for ; version <= qrMaxVersion; version++ {
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+len(data)*8 <= qrDataCodewords(version)*8 {
+			break
+		}
+	}
+	if version > qrMaxVersion {
+		return nil, errQRTooLong
+	}
+
+	// byte mode indicator, length and data, then a terminator and padding
+	bits := qrBits{}
+	bits.append(0x4, 4)
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := qrDataCodewords(version) * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	q := &qrCode{size: version*4 + 17}
+	q.modules = make([][]bool, q.size)
+	q.isFunction = make([][]bool, q.size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, q.size)
+		q.isFunction[i] = make([]bool, q.size)
+	}
+	q.drawFunctionPatterns(version)
+	q.drawCodewords(qrAddECC(codewords, version))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if penalty := q.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+	return q, nil
+}
+
+type qrBits []bool
+
+func (b *qrBits) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 != 0)
+	}
+}
+
+// qrRawCodewords is the number of codewords that fit in a version, after the
+// function patterns
+func qrRawCodewords(version int) int {
+	modules := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		modules -= (25*align-10)*align - 55
+		if version >= 7 {
+			modules -= 36
+		}
+	}
+	return modules / 8
+}
+
+func qrDataCodewords(version int) int {
+	return qrRawCodewords(version) - qrECCPerBlock[version]*qrBlocks[version]
+}
+
+// qrAddECC splits data into blocks, appends Reed-Solomon error correction
+// to each and interleaves them
+func qrAddECC(data []byte, version int) []byte {
+	numBlocks, eccLen := qrBlocks[version], qrECCPerBlock[version]
+	raw := qrRawCodewords(version)
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+
+	divisor := rsDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		block := append([]byte{}, data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, raw)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			// skip the padding added to short blocks
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func (q *qrCode) setFunction(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.isFunction[y][x] = true
+}
+
+func (q *qrCode) drawFunctionPatterns(version int) {
+	for i := 0; i < q.size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+	q.drawFinder(3, 3)
+	q.drawFinder(q.size-4, 3)
+	q.drawFinder(3, q.size-4)
+
+	positions := qrAlignmentPositions(version, q.size)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// the corners overlap the finder patterns
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// reserve the format bits, drawn for real once the mask is chosen
+	q.drawFormatBits(0)
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 != 0
+			a, b := q.size-11+i%3, i/3
+			q.setFunction(a, b, dark)
+			q.setFunction(b, a, dark)
+		}
+	}
+}
+
+func (q *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx >= 0 && xx < q.size && yy >= 0 && yy < q.size {
+				dist := max(abs(dx), abs(dy))
+				q.setFunction(xx, yy, dist != 2 && dist != 4)
+			}
+		}
+	}
+}
+
+func qrAlignmentPositions(version, size int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*8 + count*3 + 5) / (count*4 - 4) * 2
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, size-7; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// drawFormatBits draws the error correction level, M, and mask with their
+// BCH code in both of the places they appear
+func (q *qrCode) drawFormatBits(mask int) {
+	data := 0<<3 | mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.size-15+i, bit(i))
+	}
+	q.setFunction(8, q.size-8, true)
+}
+
+// drawCodewords fills the data area in the zigzag order of the standard
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.isFunction[y][x] && i < len(data)*8 {
+					q.modules[y][x] = data[i>>3]>>(7-i&7)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by mask, applying it twice
+// undoes it
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.isFunction[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to scan, following the rules of the
+// standard for runs, blocks, finder-like patterns and dark/light balance
+func (q *qrCode) penalty() int {
+	score := 0
+	finderLike := []string{"10111010000", "00001011101"}
+	for _, transpose := range []bool{false, true} {
+		for a := 0; a < q.size; a++ {
+			var line strings.Builder
+			run := 0
+			var prev bool
+			for b := 0; b < q.size; b++ {
+				dark := q.modules[a][b]
+				if transpose {
+					dark = q.modules[b][a]
+				}
+				if b > 0 && dark == prev {
+					run++
+				} else {
+					run = 1
+				}
+				if run == 5 {
+					score += 3
+				} else if run > 5 {
+					score++
+				}
+				prev = dark
+				if dark {
+					line.WriteByte('1')
+				} else {
+					line.WriteByte('0')
+				}
+			}
+			for _, pattern := range finderLike {
+				score += 40 * strings.Count(line.String(), pattern)
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.modules[y][x]
+				if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	k := (abs(dark*20-total*10) + total - 1) / total
+	return score + (k-1)*10
+}
+
+// print draws the code with half block characters, two rows to a line. Light
+// modules are drawn with the foreground colour, which suits the light text on
+// a dark background of most terminals
+func (q *qrCode) print(w io.Writer) {
+	const quiet = 2
+	light := func(x, y int) bool {
+		x, y = x-quiet, y-quiet
+		return x < 0 || y < 0 || x >= q.size || y >= q.size || !q.modules[y][x]
+	}
+	side := q.size + quiet*2
+	var b strings.Builder
+	for y := 0; y < side; y += 2 {
+		for x := 0; x < side; x++ {
+			top, bottom := light(x, y), y+1 < side && light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	io.WriteString(w, b.String())
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Alexendoo/serve/server"
+)
+
+// configPollInterval is how often --watch-config checks the config file
+const configPollInterval = time.Second
+
+// startArgs are the arguments serve was started with, after the share
+// subcommand if there was one, for reload to parse again in the same mode
+var startArgs []string
+
+// reloadOnHangup rereads the configuration each time serve receives a SIGHUP
+func reloadOnHangup(srv *server.Server) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			reload(srv)
+		}
+	}()
+}
+
+// watchConfig reloads the configuration each time the config file at name
+// changes, for --watch-config, until srv is closed. It polls the file's size
+// and modification time, as live reload does with the files served, and waits
+// for them to settle so that a file an editor is still writing isn't read half
+// written
+func watchConfig(srv *server.Server, name string) {
+	stat := func() (time.Time, int64, bool) {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, 0, false
+		}
+		return info.ModTime(), info.Size(), true
+	}
+	modTime, size, _ := stat()
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-srv.Done():
+				return
+			case <-ticker.C:
+			}
+			nextModTime, nextSize, ok := stat()
+			if !ok || nextModTime.Equal(modTime) && nextSize == size {
+				continue
+			}
+			time.Sleep(configPollInterval / 4)
+			if settledModTime, settledSize, _ := stat(); !settledModTime.Equal(nextModTime) || settledSize != nextSize {
+				continue
+			}
+			modTime, size = nextModTime, nextSize
+			log.Printf("%s changed, reloading", name)
+			reload(srv)
+		}
+	}()
+}
+
+// reload reads the environment, config file and flags again, and swaps in the
+// new configuration into srv. Requests in flight finish with the settings they started
+// with
+//
+// Settings that are only read when the server starts, such as the address it
+// listens on, keep their old values with a warning. An invalid configuration
+// is rejected as a whole
+func reload(srv *server.Server) {
+	old := current.Load()
+	next, err := getFlags(startArgs)
+	if err != nil {
+		// getFlags has already printed why
+		log.Println("reload failed, keeping the current configuration")
+		return
+	}
+
+	fixed := []struct {
+		name    string
+		changed bool
+	}{
+		{"host", next.Host != old.Host},
+		{"port", next.Port != old.Port},
+		{"listen", next.Listen.String() != old.Listen.String()},
+		{"cert", next.CertFile != old.CertFile},
+		{"key", next.KeyFile != old.KeyFile},
+		{"expvar", next.ExpvarPort != old.ExpvarPort},
+		{"http2", next.HTTP2 != old.HTTP2},
+		{"no-keepalive", next.NoKeepAlive != old.NoKeepAlive},
+		{"otel", next.Otel != old.Otel},
+		{"reuse-port", next.ReusePort != old.ReusePort},
+		{"tcp-keepalive", next.TCPKeepAlive != old.TCPKeepAlive},
+		{"live-reload", next.LiveReload != old.LiveReload},
+		{"track-404s", next.Track404s != old.Track404s},
+		{"hits", next.TrackHits != old.TrackHits},
+		{"stats-file", next.StatsFile != old.StatsFile},
+		{"read-header-timeout", next.ReadHeaderTimeout != old.ReadHeaderTimeout},
+		{"recent-requests", next.RecentRequests != old.RecentRequests},
+		{"manifest", next.Manifest != old.Manifest},
+		{"max-large-transfers", next.MaxLargeTransfers != old.MaxLargeTransfers},
+		{"share-secret", (next.ShareSecret == "") != (old.ShareSecret == "")},
+		{"precompress", next.Precompress != old.Precompress},
+		{"precompress-eager", next.PrecompressEager != old.PrecompressEager},
+		{"cache-dir", next.CacheDir != old.CacheDir},
+		{"daemon", next.Daemon != old.Daemon},
+		{"exec", next.Exec != old.Exec},
+		{"pidfile", next.PidFile != old.PidFile},
+		{"log-file", next.LogFile != old.LogFile},
+		{"syslog", next.Syslog != old.Syslog},
+		{"syslog-addr", next.SyslogAddr != old.SyslogAddr},
+		{"watch-config", next.WatchConfig != old.WatchConfig},
+	}
+	for _, setting := range fixed {
+		if setting.changed {
+			log.Printf("reload: --%s can't be changed without a restart, ignoring it", setting.name)
+		}
+	}
+	next.Host, next.Port, next.ExpvarPort = old.Host, old.Port, old.ExpvarPort
+	next.Listen, next.CertFile, next.KeyFile = old.Listen, old.CertFile, old.KeyFile
+	next.HTTP2, next.NoKeepAlive, next.Otel = old.HTTP2, old.NoKeepAlive, old.Otel
+	next.Track404s, next.TrackHits, next.StatsFile = old.Track404s, old.TrackHits, old.StatsFile
+	next.ReadHeaderTimeout, next.RecentRequests, next.Manifest = old.ReadHeaderTimeout, old.RecentRequests, old.Manifest
+	next.LiveReload, next.ReusePort, next.TCPKeepAlive = old.LiveReload, old.ReusePort, old.TCPKeepAlive
+	next.Daemon, next.PidFile, next.LogFile, next.Exec = old.Daemon, old.PidFile, old.LogFile, old.Exec
+	next.Syslog, next.SyslogAddr = old.Syslog, old.SyslogAddr
+	next.Precompress, next.PrecompressEager, next.CacheDir = old.Precompress, old.PrecompressEager, old.CacheDir
+	next.MaxLargeTransfers = old.MaxLargeTransfers
+	next.WatchConfig, next.ConfigFile = old.WatchConfig, old.ConfigFile
+
+	if err := srv.SetConfig(next.Config); err != nil {
+		log.Printf("reload failed, keeping the current configuration: %s", err)
+		return
+	}
+	current.Store(next)
+	log.Printf("reloaded configuration, serving: %v", next.Dirs)
+}
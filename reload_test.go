@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Alexendoo/serve/server"
+)
+
+// a reload of serve share parses the arguments as a share again, rather than
+// taking the subcommand for a directory
+func TestReloadSharing(t *testing.T) {
+	captureLog(t)
+	file := writeConfig(t, "notes.txt", "notes")
+	oldArgs, oldSharing := startArgs, sharing
+	t.Cleanup(func() { startArgs, sharing = oldArgs, oldSharing })
+	startArgs, sharing = []string{"--host", "127.0.0.1", file}, true
+
+	conf, err := getFlags(startArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := server.New(conf.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	old := current.Swap(conf)
+	t.Cleanup(func() { current.Store(old) })
+
+	reload(srv)
+	next := current.Load()
+	if next == conf {
+		t.Fatal("the configuration wasn't reloaded")
+	}
+	if next.Share != file || len(next.Dirs) != 0 {
+		t.Errorf("reloaded Share = %q, Dirs = %q, want the shared file and no dirs", next.Share, next.Dirs)
+	}
+}
@@ -1,327 +1,353 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"html/template"
-	"io/ioutil"
 	"log"
-	"net"
-	"net/http"
+	"maps"
 	"os"
 	"os/signal"
-	"path"
 	"path/filepath"
 	"strings"
 	"syscall"
-)
 
-var (
-	host     string
-	port     string
-	index    string
-	noList   bool
-	verbose  bool
-	version  = "HEAD"
-	htmlTmpl = template.Must(template.New("html").Parse(html))
+	"github.com/Alexendoo/serve/server"
 )
 
-const (
-	html = `<!DOCTYPE html>
-<html>
-<head>
-	<meta charset="UTF-8">
-	<style>
-		body {
-			font-size: 14px;
-			font-family: consolas, "Liberation Mono", "DejaVu Sans Mono", Menlo, monospace;
-		}
-		a {
-			display: block;
-			color: blue;
-			text-decoration: none;
-		}
-		a:hover {
-			background-color: #f3f3f3;
-		}
-		.req-path {
-			color: #bbb;
-		}
-	</style>
-</head>
-<body>
-{{range .}}
-	<h3>
-		<span class="local-path">{{.LocalPath}}</span><span class="req-path">{{.RequestPath}}</span>
-	</h3>
-	{{range .Entries}}
-		<a class="entry" href="{{.Link}}">{{.Name}}</a>
-	{{end}}
-{{end}}
-</body>
-`
-	usage = `
+const usage = `
 NAME:
    Serve - HTTP server for files spanning multiple directories
 
 USAGE:
-   %s [OPTION]... [DIR]...
+   %[1]s [OPTION]... [DIR]...
+   %[1]s share [OPTION]... FILE
+   %[1]s completion bash|zsh|fish
 
 VERSION:
    %s
 
 OPTIONS:
-       --host     --  bind to host (default: localhost)
-   -i, --index    --  serve all paths to index if file not found
-       --no-list  --  disable directory listings
-   -p, --port     --  bind to port (default: 8080)
-   -v, --verbose  --  display requests and responses
-`
-)
+%s`
 
 func main() {
-	// handle interrupts (0 exit on ctrl + c)
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-c
-		os.Exit(0)
-	}()
-
 	// Log just the timestamp + message
 	log.SetFlags(log.Ltime)
 
-	flags := getFlags()
-	serve(flags)
-}
+	if len(os.Args) == 3 && os.Args[1] == "completion" {
+		if err := printCompletion(os.Stdout, os.Args[2]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-// getFlags returns the command line flags passed to the serve binary
-func getFlags() *flag.FlagSet {
-	flags := flag.NewFlagSet("flags", flag.ContinueOnError)
-	flags.Usage = func() {
-		usageName := filepath.Base(os.Args[0])
-		fmt.Printf(usage, usageName, version)
+	startArgs = os.Args[1:]
+	if len(startArgs) > 0 && startArgs[0] == "share" {
+		sharing = true
+		startArgs = startArgs[1:]
 	}
-	flags.StringVar(&port, "port", "8080", "")
-	flags.StringVar(&port, "p", "8080", "")
-	flags.StringVar(&host, "host", "localhost", "")
-	flags.StringVar(&index, "index", "", "")
-	flags.StringVar(&index, "i", "", "")
-	flags.BoolVar(&noList, "no-list", false, "")
-	flags.BoolVar(&verbose, "verbose", false, "")
-	flags.BoolVar(&verbose, "v", false, "")
-	err := flags.Parse(os.Args[1:])
-	if err == flag.ErrHelp {
+	conf, err := getFlags(startArgs)
+	switch err {
+	case nil:
+	case flag.ErrHelp:
 		os.Exit(0)
-	}
-	if err != nil {
+	case errVersion:
+		fmt.Print(versionInfo())
+		os.Exit(0)
+	case errCheck:
+		os.Exit(0)
+	case errStop:
+		if err := stopDaemon(conf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	default:
 		os.Exit(1)
 	}
-	return flags
-}
 
-func serve(flags *flag.FlagSet) {
-	dirs := make([]string, flags.NArg())
-	for i := range dirs {
-		dirs[i] = flags.Arg(i)
-	}
-	if len(dirs) == 0 {
-		// serve from the current directory
-		dirs = []string{"."}
+	if err := openLog(conf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	http.HandleFunc("/", makeHandler(dirs))
-	address := net.JoinHostPort(host, port)
-	log.Printf("starting on: http://%s", address)
-	log.Fatal(http.ListenAndServe(address, nil))
-}
-
-func makeHandler(dirs []string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		logRequest(r)
-		server := fmt.Sprintf("serve/%s", version)
-		w.Header().Set("Server", server)
-		if !validRequest(r) {
-			http.Error(w, "invalid path", http.StatusBadRequest)
-			log.Printf("invalid path: %s", r.URL.Path)
-			return
-		}
-		if tryDirs(w, r, dirs) {
-			return
-		}
-		if tryFiles(w, r, dirs) {
-			return
+	switch {
+	case conf.Daemon && !isDaemonChild():
+		if err := startDaemon(conf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-		if len(index) > 0 && staticIndex(w, r) {
-			return
+		os.Exit(0)
+	case conf.PidFile != "" && !isDaemonChild():
+		if err := writePidFile(conf.PidFile, os.Getpid()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-		http.NotFound(w, r)
 	}
-}
-
-func logRequest(r *http.Request) {
-	if !verbose {
+	current.Store(conf)
+	if sharing {
+		share(conf)
 		return
 	}
-	log.Printf("%s → %s %s %s", r.RemoteAddr, r.Method, r.RequestURI, r.Proto)
+	serve(conf)
 }
 
-// validRequest returns false if the request is invalid: Contains ".."
-func validRequest(r *http.Request) bool {
-	if !strings.Contains(r.URL.Path, "..") {
-		return true
-	}
-	for _, field := range strings.FieldsFunc(r.URL.Path, isSlashRune) {
-		if field == ".." {
-			return false
-		}
-	}
-	return true
+// cliFlags are the flags that control the command rather than the server
+type cliFlags struct {
+	configFile  string
+	check       bool
+	stop        bool
+	showVersion bool
 }
 
-func isSlashRune(r rune) bool { return r == '/' || r == '\\' }
-
-func tryFiles(w http.ResponseWriter, r *http.Request, dirs []string) bool {
-	for _, dir := range dirs {
-		filePath := filepath.Join(dir, r.URL.Path)
-		indexPath := filepath.Join(filePath, "index.html")
-		if tryFile(w, r, filePath) || tryFile(w, r, indexPath) {
-			return true
-		}
-	}
-	return false
+// defineFlags returns the flags accepted by serve, bound to the fields of
+// conf and cli. Their descriptions and short aliases are in options
+func defineFlags(conf *config, cli *cliFlags) *flag.FlagSet {
+	flags := flag.NewFlagSet("flags", flag.ContinueOnError)
+	flags.StringVar(&conf.Aliases, "aliases", "", "")
+	flags.BoolVar(&conf.AllowForceList, "allow-force-list", false, "")
+	flags.StringVar(&conf.AutoIndex, "auto-index", "", "")
+	flags.BoolVar(&conf.BlockDotfiles, "block-dotfiles", false, "")
+	flags.Var(&conf.BotUA, "bot-ua", "")
+	flags.StringVar(&conf.CacheDir, "cache-dir", "", "")
+	flags.BoolVar(&conf.CanonicalPaths, "canonical-paths", false, "")
+	flags.StringVar(&conf.CertFile, "cert", "", "")
+	flags.Var(&conf.CGI, "cgi", "")
+	flags.DurationVar(&conf.CGITimeout, "cgi-timeout", conf.CGITimeout, "")
+	flags.BoolVar(&cli.check, "check", false, "")
+	flags.StringVar(&cli.configFile, "config", "", "")
+	flags.BoolVar(&conf.Copy, "copy", false, "")
+	flags.Var(&conf.CORS, "cors", "")
+	flags.BoolVar(&conf.CORSCredentials, "cors-credentials", false, "")
+	flags.BoolVar(&conf.Daemon, "daemon", false, "")
+	flags.StringVar(&conf.DefaultLang, "default-lang", "", "")
+	flags.StringVar(&conf.DefaultType, "default-type", "", "")
+	flags.Var(&conf.Delay, "delay", "")
+	flags.BoolVar(&conf.DigestHeader, "digest-header", false, "")
+	flags.Var(&conf.ErrorPages, "error-page", "")
+	flags.StringVar(&conf.Exec, "exec", "", "")
+	flags.StringVar(&conf.ExpvarPort, "expvar", "", "")
+	flags.Var(&conf.FailPaths, "fail-path", "")
+	flags.Float64Var(&conf.FailRate, "fail-rate", 0, "")
+	flags.IntVar(&conf.FailStatus, "fail-status", 0, "")
+	flags.StringVar(&conf.Favicon, "favicon", "", "")
+	flags.BoolVar(&conf.Follow, "follow", false, "")
+	flags.DurationVar(&conf.FSTimeout, "fs-timeout", 0, "")
+	flags.StringVar(&conf.GroupBy, "group-by", "", "")
+	flags.StringVar(&conf.HeadersFile, "headers-file", "", "")
+	flags.BoolVar(&conf.TrackHits, "hits", false, "")
+	flags.StringVar(&conf.Host, "host", conf.Host, "")
+	flags.BoolVar(&conf.HTTP2, "http2", false, "")
+	flags.StringVar(&conf.Index, "index", "", "")
+	flags.StringVar(&conf.IndexPriority, "index-priority", "", "")
+	flags.StringVar(&conf.KeyFile, "key", "", "")
+	flags.BoolVar(&conf.LAN, "lan", false, "")
+	flags.BoolVar(&conf.LangNegotiate, "lang-negotiate", false, "")
+	flags.Var(&conf.LargeThreshold, "large-threshold", "")
+	flags.DurationVar(&conf.LargeTransferWait, "large-transfer-wait", 0, "")
+	flags.Var(&conf.Listen, "listen", "")
+	flags.BoolVar(&conf.LiveReload, "live-reload", false, "")
+	flags.StringVar(&conf.LogFile, "log-file", "", "")
+	flags.BoolVar(&conf.Manifest, "manifest", false, "")
+	flags.IntVar(&conf.MaxEntries, "max-entries", 0, "")
+	flags.IntVar(&conf.MaxLargeTransfers, "max-large-transfers", 0, "")
+	flags.BoolVar(&conf.MergeListings, "merge-listings", false, "")
+	flags.StringVar(&conf.MirrorCache, "mirror-cache", "", "")
+	flags.Var(&conf.MirrorMaxSize, "mirror-max-size", "")
+	flags.BoolVar(&conf.MirrorStale, "mirror-stale", false, "")
+	flags.DurationVar(&conf.MirrorTimeout, "mirror-timeout", conf.MirrorTimeout, "")
+	flags.StringVar(&conf.Mock, "mock", "", "")
+	flags.BoolVar(&conf.MockFallthrough, "mock-fallthrough", false, "")
+	flags.StringVar(&conf.MockPrefix, "mock-prefix", "", "")
+	flags.Var(&conf.Mounts, "mount", "")
+	flags.Var(&conf.MTime, "mtime", "")
+	flags.BoolVar(&conf.NoFavicon, "no-favicon", false, "")
+	flags.BoolVar(&conf.NoIndex, "no-index", false, "")
+	flags.BoolVar(&conf.NoKeepAlive, "no-keepalive", false, "")
+	flags.BoolVar(&conf.NoKeyNav, "no-keynav", false, "")
+	flags.BoolVar(&conf.NoList, "no-list", false, "")
+	flags.BoolVar(&conf.NoListBots, "no-list-bots", false, "")
+	flags.BoolVar(&conf.NoindexListings, "noindex-listings", conf.NoindexListings, "")
+	flags.BoolVar(&conf.NoMissCache, "no-miss-cache", false, "")
+	flags.BoolVar(&conf.NoSniff, "no-sniff", false, "")
+	flags.BoolVar(&conf.Otel, "otel", false, "")
+	flags.StringVar(&conf.PidFile, "pidfile", "", "")
+	flags.StringVar(&conf.Port, "port", conf.Port, "")
+	flags.BoolVar(&conf.Precompress, "precompress", false, "")
+	flags.BoolVar(&conf.PrecompressEager, "precompress-eager", false, "")
+	flags.Var(&conf.Proxies, "proxy", "")
+	flags.BoolVar(&conf.QR, "qr", false, "")
+	flags.Var(&conf.Redirects, "redirect", "")
+	flags.StringVar(&conf.RedirectFile, "redirect-file", "", "")
+	flags.StringVar(&conf.RedirectsFile, "redirects-file", "", "")
+	flags.Var(&conf.Rewrites, "rewrite", "")
+	flags.BoolVar(&conf.ReusePort, "reuse-port", false, "")
+	flags.DurationVar(&conf.ReadHeaderTimeout, "read-header-timeout", conf.ReadHeaderTimeout, "")
+	flags.IntVar(&conf.RecentRequests, "recent-requests", conf.RecentRequests, "")
+	flags.StringVar(&conf.ShareSecret, "share-secret", "", "")
+	flags.DurationVar(&conf.SlowThreshold, "slow-threshold", 0, "")
+	flags.BoolVar(&conf.SlowTTFB, "slow-ttfb", false, "")
+	flags.BoolVar(&conf.SSI, "ssi", false, "")
+	flags.StringVar(&conf.SSIExt, "ssi-ext", "", "")
+	flags.StringVar(&conf.StatsFile, "stats-file", "", "")
+	flags.BoolVar(&cli.stop, "stop", false, "")
+	flags.BoolVar(&conf.Strict, "strict", false, "")
+	flags.BoolVar(&conf.Syslog, "syslog", false, "")
+	flags.StringVar(&conf.SyslogAddr, "syslog-addr", "", "")
+	flags.DurationVar(&conf.TCPKeepAlive, "tcp-keepalive", 0, "")
+	flags.Var(&conf.Throttle, "throttle", "")
+	flags.StringVar(&conf.Title, "title", conf.Title, "")
+	flags.BoolVar(&conf.Track404s, "track-404s", false, "")
+	flags.Var(&conf.Types, "type", "")
+	flags.DurationVar(&conf.TTL, "ttl", 0, "")
+	flags.Var(&conf.TrustedProxies, "trust-proxy", "")
+	flags.BoolVar(&conf.Verbose, "verbose", false, "")
+	flags.BoolVar(&conf.WatchConfig, "watch-config", false, "")
+	flags.BoolVar(&conf.Zip, "zip", false, "")
+	flags.BoolVar(&cli.showVersion, "version", false, "")
+	checkOptions(flags)
+	return flags
 }
 
-// tryFile attempts to serve a file at filePath to the provided ResponseWriter
-func tryFile(w http.ResponseWriter, r *http.Request, filePath string) bool {
-	stat, statErr := os.Stat(filePath)
-	if statErr != nil || stat.IsDir() {
-		return false
+// getFlags reads the configuration from the environment, the config file and
+// the command line flags, in increasing order of precedence. It returns
+// flag.ErrHelp, errVersion, errCheck or errStop if --help, --version, --check
+// or --stop were given
+func getFlags(args []string) (*config, error) {
+	conf := newConfig()
+	cli := cliFlags{}
+	flags := defineFlags(conf, &cli)
+	dirs, err := parseArgs(flags, args)
+	switch {
+	case err == flag.ErrHelp:
+		fmt.Printf(usage, filepath.Base(os.Args[0]), server.Version, optionsUsage())
+		return nil, err
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s\nTry '%s --help' for more information\n", err, filepath.Base(os.Args[0]))
+		return nil, err
+	case cli.showVersion:
+		return nil, errVersion
 	}
-	file, fileErr := os.Open(filePath)
-	defer file.Close()
-	if fileErr != nil {
-		return false
+	passed := passedFlags(flags)
+	if len(dirs) > 0 {
+		conf.Dirs = dirs
+		passed["dirs"] = true
 	}
-	if verbose {
-		filename, _ := filepath.Abs(filePath)
-		log.Printf("%s ← %s", r.RemoteAddr, filename)
+	// the config file is read before the environment, which then only sets
+	// what neither the file nor the flags did. A list such as --mount comes
+	// from a single one of them, rather than adding up across them
+	configFile, configFromEnv := cli.configFile, false
+	if name, ok := os.LookupEnv(envName("config")); ok && !passed["config"] {
+		configFile, configFromEnv = name, true
 	}
-	http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
-	return true
-}
-
-// staticIndex will attempt to serve the globally defined index file
-func staticIndex(w http.ResponseWriter, r *http.Request) bool {
-	file, fileErr := os.Open(index)
-	defer file.Close()
-	stat, statErr := os.Stat(index)
-	if fileErr != nil || statErr != nil {
-		log.Println(fileErr)
-		return false
+	set := maps.Clone(passed)
+	err = loadConfig(conf, flags, configFile, set)
+	var fromEnv []string
+	if err == nil {
+		fromEnv, err = loadEnv(conf, flags, set)
 	}
-	http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
-	return true
-}
-
-// DirList is the contents of a directory at the path given by joining
-// LocalPath and RequestPath
-type DirList struct {
-	LocalPath   string
-	RequestPath string
-	Entries     []Entry
-}
-
-// Entry contains the details of a single file/directory for rendering in
-// htmlTmpl
-type Entry struct {
-	Name  string
-	Link  string
-	IsDir bool
-}
-
-// tryDirs will generate directory listings for any available directories,
-// providing multiple in the case that there are several matching directories
-//
-// Example: `serve dir1 dir2` would list directory entries dir1 containing
-// file1, and dir2 containing file2 and file3
-// .
-// ├── dir1
-// │   └── file1
-// └── dir2
-//     ├── file2
-//     └── file3
-func tryDirs(w http.ResponseWriter, r *http.Request, dirs []string) bool {
-	if noList || !strings.HasSuffix(r.URL.Path, "/") {
-		return false
+	if configFromEnv {
+		fromEnv = append(fromEnv, envName("config"))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+	if conf.Verbose && len(fromEnv) > 0 {
+		log.Printf("settings from the environment: %s", strings.Join(fromEnv, ", "))
 	}
 
-	dirLists := []DirList{}
-	for _, dir := range dirs {
-		list := getDirList(dir, r)
-
-		if list == nil {
-			continue
+	if cli.stop {
+		return conf, errStop
+	}
+	if sharing {
+		if len(conf.Dirs) != 1 || !passed["dirs"] {
+			fmt.Fprintln(os.Stderr, errShareArgs)
+			return nil, errShareArgs
 		}
-
-		dirLists = append(dirLists, *list)
+		conf.Share, conf.Dirs = conf.Dirs[0], nil
+		if !passed["host"] && conf.Host == "localhost" {
+			conf.Host = lanAddress()
+		}
+	} else if len(conf.Dirs) == 0 {
+		// serve from the current directory
+		conf.Dirs = []string{"."}
 	}
-
-	found := len(dirLists) > 0
-	if found {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		logDirLists(r, dirLists)
-		htmlTmpl.Execute(w, dirLists)
+	if conf.LAN && !passed["host"] {
+		conf.Host = lanAddress()
+	}
+	warnings, err := conf.validate()
+	for _, warning := range warnings {
+		log.Printf("warning: %s", warning)
 	}
-	return found
-}
-
-func getDirList(dir string, r *http.Request) *DirList {
-	dirPath := filepath.Join(dir, r.URL.Path)
-	dirInfo, err := ioutil.ReadDir(dirPath)
 	if err != nil {
-		return nil
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
 	}
-
-	entries := []Entry{}
-
-	// Parent directory
-	if r.URL.Path != "/" {
-		entries = append(entries, Entry{
-			Name:  "../",
-			Link:  "../",
-			IsDir: true,
-		})
+	if cli.check {
+		printConfig(os.Stdout, conf, flags)
+		return nil, errCheck
 	}
+	return conf, nil
+}
 
-	for _, file := range dirInfo {
-		entry := Entry{
-			IsDir: file.IsDir(),
-			Name:  file.Name(),
-			Link:  path.Join(r.URL.Path, file.Name()),
-		}
-
-		if entry.IsDir {
-			entry.Name += "/"
-			entry.Link += "/"
+func serve(conf *config) {
+	srv := newServer(conf)
+	if conf.Copy {
+		// other machines can't use localhost, prefer the network address
+		local, network := listenURLs(conf.Listeners()[0])
+		if network != "" {
+			copyToClipboard(network)
+		} else {
+			copyToClipboard(local)
 		}
-
-		entries = append(entries, entry)
 	}
-
-	return &DirList{
-		LocalPath:   filepath.ToSlash(dir),
-		RequestPath: r.URL.Path,
-		Entries:     entries,
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	exitCode := make(chan int, 1)
+	if conf.Exec != "" {
+		go func() {
+			exitCode <- runExec(ctx, conf, srv)
+			stop()
+		}()
+	}
+	if err := srv.ListenAndServe(ctx); err != nil {
+		log.Fatal(err)
+	}
+	stopped(srv)
+	if conf.Exec != "" {
+		os.Exit(<-exitCode)
 	}
 }
 
-func logDirLists(r *http.Request, dirLists []DirList) {
-	if !verbose {
-		return
+// newServer returns the server for conf, with reloading and the expvar
+// listener set up around it
+func newServer(conf *config) *server.Server {
+	srv, err := server.New(conf.Config)
+	if err != nil {
+		log.Fatal(err)
 	}
-	output := ""
-	for _, dir := range dirLists {
-		output += dir.LocalPath + "/, "
+	reloadOnHangup(srv)
+	if conf.WatchConfig && conf.ConfigFile != "" {
+		watchConfig(srv, conf.ConfigFile)
 	}
-	log.Printf("%s ← %s", r.RemoteAddr, output[:len(output)-2])
+	serveExpvar(conf, srv)
+	for _, l := range conf.Listeners() {
+		local, network := listenURLs(l)
+		if local != "" {
+			log.Printf("serve %s (%s) starting on: %s", server.Version, server.ShortCommit(), local)
+			if network != "" {
+				log.Printf("on your network: %s", network)
+			}
+		} else {
+			log.Printf("serve %s (%s) starting on: %s", server.Version, server.ShortCommit(), network)
+		}
+	}
+	return srv
+}
+
+// stopped logs a summary of what srv served once it has shut down
+func stopped(srv *server.Server) {
+	srv.LogSummary()
+	removePidFile(current.Load())
 }
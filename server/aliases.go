@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var aliasesTmpl = template.Must(template.New("aliases").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<title>Aliases in {{.Dir}}</title>
+	<style>
+		body {
+			font-size: 14px;
+			font-family: consolas, "Liberation Mono", "DejaVu Sans Mono", Menlo, monospace;
+		}
+		td, th {
+			padding: 2px 12px 2px 0;
+			text-align: left;
+			white-space: nowrap;
+		}
+	</style>
+</head>
+<body>
+	<table>
+		<tr><th>Alias</th><th>Target</th><th></th></tr>
+	{{range .Aliases}}
+		<tr>
+			<td><a href="{{.Path}}">{{.Path}}</a></td><td>{{.Target}}</td><td>{{if .Serve}}served{{else}}redirect{{end}}</td>
+		</tr>
+	{{end}}
+	</table>
+</body>
+`))
+
+// Alias is a short path of an aliases file and the path it stands for
+type Alias struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+	// Serve serves Target in place of Path rather than redirecting to it
+	Serve bool `json:"serve"`
+}
+
+// parseAliases reads an aliases file, in which each line is an alias and
+// its target, both paths starting with /. The alias is redirected to the
+// target with a 302, or if it is written with a leading = the target is
+// served in its place. Blank lines and those starting with # are skipped.
+// name is used in errors, which give the line
+func parseAliases(name string, data []byte) ([]Alias, error) {
+	var aliases []Alias
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected ALIAS TARGET, got %q", name, line, text)
+		}
+		alias := Alias{Target: fields[1]}
+		alias.Path, alias.Serve = strings.CutPrefix(fields[0], "=")
+		if !strings.HasPrefix(alias.Path, "/") || strings.HasSuffix(alias.Path, "/") || !validPath(alias.Path) {
+			return nil, fmt.Errorf("%s:%d: alias %q must be a path starting with /, and not a directory", name, line, alias.Path)
+		}
+		target, err := url.Parse(alias.Target)
+		if err != nil || target.IsAbs() || target.Host != "" || !strings.HasPrefix(target.Path, "/") || !validPath(target.Path) {
+			return nil, fmt.Errorf("%s:%d: target %q must be a path starting with /", name, line, alias.Target)
+		}
+		if seen[alias.Path] {
+			return nil, fmt.Errorf("%s:%d: alias %q is given more than once", name, line, alias.Path)
+		}
+		seen[alias.Path] = true
+		aliases = append(aliases, alias)
+	}
+	return aliases, scanner.Err()
+}
+
+// danglingAliases describes the aliases whose targets aren't in the Dirs, FS
+// or a mount. Those under a proxy are left for it to answer
+func (c *Config) danglingAliases(aliases []Alias) []string {
+	var dangling []string
+	for _, alias := range aliases {
+		target, _ := url.Parse(alias.Target)
+		if c.Proxies.match(target.Path) != nil {
+			continue
+		}
+		sources, urlPath := c.sources(), target.Path
+		if m := c.Mounts.match(target.Path); m != nil {
+			sources, urlPath = []source{m.source()}, strings.TrimPrefix(target.Path, m.Prefix)
+		}
+		found := false
+		for _, src := range sources {
+			if _, err := fs.Stat(src.fsys, fsName(urlPath)); err == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dangling = append(dangling, fmt.Sprintf("--aliases: the target of %s, %s, doesn't exist", alias.Path, alias.Target))
+		}
+	}
+	return dangling
+}
+
+// checkAliases parses the Aliases file at startup, returning warnings for
+// the targets that don't exist
+func (c *Config) checkAliases() (warnings []string, err error) {
+	if c.Aliases == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.Aliases)
+	if err != nil {
+		return nil, nil
+	}
+	aliases, err := parseAliases(c.Aliases, data)
+	if err != nil {
+		return nil, err
+	}
+	return c.danglingAliases(aliases), nil
+}
+
+// aliasesCache holds the parsed Aliases file, which is read again when its
+// size or modification time changes. A file that no longer parses keeps its
+// last good aliases, with the error logged once
+type aliasesCache struct {
+	mu      sync.Mutex
+	name    string
+	size    int64
+	modTime time.Time
+	aliases []Alias
+}
+
+func newAliasesCache() *aliasesCache {
+	return &aliasesCache{}
+}
+
+// load returns the aliases of conf.Aliases. The targets that don't exist
+// are logged when it changes, as they are by Validate at startup
+func (c *aliasesCache) load(conf *Config) []Alias {
+	if conf.Aliases == "" {
+		return nil
+	}
+	info, err := os.Stat(conf.Aliases)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	same := c.name == conf.Aliases
+	if same && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		return c.aliases
+	}
+	if !same {
+		c.aliases = nil
+	}
+
+	data, err := os.ReadFile(conf.Aliases)
+	if err != nil {
+		return c.aliases
+	}
+	aliases, err := parseAliases(conf.Aliases, data)
+	if err != nil {
+		conf.logger().Printf("%s, keeping the previous aliases", err)
+		aliases = c.aliases
+	} else if same {
+		for _, warning := range conf.danglingAliases(aliases) {
+			conf.logger().Printf("warning: %s", warning)
+		}
+	}
+	c.name, c.size, c.modTime, c.aliases = conf.Aliases, info.Size(), info.ModTime(), aliases
+	return aliases
+}
+
+// try answers a request for an alias with a redirect to its target, or
+// returns the request for the target to be served in its place. A request
+// for a directory that holds aliases but isn't in the Dirs or FS gets a
+// table of them, when listings are enabled
+func (c *aliasesCache) try(w http.ResponseWriter, r *http.Request) (rewritten *http.Request, handled bool) {
+	conf := configFor(r)
+	aliases := c.load(conf)
+	if len(aliases) == 0 {
+		return r, false
+	}
+	if strings.HasSuffix(r.URL.Path, "/") {
+		return r, c.list(w, r, aliases)
+	}
+	for _, alias := range aliases {
+		if alias.Path != r.URL.Path {
+			continue
+		}
+		target, _ := url.Parse(alias.Target)
+		if target.RawQuery == "" {
+			target.RawQuery = r.URL.RawQuery
+		}
+		if conf.Verbose {
+			conf.logger().Printf("%s ⇢ %s → %s", remoteAddr(r), r.URL.Path, target)
+		}
+		if !alias.Serve {
+			http.Redirect(w, r, target.String(), http.StatusFound)
+			return r, true
+		}
+		if conf.BlockDotfiles && dotPath(target.Path) {
+			respondError(w, r, http.StatusForbidden, "forbidden")
+			return r, true
+		}
+		rewritten = r.Clone(r.Context())
+		rewritten.URL.Path, rewritten.URL.RawPath, rewritten.URL.RawQuery = target.Path, "", target.RawQuery
+		return rewritten, false
+	}
+	return r, false
+}
+
+// list serves the table of the aliases in the request's directory, as HTML
+// or, if requested by the Accept header, JSON
+func (c *aliasesCache) list(w http.ResponseWriter, r *http.Request, aliases []Alias) bool {
+	conf := configFor(r)
+	if conf.NoList || conf.Mounts.match(r.URL.Path) != nil {
+		return false
+	}
+	listed := []Alias{}
+	for _, alias := range aliases {
+		if strings.TrimSuffix(path.Dir(alias.Path), "/")+"/" == r.URL.Path {
+			listed = append(listed, alias)
+		}
+	}
+	if len(listed) == 0 {
+		return false
+	}
+	if found, err := isDirectory(r, conf.sources()); found || err != nil {
+		return false
+	}
+	w.Header().Add("Vary", "Accept, X-Serve-Format")
+	if conf.NoindexListings {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	}
+	if wantsJSONListing(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listed)
+		return true
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	aliasesTmpl.Execute(w, struct {
+		Dir     string
+		Aliases []Alias
+	}{r.URL.Path, listed})
+	return true
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// aliasServer serves files with an Aliases file of aliases, and logs to logs
+// if it isn't nil
+func aliasServer(t *testing.T, files map[string]string, aliases string, logs *bytes.Buffer) (*Server, string) {
+	file := filepath.Join(t.TempDir(), "aliases")
+	if err := os.WriteFile(file, []byte(aliases), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf := testConfig(writeTree(t, files))
+	conf.Aliases = file
+	conf.BlockDotfiles = true
+	if logs != nil {
+		conf.Logger = log.New(logs, "", 0)
+	}
+	return newTestServer(t, conf), file
+}
+
+func TestAliases(t *testing.T) {
+	s, _ := aliasServer(t, map[string]string{
+		"builds/2024-05-01/app.zip": "build",
+		"docs/guide.txt":            "guide",
+		".env":                      "SECRET",
+	}, `# short links
+/r/nightly  /builds/2024-05-01/app.zip
+=/guide     /docs/guide.txt
+/r/search   /docs/guide.txt?from=alias
+=/env       /.env
+`, nil)
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{"/r/nightly", 302, "/builds/2024-05-01/app.zip", ""},
+		{"/r/nightly?v=1", 302, "/builds/2024-05-01/app.zip?v=1", ""},
+		// the alias's own query wins
+		{"/r/search?q=go", 302, "/docs/guide.txt?from=alias", ""},
+		{"/guide", 200, "", "guide"},
+		{"/env", 403, "", "forbidden"},
+		{"/r/other", 404, "", ""},
+		{"/docs/guide.txt", 200, "", "guide"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := get(s, "GET", tt.path)
+			expect(t, w, tt.status, tt.body)
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestAliasesListed(t *testing.T) {
+	s, _ := aliasServer(t, map[string]string{"docs/guide.txt": "guide"}, "/r/a /docs/guide.txt\n=/r/b /docs/guide.txt\n/c /docs/guide.txt\n", nil)
+
+	var aliases []Alias
+	w := get(s, "GET", "/r/", "Accept", "application/json")
+	if err := json.Unmarshal(w.Body.Bytes(), &aliases); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	want := []Alias{{"/r/a", "/docs/guide.txt", false}, {"/r/b", "/docs/guide.txt", true}}
+	if !slices.Equal(aliases, want) {
+		t.Errorf("aliases in /r/ = %+v, want %+v", aliases, want)
+	}
+	w = get(s, "GET", "/r/")
+	expect(t, w, 200, `<td><a href="/r/b">/r/b</a></td><td>/docs/guide.txt</td><td>served</td>`)
+	// a directory that exists is listed as usual
+	if names := listing(t, s, "/").names()[0]; !slices.Equal(names, []string{"docs/"}) {
+		t.Errorf("listing of / = %v", names)
+	}
+}
+
+func TestAliasesNotListed(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "aliases")
+	os.WriteFile(file, []byte("/r/a /a.txt\n"), 0o644)
+	conf := testConfig(writeTree(t, map[string]string{"a.txt": "a"}))
+	conf.Aliases = file
+	conf.NoList = true
+	s := newTestServer(t, conf)
+	expect(t, get(s, "GET", "/r/"), 404, "")
+	expect(t, get(s, "GET", "/r/a"), 302, "")
+}
+
+func TestAliasesReloaded(t *testing.T) {
+	var logs bytes.Buffer
+	s, file := aliasServer(t, map[string]string{"v1.txt": "1", "v2.txt": "2"}, "/latest /v1.txt\n", &logs)
+	location := func() string { return get(s, "GET", "/latest").Header().Get("Location") }
+	if l := location(); l != "/v1.txt" {
+		t.Fatalf("Location = %q, want /v1.txt", l)
+	}
+
+	write := func(contents string, age time.Duration) {
+		if err := os.WriteFile(file, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(age)
+		os.Chtimes(file, modTime, modTime)
+	}
+	write("/latest /v2.txt\n", time.Hour)
+	if l := location(); l != "/v2.txt" {
+		t.Errorf("Location = %q after an edit, want /v2.txt", l)
+	}
+	// a mistake keeps the aliases that worked
+	write("/latest\n", 2*time.Hour)
+	if l := location(); l != "/v2.txt" {
+		t.Errorf("Location = %q after a bad edit, want /v2.txt still", l)
+	}
+	if !strings.Contains(logs.String(), "keeping the previous aliases") {
+		t.Errorf("the bad edit wasn't logged:\n%s", logs.String())
+	}
+	// a target that doesn't exist is still followed, and logged
+	logs.Reset()
+	write("/latest /v3.txt\n", 3*time.Hour)
+	if l := location(); l != "/v3.txt" {
+		t.Errorf("Location = %q, want /v3.txt", l)
+	}
+	if want := "warning: --aliases: the target of /latest, /v3.txt, doesn't exist"; !strings.Contains(logs.String(), want) {
+		t.Errorf("the dangling target wasn't logged:\n%s", logs.String())
+	}
+}
+
+func TestAliasesDangling(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a", "mounted/b.txt": "b"})
+	file := filepath.Join(t.TempDir(), "aliases")
+	os.WriteFile(file, []byte("/a /a.txt\n/b /m/b.txt\n/gone /gone.txt\n/m-gone /m/gone.txt\n/api /api/users\n"), 0o644)
+	conf := testConfig(dir)
+	conf.Aliases = file
+	conf.Mounts = MountList{{Prefix: "/m", Dir: filepath.Join(dir, "mounted")}}
+	conf.Proxies.Set("/api=http://127.0.0.1:1")
+	warnings, err := conf.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"--aliases: the target of /gone, /gone.txt, doesn't exist",
+		"--aliases: the target of /m-gone, /m/gone.txt, doesn't exist",
+	}
+	var got []string
+	for _, warning := range warnings {
+		if strings.HasPrefix(warning, "--aliases") {
+			got = append(got, warning)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("warnings = %q, want %q", got, want)
+	}
+}
+
+func TestParseAliases(t *testing.T) {
+	tests := []struct {
+		data, err string
+	}{
+		{"# comment\n\n/a /b\n=/c /d?x=1\n", ""},
+		{"/a\n", `f:1: expected ALIAS TARGET, got "/a"`},
+		{"/a /b /c\n", `f:1: expected ALIAS TARGET, got "/a /b /c"`},
+		{"a /b\n", `f:1: alias "a" must be a path starting with /, and not a directory`},
+		{"/a/ /b\n", `f:1: alias "/a/" must be a path starting with /, and not a directory`},
+		{"/../a /b\n", `f:1: alias "/../a" must be a path starting with /, and not a directory`},
+		{"/a https://example.com/\n", `f:1: target "https://example.com/" must be a path starting with /`},
+		{"/a //example.com/b\n", `f:1: target "//example.com/b" must be a path starting with /`},
+		{"/a /../b\n", `f:1: target "/../b" must be a path starting with /`},
+		{"/a /b\n\n/a /c\n", `f:3: alias "/a" is given more than once`},
+	}
+	for _, tt := range tests {
+		_, err := parseAliases("f", []byte(tt.data))
+		switch {
+		case err != nil && err.Error() != tt.err:
+			t.Errorf("parseAliases(%q) = %q, want %q", tt.data, err, tt.err)
+		case err == nil && tt.err != "":
+			t.Errorf("parseAliases(%q) = nil, want %q", tt.data, tt.err)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isArchive reports whether a directory to serve is a zip or tar archive by
+// its extension, unless it's a directory named like one
+func isArchive(dir string) bool {
+	lower := strings.ToLower(dir)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			stat, err := os.Stat(dir)
+			return err != nil || !stat.IsDir()
+		}
+	}
+	return false
+}
+
+// archiveEntry is a file or directory in an archive, which is its own
+// fs.FileInfo and fs.DirEntry
+type archiveEntry struct {
+	name     string
+	size     int64
+	mode     fs.FileMode
+	modTime  time.Time
+	children []*archiveEntry
+	// section is the contents of a file stored without compression, which
+	// can seek. open reads the others from the start
+	section *io.SectionReader
+	open    func() (io.ReadCloser, error)
+}
+
+func (e *archiveEntry) Name() string               { return e.name }
+func (e *archiveEntry) Size() int64                { return e.size }
+func (e *archiveEntry) Mode() fs.FileMode          { return e.mode }
+func (e *archiveEntry) ModTime() time.Time         { return e.modTime }
+func (e *archiveEntry) IsDir() bool                { return e.mode.IsDir() }
+func (e *archiveEntry) Sys() any                   { return nil }
+func (e *archiveEntry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *archiveEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+// archiveFS is the tree of an archive read into memory, with the contents of
+// its files read from the archive as they're opened. Archives inside it are
+// served as files
+type archiveFS struct {
+	entries map[string]*archiveEntry
+	// file is the archive the contents are read from, nil for a gzipped
+	// tar, whose files open it again
+	file *os.File
+
+	mu sync.Mutex
+	// open counts the files being read, the archive is closed once it is
+	// retired and the last of them is
+	open    int
+	retired bool
+}
+
+func newArchiveFS(modTime time.Time) *archiveFS {
+	root := &archiveEntry{name: ".", mode: fs.ModeDir | 0o555, modTime: modTime}
+	return &archiveFS{entries: map[string]*archiveEntry{".": root}}
+}
+
+// add puts an entry in the tree under its cleaned name, creating the
+// directories above it that the archive leaves out. Names that would be
+// outside the tree are skipped
+func (a *archiveFS) add(name string, entry *archiveEntry) {
+	name = strings.TrimPrefix(path.Clean(strings.ReplaceAll(name, `\`, "/")), "/")
+	if name == "." || !fs.ValidPath(name) {
+		return
+	}
+	if existing, ok := a.entries[name]; ok {
+		// an explicit directory entry after its files were seen
+		if existing.IsDir() && entry.IsDir() {
+			existing.modTime, existing.mode = entry.modTime, entry.mode
+		}
+		return
+	}
+	entry.name = path.Base(name)
+	a.entries[name] = entry
+	dir := path.Dir(name)
+	parent, ok := a.entries[dir]
+	if !ok {
+		parent = &archiveEntry{mode: fs.ModeDir | 0o555, modTime: entry.modTime}
+		a.add(dir, parent)
+	}
+	parent.children = append(parent.children, entry)
+}
+
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	entry, ok := a.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.IsDir() {
+		return &archiveDir{entry: entry}, nil
+	}
+	if err := a.acquire(); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if entry.section != nil {
+		return &archiveSeekable{SectionReader: io.NewSectionReader(entry.section, 0, entry.size), entry: entry, fsys: a}, nil
+	}
+	contents, err := entry.open()
+	if err != nil {
+		a.release()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &archiveFile{ReadCloser: contents, entry: entry, fsys: a}, nil
+}
+
+// acquire counts a file being opened, which fails once the archive is
+// closed
+func (a *archiveFS) acquire() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.retired && a.open == 0 {
+		return fs.ErrClosed
+	}
+	a.open++
+	return nil
+}
+
+// release counts a file being closed, closing the archive if it was the last
+// one of a retired archive
+func (a *archiveFS) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.open--
+	if a.retired && a.open == 0 && a.file != nil {
+		a.file.Close()
+	}
+}
+
+// Close retires the archive, its file is closed straight away or once the
+// files being read from it are
+func (a *archiveFS) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.retired {
+		return nil
+	}
+	a.retired = true
+	if a.open == 0 && a.file != nil {
+		return a.file.Close()
+	}
+	return nil
+}
+
+// archiveFile is a compressed file of an archive, which can only be read
+// through
+type archiveFile struct {
+	io.ReadCloser
+	entry *archiveEntry
+	fsys  *archiveFS
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+
+func (f *archiveFile) Close() error {
+	err := f.ReadCloser.Close()
+	f.fsys.release()
+	return err
+}
+
+// archiveSeekable is a file stored in an archive without compression, which
+// can be served in ranges
+type archiveSeekable struct {
+	*io.SectionReader
+	entry *archiveEntry
+	fsys  *archiveFS
+}
+
+func (f *archiveSeekable) Stat() (fs.FileInfo, error) { return f.entry, nil }
+
+func (f *archiveSeekable) Close() error {
+	f.fsys.release()
+	return nil
+}
+
+// archiveDir is a directory of an archive
+type archiveDir struct {
+	entry  *archiveEntry
+	offset int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *archiveDir) Close() error               { return nil }
+
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+func (d *archiveDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entry.children[d.offset:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.offset += len(rest)
+	entries := make([]fs.DirEntry, len(rest))
+	for i, entry := range rest {
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+// openArchive reads the tree of a zip or tar archive. Its file is kept open
+// to read the contents from until the archiveFS is closed
+func openArchive(name string) (*archiveFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var fsys *archiveFS
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		fsys, err = readZip(f, stat)
+	case strings.HasSuffix(lower, ".tar"):
+		fsys, err = readTar(f, stat)
+	default:
+		// a compressed tar can't seek, so its files are read from the
+		// start of the archive and it doesn't need to stay open
+		f.Close()
+		f = nil
+		fsys, err = readTarGz(name, stat)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fsys.file = f
+	// directories are listed in order, as they are by fs.ReadDir
+	for _, entry := range fsys.entries {
+		slices.SortFunc(entry.children, func(a, b *archiveEntry) int { return strings.Compare(a.name, b.name) })
+	}
+	return fsys, nil
+}
+
+func readZip(f *os.File, stat fs.FileInfo) (*archiveFS, error) {
+	zr, err := zip.NewReader(f, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	fsys := newArchiveFS(stat.ModTime())
+	for _, zf := range zr.File {
+		info := zf.FileInfo()
+		entry := &archiveEntry{size: int64(zf.UncompressedSize64), mode: info.Mode(), modTime: zf.Modified}
+		switch {
+		case info.IsDir():
+			entry.size = 0
+		case !info.Mode().IsRegular():
+			continue
+		case zf.Method == zip.Store:
+			offset, err := zf.DataOffset()
+			if err != nil {
+				return nil, err
+			}
+			entry.section = io.NewSectionReader(f, offset, entry.size)
+		default:
+			entry.open = zf.Open
+		}
+		fsys.add(zf.Name, entry)
+	}
+	return fsys, nil
+}
+
+// readTar reads the headers of an uncompressed tar, whose files can be read
+// in place
+func readTar(f *os.File, stat fs.FileInfo) (*archiveFS, error) {
+	fsys := newArchiveFS(stat.ModTime())
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := tarEntry(hdr)
+		if entry == nil {
+			continue
+		}
+		if !entry.IsDir() {
+			// the tar reader leaves the file at the start of the contents
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			entry.section = io.NewSectionReader(f, offset, entry.size)
+		}
+		fsys.add(hdr.Name, entry)
+	}
+}
+
+// readTarGz reads the headers of a gzipped tar. Opening a file decompresses
+// the archive again up to it, as a gzip stream can't seek
+func readTarGz(name string, stat fs.FileInfo) (*archiveFS, error) {
+	f, tr, err := openTarGz(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fsys := newArchiveFS(stat.ModTime())
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := tarEntry(hdr)
+		if entry == nil {
+			continue
+		}
+		if !entry.IsDir() {
+			entry.open = func() (io.ReadCloser, error) {
+				return tarGzFile(name, i, hdr.Name)
+			}
+		}
+		fsys.add(hdr.Name, entry)
+	}
+}
+
+func openTarGz(name string) (*os.File, *tar.Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, tar.NewReader(gz), nil
+}
+
+// tarGzFile returns the contents of the file that was the i-th entry of a
+// gzipped tar, read as the archive is decompressed. It is an error for it
+// to be another file, if the archive was replaced since it was read
+func tarGzFile(name string, i int, entryName string) (io.ReadCloser, error) {
+	f, tr, err := openTarGz(name)
+	if err != nil {
+		return nil, err
+	}
+	for j := 0; ; j++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			err = fs.ErrNotExist
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if j < i {
+			continue
+		}
+		if hdr.Name != entryName {
+			f.Close()
+			return nil, fs.ErrNotExist
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{tr, f}, nil
+	}
+}
+
+// tarEntry returns the entry for a tar header, nil for those that aren't a
+// regular file or directory, such as links
+func tarEntry(hdr *tar.Header) *archiveEntry {
+	info := hdr.FileInfo()
+	switch {
+	case info.IsDir():
+		return &archiveEntry{mode: info.Mode(), modTime: hdr.ModTime}
+	case info.Mode().IsRegular():
+		return &archiveEntry{size: hdr.Size, mode: info.Mode(), modTime: hdr.ModTime}
+	}
+	return nil
+}
+
+// cachedArchive is the tree of an archive when it had a size and
+// modification time, or the error opening it
+type cachedArchive struct {
+	size    int64
+	modTime time.Time
+	fsys    *archiveFS
+	err     error
+}
+
+// archiveCache holds the archives being served, which are opened again when
+// their size or modification time changes
+type archiveCache struct {
+	mu    sync.Mutex
+	files map[string]cachedArchive
+}
+
+func newArchiveCache() *archiveCache {
+	return &archiveCache{files: map[string]cachedArchive{}}
+}
+
+// source returns the source serving the archive at name. An archive that
+// can't be opened serves nothing, Validate reports it at startup
+func (c *archiveCache) source(name string) source {
+	src := source{name: filepath.ToSlash(name), fsys: brokenFS{}}
+	info, err := os.Stat(name)
+	if err != nil {
+		return src
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.files[name]
+	if !ok || cached.size != info.Size() || !cached.modTime.Equal(info.ModTime()) {
+		if cached.fsys != nil {
+			cached.fsys.Close()
+		}
+		fsys, err := openArchive(name)
+		cached = cachedArchive{size: info.Size(), modTime: info.ModTime(), fsys: fsys, err: err}
+		c.files[name] = cached
+	}
+	if cached.err == nil {
+		src.fsys = cached.fsys
+	}
+	return src
+}
+
+// keep closes the archives that aren't in dirs, after the configuration is
+// replaced
+func (c *archiveCache) keep(dirs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, cached := range c.files {
+		if !slices.Contains(dirs, name) {
+			if cached.fsys != nil {
+				cached.fsys.Close()
+			}
+			delete(c.files, name)
+		}
+	}
+}
+
+// brokenFS is an empty tree, for an archive that can't be read
+type brokenFS struct{}
+
+func (brokenFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeZip writes an archive of files to name, storing those ending in .bin
+// without compression
+func writeZip(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for file, contents := range files {
+		header := &zip.FileHeader{Name: file, Method: zip.Deflate, Modified: time.Now()}
+		if filepath.Ext(file) == ".bin" {
+			header.Method = zip.Store
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, contents)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+// writeTar writes a tar of files to name, gzipped if it ends in .tgz
+func writeTar(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if filepath.Ext(name) == ".tgz" {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for file, contents := range files {
+		hdr := &tar.Header{Name: file, Mode: 0o644, Size: int64(len(contents)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, contents)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeArchives(t *testing.T) {
+	files := map[string]string{"a.txt": "deflated", "sub/b.bin": "0123456789"}
+	dir := t.TempDir()
+	writers := map[string]func(*testing.T, string, map[string]string){
+		"site.zip": writeZip,
+		"site.tar": writeTar,
+		"site.tgz": writeTar,
+	}
+	for name, write := range writers {
+		t.Run(name, func(t *testing.T) {
+			archive := filepath.Join(dir, name)
+			write(t, archive, files)
+			s := newTestServer(t, testConfig(archive))
+
+			expect(t, get(s, "GET", "/a.txt"), 200, "deflated")
+			expect(t, get(s, "GET", "/sub/b.bin"), 200, "0123456789")
+			expect(t, get(s, "GET", "/sub/"), 200, "b.bin")
+			expect(t, get(s, "GET", "/missing.txt"), 404, "")
+			if name != "site.tgz" {
+				w := get(s, "GET", "/sub/b.bin", "Range", "bytes=2-4")
+				expect(t, w, 206, "234")
+			}
+		})
+	}
+}
+
+func TestArchiveTraversalEntries(t *testing.T) {
+	parent := t.TempDir()
+	archive := filepath.Join(parent, "site", "site.zip")
+	os.Mkdir(filepath.Dir(archive), 0o755)
+	writeZip(t, archive, map[string]string{
+		"../outside.txt":    "dot dot",
+		"a/../../up.txt":    "cleaned up",
+		`..\windows.txt`:    "backslashes",
+		"/absolute.txt":     "absolute",
+		"./inside/file.txt": "inside",
+	})
+	fsys, err := openArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	for name := range fsys.entries {
+		if !fs.ValidPath(name) {
+			t.Errorf("entry %q is outside the tree", name)
+		}
+	}
+	for _, name := range []string{"outside.txt", "up.txt", "windows.txt", "../outside.txt"} {
+		if _, ok := fsys.entries[name]; ok {
+			t.Errorf("entry %q outside the tree was kept", name)
+		}
+	}
+
+	s := newTestServer(t, testConfig(archive))
+	expect(t, get(s, "GET", "/absolute.txt"), 200, "absolute")
+	expect(t, get(s, "GET", "/inside/file.txt"), 200, "inside")
+	expect(t, get(s, "GET", "/outside.txt"), 404, "")
+	expect(t, get(s, "GET", "/../outside.txt"), 400, "")
+	if _, err := os.Stat(filepath.Join(parent, "outside.txt")); err == nil {
+		t.Error("an entry was written outside the archive's directory")
+	}
+}
+
+func TestArchiveReopened(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	writeZip(t, archive, map[string]string{"a.bin": "old"})
+	s := newTestServer(t, testConfig(archive))
+	expect(t, get(s, "GET", "/a.bin"), 200, "old")
+	old := s.archives.files[archive].fsys
+
+	writeZip(t, archive, map[string]string{"a.bin": "new contents"})
+	os.Chtimes(archive, time.Now(), time.Now().Add(time.Minute))
+	expect(t, get(s, "GET", "/a.bin"), 200, "new contents")
+	if _, err := old.file.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("the replaced archive is still open: %v", err)
+	}
+}
+
+func TestArchiveClosedBySetConfig(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.bin": "from the directory"})
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	writeZip(t, archive, map[string]string{"a.bin": "from the archive"})
+	s := newTestServer(t, testConfig(archive))
+	expect(t, get(s, "GET", "/a.bin"), 200, "from the archive")
+	old := s.archives.files[archive].fsys
+
+	if err := s.SetConfig(testConfig(dir)); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, get(s, "GET", "/a.bin"), 200, "from the directory")
+	if _, err := old.file.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("the archive is still open after it was removed from Dirs: %v", err)
+	}
+}
+
+func TestArchiveClosedAfterReads(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	writeZip(t, archive, map[string]string{"a.bin": "stored", "b.txt": "deflated"})
+	fsys, err := openArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := fsys.Open("a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deflated, err := fsys.Open("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files being read keep the archive open after it's retired
+	fsys.Close()
+	for _, f := range []fs.File{stored, deflated} {
+		if _, err := io.ReadAll(f); err != nil {
+			t.Errorf("reading a file of a retired archive = %v", err)
+		}
+	}
+	stored.Close()
+	if _, err := fsys.file.Stat(); err != nil {
+		t.Errorf("the archive was closed while a file was being read: %v", err)
+	}
+	deflated.Close()
+	if _, err := fsys.file.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("the archive is still open after its last file was closed: %v", err)
+	}
+	if _, err := fsys.Open("a.bin"); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("opening a file of a closed archive = %v, want fs.ErrClosed", err)
+	}
+}
+
+func TestValidateClosesArchives(t *testing.T) {
+	if _, err := os.ReadDir("/proc/self/fd"); err != nil {
+		t.Skip("can't count open files")
+	}
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	writeZip(t, archive, map[string]string{"a.bin": "a"})
+	conf := testConfig(archive)
+	before, _ := os.ReadDir("/proc/self/fd")
+	for range 20 {
+		if _, err := conf.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, _ := os.ReadDir("/proc/self/fd")
+	if len(after) > len(before)+2 {
+		t.Errorf("%d files open after validating 20 times, %d before", len(after), len(before))
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultBots are the User-Agents of the common search engine, social and
+// SEO crawlers, matched case insensitively anywhere in the header
+var defaultBots = BotList{
+	"googlebot", "bingbot", "slurp", "duckduckbot", "baiduspider",
+	"yandexbot", "applebot", "petalbot", "bytespider", "gptbot", "ccbot",
+	"facebookexternalhit", "twitterbot", "linkedinbot", "ahrefsbot",
+	"semrushbot", "mj12bot", "dotbot", "crawler", "spider",
+}
+
+// BotList is a flag.Value for --bot-ua, a comma separated list of strings
+// that a crawler's User-Agent contains, which may be given more than once.
+// They replace defaultBots:
+//
+//	--bot-ua 'googlebot,bingbot'
+type BotList []string
+
+func (l *BotList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *BotList) Set(value string) error {
+	for _, bot := range strings.Split(value, ",") {
+		bot = strings.TrimSpace(bot)
+		if bot == "" {
+			return fmt.Errorf("%q is not a list of User-Agents such as googlebot,bingbot", value)
+		}
+		*l = append(*l, strings.ToLower(bot))
+	}
+	return nil
+}
+
+// noListBot reports whether the request is from a crawler that --no-list-bots
+// keeps from seeing listings
+func noListBot(r *http.Request) bool {
+	conf := configFor(r)
+	if !conf.NoListBots {
+		return false
+	}
+	bots := conf.BotUA
+	if len(bots) == 0 {
+		bots = defaultBots
+	}
+	agent := strings.ToLower(r.UserAgent())
+	for _, bot := range bots {
+		if strings.Contains(agent, strings.ToLower(bot)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNoListBots(t *testing.T) {
+	dir := writeTree(t, map[string]string{"sub/a.txt": "a", "site/index.html": "site"})
+	conf := testConfig(dir)
+	conf.NoListBots = true
+	conf.NoindexListings = false
+	s := newTestServer(t, conf)
+
+	const googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	const firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+	// crawlers still get files and index pages, but not listings
+	expect(t, get(s, "GET", "/sub/", "User-Agent", googlebot), 403, "")
+	expect(t, get(s, "GET", "/sub/a.txt", "User-Agent", googlebot), 200, "a")
+	expect(t, get(s, "GET", "/site/", "User-Agent", googlebot), 200, "site")
+	expect(t, get(s, "GET", "/missing/", "User-Agent", googlebot), 404, "")
+
+	// everyone else is listed, with the listing kept out of search results
+	w := get(s, "GET", "/sub/", "User-Agent", firefox)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `href="/sub/a.txt"`) {
+		t.Fatalf("GET /sub/ = %d, want it listed for a browser", w.Code)
+	}
+	if tag := w.Header().Get("X-Robots-Tag"); tag != "noindex, nofollow" {
+		t.Errorf("listing X-Robots-Tag = %q, want noindex, nofollow", tag)
+	}
+	if tag := get(s, "GET", "/sub/a.txt", "User-Agent", firefox).Header().Get("X-Robots-Tag"); tag != "" {
+		t.Errorf("file X-Robots-Tag = %q, want files indexed", tag)
+	}
+
+	// --bot-ua replaces the built in list
+	conf.BotUA = BotList{"examplebot"}
+	s = newTestServer(t, conf)
+	expect(t, get(s, "GET", "/sub/", "User-Agent", "ExampleBot/1.0"), 403, "")
+	expect(t, get(s, "GET", "/sub/", "User-Agent", googlebot), 200, "")
+}
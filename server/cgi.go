@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CGIList is a flag.Value for --cgi, a comma separated list of URL prefixes
+// and file extensions that are run as CGI scripts. It may be given more than
+// once:
+//
+//	--cgi /cgi-bin/ --cgi .cgi,.pl
+type CGIList []string
+
+func (l *CGIList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *CGIList) Set(value string) error {
+	for _, rule := range strings.Split(value, ",") {
+		rule = strings.TrimSpace(rule)
+		switch {
+		case strings.HasPrefix(rule, "/"):
+			*l = append(*l, strings.TrimSuffix(path.Clean(rule), "/")+"/")
+		case strings.HasPrefix(rule, ".") && len(rule) > 1 && !strings.Contains(rule, "/"):
+			*l = append(*l, rule)
+		default:
+			return fmt.Errorf("%q is not a /prefix/ or an .extension", rule)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the file at urlPath is a CGI script, because it is
+// under one of the prefixes or has one of the extensions
+func (l CGIList) matches(urlPath string) bool {
+	if foldPaths {
+		urlPath = strings.ToLower(urlPath)
+	}
+	for _, rule := range l {
+		if foldPaths {
+			rule = strings.ToLower(rule)
+		}
+		if strings.HasPrefix(rule, "/") {
+			if strings.HasPrefix(urlPath, rule) && len(urlPath) > len(rule) {
+				return true
+			}
+		} else if path.Ext(urlPath) == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// tryCGI runs the CGI script the request path leads to, if it is in one of
+// the directories on disk in sources. The script is the shortest leading part
+// of the path that --cgi matches, the rest is passed as PATH_INFO. Scripts
+// that aren't executable are refused with a 403 so they aren't sent as files
+func tryCGI(w http.ResponseWriter, r *http.Request, sources []source) bool {
+	conf := configFor(r)
+	if len(conf.CGI) == 0 {
+		return false
+	}
+	urlPath := path.Clean("/" + r.URL.Path)
+	for end := 1; end <= len(urlPath); end++ {
+		if end < len(urlPath) && urlPath[end] != '/' {
+			continue
+		}
+		scriptPath := urlPath[:end]
+		if !conf.CGI.matches(scriptPath) {
+			continue
+		}
+		for _, src := range sources {
+			if src.dir == "" {
+				continue
+			}
+			stat, err := fs.Stat(src.fsys, fsName(scriptPath))
+			if err != nil || stat.IsDir() || ignoredFile(r, src, fsName(scriptPath), false) {
+				continue
+			}
+			if !src.contains(fsName(scriptPath)) {
+				forbidden(w, r)
+				return true
+			}
+			if !stat.Mode().IsRegular() || stat.Mode().Perm()&0111 == 0 {
+				respondError(w, r, http.StatusForbidden, "CGI script is not executable")
+				return true
+			}
+			runCGI(w, r, src.path(fsName(scriptPath)), mountPrefix(r)+scriptPath, urlPath[end:])
+			return true
+		}
+	}
+	return false
+}
+
+// runCGI runs script for the request as described by RFC 3875, in the
+// script's directory. It is killed if it runs for longer than --cgi-timeout
+// or the client goes away
+func runCGI(w http.ResponseWriter, r *http.Request, script, scriptName, pathInfo string) {
+	conf := configFor(r)
+	ctx := r.Context()
+	if conf.CGITimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.CGITimeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Dir = filepath.Dir(script)
+	cmd.Env = cgiEnv(r, script, scriptName, pathInfo)
+	cmd.Stderr = conf.logger().Writer()
+	// a script's children can hold stdout and stderr open after it's killed
+	cmd.WaitDelay = time.Second
+	if r.ContentLength != 0 {
+		cmd.Stdin = r.Body
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		conf.logger().Printf("cgi %s: %s", script, err)
+		respondError(w, r, http.StatusInternalServerError, "CGI script failed")
+		return
+	}
+	defer cmd.Wait()
+	defer stdout.Close()
+	stop := context.AfterFunc(ctx, func() { stdout.Close() })
+	defer stop()
+
+	failed := func(err error) {
+		conf.logger().Printf("cgi %s: %s", script, err)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			respondError(w, r, http.StatusGatewayTimeout, "CGI script timed out")
+		} else {
+			respondError(w, r, http.StatusInternalServerError, "CGI script failed")
+		}
+	}
+	body := bufio.NewReader(stdout)
+	header, err := textproto.NewReader(body).ReadMIMEHeader()
+	if err != nil {
+		failed(fmt.Errorf("reading headers: %w", err))
+		return
+	}
+	status := http.StatusOK
+	if s := header.Get("Status"); s != "" {
+		code, _, _ := strings.Cut(s, " ")
+		status, err = strconv.Atoi(code)
+		if err != nil || status < 100 || status > 999 {
+			failed(fmt.Errorf("invalid Status %q", s))
+			return
+		}
+		header.Del("Status")
+	} else if header.Get("Location") != "" {
+		status = http.StatusFound
+	}
+	if header.Get("Content-Type") == "" && header.Get("Location") == "" {
+		failed(errors.New("no Content-Type header"))
+		return
+	}
+
+	for key, values := range header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(status)
+	if _, err := body.WriteTo(w); err != nil {
+		conf.logger().Printf("cgi %s: %s", script, err)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		conf.logger().Printf("cgi %s: killed after %s", script, conf.CGITimeout)
+	}
+}
+
+// cgiEnv returns the environment of a CGI script, the request's meta
+// variables and PATH
+func cgiEnv(r *http.Request, script, scriptName, pathInfo string) []string {
+	// scripts build their redirects and links from the scheme and port, which
+	// are those of the proxy in front of the server if there's a trusted one
+	scheme := schemeOf(r)
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = r.Host, "80"
+		if scheme == "https" {
+			port = "443"
+		}
+	}
+	remote, remotePort, _ := net.SplitHostPort(r.RemoteAddr)
+	if addr, ok := forwardedFor(r); ok {
+		remote, remotePort = addr.String(), ""
+	}
+	env := []string{
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SERVER_SOFTWARE=" + serverHeader(),
+		"SERVER_PROTOCOL=" + r.Proto,
+		"SERVER_NAME=" + host,
+		"SERVER_PORT=" + port,
+		"REQUEST_METHOD=" + r.Method,
+		"REQUEST_SCHEME=" + scheme,
+		"REQUEST_URI=" + r.RequestURI,
+		"QUERY_STRING=" + r.URL.RawQuery,
+		"SCRIPT_NAME=" + scriptName,
+		"SCRIPT_FILENAME=" + script,
+		"PATH_INFO=" + pathInfo,
+		"REMOTE_ADDR=" + remote,
+		"REMOTE_HOST=" + remote,
+		"PATH=" + os.Getenv("PATH"),
+	}
+	if remotePort != "" {
+		env = append(env, "REMOTE_PORT="+remotePort)
+	}
+	if scheme == "https" {
+		env = append(env, "HTTPS=on")
+	}
+	if r.ContentLength > 0 {
+		env = append(env, "CONTENT_LENGTH="+strconv.FormatInt(r.ContentLength, 10))
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		env = append(env, "CONTENT_TYPE="+contentType)
+	}
+	for key, values := range r.Header {
+		// Proxy is skipped so scripts can't be pointed at a proxy through
+		// HTTP_PROXY (httpoxy)
+		if key == "Content-Type" || key == "Content-Length" || key == "Proxy" {
+			continue
+		}
+		name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		env = append(env, "HTTP_"+name+"="+strings.Join(values, ", "))
+	}
+	return env
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cgiServer serves scripts, shell scripts keyed by their path, as CGI under
+// /cgi-bin/
+func cgiServer(t *testing.T, scripts map[string]string) *Server {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the scripts are shell scripts")
+	}
+	files := map[string]string{}
+	for name, script := range scripts {
+		files[name] = "#!/bin/sh\n" + script
+	}
+	dir := writeTree(t, files)
+	for name := range scripts {
+		if err := os.Chmod(filepath.Join(dir, filepath.FromSlash(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := testConfig(dir)
+	conf.CGI = CGIList{"/cgi-bin/"}
+	conf.CGITimeout = 200 * time.Millisecond
+	return newTestServer(t, conf)
+}
+
+func TestCGI(t *testing.T) {
+	s := cgiServer(t, map[string]string{
+		"cgi-bin/env": `printf 'Content-Type: text/plain\n\n'
+echo "$REQUEST_METHOD $SCRIPT_NAME $PATH_INFO $QUERY_STRING $HTTP_X_TEST proxy=$HTTP_PROXY"`,
+		"cgi-bin/created":   `printf 'Status: 201 Created\nContent-Type: application/json\nX-Id: 7\n\n{}'`,
+		"cgi-bin/moved":     `printf 'Location: /elsewhere\n\n'`,
+		"cgi-bin/untyped":   `printf 'X-Only: this\n\nbody'`,
+		"cgi-bin/crash":     `echo oops >&2; exit 1`,
+		"cgi-bin/late-exit": `printf 'Content-Type: text/plain\n\npartial'; exit 3`,
+		"cgi-bin/bad":       `printf 'Status: teapot\nContent-Type: text/plain\n\n'`,
+		"cgi-bin/slow":      `exec sleep 10`,
+	})
+
+	tests := []struct {
+		method, path string
+		headers      []string
+		status       int
+		body         string
+	}{
+		{"GET", "/cgi-bin/env/extra/path?q=1", []string{"X-Test", "yes", "Proxy", "http://evil"}, 200, "GET /cgi-bin/env /extra/path q=1 yes proxy=\n"},
+		{"GET", "/cgi-bin/created", nil, 201, "{}"},
+		{"GET", "/cgi-bin/moved", nil, 302, ""},
+		{"GET", "/cgi-bin/untyped", nil, 500, "CGI script failed"},
+		// a script that exits without writing headers failed
+		{"GET", "/cgi-bin/crash", nil, 500, "CGI script failed"},
+		// once the headers are out the response can't change
+		{"GET", "/cgi-bin/late-exit", nil, 200, "partial"},
+		{"GET", "/cgi-bin/bad", nil, 500, "CGI script failed"},
+		{"GET", "/cgi-bin/slow", nil, 504, "CGI script timed out"},
+		{"GET", "/cgi-bin/missing", nil, 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := get(s, tt.method, tt.path, tt.headers...)
+			expect(t, w, tt.status, tt.body)
+		})
+	}
+	if w := get(s, "GET", "/cgi-bin/created"); w.Header().Get("X-Id") != "7" || w.Header().Get("Status") != "" {
+		t.Errorf("headers = %v, want the script's without Status", w.Header())
+	}
+	if w := get(s, "GET", "/cgi-bin/moved"); w.Header().Get("Location") != "/elsewhere" {
+		t.Errorf("Location = %q", w.Header().Get("Location"))
+	}
+}
+
+func TestCGIRequestBody(t *testing.T) {
+	s := cgiServer(t, map[string]string{"cgi-bin/echo": `printf 'Content-Type: text/plain\n\n'
+echo "$CONTENT_LENGTH $CONTENT_TYPE"
+cat`})
+	r := httptest.NewRequest("POST", "/cgi-bin/echo", strings.NewReader("name=value"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	expect(t, w, 200, "10 application/x-www-form-urlencoded\nname=value")
+}
+
+func TestCGINotExecutable(t *testing.T) {
+	dir := writeTree(t, map[string]string{"cgi-bin/script": "#!/bin/sh\necho source"})
+	conf := testConfig(dir)
+	conf.CGI = CGIList{"/cgi-bin/"}
+	s := newTestServer(t, conf)
+
+	// the script's source isn't sent as a file either
+	w := get(s, "GET", "/cgi-bin/script")
+	expect(t, w, 403, "CGI script is not executable")
+	if strings.Contains(w.Body.String(), "source") {
+		t.Error("sent the script's source")
+	}
+}
+
+func TestCGIListSet(t *testing.T) {
+	var l CGIList
+	for _, value := range []string{"/cgi-bin", ".cgi, .pl"} {
+		if err := l.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l.String() != "/cgi-bin/,.cgi,.pl" {
+		t.Errorf("String = %q", l.String())
+	}
+	for _, value := range []string{"cgi-bin", ".", "./x", ""} {
+		if err := new(CGIList).Set(value); err == nil {
+			t.Errorf("Set(%q) = nil, want an error", value)
+		}
+	}
+
+	for urlPath, want := range map[string]bool{
+		"/cgi-bin/run": true,
+		"/cgi-bin/":    false,
+		"/cgi-binx/a":  false,
+		"/app/x.cgi":   true,
+		"/app/x.cgix":  false,
+		"/app/x.pl":    true,
+	} {
+		if got := l.matches(urlPath); got != want {
+			t.Errorf("matches(%q) = %v, want %v", urlPath, got, want)
+		}
+	}
+}
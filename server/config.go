@@ -0,0 +1,569 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings of a Server. The field names match the serve
+// command's long options, see its --help for what each one does
+type Config struct {
+	// Dirs are the directories served, overlaid in order so that a file in
+	// the first one hides the same file in the others
+	Dirs []string
+	// FS are served after Dirs, for files that aren't on disk
+	FS   []FS
+	Host string
+	Port string
+	// Listen replaces Host and Port if it is set, serving on each address.
+	// CertFile and KeyFile are used by those with TLS
+	Listen   ListenerList
+	CertFile string
+	KeyFile  string
+	// TCPKeepAlive is the keep-alive period of accepted connections, 0 uses
+	// Go's default of 15s and a negative period disables keep-alives
+	TCPKeepAlive time.Duration
+	// ReusePort sets SO_REUSEPORT on the listeners where the platform
+	// supports it, so that several servers can share a port
+	ReusePort bool
+	// Index is served for the paths that aren't found, the first of them
+	// that exists if it's a comma separated list
+	Index string
+	// NoMissCache stops paths that weren't found being remembered for a few
+	// seconds, which saves looking for them again in every directory before
+	// falling back to Index
+	NoMissCache bool
+	HTTP2       bool
+	// ErrorPages are served in place of the plain text error responses
+	ErrorPages ErrorPageList
+	// NoList disables directory listings, MaxEntries limits how many
+	// entries they show if it's more than 0
+	NoList     bool
+	MaxEntries int
+	// MergeListings lists the same directory in every one of Dirs and FS as
+	// one, showing where each entry is from, rather than one after another
+	MergeListings bool
+	// IndexPriority picks what a request for a directory gets when it could be
+	// listed: "dir" its index.html, "global" the Index page, or by default
+	// "list" its listing
+	IndexPriority string
+	// AutoIndex answers a request for a directory without an index.html
+	// when NoList is set: "list" lists it anyway, "forbidden" is a 403. It is
+	// a 404 if empty, the same as a directory that doesn't exist
+	AutoIndex string
+	// NoListBots keeps the crawlers whose User-Agent contains one of BotUA,
+	// or a common one if it's empty, from seeing listings. They still get
+	// files, and a 403 for a directory without an index.html
+	NoListBots bool
+	BotUA      BotList
+	// NoindexListings asks search engines not to index listings or follow
+	// their links with an X-Robots-Tag, it is on by default
+	NoindexListings bool
+	// GroupBy is "type" to group listings by the kind of file, or empty
+	GroupBy string
+	// AllowForceList lets ?list show a listing where NoList or an
+	// index.html would otherwise hide it
+	AllowForceList bool
+	// BlockDotfiles refuses every path with an element starting with a dot,
+	// such as /.env or /.git/config, with a 403 and leaves them out of
+	// listings
+	BlockDotfiles bool
+	NoSniff       bool
+	// CanonicalPaths redirects GET and HEAD requests for paths with repeated
+	// slashes or . elements to the clean path, and those for a directory
+	// without a trailing slash or a file with one to the path that has it
+	// right, so that each file has one URL
+	CanonicalPaths bool
+	// Types override the Content-Type of files by name, DefaultType is the
+	// type of those without an extension that no override matches
+	Types       TypeList
+	DefaultType string
+	NoIndex     bool
+	NoKeepAlive bool
+	NoKeyNav    bool
+	Title       string
+	Verbose     bool
+	Favicon     string
+	Mounts      MountList
+	// CGI are the URL prefixes and extensions of scripts that are run
+	// rather than served, CGITimeout kills those that run for longer
+	CGI        CGIList
+	CGITimeout time.Duration
+	// Mock answers requests under MockPrefix, /api by default, with the
+	// fixtures in the Mock directory. Requests without one are a 404 unless
+	// MockFallthrough is set
+	Mock            string
+	MockPrefix      string
+	MockFallthrough bool
+	// Proxies forward the requests under their prefixes, ahead of Mounts
+	// and Dirs
+	Proxies        ProxyList
+	NoFavicon      bool
+	SlowThreshold  time.Duration
+	SlowTTFB       bool
+	Otel           bool
+	TrustedProxies PrefixList
+	Track404s      bool
+	TrackHits      bool
+	StatsFile      string
+	// Strict makes directories that can't be served an error rather than a
+	// warning
+	Strict         bool
+	RecentRequests int
+	FSTimeout      time.Duration
+	// ReadHeaderTimeout stops slow clients holding connections open by
+	// dripping their request headers
+	ReadHeaderTimeout time.Duration
+	// Share is a single file or directory to serve at a random link instead
+	// of Dirs, see Server.ShareLink. Track404s, TrackHits, StatsFile and
+	// RecentRequests are ignored with it, as their reports list the link
+	Share string
+	// LiveReload reloads pages in the browser when a file in Dirs or a
+	// mount changes
+	LiveReload bool
+	// SSI processes server-side includes in files with the comma separated
+	// extensions in SSIExt, .shtml if it's empty
+	SSI    bool
+	SSIExt string
+	// Follow allows ?follow on a file, streaming what is appended to it
+	// like tail -f
+	Follow bool
+	// ShareSecret signs the links made by /_sign and Server.SignLink, which
+	// download files from mounts with auth without the credentials until
+	// they expire
+	ShareSecret string
+	// Throttle slows the body of each file response down to this many bytes
+	// a second, to try out slow connections
+	Throttle Rate
+	// FailRate is the fraction of requests answered with FailStatus, 500 by
+	// default, limited to those matching FailPaths if there are any
+	FailRate   float64
+	FailStatus int
+	FailPaths  GlobList
+	// Delay is waited before answering each request for files, listings,
+	// proxies and mocks
+	Delay Delay
+	// MTime is the modification time sent for files, their own by default
+	MTime ModTime
+	// MaxLargeTransfers limits how many files larger than LargeThreshold,
+	// 100MiB by default, are sent at once. Requests over the limit wait up
+	// to LargeTransferWait for one to finish, then get a 503
+	MaxLargeTransfers int
+	LargeThreshold    ByteSize
+	LargeTransferWait time.Duration
+	// Precompress serves gzipped copies of compressible files, made when
+	// they are first requested, or at startup with PrecompressEager, and
+	// kept in CacheDir, the user cache directory if it's empty
+	Precompress      bool
+	PrecompressEager bool
+	CacheDir         string
+	// CORS are the origins allowed to make cross-origin requests,
+	// CORSCredentials allows them to send cookies and authorization
+	CORS            OriginList
+	CORSCredentials bool
+	// LangNegotiate serves the variant of a directory's index.html, or of a
+	// file that doesn't exist, in the language the client prefers, such as
+	// index.de.html, falling back to DefaultLang's
+	LangNegotiate bool
+	DefaultLang   string
+	// DigestHeader sends the SHA-256 of files in Repr-Digest and Digest
+	DigestHeader bool
+	// Manifest serves the hash of every file at /__manifest.json
+	Manifest bool
+	// Headers are added to every response
+	Headers http.Header
+	// HeadersFile is a Netlify style _headers file of headers to send for
+	// the paths matching its patterns, after those of the _headers at the
+	// root of the Dirs and FS. Each overrides Headers
+	HeadersFile string
+	// Rewrites serve paths matching a regular expression as another path,
+	// without the client knowing
+	Rewrites RewriteList
+	// Redirects send the paths matching them elsewhere, ahead of anything
+	// else, followed by those in RedirectFile
+	Redirects    RedirectList
+	RedirectFile string
+	// redirectFileRules are the rules read from RedirectFile
+	redirectFileRules RedirectList
+	// archives are the archives in Dirs opened by the Server
+	archives *archiveCache
+	// RedirectsFile is a Netlify style _redirects file of paths to redirect
+	// or rewrite, after those in the _redirects at the root of the Dirs and
+	// FS
+	RedirectsFile string
+	// MirrorCache keeps the files fetched from the URLs in Dirs under this
+	// directory, revalidating them with the mirror each time they're
+	// requested. MirrorMaxSize limits the size of those kept, and
+	// MirrorStale serves them when the mirror can't be reached
+	MirrorCache   string
+	MirrorMaxSize ByteSize
+	MirrorStale   bool
+	// MirrorTimeout is how long a mirror has to start answering, 0 for no
+	// limit
+	MirrorTimeout time.Duration
+	// Aliases is a file of short paths that redirect to or serve others,
+	// read again when it changes
+	Aliases string
+	// Logger is used for the server's logs, the standard logger if nil
+	Logger *log.Logger
+}
+
+// DefaultConfig returns the configuration the serve command starts from,
+// serving the current directory on localhost:8080
+func DefaultConfig() Config {
+	return Config{
+		Dirs:              []string{"."},
+		Host:              "localhost",
+		Port:              "8080",
+		Title:             "Index of",
+		RecentRequests:    500,
+		ReadHeaderTimeout: 10 * time.Second,
+		CGITimeout:        30 * time.Second,
+		NoindexListings:   true,
+		MirrorTimeout:     10 * time.Second,
+	}
+}
+
+// logger returns the Logger to log to
+func (c *Config) logger() *log.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return log.Default()
+}
+
+// configKey is the request context key of the configuration snapshot
+type configKey struct{}
+
+// withConfig attaches conf to the request, for configFor
+func withConfig(r *http.Request, conf *Config) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), configKey{}, conf))
+}
+
+// configFor returns the configuration the request is being served with, so
+// that it sees the same settings from start to finish even if the server is
+// reconfigured meanwhile
+func configFor(r *http.Request) *Config {
+	return r.Context().Value(configKey{}).(*Config)
+}
+
+// Validate checks the configuration, so that typos fail at startup rather
+// than as confusing behaviour later. Directories that can't be read are
+// returned as warnings unless Strict is set
+func (c *Config) Validate() (warnings []string, err error) {
+	errs := []error{}
+	invalid := func(name, value, reason string) {
+		errs = append(errs, fmt.Errorf("invalid value %q for flag --%s: %s", value, name, reason))
+	}
+
+	if !ValidPort(c.Port) {
+		invalid("port", c.Port, "must be a number from 1 to 65535")
+	}
+	useTLS := false
+	for _, l := range c.Listen {
+		useTLS = useTLS || l.TLS
+	}
+	switch {
+	case useTLS && (c.CertFile == "" || c.KeyFile == ""):
+		errs = append(errs, errors.New("--listen with tls needs --cert and --key"))
+	case useTLS:
+		if _, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+			errs = append(errs, fmt.Errorf("--cert and --key: %v", err))
+		}
+	case c.CertFile != "" || c.KeyFile != "":
+		warnings = append(warnings, "--cert and --key have no effect without --listen ADDRESS,tls")
+	}
+	if c.ReusePort && !reusePortSupported {
+		warnings = append(warnings, fmt.Sprintf("--reuse-port is not supported on %s, ignoring it", runtime.GOOS))
+	}
+	// whether the name resolves is left to the command, so setting the
+	// configuration doesn't wait on DNS
+	if c.Host != "" && net.ParseIP(c.Host) == nil && !validHostname(c.Host) {
+		invalid("host", c.Host, "not an IP address or a host name")
+	}
+	for _, file := range []struct{ name, value string }{
+		{"favicon", c.Favicon},
+		{"headers-file", c.HeadersFile},
+		{"redirects-file", c.RedirectsFile},
+		{"aliases", c.Aliases},
+	} {
+		if file.value == "" {
+			continue
+		}
+		if stat, err := os.Stat(file.value); err != nil {
+			invalid(file.name, file.value, "no such file")
+		} else if stat.IsDir() {
+			invalid(file.name, file.value, "is a directory")
+		}
+	}
+	if c.Index != "" {
+		found := false
+		for _, file := range c.indexFiles() {
+			src, name := c.indexSource(file)
+			stat, err := fs.Stat(src.fsys, name)
+			if err == nil && stat.IsDir() {
+				invalid("index", file, "is a directory")
+			}
+			found = found || err == nil
+		}
+		if !found {
+			invalid("index", c.Index, "no such file")
+		}
+	}
+	if err := c.checkHeadersFiles(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.checkRedirectsFiles(); err != nil {
+		errs = append(errs, err)
+	}
+	if dangling, err := c.checkAliases(); err != nil {
+		errs = append(errs, err)
+	} else {
+		warnings = append(warnings, dangling...)
+	}
+	if _, err := readRedirectFile(c.RedirectFile); err != nil {
+		errs = append(errs, fmt.Errorf("--redirect-file: %w", err))
+	}
+	for _, page := range c.ErrorPages {
+		if stat, err := os.Stat(page.File); err != nil {
+			invalid("error-page", page.String(), "no such file")
+		} else if stat.IsDir() {
+			invalid("error-page", page.String(), "is a directory")
+		}
+	}
+	if c.MaxEntries < 0 {
+		invalid("max-entries", strconv.Itoa(c.MaxEntries), "must not be negative")
+	}
+	switch {
+	case c.IndexPriority != "" && c.IndexPriority != "dir" && c.IndexPriority != "global" && c.IndexPriority != "list":
+		invalid("index-priority", c.IndexPriority, "must be dir, global or list")
+	case c.IndexPriority == "global" && c.Index == "":
+		warnings = append(warnings, "--index-priority global has no effect without --index")
+	case c.IndexPriority != "" && c.IndexPriority != "list" && c.NoList:
+		warnings = append(warnings, "--index-priority has no effect with --no-list, directories aren't listed")
+	}
+	if c.AutoIndex != "" && c.AutoIndex != "list" && c.AutoIndex != "forbidden" {
+		invalid("auto-index", c.AutoIndex, "must be list or forbidden")
+	} else if c.AutoIndex != "" && !c.NoList && !slices.ContainsFunc(c.Mounts, func(m Mount) bool { return m.NoList }) {
+		warnings = append(warnings, "--auto-index has no effect without --no-list or a --mount with nolist")
+	}
+	hasMirrors := slices.ContainsFunc(c.Dirs, isMirror)
+	switch {
+	case c.MirrorTimeout < 0:
+		invalid("mirror-timeout", c.MirrorTimeout.String(), "must not be negative")
+	case !hasMirrors && (c.MirrorCache != "" || c.MirrorStale || c.MirrorMaxSize != 0):
+		warnings = append(warnings, "--mirror-cache, --mirror-stale and --mirror-max-size have no effect without a URL to mirror")
+	case c.MirrorCache == "" && (c.MirrorStale || c.MirrorMaxSize != 0):
+		warnings = append(warnings, "--mirror-stale and --mirror-max-size have no effect without --mirror-cache")
+	}
+	if len(c.BotUA) > 0 && !c.NoListBots {
+		warnings = append(warnings, "--bot-ua has no effect without --no-list-bots")
+	}
+	if c.GroupBy != "" && c.GroupBy != "none" && c.GroupBy != "type" {
+		invalid("group-by", c.GroupBy, "must be type or none")
+	}
+	if c.ReadHeaderTimeout < 0 {
+		invalid("read-header-timeout", c.ReadHeaderTimeout.String(), "must not be negative")
+	}
+	if c.CGITimeout < 0 {
+		invalid("cgi-timeout", c.CGITimeout.String(), "must not be negative")
+	}
+	for _, ext := range c.ssiExtensions() {
+		if !strings.HasPrefix(ext, ".") || len(ext) == 1 || strings.Contains(ext, "/") {
+			invalid("ssi-ext", c.SSIExt, "must be a comma separated list of extensions such as .shtml")
+			break
+		}
+	}
+	if c.Mock != "" {
+		if stat, err := os.Stat(c.Mock); err != nil {
+			invalid("mock", c.Mock, "no such directory")
+		} else if !stat.IsDir() {
+			invalid("mock", c.Mock, "is not a directory")
+		}
+	} else if c.MockPrefix != "" || c.MockFallthrough {
+		warnings = append(warnings, "--mock-prefix and --mock-fallthrough have no effect without --mock")
+	}
+	if c.MockPrefix != "" && !strings.HasPrefix(c.MockPrefix, "/") {
+		invalid("mock-prefix", c.MockPrefix, "must start with /")
+	}
+	if c.ShareSecret != "" && !slices.ContainsFunc(c.Mounts, func(m Mount) bool { return m.Auth != "" }) {
+		warnings = append(warnings, "--share-secret has no effect without a --mount with auth")
+	}
+	if c.DefaultLang != "" && !langTag.MatchString(c.DefaultLang) {
+		errs = append(errs, fmt.Errorf("--default-lang %q is not a language tag such as en or pt-BR", c.DefaultLang))
+	} else if c.DefaultLang != "" && !c.LangNegotiate {
+		warnings = append(warnings, "--default-lang has no effect without --lang-negotiate")
+	}
+	if c.DefaultType != "" {
+		if _, _, err := mime.ParseMediaType(c.DefaultType); err != nil {
+			errs = append(errs, fmt.Errorf("--default-type %q is not a content type: %s", c.DefaultType, err))
+		}
+	}
+	if c.FailRate < 0 || c.FailRate > 1 {
+		errs = append(errs, fmt.Errorf("--fail-rate %g must be between 0 and 1", c.FailRate))
+	}
+	if c.FailStatus != 0 && (c.FailStatus < 400 || c.FailStatus > 599) {
+		errs = append(errs, fmt.Errorf("--fail-status %d must be an error status, from 400 to 599", c.FailStatus))
+	}
+	if c.FailRate == 0 && (c.FailStatus != 0 || len(c.FailPaths) > 0) {
+		warnings = append(warnings, "--fail-status and --fail-path have no effect without --fail-rate")
+	}
+	if c.MaxLargeTransfers < 0 {
+		errs = append(errs, errors.New("--max-large-transfers can't be negative"))
+	} else if c.MaxLargeTransfers == 0 && (c.LargeThreshold != 0 || c.LargeTransferWait != 0) {
+		warnings = append(warnings, "--large-threshold and --large-transfer-wait have no effect without --max-large-transfers")
+	}
+	if c.Precompress {
+		if _, err := c.cacheDir(); err != nil {
+			errs = append(errs, errors.New("--precompress needs --cache-dir, there is no user cache directory"))
+		}
+	} else if c.PrecompressEager || c.CacheDir != "" {
+		warnings = append(warnings, "--precompress-eager and --cache-dir have no effect without --precompress")
+	}
+	if c.CORSCredentials && len(c.CORS) == 0 {
+		warnings = append(warnings, "--cors-credentials has no effect without --cors")
+	}
+	if c.SSIExt != "" && !c.SSI {
+		warnings = append(warnings, "--ssi-ext has no effect without --ssi")
+	}
+	if c.RecentRequests < 0 {
+		invalid("recent-requests", strconv.Itoa(c.RecentRequests), "must not be negative")
+	}
+	for _, conflict := range conflicts {
+		if conflict.applies(c) {
+			errs = append(errs, fmt.Errorf("--%s can't be used with --%s: %s", conflict.flag, conflict.with, conflict.reason))
+		}
+	}
+	if c.SlowTTFB && c.SlowThreshold == 0 {
+		warnings = append(warnings, "--slow-ttfb has no effect without --slow-threshold")
+	}
+	if c.Share != "" {
+		if _, err := os.Stat(c.Share); err != nil {
+			errs = append(errs, fmt.Errorf("can't share %q: no such file", c.Share))
+		}
+	}
+
+	for _, f := range c.FS {
+		if f.FS == nil {
+			errs = append(errs, fmt.Errorf("FS %q is nil", f.Name))
+		}
+	}
+
+	dirs := slices.Clone(c.Dirs)
+	for _, m := range c.Mounts {
+		if m.FS == nil {
+			dirs = append(dirs, m.Dir)
+		}
+	}
+	if err := c.checkMirrors(); err != nil {
+		errs = append(errs, err)
+	}
+	for i, dir := range dirs {
+		problem := ""
+		if i < len(c.Dirs) && isMirror(dir) {
+			continue
+		}
+		if stat, err := os.Stat(dir); err != nil {
+			problem = "does not exist"
+		} else if i < len(c.Dirs) && isArchive(dir) {
+			if fsys, err := openArchive(dir); err != nil {
+				problem = fmt.Sprintf("is not a readable archive: %s", err)
+			} else {
+				fsys.Close()
+			}
+		} else if !stat.IsDir() {
+			problem = "is not a directory"
+		} else if f, err := os.Open(dir); err != nil {
+			problem = "is not readable"
+		} else {
+			f.Close()
+		}
+		switch {
+		case problem == "":
+		case c.Strict:
+			errs = append(errs, fmt.Errorf("directory %q %s", dir, problem))
+		default:
+			warnings = append(warnings, fmt.Sprintf("directory %q %s", dir, problem))
+		}
+	}
+	return warnings, errors.Join(errs...)
+}
+
+// conflicts are the settings that can't be used together, which are an error
+// rather than one of them being silently ignored
+var conflicts = []struct {
+	flag, with string
+	reason     string
+	applies    func(c *Config) bool
+}{
+	{"favicon", "no-favicon", "the favicon would never be served", func(c *Config) bool {
+		return c.Favicon != "" && c.NoFavicon
+	}},
+	{"max-entries", "no-list", "there are no listings to limit, unless --allow-force-list is set", func(c *Config) bool {
+		return c.MaxEntries > 0 && c.NoList && !c.AllowForceList
+	}},
+	{"group-by", "no-list", "there are no listings to group, unless --allow-force-list is set", func(c *Config) bool {
+		return c.GroupBy != "" && c.GroupBy != "none" && c.NoList && !c.AllowForceList
+	}},
+	{"mount", "share", "only the shared file is served", func(c *Config) bool {
+		return len(c.Mounts) > 0 && c.Share != ""
+	}},
+	{"index", "share", "only the shared file is served", func(c *Config) bool {
+		return c.Index != "" && c.Share != ""
+	}},
+	{"cgi", "share", "only the shared file is served", func(c *Config) bool {
+		return len(c.CGI) > 0 && c.Share != ""
+	}},
+	{"proxy", "share", "only the shared file is served", func(c *Config) bool {
+		return len(c.Proxies) > 0 && c.Share != ""
+	}},
+	{"cors-credentials", "cors *", "browsers refuse credentials for any origin, list the origins instead", func(c *Config) bool {
+		return c.CORSCredentials && slices.Contains(c.CORS, "*")
+	}},
+	{"mock", "share", "only the shared file is served", func(c *Config) bool {
+		return c.Mock != "" && c.Share != ""
+	}},
+	{"ssi", "share", "only the shared file is served", func(c *Config) bool {
+		return c.SSI && c.Share != ""
+	}},
+}
+
+// ValidPort reports whether port is a TCP port number
+func ValidPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 1 && n <= 65535
+}
+
+// validHostname reports whether host is spelled as a host name can be:
+// labels of letters, digits, hyphens and underscores, without a hyphen at
+// either end, separated by dots
+func validHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
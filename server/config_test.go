@@ -0,0 +1,54 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateHost(t *testing.T) {
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"localhost", true},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"example.com.", true},
+		{"my_host.local", true},
+		// the name isn't looked up, so New and SetConfig don't wait on DNS
+		{"no-such-host.invalid", true},
+		{"bad host", false},
+		{"-leading.example", false},
+		{"trailing-.example", false},
+		{"a..b", false},
+		{strings.Repeat("a", 64) + ".example", false},
+		{"example.com:8080", false},
+	}
+	for _, tt := range tests {
+		conf := testConfig(t.TempDir())
+		conf.Host = tt.host
+		_, err := conf.Validate()
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("Validate with --host %q = %v, want ok %v", tt.host, err, tt.ok)
+		}
+		if err != nil && !strings.Contains(err.Error(), "--host") {
+			t.Errorf("error for --host %q doesn't name the flag: %v", tt.host, err)
+		}
+	}
+}
+
+// the mounts checked along with Dirs mustn't be written into the spare
+// capacity of the caller's slice
+func TestValidateKeepsDirs(t *testing.T) {
+	dirs := make([]string, 1, 2)
+	dirs[0] = t.TempDir()
+	conf := testConfig()
+	conf.Dirs = dirs
+	conf.Mounts = MountList{{Prefix: "/m", Dir: t.TempDir()}}
+	if _, err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if spare := dirs[:2][1]; spare != "" {
+		t.Errorf("Validate wrote %q past the end of Dirs", spare)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	// corsMethods are allowed by preflights, proxies, mocks and CGI scripts
+	// may answer more than files do
+	corsMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+	// corsMaxAge is how long browsers may cache a preflight, in seconds
+	corsMaxAge = "600"
+)
+
+// OriginList is a flag.Value for --cors, a comma separated list of the
+// origins allowed to make cross-origin requests. * allows any origin, and
+// *. at the start of a host allows its subdomains. It may be given more than
+// once:
+//
+//	--cors http://localhost:3000,https://*.example.com
+type OriginList []string
+
+func (l *OriginList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *OriginList) Set(value string) error {
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "*" {
+			u, err := url.Parse(strings.Replace(origin, "*.", "x.", 1))
+			if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || strings.Count(origin, "*") > 1 ||
+				strings.Contains(origin, "*") && !strings.Contains(origin, "://*.") {
+				return fmt.Errorf("%q is not *, or an origin such as https://example.com or https://*.example.com", origin)
+			}
+		}
+		*l = append(*l, origin)
+	}
+	return nil
+}
+
+// allows reports whether requests from origin are allowed
+func (l OriginList) allows(origin string) bool {
+	for _, allowed := range l {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://"); ok &&
+			strings.HasSuffix(rest, "."+strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// setCORS adds the CORS headers to every response to a request from an origin
+// allowed by --cors, so that files, listings, errors and the /_ endpoints
+// can all be read cross-origin. Preflights are answered without reaching the
+// files, returning true
+func setCORS(w http.ResponseWriter, r *http.Request) bool {
+	conf := configFor(r)
+	if len(conf.CORS) == 0 {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	anyOrigin := slices.Contains(conf.CORS, "*") && !conf.CORSCredentials
+	if !anyOrigin {
+		w.Header().Add("Vary", "Origin")
+	}
+	if origin == "" || !conf.CORS.allows(origin) {
+		return false
+	}
+
+	if anyOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if conf.CORSCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	method := r.Header.Get("Access-Control-Request-Method")
+	if r.Method != http.MethodOptions || method == "" {
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range")
+		return false
+	}
+
+	w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+	w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// corsServer serves a file, allowing requests from origins
+func corsServer(t *testing.T, credentials bool, origins ...string) *Server {
+	conf := testConfig(writeTree(t, map[string]string{"a.txt": "a"}))
+	conf.CORS = origins
+	conf.CORSCredentials = credentials
+	return newTestServer(t, conf)
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		origin      string
+		path        string
+		allow       string
+		vary        string
+	}{
+		{"listed", []string{"http://localhost:3000"}, false, "http://localhost:3000", "/a.txt", "http://localhost:3000", "Origin"},
+		{"not listed", []string{"http://localhost:3000"}, false, "http://evil.com", "/a.txt", "", "Origin"},
+		{"no origin", []string{"http://localhost:3000"}, false, "", "/a.txt", "", "Origin"},
+		{"any", []string{"*"}, false, "http://evil.com", "/a.txt", "*", ""},
+		{"credentials", []string{"http://localhost:3000"}, true, "http://localhost:3000", "/a.txt", "http://localhost:3000", "Origin"},
+		{"subdomain", []string{"https://*.example.com"}, false, "https://app.Example.com", "/a.txt", "https://app.Example.com", "Origin"},
+		{"not a subdomain", []string{"https://*.example.com"}, false, "https://example.com", "/a.txt", "", "Origin"},
+		{"other scheme", []string{"https://*.example.com"}, false, "http://app.example.com", "/a.txt", "", "Origin"},
+		{"lookalike", []string{"https://*.example.com"}, false, "https://app.notexample.com", "/a.txt", "", "Origin"},
+		// errors can be read too
+		{"error", []string{"*"}, false, "http://a.com", "/missing", "*", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := corsServer(t, tt.credentials, tt.origins...)
+			w := get(s, "GET", tt.path, "Origin", tt.origin)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allow)
+			}
+			if got := w.Header().Get("Vary"); got != tt.vary {
+				t.Errorf("Vary = %q, want %q", got, tt.vary)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); (got == "true") != (tt.credentials && tt.allow != "") {
+				t.Errorf("Access-Control-Allow-Credentials = %q", got)
+			}
+			if tt.allow != "" && w.Header().Get("Access-Control-Expose-Headers") == "" {
+				t.Error("no Access-Control-Expose-Headers")
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s := corsServer(t, false, "http://localhost:3000")
+
+	w := get(s, "OPTIONS", "/a.txt", "Origin", "http://localhost:3000",
+		"Access-Control-Request-Method", "PUT", "Access-Control-Request-Headers", "X-Token")
+	expect(t, w, 204, "")
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "http://localhost:3000",
+		"Access-Control-Allow-Methods": corsMethods,
+		"Access-Control-Allow-Headers": "X-Token",
+		"Access-Control-Max-Age":       corsMaxAge,
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if vary := strings.Join(w.Header().Values("Vary"), ", "); !strings.Contains(vary, "Access-Control-Request-Method") {
+		t.Errorf("Vary = %q", vary)
+	}
+
+	// a preflight from an origin that isn't allowed gets the plain OPTIONS
+	// answer, without the CORS headers
+	w = get(s, "OPTIONS", "/a.txt", "Origin", "http://evil.com", "Access-Control-Request-Method", "PUT")
+	expect(t, w, 204, "")
+	if w.Header().Get("Access-Control-Allow-Methods") != "" || w.Header().Get("Allow") != staticMethods {
+		t.Errorf("headers = %v", w.Header())
+	}
+}
+
+func TestCORSCredentialsNeedOrigins(t *testing.T) {
+	conf := testConfig(t.TempDir())
+	conf.CORS = OriginList{"*"}
+	conf.CORSCredentials = true
+	if _, err := New(conf); err == nil || !strings.Contains(err.Error(), "--cors-credentials") {
+		t.Errorf("New = %v, want --cors-credentials with --cors * refused", err)
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	s := newTestServer(t, testConfig(writeTree(t, map[string]string{"a.txt": "a"})))
+	w := get(s, "GET", "/a.txt", "Origin", "http://localhost:3000")
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Vary") != "" {
+		t.Errorf("headers = %v, want no CORS headers without --cors", w.Header())
+	}
+}
+
+func TestOriginListSet(t *testing.T) {
+	var l OriginList
+	if err := l.Set("http://localhost:3000/, https://*.example.com,*"); err != nil {
+		t.Fatal(err)
+	}
+	if l.String() != "http://localhost:3000,https://*.example.com,*" {
+		t.Errorf("String = %q", l.String())
+	}
+	for _, value := range []string{"localhost:3000", "http://", "https://example.com/path", "https://*.*.example.com", "https://a*.example.com", "*.example.com"} {
+		if err := new(OriginList).Set(value); err == nil {
+			t.Errorf("Set(%q) = nil, want an error", value)
+		}
+	}
+}
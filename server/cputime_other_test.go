@@ -0,0 +1,10 @@
+//go:build !unix
+
+package server
+
+import "time"
+
+// cpuTime isn't measured on this OS
+func cpuTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Delay is a flag.Value for --delay, how long to wait before answering each
+// request, to try out loading states. A range such as 100ms-2s waits for a
+// random time within it
+//
+//	--delay 500ms
+//	--delay 100ms-2s
+type Delay struct {
+	Min, Max time.Duration
+}
+
+func (d *Delay) String() string {
+	if d.Min == d.Max {
+		if d.Min == 0 {
+			return ""
+		}
+		return d.Min.String()
+	}
+	return d.Min.String() + "-" + d.Max.String()
+}
+
+func (d *Delay) Set(value string) error {
+	low, high, isRange := strings.Cut(value, "-")
+	shortest, err := time.ParseDuration(low)
+	longest := shortest
+	if err == nil && isRange {
+		longest, err = time.ParseDuration(high)
+	}
+	if err != nil || shortest < 0 || longest < shortest {
+		return fmt.Errorf("%q is not a duration such as 500ms, or a range such as 100ms-2s", value)
+	}
+	*d = Delay{Min: shortest, Max: longest}
+	return nil
+}
+
+// wait sleeps for the delay, returning false if the request was cancelled
+// first
+func (d Delay) wait(r *http.Request) bool {
+	delay := d.Min
+	if d.Max > d.Min {
+		delay += rand.N(d.Max - d.Min + 1)
+	}
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDelaySet(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   Delay
+		String string
+	}{
+		{"500ms", Delay{500 * time.Millisecond, 500 * time.Millisecond}, "500ms"},
+		{"100ms-2s", Delay{100 * time.Millisecond, 2 * time.Second}, "100ms-2s"},
+		{"1s-1s", Delay{time.Second, time.Second}, "1s"},
+		{"0-250ms", Delay{0, 250 * time.Millisecond}, "0s-250ms"},
+		{"0", Delay{}, ""},
+	}
+	for _, tt := range tests {
+		var d Delay
+		if err := d.Set(tt.value); err != nil || d != tt.want {
+			t.Errorf("Set(%q) = %+v, %v, want %+v", tt.value, d, err, tt.want)
+		}
+		if got := d.String(); got != tt.String {
+			t.Errorf("Set(%q).String() = %q, want %q", tt.value, got, tt.String)
+		}
+	}
+	for _, value := range []string{"", "soon", "-1s", "2s-100ms", "100ms-", "-", "1s-2s-3s"} {
+		var d Delay
+		if err := d.Set(value); err == nil {
+			t.Errorf("Set(%q) = %+v, want an error", value, d)
+		}
+	}
+}
+
+func TestDelayWait(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if !(Delay{}).wait(r) {
+		t.Error("no delay reported the request as cancelled")
+	}
+	start := time.Now()
+	if !(Delay{Min: 20 * time.Millisecond, Max: 30 * time.Millisecond}).wait(r) {
+		t.Error("the delay reported the request as cancelled")
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("waited %s, want at least 20ms", waited)
+	}
+
+	// a client that goes away isn't kept waiting for
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start = time.Now()
+	if (Delay{Min: time.Minute, Max: time.Minute}).wait(r.WithContext(ctx)) {
+		t.Error("wait returned true for a cancelled request")
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("waited %s after the request was cancelled", waited)
+	}
+}
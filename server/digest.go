@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cachedHash is the hash of a file when it had a size and modification time
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	sum     []byte
+}
+
+// hashCache remembers the SHA-256 of files until their size or modification
+// time changes, so that /__manifest.json and DigestHeader read each file once
+// between them
+type hashCache struct {
+	mu     sync.Mutex
+	hashes map[string]cachedHash
+}
+
+func newHashCache() *hashCache {
+	return &hashCache{hashes: map[string]cachedHash{}}
+}
+
+// get returns the SHA-256 of the file called name in src, which info was
+// stat'd from. The file is read without holding the lock, two requests for
+// a file that changed may both read it
+func (c *hashCache) get(src source, name string, info fs.FileInfo) ([]byte, error) {
+	key := src.path(name)
+	c.mu.Lock()
+	cached, ok := c.hashes[key]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+
+	file, err := src.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	cached = cachedHash{size: info.Size(), modTime: info.ModTime(), sum: h.Sum(nil)}
+	c.mu.Lock()
+	c.hashes[key] = cached
+	c.mu.Unlock()
+	return cached.sum, nil
+}
+
+// forget drops the hashes of the files not in keep, by source path
+func (c *hashCache) forget(keep map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.hashes {
+		if !keep[key] {
+			delete(c.hashes, key)
+		}
+	}
+}
+
+// hashesKey is the request context key of the Server's hashCache, set when
+// DigestHeader is
+type hashesKey struct{}
+
+// setDigest adds the RFC 9530 Repr-Digest of the file called name in src to
+// the response, and the older RFC 3230 Digest, for clients that check their
+// downloads. Both are of the whole file, even for a range
+func setDigest(w http.ResponseWriter, r *http.Request, src source, name string, stat fs.FileInfo) {
+	hashes, ok := r.Context().Value(hashesKey{}).(*hashCache)
+	if !ok {
+		return
+	}
+	sum, err := hashes.get(src, name, stat)
+	if err != nil {
+		configFor(r).logger().Printf("digest %s: %s", src.path(name), err)
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	w.Header().Set("Repr-Digest", "sha-256=:"+encoded+":")
+	w.Header().Set("Digest", "SHA-256="+encoded)
+}
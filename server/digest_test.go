@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openCountingFS counts the times each file is opened
+type openCountingFS struct {
+	fs.FS
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (c *openCountingFS) Open(name string) (fs.File, error) {
+	c.mu.Lock()
+	c.opens[name]++
+	c.mu.Unlock()
+	return c.FS.Open(name)
+}
+
+func (c *openCountingFS) count(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opens[name]
+}
+
+// sha256Base64 is the base64 SHA-256 of s
+func sha256Base64(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestDigestHeader(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "0123456789"})
+	counting := &openCountingFS{FS: os.DirFS(dir), opens: map[string]int{}}
+	conf := testConfig()
+	conf.FS = []FS{{Name: "fs", FS: counting}}
+	conf.DigestHeader = true
+	conf.Manifest = true
+	s := newTestServer(t, conf)
+	want := sha256Base64("0123456789")
+
+	tests := []struct {
+		name, method string
+		headers      []string
+		status       int
+	}{
+		{"full", "GET", nil, 200},
+		// the digest is of the whole file, not the part sent
+		{"range", "GET", []string{"Range", "bytes=2-4"}, 206},
+		{"HEAD", "HEAD", nil, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(s, tt.method, "/a.txt", tt.headers...)
+			expect(t, w, tt.status, "")
+			if got := w.Header().Get("Repr-Digest"); got != "sha-256=:"+want+":" {
+				t.Errorf("Repr-Digest = %q", got)
+			}
+			if got := w.Header().Get("Digest"); got != "SHA-256="+want {
+				t.Errorf("Digest = %q", got)
+			}
+		})
+	}
+	// each request opens the file to send it, only the first to hash it
+	if n := counting.count("a.txt"); n != len(tests)+1 {
+		t.Errorf("a.txt was opened %d times for %d requests, want it hashed once", n, len(tests))
+	}
+	// the manifest reuses the hash too
+	sum := sha256.Sum256([]byte("0123456789"))
+	if entry := manifestOf(t, s)["/a.txt"]; entry.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("manifest entry = %+v", entry)
+	}
+	if n := counting.count("a.txt"); n != len(tests)+1 {
+		t.Errorf("the manifest hashed a.txt again")
+	}
+
+	// a changed file is hashed again
+	later := time.Now().Add(time.Hour)
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filepath.Join(dir, "a.txt"), later, later)
+	if got := get(s, "GET", "/a.txt").Header().Get("Digest"); got != "SHA-256="+sha256Base64("changed") {
+		t.Errorf("Digest after a change = %q, want the new file's", got)
+	}
+}
+
+func TestDigestHeaderOff(t *testing.T) {
+	s := newTestServer(t, testConfig(writeTree(t, map[string]string{"a.txt": "a"})))
+	if w := get(s, "GET", "/a.txt"); w.Header().Get("Repr-Digest") != "" || w.Header().Get("Digest") != "" {
+		t.Errorf("headers = %v, want no digest without --digest-header", w.Header())
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrorPage is a file served in place of the plain text response for an error
+// status
+type ErrorPage struct {
+	Status int
+	File   string
+}
+
+// ErrorPageList is a flag.Value for --error-page, which may be given once per
+// status:
+//
+//	--error-page 404=./404.html --error-page 403=./403.html
+type ErrorPageList []ErrorPage
+
+func (l *ErrorPageList) String() string {
+	pages := make([]string, len(*l))
+	for i, page := range *l {
+		pages[i] = page.String()
+	}
+	return strings.Join(pages, " ")
+}
+
+func (l *ErrorPageList) Set(value string) error {
+	code, file, found := strings.Cut(value, "=")
+	if !found || file == "" {
+		return fmt.Errorf("expected status=file")
+	}
+	status, err := strconv.Atoi(code)
+	if err != nil || status < 400 || status > 599 {
+		return fmt.Errorf("status %q must be a number from 400 to 599", code)
+	}
+	if l.file(status) != "" {
+		return fmt.Errorf("status %d already has a page", status)
+	}
+	*l = append(*l, ErrorPage{Status: status, File: file})
+	return nil
+}
+
+func (p ErrorPage) String() string {
+	return strconv.Itoa(p.Status) + "=" + p.File
+}
+
+// file returns the page for status, or an empty string
+func (l ErrorPageList) file(status int) string {
+	for _, page := range l {
+		if page.Status == status {
+			return page.File
+		}
+	}
+	return ""
+}
+
+// errorTmpl is the page sent for errors to browsers, when there is no
+// --error-page for the status
+var errorTmpl = template.Must(template.New("error").Parse(`<!doctype html>
+<meta charset="utf-8">
+<title>{{.Status}} {{.Text}}</title>
+<h1>{{.Status}} {{.Text}}</h1>
+<p>{{.Message}}</p>
+`))
+
+// jsonError is the body of error responses to clients that accept JSON, e.g.
+//
+//	{"error":"not found","message":"404 page not found","path":"/x"}
+type jsonError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Path    string `json:"path"`
+}
+
+// respondError responds with status as JSON to clients that ask for it,
+// serving its --error-page if there is one or otherwise message as HTML to
+// browsers and plain text to everything else.
+// The page is read for every response so that it can be edited while
+// serving. Errors are logged, apart from 401 and 404 which are routine and so
+// only logged with --verbose
+func respondError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	conf := configFor(r)
+	if conf.Verbose || status != http.StatusNotFound && status != http.StatusUnauthorized {
+		conf.logger().Printf("%s ✕ %d %s: %s", remoteAddr(r), status, message, r.URL.Path)
+	}
+	writeError(w, r, status, message)
+}
+
+// writeError is respondError without the logging, for callers that log the
+// error their own way
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	conf := configFor(r)
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(jsonError{
+			Error:   strings.ToLower(http.StatusText(status)),
+			Message: message,
+			Path:    r.URL.Path,
+		})
+		return
+	}
+	if file := conf.ErrorPages.file(status); file != "" {
+		page, err := os.ReadFile(file)
+		if err == nil {
+			contentType := mime.TypeByExtension(filepath.Ext(file))
+			if contentType == "" {
+				contentType = "text/html; charset=utf-8"
+			}
+			writeCompressed(w, r, status, contentType, page)
+			return
+		}
+		conf.logger().Printf("error page: %s", err)
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		h.Set("Content-Security-Policy", "default-src 'none'")
+		w.WriteHeader(status)
+		errorTmpl.Execute(w, struct {
+			Status        int
+			Text, Message string
+		}{status, http.StatusText(status), message})
+		return
+	}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, message)
+}
+
+// notFound responds with 404 Not Found, as http.NotFound does
+func notFound(w http.ResponseWriter, r *http.Request) {
+	respondError(w, r, http.StatusNotFound, "404 page not found")
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONErrors(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a", ".env": "SECRET=1"})
+	conf := testConfig(dir)
+	conf.BlockDotfiles = true
+	s := newTestServer(t, conf)
+
+	tests := []struct {
+		method, path string
+		status       int
+		error        string
+	}{
+		{"GET", "/missing.txt", 404, "not found"},
+		{"GET", "/.env", 403, "forbidden"},
+		{"POST", "/a.txt", 405, "method not allowed"},
+		{"GET", "/../a.txt", 400, "bad request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := get(s, tt.method, tt.path, "Accept", "application/json")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var body jsonError
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%v: %s", err, w.Body.String())
+			}
+			if body.Error != tt.error || body.Message == "" || body.Path != tt.path {
+				t.Errorf("body = %+v, want error %q for %s", body, tt.error, tt.path)
+			}
+		})
+	}
+}
+
+func TestErrorFormats(t *testing.T) {
+	page := filepath.Join(t.TempDir(), "404.html")
+	if err := os.WriteFile(page, []byte("<h1>custom</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	plain := newTestServer(t, testConfig(t.TempDir()))
+	conf := testConfig(t.TempDir())
+	conf.ErrorPages = ErrorPageList{{404, page}}
+	custom := newTestServer(t, conf)
+
+	tests := []struct {
+		name        string
+		s           *Server
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"plain text", plain, "", "text/plain; charset=utf-8", "404 page not found\n"},
+		{"browser", plain, "text/html,*/*", "text/html; charset=utf-8", "<h1>404 Not Found</h1>"},
+		{"JSON over a page", custom, "application/json", "application/json", `"error":"not found"`},
+		{"error page", custom, "text/html", "text/html; charset=utf-8", "<h1>custom</h1>"},
+		// the page is sent to everything, as it would be by another server
+		{"error page for curl", custom, "*/*", "text/html; charset=utf-8", "<h1>custom</h1>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(tt.s, "GET", "/missing", "Accept", tt.accept)
+			expect(t, w, 404, tt.body)
+			if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", ct, tt.contentType)
+			}
+			if nosniff := w.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q", nosniff)
+			}
+		})
+	}
+}
+
+func TestErrorPageListSet(t *testing.T) {
+	tests := []struct {
+		values []string
+		err    string
+	}{
+		{[]string{"404=404.html", "500=oops.html"}, ""},
+		{[]string{"404"}, "expected status=file"},
+		{[]string{"404="}, "expected status=file"},
+		{[]string{"200=ok.html"}, `status "200" must be a number from 400 to 599`},
+		{[]string{"abc=x.html"}, `status "abc" must be a number from 400 to 599`},
+		{[]string{"404=a.html", "404=b.html"}, "status 404 already has a page"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.values, " "), func(t *testing.T) {
+			var l ErrorPageList
+			var err error
+			for _, value := range tt.values {
+				if err = l.Set(value); err != nil {
+					break
+				}
+			}
+			switch {
+			case err != nil && err.Error() != tt.err:
+				t.Errorf("Set = %q, want %q", err, tt.err)
+			case err == nil && tt.err != "":
+				t.Errorf("Set = nil, want %q", tt.err)
+			case err == nil && l.String() != strings.Join(tt.values, " "):
+				t.Errorf("String = %q", l.String())
+			}
+		})
+	}
+}
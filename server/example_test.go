@@ -0,0 +1,86 @@
+package server_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing/fstest"
+
+	"github.com/Alexendoo/serve/server"
+)
+
+func ExampleNew() {
+	cfg := server.DefaultConfig()
+	cfg.FS = []server.FS{{Name: "site", FS: fstest.MapFS{
+		"index.html": {Data: []byte("<h1>Hello</h1>")},
+	}}}
+	cfg.Logger = log.New(io.Discard, "", 0)
+	srv, err := server.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Close()
+	srv.Handle("/_healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+
+	for _, target := range []string{"/index.html", "/_healthz"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		fmt.Println(target, w.Code, w.Body.String())
+	}
+	// Output:
+	// /index.html 200 <h1>Hello</h1>
+	// /_healthz 200 ok
+}
+
+func ExampleServer_ServeHTTP() {
+	cfg := server.DefaultConfig()
+	cfg.FS = []server.FS{{Name: "docs", FS: fstest.MapFS{
+		"guide/intro.txt": {Data: []byte("Start here")},
+	}}}
+	cfg.NoList = true
+	cfg.Logger = log.New(io.Discard, "", 0)
+	srv, err := server.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Close()
+
+	// a Server is an http.Handler, so it can be mounted under a prefix
+	mux := http.NewServeMux()
+	mux.Handle("/docs/", http.StripPrefix("/docs", srv))
+
+	for _, target := range []string{"/docs/guide/intro.txt", "/docs/guide/", "/docs/missing.txt"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		fmt.Println(target, w.Code, w.Header().Get("Content-Type"))
+	}
+	// Output:
+	// /docs/guide/intro.txt 200 text/plain; charset=utf-8
+	// /docs/guide/ 404 text/plain; charset=utf-8
+	// /docs/missing.txt 404 text/plain; charset=utf-8
+}
+
+func ExampleNewHandler() {
+	h, err := server.NewHandler(nil,
+		server.WithFS("app", fstest.MapFS{
+			"index.html": {Data: []byte("<div id=app></div>")},
+			"app.js":     {Data: []byte("render()")},
+		}),
+		server.WithListings(false),
+		server.WithLogger(log.New(io.Discard, "", 0)),
+		server.WithHeaders(http.Header{"Cache-Control": {"no-cache"}}),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/app.js", nil))
+	fmt.Println(w.Code, w.Header().Get("Cache-Control"), w.Body.String())
+	// Output:
+	// 200 no-cache render()
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// GlobList is a flag.Value for --fail-path, a comma separated list of
+// path.Match patterns, which may be given more than once. A pattern matches a
+// path or any directory above it, so /api/* covers everything under /api:
+//
+//	--fail-path '/api/*,*.json'
+type GlobList []string
+
+func (l *GlobList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *GlobList) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("%q is not a pattern such as /api/* or *.json", pattern)
+		}
+		*l = append(*l, pattern)
+	}
+	return nil
+}
+
+// matches reports whether urlPath or a directory above it matches one of the
+// patterns. A pattern without a / is matched against the last element only
+func (l GlobList) matches(urlPath string) bool {
+	for _, pattern := range l {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(urlPath)); ok {
+				return true
+			}
+			continue
+		}
+		for p := path.Clean(urlPath); ; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if p == "/" || p == "." {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// failStatus returns FailStatus, 500 if it's unset
+func (c *Config) failStatus() int {
+	if c.FailStatus == 0 {
+		return http.StatusInternalServerError
+	}
+	return c.FailStatus
+}
+
+// injectFault fails FailRate of the requests under FailPaths, or of all of
+// them if there are none, with FailStatus, so clients' retries can be tried
+// against a real server. It returns true if it failed r
+func injectFault(w http.ResponseWriter, r *http.Request) bool {
+	conf := configFor(r)
+	if conf.FailRate <= 0 || len(conf.FailPaths) > 0 && !conf.FailPaths.matches(r.URL.Path) ||
+		rand.Float64() >= conf.FailRate {
+		return false
+	}
+	status := conf.failStatus()
+	conf.logger().Printf("%s ⚡ injected %d: %s", remoteAddr(r), status, r.URL.Path)
+	writeError(w, r, status, "fault injected by --fail-rate")
+	return true
+}
@@ -0,0 +1,82 @@
+package server
+
+import "testing"
+
+func TestGlobListSet(t *testing.T) {
+	var l GlobList
+	if err := l.Set("/api/*, *.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Set("/admin"); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.String(); got != "/api/*,*.json,/admin" {
+		t.Errorf("String() = %q, want the patterns of both flags", got)
+	}
+	for _, value := range []string{"", "/api/*,", "/[", "*.json,["} {
+		var l GlobList
+		if err := l.Set(value); err == nil {
+			t.Errorf("Set(%q) = %q, want an error", value, l)
+		}
+	}
+}
+
+func TestGlobListMatches(t *testing.T) {
+	l := GlobList{"/api/*", "*.json", "/admin"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		// a pattern with a / is matched against the path and the directories
+		// above it
+		{"/api/users", true},
+		{"/api/users/1/posts", true},
+		{"/api", false},
+		{"/v1/api/users", false},
+		{"/admin", true},
+		{"/admin/settings", true},
+		{"/administrator", false},
+		// a bare name is matched against the last element wherever it is
+		{"/data.json", true},
+		{"/deep/down/data.json", true},
+		{"/data.json/more", false},
+		{"/index.html", false},
+	}
+	for _, tt := range tests {
+		if got := l.matches(tt.path); got != tt.want {
+			t.Errorf("matches(%q) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+	if (GlobList{}).matches("/anything") {
+		t.Error("an empty list matched a path")
+	}
+}
+
+func TestInjectFault(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a", "api/b.txt": "b"})
+
+	conf := testConfig(dir)
+	conf.FailRate = 1
+	s := newTestServer(t, conf)
+	for _, path := range []string{"/a.txt", "/api/b.txt", "/missing"} {
+		if w := get(s, "GET", path); w.Code != 500 {
+			t.Errorf("GET %s = %d, want the injected 500", path, w.Code)
+		}
+	}
+
+	// only the requests under FailPaths fail, with FailStatus
+	conf.FailPaths = GlobList{"/api/*"}
+	conf.FailStatus = 503
+	s = newTestServer(t, conf)
+	expect(t, get(s, "GET", "/a.txt"), 200, "a")
+	if w := get(s, "GET", "/api/b.txt"); w.Code != 503 {
+		t.Errorf("GET /api/b.txt = %d, want the injected 503", w.Code)
+	}
+
+	conf = testConfig(dir)
+	conf.FailRate = 0
+	s = newTestServer(t, conf)
+	for range 20 {
+		expect(t, get(s, "GET", "/api/b.txt"), 200, "b")
+	}
+}
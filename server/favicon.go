@@ -0,0 +1,30 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"net/http"
+	"time"
+)
+
+var (
+	//go:embed favicon.ico
+	defaultFavicon []byte
+	faviconTime    = time.Now()
+)
+
+// tryFavicon serves --favicon, or the built in icon, for /favicon.ico
+// requests that didn't match a file in any of the serving directories. This
+// saves a 404 for every page a browser loads
+func tryFavicon(w http.ResponseWriter, r *http.Request) bool {
+	conf := configFor(r)
+	if conf.NoFavicon || r.URL.Path != "/favicon.ico" {
+		return false
+	}
+	if len(conf.Favicon) > 0 {
+		return tryLocalFile(w, r, conf.Favicon)
+	}
+	w.Header().Set("Content-Type", "image/x-icon")
+	http.ServeContent(w, r, "favicon.ico", faviconTime, bytes.NewReader(defaultFavicon))
+	return true
+}
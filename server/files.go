@@ -0,0 +1,882 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var htmlTmpl = template.Must(template.New("html").Parse(html))
+
+const html = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<title>{{.Title}}</title>
+	<style>
+		body {
+			font-size: 14px;
+			font-family: consolas, "Liberation Mono", "DejaVu Sans Mono", Menlo, monospace;
+		}
+		a {
+			display: block;
+			color: blue;
+			text-decoration: none;
+		}
+		a:hover, a:focus {
+			background-color: #f3f3f3;
+			outline: none;
+		}
+		.req-path, .target, .source {
+			color: #bbb;
+		}
+		.symlink {
+			font-style: italic;
+		}
+		.broken {
+			color: #c33;
+			text-decoration: line-through;
+		}
+		.sort a {
+			display: inline;
+			margin-right: 12px;
+			color: #888;
+		}
+		.sort a.active {
+			color: blue;
+		}
+		.truncated, .group {
+			color: #888;
+		}
+		.group {
+			margin-top: 8px;
+		}
+		.group-images {
+			color: #6a3d9a;
+		}
+		.group-media {
+			color: #9a3d5c;
+		}
+		.group-code {
+			color: #1c6b48;
+		}
+		.group-archives {
+			color: #8a5a00;
+		}
+	</style>
+</head>
+<body>
+<p class="sort">sort by
+{{- range .Sort}} <a class="sort-{{.Key}}{{if .Active}} active{{end}}" href="{{.Href}}">{{.Key}}{{if .Active}}{{if .Reverse}} ↑{{else}} ↓{{end}}{{end}}</a>{{end}}
+</p>
+{{range .Dirs}}
+	<h3>
+		<span class="local-path">{{.LocalPath}}</span><span class="req-path">{{.RequestPath}}</span>
+	</h3>
+	{{range .Entries}}
+		{{if .GroupStart}}<div class="group">{{.Group}}</div>{{end}}
+		<a class="entry{{if .Group}} group-{{.Group}}{{end}}{{if .Symlink}} symlink{{end}}{{if .Broken}} broken{{end}}" href="{{.Link}}">
+			{{- .Name}}{{if .Symlink}} <span class="target">-> {{.Target}}</span>{{end -}}
+			{{- if .Source}} <span class="source">{{.Source}}</span>{{end -}}
+		</a>
+	{{end}}
+	{{if .Total}}
+		<p class="truncated">truncated, {{.Shown}} of {{.Total}} shown</p>
+	{{end}}
+{{end}}
+{{if .KeyNav}}
+<script>
+	// arrow keys move between entries, enter opens, u or backspace goes up
+	document.addEventListener("keydown", function (e) {
+		if (e.altKey || e.ctrlKey || e.metaKey) return;
+		var entries = Array.prototype.slice.call(document.querySelectorAll("a.entry"));
+		var i = entries.indexOf(document.activeElement);
+		switch (e.key) {
+		case "ArrowDown":
+			i = Math.min(i + 1, entries.length - 1);
+			break;
+		case "ArrowUp":
+			i = Math.max(i - 1, 0);
+			break;
+		case "u":
+		case "Backspace":
+			if (location.pathname !== "/") location.href = "../";
+			return;
+		default:
+			return;
+		}
+		e.preventDefault();
+		if (entries[i]) entries[i].focus();
+	});
+</script>
+{{end}}
+</body>
+`
+
+func logRequest(r *http.Request) {
+	conf := configFor(r)
+	if !conf.Verbose {
+		return
+	}
+	conf.logger().Printf("%s → %s %s %s", remoteAddr(r), r.Method, r.RequestURI, r.Proto)
+}
+
+// validRequest returns false if the request is invalid: Contains ".."
+func validRequest(r *http.Request) bool {
+	return validPath(r.URL.Path)
+}
+
+// validPath reports whether urlPath has no .. elements, and nothing the
+// operating system would read differently from the path as it is matched
+func validPath(urlPath string) bool {
+	if !validOSPath(urlPath) {
+		return false
+	}
+	if !strings.Contains(urlPath, "..") {
+		return true
+	}
+	for _, field := range strings.FieldsFunc(urlPath, isSlashRune) {
+		if field == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// dotPath reports whether an element of urlPath starts with a dot, such as
+// /.env or /sub/.git/config
+func dotPath(urlPath string) bool {
+	for _, field := range strings.FieldsFunc(urlPath, isSlashRune) {
+		if strings.HasPrefix(field, ".") && field != "." {
+			return true
+		}
+	}
+	return false
+}
+
+func isSlashRune(r rune) bool { return r == '/' || r == '\\' }
+
+// canonicalPath returns urlPath without repeated slashes or . elements,
+// keeping a trailing slash, or a trailing /. as one, so that directories stay
+// directories
+func canonicalPath(urlPath string) string {
+	clean := path.Clean("/" + urlPath)
+	if (strings.HasSuffix(urlPath, "/") || strings.HasSuffix(urlPath, "/.")) && clean != "/" {
+		clean += "/"
+	}
+	return clean
+}
+
+// redirectsCanonical reports whether r may be redirected to its canonical
+// path, with --canonical-paths. Only GET and HEAD are, as clients resend
+// anything else as a GET after a 301. Paths with escaped characters that would
+// be decoded differently, such as %2F, are left alone, as cleaning them could
+// change what they refer to
+func redirectsCanonical(r *http.Request) bool {
+	return configFor(r).CanonicalPaths && r.URL.RawPath == "" &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead)
+}
+
+// redirectCanonical redirects a request for a path that isn't canonical to
+// the one that is with a 301, keeping the query, with --canonical-paths
+func redirectCanonical(w http.ResponseWriter, r *http.Request) bool {
+	if !redirectsCanonical(r) {
+		return false
+	}
+	canonical := canonicalPath(r.URL.Path)
+	if canonical == r.URL.Path {
+		return false
+	}
+	target := url.URL{Path: canonical, RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+	return true
+}
+
+// redirectSlash redirects a request for the directory or file called name
+// that it was found as to the path with the trailing slash a directory has,
+// or without it for a file, with --canonical-paths. Relative links on an
+// index page then resolve the same way however it was reached
+func redirectSlash(w http.ResponseWriter, r *http.Request, name string, isDir bool) bool {
+	if !redirectsCanonical(r) || name != fsName(r.URL.Path) {
+		return false
+	}
+	urlPath := r.URL.Path
+	switch {
+	case isDir && !strings.HasSuffix(urlPath, "/"):
+		urlPath += "/"
+	case !isDir && strings.HasSuffix(urlPath, "/"):
+		urlPath = strings.TrimSuffix(urlPath, "/")
+	default:
+		return false
+	}
+	target := url.URL{Path: mountPrefix(r) + urlPath, RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+	return true
+}
+
+func tryFiles(w http.ResponseWriter, r *http.Request, sources []source) bool {
+	conf := configFor(r)
+	name := fsName(r.URL.Path)
+	for _, src := range sources {
+		name := name
+		served, isDir := tryFile(w, r, src, name)
+		if !served && !isDir {
+			name, served, isDir = tryOtherForms(w, r, src, name)
+		}
+		if served || !isDir && tryLanguages(w, r, src, name) {
+			return true
+		}
+		// only directories can have an index.html, a name that wasn't
+		// found needn't be probed again. With --lang-negotiate a variant
+		// such as index.de.html is preferred to the index.html
+		if isDir && !conf.NoIndex {
+			index := path.Join(name, "index.html")
+			if tryLanguages(w, r, src, index) {
+				return true
+			}
+			if served, _ := tryFile(w, r, src, index); served {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// alternateForms returns the NFC and NFD forms of name that differ from it,
+// for a name copied from a page made on a system that normalizes differently
+// to the one the file is on. macOS stores names decomposed, most others as
+// they were typed, which is usually composed
+func alternateForms(name string) []string {
+	var forms []string
+	for _, form := range []string{norm.NFC.String(name), norm.NFD.String(name)} {
+		if form != name && (len(forms) == 0 || forms[0] != form) {
+			forms = append(forms, form)
+		}
+	}
+	return forms
+}
+
+// readDirAnyForm reads the directory called name in fsys, or in its other
+// normalization forms if it doesn't exist as it is
+func readDirAnyForm(fsys fs.FS, name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(fsys, name)
+	if err == nil {
+		return entries, nil
+	}
+	for _, form := range alternateForms(name) {
+		if entries, formErr := fs.ReadDir(fsys, form); formErr == nil {
+			return entries, nil
+		}
+	}
+	return nil, err
+}
+
+// tryOtherForms serves the file called name in src under its other
+// normalization forms, after it wasn't found as it is, returning the form
+// that was found. The forms go through the same checks as the request path
+func tryOtherForms(w http.ResponseWriter, r *http.Request, src source, name string) (found string, served, isDir bool) {
+	conf := configFor(r)
+	for _, form := range alternateForms(name) {
+		if !validPath("/"+form) || conf.BlockDotfiles && dotPath("/"+form) {
+			continue
+		}
+		if served, isDir := tryFile(w, r, src, form); served || isDir {
+			return form, served, isDir
+		}
+	}
+	return name, false, false
+}
+
+// tryLocalFile attempts to serve the file on disk at filePath
+func tryLocalFile(w http.ResponseWriter, r *http.Request, filePath string) bool {
+	served, _ := tryFile(w, r, dirSource(filepath.Dir(filePath)), filepath.Base(filePath))
+	return served
+}
+
+// tryFile attempts to serve the file called name in src to the provided
+// ResponseWriter, isDir reports that nothing was served because it is a
+// directory. The file is opened before it is stat'd, so the file served is
+// always the one that was checked even if it is replaced meanwhile
+func tryFile(w http.ResponseWriter, r *http.Request, src source, name string) (served, isDir bool) {
+	conf := configFor(r)
+	file, fileErr := fsCall(r, func() (fs.File, error) {
+		return src.fsys.Open(name)
+	}, func(file fs.File) { file.Close() })
+	if fileErr == errFSTimeout {
+		fsTimeoutError(w, r)
+		return true, false
+	}
+	if errors.Is(fileErr, fs.ErrPermission) {
+		respondError(w, r, http.StatusForbidden, "permission denied")
+		return true, false
+	}
+	if fileErr != nil {
+		return false, false
+	}
+	defer file.Close()
+	stat, statErr := fsCall(r, file.Stat, nil)
+	if statErr == errFSTimeout {
+		fsTimeoutError(w, r)
+		return true, false
+	}
+	if statErr != nil {
+		return false, false
+	}
+	if ignoredFile(r, src, name, stat.IsDir()) {
+		return false, false
+	}
+	if !src.contains(name) {
+		forbidden(w, r)
+		return true, false
+	}
+	if redirectSlash(w, r, name, stat.IsDir()) {
+		return true, false
+	}
+	if stat.IsDir() {
+		return false, true
+	}
+	// scripts that tryCGI couldn't run, such as those in an fs.FS, mustn't
+	// have their source sent instead
+	if conf.CGI.matches("/" + name) {
+		respondError(w, r, http.StatusForbidden, "CGI script can't be run")
+		return true, false
+	}
+	filename := src.path(name)
+	setServedFile(w, filename)
+	setServedSource(w, src.name)
+	if conf.Verbose {
+		conf.logger().Printf("%s ← %s", remoteAddr(r), filename)
+	}
+	release, ok := acquireTransfer(w, r, stat.Size())
+	if !ok {
+		return true, false
+	}
+	defer release()
+	if content, ok := file.(io.ReadSeeker); ok && wantsFollow(r) {
+		followFile(w, r, file, content, stat)
+		return true, false
+	}
+	setContentType(w, r, stat.Name())
+	// the stats and logs still need the recorder, only the body goes
+	// through the throttle
+	body := throttle(w, r)
+	if conf.usesSSI(name) {
+		serveSSI(body, r, name, stat, file)
+		return true, false
+	}
+	if tryPrecompressed(body, r, src, name, stat) {
+		logDisconnect(w, r, stat.Size())
+		return true, false
+	}
+	setDigest(w, r, src, name, stat)
+	if content, ok := file.(io.ReadSeeker); ok {
+		// No ETag is set, so an If-Range validator is only ever matched
+		// against the modification time. A stale date or any ETag fails to
+		// match and the full file is sent with a 200 rather than a 206 or 416
+		http.ServeContent(body, r, stat.Name(), conf.MTime.of(stat.ModTime()), content)
+	} else {
+		serveUnseekable(body, r, stat, file)
+	}
+	logDisconnect(w, r, stat.Size())
+	return true, false
+}
+
+// serveUnseekable sends a file from an fs.FS that can't seek. Ranges can't be
+// served without seeking, so the whole file is always sent
+func serveUnseekable(w http.ResponseWriter, r *http.Request, stat fs.FileInfo, file io.Reader) {
+	if w.Header().Get("Content-Type") == "" {
+		ctype := mime.TypeByExtension(path.Ext(stat.Name()))
+		if ctype == "" {
+			buffered := bufio.NewReader(file)
+			start, _ := buffered.Peek(512)
+			ctype = http.DetectContentType(start)
+			file = buffered
+		}
+		w.Header().Set("Content-Type", ctype)
+	}
+	if modTime := configFor(r).MTime.of(stat.ModTime()); !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.Copy(w, file)
+	}
+}
+
+// indexFiles returns the comma separated files of Index in the order they're
+// tried
+func (c *Config) indexFiles() []string {
+	files := []string{}
+	for _, file := range strings.Split(c.Index, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// indexSource returns the source the --index file called file is opened
+// from, and its name there. A file under the Dir of a Mount or FS that has an
+// fs.FS is read from that, any other from the directory on disk it's in
+func (c *Config) indexSource(file string) (source, string) {
+	for _, m := range c.Mounts {
+		if name, ok := nameWithin(m.Dir, file); ok && m.FS != nil {
+			return m.source(), name
+		}
+	}
+	for _, f := range c.FS {
+		if name, ok := nameWithin(f.Name, file); ok {
+			return fsSource(f), name
+		}
+	}
+	return dirSource(filepath.Dir(file)), filepath.Base(file)
+}
+
+// nameWithin returns the slash separated name of file relative to dir, if
+// file is inside it
+func nameWithin(dir, file string) (string, bool) {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(file))
+	if err != nil {
+		return "", false
+	}
+	name := filepath.ToSlash(rel)
+	return name, name != "." && fs.ValidPath(name)
+}
+
+// staticIndex will attempt to serve the first of the globally defined index
+// files that exists. They're opened from their source as tryFiles does, so
+// ignoreFile rules and the checks that names stay inside it apply
+func staticIndex(w http.ResponseWriter, r *http.Request) bool {
+	conf := configFor(r)
+	for _, file := range conf.indexFiles() {
+		src, name := conf.indexSource(file)
+		if served, _ := tryFile(w, r, src, name); served {
+			return true
+		}
+	}
+	conf.logger().Printf("none of the --index files could be served: %s", conf.Index)
+	return false
+}
+
+// setContentType sets the Content-Type of the file called name ahead of
+// http.ServeContent, which would otherwise sniff files with an unknown
+// extension. A --type pattern matching the name comes first, then
+// --default-type for files without an extension, then with --no-sniff
+// application/octet-stream for any unknown type
+func setContentType(w http.ResponseWriter, r *http.Request, name string) {
+	conf := configFor(r)
+	name = path.Base(name)
+	ext := path.Ext(name)
+	if ctype, ok := conf.Types.match(name); ok {
+		w.Header().Set("Content-Type", ctype)
+	} else if conf.DefaultType != "" && ext == "" {
+		w.Header().Set("Content-Type", conf.DefaultType)
+	} else if conf.NoSniff && mime.TypeByExtension(ext) == "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if conf.NoSniff {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+}
+
+// Listing is the page rendered by htmlTmpl, the directories matching a
+// request path merged with the title shown in the browser
+type Listing struct {
+	Title  string    `json:"title"`
+	Dirs   []DirList `json:"dirs"`
+	KeyNav bool      `json:"-"`
+	// Sort are the headings that sort the listing
+	Sort []SortLink `json:"-"`
+}
+
+// DirList is the contents of a directory at the path given by joining
+// LocalPath and RequestPath. If the directory has more than --max-entries
+// entries, Total is how many there are and Shown how many are listed
+type DirList struct {
+	LocalPath   string  `json:"localPath"`
+	RequestPath string  `json:"requestPath"`
+	Entries     []Entry `json:"entries"`
+	Shown       int     `json:"shown"`
+	Total       int     `json:"total,omitempty"`
+}
+
+// Entry contains the details of a single file/directory for rendering in
+// htmlTmpl. With --group-by type, Group is its category and GroupStart is set
+// on the first entry of each one. Ranges is set for the regular files, which
+// can be fetched in pieces to resume a download
+type Entry struct {
+	Name       string `json:"name"`
+	Link       string `json:"link"`
+	IsDir      bool   `json:"dir"`
+	Symlink    bool   `json:"symlink,omitempty"`
+	Target     string `json:"target,omitempty"`
+	Broken     bool   `json:"broken,omitempty"`
+	Group      string `json:"group,omitempty"`
+	GroupStart bool   `json:"-"`
+	Ranges     bool   `json:"ranges"`
+	// Source is the directory the entry is from in a merged listing
+	Source string `json:"source,omitempty"`
+}
+
+// tryDirs will generate directory listings for any available directories,
+// providing multiple in the case that there are several matching directories
+//
+// Example: `serve dir1 dir2` would list directory entries dir1 containing
+// file1, and dir2 containing file2 and file3
+// .
+// ├── dir1
+// │   └── file1
+// └── dir2
+//
+//	├── file2
+//	└── file3
+func tryDirs(w http.ResponseWriter, r *http.Request, sources []source) bool {
+	conf := configFor(r)
+	if conf.NoList && !forceList(r) || noListBot(r) || !strings.HasSuffix(r.URL.Path, "/") {
+		return false
+	}
+
+	dirLists := []DirList{}
+	// the served directory that the listing is counted against, the first
+	// one that has the path
+	var served string
+	if conf.MergeListings && len(sources) > 1 {
+		list, err := fsCall(r, func() (*DirList, error) {
+			list, first := getMergedDirList(sources, r)
+			served = first
+			return list, nil
+		}, nil)
+		if err == errFSTimeout {
+			fsTimeoutError(w, r)
+			return true
+		}
+		if list != nil {
+			for _, src := range sources {
+				if !src.contains(fsName(r.URL.Path)) {
+					forbidden(w, r)
+					return true
+				}
+			}
+			dirLists = append(dirLists, *list)
+		}
+		sources = nil
+	}
+	for _, src := range sources {
+		list, err := fsCall(r, func() (*DirList, error) {
+			return getDirList(src, r), nil
+		}, nil)
+
+		if err == errFSTimeout {
+			fsTimeoutError(w, r)
+			return true
+		}
+		if list == nil {
+			continue
+		}
+		if !src.contains(fsName(r.URL.Path)) {
+			forbidden(w, r)
+			return true
+		}
+		if served == "" {
+			served = src.name
+		}
+
+		dirLists = append(dirLists, *list)
+	}
+
+	found := len(dirLists) > 0
+	if found {
+		setServedSource(w, served)
+		logDirLists(r, dirLists)
+		listing := Listing{
+			Title:  conf.Title + " " + mountPrefix(r) + r.URL.Path,
+			Dirs:   dirLists,
+			KeyNav: !conf.NoKeyNav,
+			Sort:   sortLinks(r),
+		}
+		w.Header().Add("Vary", "Accept, X-Serve-Format")
+		// files are still indexed, only the listings of them aren't
+		if conf.NoindexListings || conf.NoListBots {
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		}
+		if wantsJSONListing(r) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(listing)
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			htmlTmpl.Execute(w, listing)
+		}
+	}
+	return found
+}
+
+// wantsJSONListing reports whether a listing should be sent as JSON rather
+// than HTML, for scripts that Accept application/json. X-Serve-Format: json
+// or ?format=json ask for it explicitly, for tools behind proxies that
+// rewrite Accept
+func wantsJSONListing(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("X-Serve-Format"), "json") ||
+		r.URL.Query().Get("format") == "json" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// tryAutoIndex answers a request for a directory that has no index.html and
+// whose listing is disabled, as set by --auto-index. list serves its listing
+// regardless, forbidden a 403 so the client can tell a directory that exists
+// but isn't listed from one that doesn't, which is still a 404. With
+// --no-list-bots, a crawler gets a 403 where it would have been listed
+func tryAutoIndex(w http.ResponseWriter, r *http.Request, sources []source) bool {
+	conf := configFor(r)
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		return false
+	}
+	bot := noListBot(r) && (!conf.NoList || conf.AutoIndex == "list" || forceList(r))
+	if !bot && (conf.AutoIndex == "" || !conf.NoList) {
+		return false
+	}
+	found, err := isDirectory(r, sources)
+	if err == errFSTimeout {
+		fsTimeoutError(w, r)
+		return true
+	}
+	if !found {
+		return false
+	}
+	if bot {
+		respondError(w, r, http.StatusForbidden, "directory listing is disabled for crawlers")
+		return true
+	}
+	if conf.AutoIndex == "forbidden" {
+		respondError(w, r, http.StatusForbidden, "directory listing is disabled")
+		return true
+	}
+	listed := *conf
+	listed.NoList = false
+	return tryDirs(w, withConfig(r, &listed), sources)
+}
+
+// isDirectory reports whether the request path is a directory in one of the
+// sources, the error is errFSTimeout if one took too long to tell
+func isDirectory(r *http.Request, sources []source) (bool, error) {
+	name := fsName(r.URL.Path)
+	for _, src := range sources {
+		stat, err := fsCall(r, func() (fs.FileInfo, error) {
+			return fs.Stat(src.fsys, name)
+		}, nil)
+		if err == errFSTimeout {
+			return false, err
+		}
+		if err == nil && stat.IsDir() && !ignoredFile(r, src, name, true) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// tryIndexFirst serves a directory ahead of its listing as --index-priority
+// sets: dir with its own index.html, global with the --index page, which only
+// applies outside of mounts. The listing comes first otherwise, and is
+// served if there's no index after all
+func tryIndexFirst(w http.ResponseWriter, r *http.Request, sources []source) bool {
+	conf := configFor(r)
+	if !strings.HasSuffix(r.URL.Path, "/") || conf.NoList && !forceList(r) || noListBot(r) {
+		return false
+	}
+	switch conf.IndexPriority {
+	case "dir":
+		return tryFiles(w, r, sources)
+	case "global":
+		if len(conf.Index) == 0 || mountPrefix(r) != "" {
+			return false
+		}
+		found, err := isDirectory(r, sources)
+		if err == errFSTimeout {
+			fsTimeoutError(w, r)
+			return true
+		}
+		return found && staticIndex(w, r)
+	}
+	return false
+}
+
+// forceList reports whether ?list asked for a listing of a directory whose
+// listing is disabled, which is only honoured with --allow-force-list
+func forceList(r *http.Request) bool {
+	return configFor(r).AllowForceList && r.URL.Query().Has("list")
+}
+
+// dirEntry is an entry of a listing with the source it was read from, and
+// the ignoreFile rules of its directory there
+type dirEntry struct {
+	fs.DirEntry
+	src     source
+	ignores *ignoreMatcher
+}
+
+func getDirList(src source, r *http.Request) *DirList {
+	if ignoredFile(r, src, fsName(r.URL.Path), true) {
+		return nil
+	}
+	dirInfo, err := readDirAnyForm(src.fsys, fsName(r.URL.Path))
+	if err != nil {
+		return nil
+	}
+	ignores := dirIgnores(r, src, fsName(r.URL.Path))
+	entries := make([]dirEntry, len(dirInfo))
+	for i, file := range dirInfo {
+		entries[i] = dirEntry{file, src, ignores}
+	}
+	return newDirList(r, src.name, entries, false)
+}
+
+// getMergedDirList lists the directories at the request path in every source
+// as one, for --merge-listings. A name is only listed once, from the first
+// source that has it as that is the one served, and each entry shows the
+// source it's from. The name of the first source with the directory is
+// returned with it
+func getMergedDirList(sources []source, r *http.Request) (*DirList, string) {
+	entries := []dirEntry{}
+	seen := map[string]bool{}
+	found, first := false, ""
+	for _, src := range sources {
+		if ignoredFile(r, src, fsName(r.URL.Path), true) {
+			continue
+		}
+		dirInfo, err := readDirAnyForm(src.fsys, fsName(r.URL.Path))
+		if err != nil {
+			continue
+		}
+		if !found {
+			found, first = true, src.name
+		}
+		ignores := dirIgnores(r, src, fsName(r.URL.Path))
+		for _, file := range dirInfo {
+			if !seen[file.Name()] {
+				seen[file.Name()] = true
+				entries = append(entries, dirEntry{file, src, ignores})
+			}
+		}
+	}
+	if !found {
+		return nil, ""
+	}
+	slices.SortFunc(entries, func(a, b dirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return newDirList(r, "", entries, true), first
+}
+
+// newDirList returns the listing of dirInfo, which is in name order. Entries
+// are attributed to their source if merged is set
+func newDirList(r *http.Request, localPath string, dirInfo []dirEntry, merged bool) *DirList {
+	dirName := fsName(r.URL.Path)
+	conf := configFor(r)
+	dirInfo = slices.DeleteFunc(dirInfo, func(file dirEntry) bool {
+		return conf.BlockDotfiles && strings.HasPrefix(file.Name(), ".") ||
+			file.ignores != nil && (file.ignores.ignored(file.Name(), file.IsDir()) ||
+				conf.siteFile(file.src, path.Join(dirName, file.Name())))
+	})
+	// the first entries in the requested order are kept
+	key, reverse := listingSort(r)
+	sortEntries(dirInfo, key, reverse)
+	total := 0
+	if max := conf.MaxEntries; max > 0 && len(dirInfo) > max {
+		total = len(dirInfo)
+		dirInfo = dirInfo[:max]
+	}
+
+	entries := []Entry{}
+	// keep forcing listings and their order while browsing
+	query := listQuery(r, key, reverse)
+
+	// Parent directory
+	if r.URL.Path != "/" {
+		entries = append(entries, Entry{
+			Name:  "../",
+			Link:  "../" + query,
+			IsDir: true,
+		})
+	}
+
+	// Listings only need names and the type bits, which ReadDir gets without
+	// a stat per entry on most platforms, so Info is only called to sort by
+	// size or time. Symlinks are stat'd to tell directories from files, one
+	// that dangles or vanishes mid-read is listed as Broken
+	for _, file := range dirInfo {
+		entry := Entry{
+			IsDir: file.IsDir(),
+			Name:  file.Name(),
+			// composed, so that links copied from a listing of a macOS
+			// directory also work elsewhere
+			Link: path.Join(mountPrefix(r), r.URL.Path, norm.NFC.String(file.Name())),
+		}
+		if merged {
+			entry.Source = file.src.name
+		}
+
+		if file.Type()&fs.ModeSymlink != 0 {
+			resolveSymlink(&entry, file.src.fsys, path.Join(dirName, file.Name()))
+		}
+
+		entry.Ranges = !entry.IsDir && !entry.Broken && (file.Type().IsRegular() || entry.Symlink)
+		if entry.IsDir {
+			entry.Name += "/"
+			entry.Link += "/" + query
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if conf.GroupBy == "type" {
+		// the parent directory stays at the top
+		groupByType(entries[len(entries)-len(dirInfo):])
+	}
+
+	return &DirList{
+		LocalPath:   localPath,
+		RequestPath: mountPrefix(r) + r.URL.Path,
+		Entries:     entries,
+		Shown:       len(dirInfo),
+		Total:       total,
+	}
+}
+
+// resolveSymlink fills in the target of a symlink entry, whether it points to
+// a directory, and whether it is broken
+func resolveSymlink(entry *Entry, fsys fs.FS, name string) {
+	entry.Symlink = true
+	entry.Target, _ = fs.ReadLink(fsys, name)
+	stat, err := fs.Stat(fsys, name)
+	if err != nil {
+		entry.Broken = true
+		return
+	}
+	entry.IsDir = stat.IsDir()
+}
+
+func logDirLists(r *http.Request, dirLists []DirList) {
+	conf := configFor(r)
+	if !conf.Verbose {
+		return
+	}
+	output := ""
+	for _, dir := range dirLists {
+		output += dir.LocalPath + "/, "
+	}
+	conf.logger().Printf("%s ← %s", remoteAddr(r), output[:len(output)-2])
+}
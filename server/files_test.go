@@ -0,0 +1,626 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOtherNormalizationForms(t *testing.T) {
+	const composed, decomposed = "caf\u00e9", "cafe\u0301"
+	dir := writeTree(t, map[string]string{
+		decomposed + ".txt":          "decomposed",
+		"menu/" + composed + ".html": "composed",
+	})
+	s := newTestServer(t, testConfig(dir))
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{decomposed + ".txt", 200, "decomposed"},
+		{composed + ".txt", 200, "decomposed"},
+		{"menu/" + decomposed + ".html", 200, "composed"},
+		{"menu/" + composed + ".html", 200, "composed"},
+		{"menu/" + composed + "s.html", 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect(t, get(s, "GET", (&url.URL{Path: "/" + tt.name}).EscapedPath()), tt.status, tt.body)
+		})
+	}
+
+	// listings link to the composed form, which is found either way
+	for _, entry := range listing(t, s, "/").Dirs[0].Entries {
+		if entry.Name == decomposed+".txt" && entry.Link != "/"+composed+".txt" {
+			t.Errorf("link = %q, want the composed name", entry.Link)
+		}
+	}
+}
+
+func TestAlternateForms(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"plain.txt", 0},
+		{"caf\u00e9", 1},
+		{"cafe\u0301", 1},
+		{"\ud55c\uae00", 1},
+	}
+	for _, tt := range tests {
+		if got := alternateForms(tt.name); len(got) != tt.want {
+			t.Errorf("alternateForms(%q) = %q, want %d forms", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	dir := writeTree(t, map[string]string{"digits.txt": "0123456789"})
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "digits.txt"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, testConfig(dir))
+	lastModified := modified.Format(http.TimeFormat)
+
+	tests := []struct {
+		name, rangeHeader, ifRange string
+		status                     int
+		body                       string
+	}{
+		{"no validator", "bytes=2-4", "", 206, "234"},
+		{"matching date", "bytes=2-4", lastModified, 206, "234"},
+		{"stale date", "bytes=2-4", modified.Add(-time.Hour).Format(http.TimeFormat), 200, "0123456789"},
+		{"etag", "bytes=2-4", `"0123456789"`, 200, "0123456789"},
+		// the whole file is sent, not a 416 for a range it no longer has
+		{"unsatisfiable stale", "bytes=50-60", `"old"`, 200, "0123456789"},
+		{"unsatisfiable", "bytes=50-60", "", 416, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := []string{"Range", tt.rangeHeader}
+			if tt.ifRange != "" {
+				headers = append(headers, "If-Range", tt.ifRange)
+			}
+			w := get(s, "GET", "/digits.txt", headers...)
+			expect(t, w, tt.status, tt.body)
+			if tt.status == 200 && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want the full file", w.Body.String())
+			}
+			if got := w.Header().Get("Last-Modified"); tt.status != 416 && got != lastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, lastModified)
+			}
+		})
+	}
+}
+
+// infoCountingFS counts the Info calls on the entries its ReadDir returns
+type infoCountingFS struct {
+	fs.FS
+	infos atomic.Int64
+}
+
+// infoCountingEntry is an fs.DirEntry of an infoCountingFS
+type infoCountingEntry struct {
+	fs.DirEntry
+	fsys *infoCountingFS
+}
+
+func (c *infoCountingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(c.FS, name)
+	for i := range entries {
+		entries[i] = infoCountingEntry{entries[i], c}
+	}
+	return entries, err
+}
+
+func (e infoCountingEntry) Info() (fs.FileInfo, error) {
+	e.fsys.infos.Add(1)
+	return e.DirEntry.Info()
+}
+
+func TestListingSymlinks(t *testing.T) {
+	dir := writeTree(t, map[string]string{"file.txt": "file", "sub/inner.txt": "inner"})
+	symlink(t, "file.txt", dir, "to-file")
+	symlink(t, "sub", dir, "to-sub")
+	symlink(t, "nowhere.txt", dir, "dangling")
+	s := newTestServer(t, testConfig(dir))
+
+	got := map[string]Entry{}
+	for _, entry := range listing(t, s, "/").Dirs[0].Entries {
+		got[entry.Name] = entry
+	}
+	tests := []Entry{
+		{Name: "dangling", Symlink: true, Target: "nowhere.txt", Broken: true},
+		{Name: "file.txt", Ranges: true},
+		{Name: "sub/", IsDir: true},
+		{Name: "to-file", Symlink: true, Target: "file.txt", Ranges: true},
+		{Name: "to-sub/", IsDir: true, Symlink: true, Target: "sub"},
+	}
+	for _, want := range tests {
+		entry, ok := got[want.Name]
+		entry.Link = ""
+		if !ok || entry != want {
+			t.Errorf("entry %s = %+v, want %+v", want.Name, entry, want)
+		}
+	}
+	// the dangling link is listed, but there's nothing to serve
+	expect(t, get(s, "GET", "/dangling"), 404, "")
+}
+
+func TestListingDoesntStat(t *testing.T) {
+	files := map[string]string{}
+	for i := range 20 {
+		files[fmt.Sprintf("dir/%02d.txt", i)] = "x"
+	}
+	counting := &infoCountingFS{FS: os.DirFS(writeTree(t, files))}
+	conf := testConfig()
+	conf.FS = []FS{{Name: "fs", FS: counting}}
+	s := newTestServer(t, conf)
+
+	if names := listing(t, s, "/dir/").names()[0]; len(names) != 21 {
+		t.Fatalf("listing = %q", names)
+	}
+	if n := counting.infos.Load(); n != 0 {
+		t.Errorf("listing by name called Info %d times, want none", n)
+	}
+	// sorting by size needs it, once per entry
+	listing(t, s, "/dir/?sort=size")
+	if n := counting.infos.Load(); n != 20 {
+		t.Errorf("listing by size called Info %d times, want 20", n)
+	}
+}
+
+func BenchmarkListing(b *testing.B) {
+	files := map[string]string{}
+	for i := range 1000 {
+		files[fmt.Sprintf("dir/file%04d.txt", i)] = ""
+	}
+	s := newTestServer(b, testConfig(writeTree(b, files)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if w := get(s, "GET", "/dir/"); w.Code != 200 {
+			b.Fatalf("GET /dir/ = %d", w.Code)
+		}
+	}
+}
+
+// replacingFS calls replace after opening a file, as if it were replaced
+// between the check and the read
+type replacingFS struct {
+	fs.FS
+	replace func(name string)
+	opens   map[string]int
+}
+
+func (r *replacingFS) Open(name string) (fs.File, error) {
+	r.opens[name]++
+	file, err := r.FS.Open(name)
+	if err == nil {
+		r.replace(name)
+	}
+	return file, err
+}
+
+func TestFileReplacedWhileServed(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "original"})
+	replaced := false
+	fsys := &replacingFS{FS: os.DirFS(dir), opens: map[string]int{}, replace: func(name string) {
+		if name != "a.txt" || replaced {
+			return
+		}
+		replaced = true
+		// a new file is moved over the name, as editors and builds do
+		replacement := filepath.Join(t.TempDir(), "a.txt")
+		err := os.WriteFile(replacement, []byte("a much longer replacement"), 0o644)
+		if err == nil {
+			err = os.Rename(replacement, filepath.Join(dir, "a.txt"))
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	}}
+	conf := testConfig()
+	conf.FS = []FS{{Name: "fs", FS: fsys}}
+	s := newTestServer(t, conf)
+
+	// the size and body are both of the file that was opened
+	w := get(s, "GET", "/a.txt")
+	expect(t, w, 200, "")
+	if w.Body.String() != "original" || w.Header().Get("Content-Length") != "8" {
+		t.Errorf("sent %q with Content-Length %s, want the original file", w.Body.String(), w.Header().Get("Content-Length"))
+	}
+	expect(t, get(s, "GET", "/a.txt"), 200, "a much longer replacement")
+
+	get(s, "GET", "/missing.txt")
+	if n := fsys.opens["missing.txt"]; n != 1 {
+		t.Errorf("a missing file was opened %d times, want 1", n)
+	}
+}
+
+func BenchmarkServeFile(b *testing.B) {
+	s := newTestServer(b, testConfig(writeTree(b, map[string]string{"dir/a.txt": "hello"})))
+	b.ReportAllocs()
+	for b.Loop() {
+		if w := get(s, "GET", "/dir/a.txt"); w.Code != 200 {
+			b.Fatalf("GET /dir/a.txt = %d", w.Code)
+		}
+	}
+}
+
+// discardResponse requests target from the server at addr over a new
+// connection, and throws the body away. The body is copied from the
+// connection to /dev/null, which Linux splices without it passing through
+// the process, so that it's the server's copying that is measured
+func discardResponse(b *testing.B, addr, target string) int64 {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", target, addr)
+	// the headers are read a byte at a time, leaving the body unread
+	var header []byte
+	for !bytes.HasSuffix(header, []byte("\r\n\r\n")) {
+		var c [1]byte
+		if _, err := conn.Read(c[:]); err != nil {
+			b.Fatalf("reading the headers: %v", err)
+		}
+		header = append(header, c[0])
+	}
+	if !bytes.HasPrefix(header, []byte("HTTP/1.1 200 ")) {
+		b.Fatalf("response %q", header)
+	}
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer null.Close()
+	n, err := io.Copy(null, conn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return n
+}
+
+// BenchmarkSendLargeFile sends a 1GiB sparse file over loopback, as is and
+// through a ResponseWriter that hides net/http's ReadFrom, as a wrapper
+// without one used to do to every file. cpu-ms/op is of the whole process,
+// which is mostly the server as the client doesn't read the body itself
+func BenchmarkSendLargeFile(b *testing.B) {
+	const size = 1 << 30
+	dir := b.TempDir()
+	f, err := os.Create(filepath.Join(dir, "disk.img"))
+	if err == nil {
+		err = f.Truncate(size)
+		f.Close()
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := newTestServer(b, testConfig(dir))
+
+	handlers := map[string]http.Handler{
+		"sendfile": s,
+		"copy": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.ServeHTTP(struct{ http.ResponseWriter }{w}, r)
+		}),
+	}
+	for _, name := range []string{"sendfile", "copy"} {
+		b.Run(name, func(b *testing.B) {
+			ts := httptest.NewServer(handlers[name])
+			defer ts.Close()
+			b.SetBytes(size)
+			start, measured := cpuTime()
+			for b.Loop() {
+				if n := discardResponse(b, ts.Listener.Addr().String(), "/disk.img"); n != size {
+					b.Fatalf("read %d bytes, want %d", n, size)
+				}
+			}
+			if end, ok := cpuTime(); measured && ok {
+				b.ReportMetric(float64(end-start)/float64(b.N)/1e6, "cpu-ms/op")
+			}
+		})
+	}
+}
+
+func TestBlockDotfiles(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		".env":              "SECRET=1",
+		".git/config":       "[core]",
+		"sub/.hidden":       "hidden",
+		"sub/visible.txt":   "visible",
+		"sub/file.with.dot": "dots",
+	})
+	conf := testConfig(dir)
+	conf.BlockDotfiles = true
+	blocked := newTestServer(t, conf)
+	open := newTestServer(t, testConfig(dir))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/.env", 403},
+		{"/.git/config", 403},
+		{"/.git/", 403},
+		{"/sub/.hidden", 403},
+		{"/%2eenv", 403},
+		{"/sub%2F.hidden", 403},
+		{"/.missing", 403},
+		{"/sub/visible.txt", 200},
+		{"/sub/file.with.dot", 200},
+		{"/sub/./visible.txt", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := get(blocked, "GET", tt.path)
+			expect(t, w, tt.status, "")
+			if tt.status == 403 && strings.Contains(w.Body.String(), "SECRET") {
+				t.Error("sent the file")
+			}
+		})
+	}
+	if got := strings.Join(listing(t, blocked, "/sub/").names()[0], ","); got != "../,file.with.dot,visible.txt" {
+		t.Errorf("listing = %s, want the dotfile left out", got)
+	}
+	if names := listing(t, blocked, "/").names()[0]; len(names) != 1 || names[0] != "sub/" {
+		t.Errorf("listing = %q, want only sub/", names)
+	}
+	// without the flag they're files like any other
+	expect(t, get(open, "GET", "/.env"), 200, "SECRET=1")
+}
+
+func TestDotPath(t *testing.T) {
+	for urlPath, want := range map[string]bool{
+		"/.env":            true,
+		"/a/.git/config":   true,
+		"/a\\.git":         true,
+		"/..":              true,
+		"/":                false,
+		"/./a":             false,
+		"/a/b.c":           false,
+		"/a./b":            false,
+		"/.well-known/key": true,
+	} {
+		if got := dotPath(urlPath); got != want {
+			t.Errorf("dotPath(%q) = %v, want %v", urlPath, got, want)
+		}
+	}
+}
+
+func TestCanonicalPaths(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a", "sub/b.txt": "b", "site/index.html": "site"})
+	conf := testConfig(dir)
+	conf.CanonicalPaths = true
+	conf.Mounts = MountList{{Prefix: "/docs", Dir: dir}}
+	s := newTestServer(t, conf)
+
+	tests := []struct {
+		path     string
+		location string
+	}{
+		{"/docs//a.txt", "/docs/a.txt"},
+		{"//a.txt?x=1&y", "/a.txt?x=1&y"},
+		{"/sub/./b.txt", "/sub/b.txt"},
+		{"/sub//", "/sub/"},
+		// directories have a trailing slash and files don't
+		{"/sub", "/sub/"},
+		{"/site?page=2", "/site/?page=2"},
+		{"/a.txt/", "/a.txt"},
+		{"/sub/b.txt/?v=1", "/sub/b.txt?v=1"},
+		{"/docs/sub", "/docs/sub/"},
+		{"/docs/a.txt/", "/docs/a.txt"},
+	}
+	for _, tt := range tests {
+		for _, method := range []string{"GET", "HEAD"} {
+			w := get(s, method, tt.path)
+			if w.Code != 301 || w.Header().Get("Location") != tt.location {
+				t.Errorf("%s %s = %d to %q, want a 301 to %q", method, tt.path, w.Code, w.Header().Get("Location"), tt.location)
+			}
+		}
+	}
+	for _, path := range []string{"/a.txt", "/sub/", "/site/", "/docs/a.txt", "/missing/", "/%2F/a.txt"} {
+		if w := get(s, "GET", path); w.Code == 301 {
+			t.Errorf("GET %s was redirected to %q", path, w.Header().Get("Location"))
+		}
+	}
+	// a client would resend the body of anything else as a GET
+	for _, method := range []string{"POST", "PUT", "DELETE"} {
+		for _, path := range []string{"//a.txt", "/sub", "/a.txt/"} {
+			if w := get(s, method, path); w.Code == 301 {
+				t.Errorf("%s %s was redirected to %q", method, path, w.Header().Get("Location"))
+			}
+		}
+	}
+
+	// without the flag each of them is served where it is
+	s = newTestServer(t, testConfig(dir))
+	expect(t, get(s, "GET", "//a.txt"), 200, "a")
+	expect(t, get(s, "GET", "/a.txt/"), 200, "a")
+	expect(t, get(s, "GET", "/site"), 200, "site")
+}
+
+// unseekableFS hides the Seek method of the files it opens
+type unseekableFS struct{ fs.FS }
+
+func (u unseekableFS) Open(name string) (fs.File, error) {
+	file, err := u.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ fs.File }{file}, nil
+}
+
+func TestAcceptRanges(t *testing.T) {
+	files := map[string]string{"a.txt": "0123456789", "sub/b.txt": "b"}
+	disk := newTestServer(t, testConfig(writeTree(t, files)))
+	conf := testConfig()
+	conf.FS = []FS{{Name: "stream", FS: unseekableFS{os.DirFS(writeTree(t, files))}}}
+	stream := newTestServer(t, conf)
+
+	tests := []struct {
+		name   string
+		s      *Server
+		method string
+		ranges string
+		status int
+		body   string
+	}{
+		{"GET", disk, "GET", "bytes", 206, "234"},
+		{"HEAD", disk, "HEAD", "bytes", 206, ""},
+		// a file that can't seek is sent whole and says so
+		{"unseekable GET", stream, "GET", "none", 200, "0123456789"},
+		{"unseekable HEAD", stream, "HEAD", "none", 200, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(tt.s, tt.method, "/a.txt", "Range", "bytes=2-4")
+			expect(t, w, tt.status, tt.body)
+			if got := w.Header().Get("Accept-Ranges"); got != tt.ranges {
+				t.Errorf("Accept-Ranges = %q, want %q", got, tt.ranges)
+			}
+		})
+	}
+
+	w := get(disk, "GET", "/", "Accept", "application/json")
+	if vary := w.Header().Get("Vary"); !strings.Contains(vary, "Accept") {
+		t.Errorf("listing Vary = %q, want Accept", vary)
+	}
+	for _, entry := range listing(t, disk, "/").Dirs[0].Entries {
+		if want := !entry.IsDir; entry.Ranges != want {
+			t.Errorf("listed %s with ranges %v, want %v", entry.Name, entry.Ranges, want)
+		}
+	}
+}
+
+func TestListingFormat(t *testing.T) {
+	s := newTestServer(t, testConfig(writeTree(t, map[string]string{"dir/a.txt": "a"})))
+
+	tests := []struct {
+		name    string
+		target  string
+		headers []string
+		json    bool
+	}{
+		{"browser", "/dir/", []string{"Accept", "text/html,application/xhtml+xml,*/*;q=0.8"}, false},
+		{"no Accept", "/dir/", nil, false},
+		{"Accept", "/dir/", []string{"Accept", "application/json"}, true},
+		{"header", "/dir/", []string{"X-Serve-Format", "json"}, true},
+		{"header in capitals", "/dir/", []string{"X-Serve-Format", "JSON"}, true},
+		// the explicit switch doesn't depend on what a proxy made of Accept
+		{"header over Accept", "/dir/", []string{"X-Serve-Format", "json", "Accept", "text/html"}, true},
+		{"other header", "/dir/", []string{"X-Serve-Format", "html"}, false},
+		{"query", "/dir/?format=json", []string{"Accept", "text/html"}, true},
+		{"query with sort", "/dir/?sort=size&format=json", nil, true},
+		{"other query", "/dir/?format=xml", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(s, "GET", tt.target, tt.headers...)
+			want := "text/html; charset=utf-8"
+			if tt.json {
+				want = "application/json"
+			}
+			if ct := w.Header().Get("Content-Type"); w.Code != 200 || ct != want {
+				t.Errorf("got %d with Content-Type %q, want %q", w.Code, ct, want)
+			}
+			if vary := w.Header().Values("Vary"); !slices.Contains(vary, "Accept, X-Serve-Format") {
+				t.Errorf("Vary = %q", vary)
+			}
+			var l Listing
+			if err := json.Unmarshal(w.Body.Bytes(), &l); tt.json && (err != nil || len(l.Dirs) != 1) {
+				t.Errorf("%v: %s", err, w.Body.String())
+			}
+		})
+	}
+
+	// files are served as they are however they're asked for
+	w := get(s, "GET", "/dir/a.txt?format=json", "X-Serve-Format", "json")
+	expect(t, w, 200, "a")
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q for a file", ct)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"sub/a.txt": "a", "sub/b.txt": "bbbbb", "sub/c.txt": "ccc", "sub/d.txt": "dd", "few/e.txt": "e",
+	})
+	conf := testConfig(dir)
+	conf.MaxEntries = 2
+	s := newTestServer(t, conf)
+
+	l := listing(t, s, "/sub/")
+	if got := strings.Join(l.names()[0], ","); got != "../,a.txt,b.txt" || l.Dirs[0].Shown != 2 || l.Dirs[0].Total != 4 {
+		t.Errorf("listed %s, %d of %d, want the first 2 of 4", got, l.Dirs[0].Shown, l.Dirs[0].Total)
+	}
+	// the listing is sorted before it's cut short
+	l = listing(t, s, "/sub/?sort=size&reverse=1")
+	if got := strings.Join(l.names()[0], ","); got != "../,b.txt,c.txt" {
+		t.Errorf("listed %s, want the 2 largest", got)
+	}
+	w := get(s, "GET", "/sub/")
+	if body := w.Body.String(); !strings.Contains(body, "truncated, 2 of 4 shown") || strings.Contains(body, "d.txt") {
+		t.Errorf("page doesn't say it was truncated to 2 of 4:\n%s", body)
+	}
+	if body := get(s, "GET", "/few/").Body.String(); strings.Contains(body, "shown</p>") {
+		t.Error("a directory under the limit was said to be truncated")
+	}
+}
+
+func TestAutoIndex(t *testing.T) {
+	dir := writeTree(t, map[string]string{"sub/a.txt": "a", "site/index.html": "site"})
+	tests := []struct {
+		autoIndex string
+		status    int
+	}{
+		{"", 404},
+		{"forbidden", 403},
+		{"list", 200},
+	}
+	for _, tt := range tests {
+		conf := testConfig(dir)
+		conf.NoList = true
+		conf.AutoIndex = tt.autoIndex
+		s := newTestServer(t, conf)
+
+		w := get(s, "GET", "/sub/")
+		if w.Code != tt.status {
+			t.Errorf("--auto-index %q: GET /sub/ = %d, want %d", tt.autoIndex, w.Code, tt.status)
+		}
+		if listed := strings.Contains(w.Body.String(), `href="/sub/a.txt"`); listed != (tt.status == 200) {
+			t.Errorf("--auto-index %q: listed a.txt = %v", tt.autoIndex, listed)
+		}
+		// the index.html and directories that don't exist are as they were
+		expect(t, get(s, "GET", "/site/"), 200, "site")
+		expect(t, get(s, "GET", "/missing/"), 404, "")
+		expect(t, get(s, "GET", "/sub/a.txt"), 200, "a")
+	}
+
+	// a mount with its own --no-list is answered the same way
+	conf := testConfig(dir)
+	conf.AutoIndex = "forbidden"
+	conf.Mounts = MountList{{Prefix: "/m", Dir: dir, NoList: true}}
+	s := newTestServer(t, conf)
+	expect(t, get(s, "GET", "/m/sub/"), 403, "")
+	expect(t, get(s, "GET", "/m/missing/"), 404, "")
+	if w := get(s, "GET", "/sub/"); w.Code != 200 || !strings.Contains(w.Body.String(), `href="/sub/a.txt"`) {
+		t.Errorf("GET /sub/ = %d, want it listed outside the mount", w.Code)
+	}
+}
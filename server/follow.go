@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+const (
+	// followPollInterval is how often a followed file is checked for new
+	// data
+	followPollInterval = 250 * time.Millisecond
+	// followTail is how much of the end of a file is sent when following
+	// starts, like the last lines tail -f shows
+	followTail = 64 << 10
+)
+
+// followKey is the request context key of the channel that is closed when the
+// server stops, ending followed files
+type followKey struct{}
+
+// wantsFollow reports whether r asked for the file to be followed with
+// ?follow, which is only attached by withFollow when Follow is set
+func wantsFollow(r *http.Request) bool {
+	_, ok := r.Context().Value(followKey{}).(<-chan struct{})
+	return ok && r.URL.Query().Has("follow")
+}
+
+// withFollow attaches the channel that ends followed files to the request
+func withFollow(r *http.Request, stopping <-chan struct{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), followKey{}, stopping))
+}
+
+// followFile streams file as text from near its end, then sends whatever is
+// appended to it until the client goes away or the server stops, like
+// tail -f. The open file is followed, so a log that is rotated stops updating
+// and needs to be requested again. A file that is truncated is sent again from
+// the start
+func followFile(w http.ResponseWriter, r *http.Request, file fs.File, content io.ReadSeeker, stat fs.FileInfo) {
+	stopping := r.Context().Value(followKey{}).(<-chan struct{})
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	offset := int64(0)
+	if stat.Size() > followTail {
+		offset = stat.Size() - followTail
+	}
+	if _, err := content.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+	if offset > 0 {
+		// skip the partial first line
+		start := make([]byte, followTail)
+		n, _ := io.ReadFull(content, start)
+		if i := bytes.IndexByte(start[:n], '\n'); i >= 0 {
+			offset += int64(i + 1)
+		}
+		if _, err := content.Seek(offset, io.SeekStart); err != nil {
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	for {
+		n, err := io.Copy(w, content)
+		offset += n
+		if err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		case <-stopping:
+			return
+		}
+		if stat, err := file.Stat(); err == nil && stat.Size() < offset {
+			if offset, err = content.Seek(0, io.SeekStart); err != nil {
+				return
+			}
+		}
+	}
+}
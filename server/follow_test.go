@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// appendTo appends data to the file at name
+func appendTo(t *testing.T, name, data string) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	dir := writeTree(t, map[string]string{"app.log": "started\n"})
+	conf := testConfig(dir)
+	conf.Follow = true
+	ts := httptest.NewServer(newTestServer(t, conf))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/app.log?follow", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	if line, err := body.ReadString('\n'); err != nil || line != "started\n" {
+		t.Fatalf("read %q, %v, want the file so far", line, err)
+	}
+	for _, want := range []string{"request 1\n", "request 2\n"} {
+		appendTo(t, filepath.Join(dir, "app.log"), want)
+		line, err := body.ReadString('\n')
+		if err != nil || line != want {
+			t.Fatalf("read %q, %v, want %q", line, err, want)
+		}
+	}
+
+	// the stream ends when the client goes away
+	cancel()
+	done := make(chan error)
+	go func() {
+		_, err := io.Copy(io.Discard, body)
+		done <- err
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream didn't end when the request was cancelled")
+	}
+}
+
+func TestFollowEndsOnShutdown(t *testing.T) {
+	dir := writeTree(t, map[string]string{"app.log": "started\n"})
+	conf := testConfig(dir)
+	conf.Follow = true
+	s := newTestServer(t, conf)
+	done := make(chan string)
+	go func() {
+		done <- get(s, "GET", "/app.log?follow").Body.String()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.stop()
+	select {
+	case body := <-done:
+		if body != "started\n" {
+			t.Errorf("body = %q, want the file so far", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream didn't end when the server stopped")
+	}
+}
+
+func TestFollowOff(t *testing.T) {
+	dir := writeTree(t, map[string]string{"app.log": "started\n"})
+	s := newTestServer(t, testConfig(dir))
+	// without --follow the query is ignored and the file is sent as usual
+	expect(t, get(s, "GET", "/app.log?follow"), 200, "started\n")
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var errFSTimeout = errors.New("filesystem operation timed out")
+
+// fsCall runs fn, returning errFSTimeout if it takes longer than
+// --fs-timeout or the request is cancelled. A stuck fn is left running in the
+// background, abandon is called with its result if it ever completes so that
+// resources such as open files can be released
+func fsCall[T any](r *http.Request, fn func() (T, error), abandon func(T)) (T, error) {
+	conf := configFor(r)
+	if conf.FSTimeout <= 0 {
+		return fn()
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), conf.FSTimeout)
+	defer cancel()
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		value, err := fn()
+		select {
+		case done <- result{value, err}:
+		case <-ctx.Done():
+			if err == nil && abandon != nil {
+				abandon(value)
+			}
+		}
+	}()
+
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-ctx.Done():
+		var zero T
+		return zero, errFSTimeout
+	}
+}
+
+// fsTimeoutError responds with 504 Gateway Timeout after a filesystem
+// operation has timed out
+func fsTimeoutError(w http.ResponseWriter, r *http.Request) {
+	respondError(w, r, http.StatusGatewayTimeout, "filesystem timeout")
+}
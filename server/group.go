@@ -0,0 +1,57 @@
+package server
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// typeGroups are the categories of --group-by type, in the order they're
+// listed. Directories always come first and anything unrecognised last
+var typeGroups = []string{"directories", "images", "media", "code", "documents", "archives", "other"}
+
+// extensionGroups maps file extensions to their --group-by type category
+var extensionGroups = map[string]string{}
+
+func init() {
+	for group, extensions := range map[string]string{
+		"images":    ".apng .avif .bmp .gif .ico .jpeg .jpg .png .svg .tif .tiff .webp",
+		"media":     ".aac .avi .flac .m4a .mkv .mov .mp3 .mp4 .ogg .opus .wav .webm",
+		"code":      ".c .cpp .css .go .h .hpp .htm .html .java .js .json .jsx .kt .mjs .php .py .rb .rs .sh .sql .swift .toml .ts .tsx .xml .yaml .yml",
+		"documents": ".csv .doc .docx .epub .md .odp .ods .odt .pdf .ppt .pptx .rst .rtf .tex .txt .xls .xlsx",
+		"archives":  ".7z .bz2 .deb .dmg .gz .iso .jar .rar .rpm .tar .tbz .tgz .txz .xz .zip .zst",
+	} {
+		for _, extension := range strings.Fields(extensions) {
+			extensionGroups[extension] = group
+		}
+	}
+}
+
+// typeGroup returns the --group-by type category of an entry
+func typeGroup(entry Entry) string {
+	if entry.IsDir {
+		return "directories"
+	}
+	if group, ok := extensionGroups[strings.ToLower(path.Ext(entry.Name))]; ok {
+		return group
+	}
+	return "other"
+}
+
+// groupByType sorts entries into their categories, keeping the existing
+// order within each of them, and marks where each category starts
+func groupByType(entries []Entry) {
+	rank := map[string]int{}
+	for i, group := range typeGroups {
+		rank[group] = i
+	}
+	for i := range entries {
+		entries[i].Group = typeGroup(entries[i])
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return rank[entries[i].Group] < rank[entries[j].Group]
+	})
+	for i := range entries {
+		entries[i].GroupStart = i == 0 || entries[i].Group != entries[i-1].Group
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroupByType(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"a.zip": "", "b.png": "", "c.go": "", "d.txt": "", "e.PNG": "", "f": "", "g/h.txt": "", "z/i.txt": "",
+	})
+	conf := testConfig(dir)
+	conf.GroupBy = "type"
+	s := newTestServer(t, conf)
+
+	var got []string
+	for _, entry := range listing(t, s, "/").Dirs[0].Entries {
+		got = append(got, entry.Group+":"+entry.Name)
+	}
+	want := "directories:g/,directories:z/,images:b.png,images:e.PNG,code:c.go,documents:d.txt,archives:a.zip,other:f"
+	if strings.Join(got, ",") != want {
+		t.Errorf("listed %s, want %s", strings.Join(got, ","), want)
+	}
+	// the parent directory isn't in a group
+	if l := listing(t, s, "/g/"); l.Dirs[0].Entries[0].Name != "../" || l.Dirs[0].Entries[0].Group != "" {
+		t.Errorf("listed %+v first, want ../ without a group", l.Dirs[0].Entries[0])
+	}
+
+	body := get(s, "GET", "/").Body.String()
+	for _, heading := range []string{"directories", "images", "code", "documents", "archives", "other"} {
+		if strings.Count(body, `<div class="group">`+heading+`</div>`) != 1 {
+			t.Errorf("page doesn't have one %s heading", heading)
+		}
+	}
+	if !strings.Contains(body, `class="entry group-images" href="/b.png"`) {
+		t.Error("the image entry isn't marked as one")
+	}
+
+	// by default the listing is in name order, without headings
+	plain := newTestServer(t, testConfig(dir))
+	if names := strings.Join(listing(t, plain, "/").names()[0], ","); names != "a.zip,b.png,c.go,d.txt,e.PNG,f,g/,z/" {
+		t.Errorf("listed %s, want name order", names)
+	}
+	if body := get(plain, "GET", "/").Body.String(); strings.Contains(body, `<div class="group">`) {
+		t.Error("a listing was grouped without --group-by")
+	}
+}
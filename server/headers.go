@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headersName and redirectsName are the names of the Netlify style headers
+// and redirects files looked for at the root of each directory served
+const (
+	headersName   = "_headers"
+	redirectsName = "_redirects"
+)
+
+// headerToken matches a valid header name
+var headerToken = regexp.MustCompile("^[-!#$%&'*+.^_`|~0-9A-Za-z]+$")
+
+// headerBlock is a path pattern of a headers file and the headers of the
+// lines below it
+type headerBlock struct {
+	pattern *regexp.Regexp
+	headers http.Header
+}
+
+// placeholder matches the name of a :placeholder
+var placeholder = regexp.MustCompile(`^:[A-Za-z0-9_]+`)
+
+// pathPattern compiles a path pattern of a _headers or _redirects file, where
+// * matches anything, including slashes, as the group splat, and a
+// :placeholder at the start of a path element the rest of the element as
+// the group placeholder
+func pathPattern(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		name := placeholder.FindString(pattern[i:])
+		switch {
+		case pattern[i] == '*':
+			expr.WriteString("(?P<splat>.*)")
+		case name != "" && (i == 0 || pattern[i-1] == '/'):
+			expr.WriteString("(?P<" + name[1:] + ">[^/]+)")
+			i += len(name) - 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+// parseHeaders reads a headers file as Netlify does: a line starting with /
+// is a path pattern, the lines after it are Name: value headers to send for
+// the paths that match, and blank lines and those starting with # are
+// skipped. A header given more than once in a block has all of its values
+// sent. name is used in errors, which give the line
+func parseHeaders(name string, data []byte) ([]headerBlock, error) {
+	var blocks []headerBlock
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "/") {
+			if strings.ContainsAny(text, " \t") {
+				return nil, fmt.Errorf("%s:%d: path %q can't contain spaces", name, line, text)
+			}
+			blocks = append(blocks, headerBlock{pattern: pathPattern(text), headers: http.Header{}})
+			continue
+		}
+		if len(blocks) == 0 {
+			return nil, fmt.Errorf("%s:%d: header %q comes before any path", name, line, text)
+		}
+		key, value, found := strings.Cut(text, ":")
+		key = strings.TrimSpace(key)
+		if !found || !headerToken.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: expected a path or Name: value, got %q", name, line, text)
+		}
+		blocks[len(blocks)-1].headers.Add(key, strings.TrimSpace(value))
+	}
+	return blocks, scanner.Err()
+}
+
+// cachedHeaders is the blocks of a headers file when it had a size and
+// modification time
+type cachedHeaders struct {
+	size    int64
+	modTime time.Time
+	blocks  []headerBlock
+}
+
+// headersCache holds the parsed headers files, which are read again when
+// their size or modification time changes. A file that no longer parses
+// keeps its last good blocks, with the error logged once
+type headersCache struct {
+	mu    sync.Mutex
+	files map[string]cachedHeaders
+}
+
+func newHeadersCache() *headersCache {
+	return &headersCache{files: map[string]cachedHeaders{}}
+}
+
+// blocks returns the blocks of the headers file called name in fsys, key
+// identifying it in the cache
+func (c *headersCache) blocks(fsys fs.FS, name, key string, logger *log.Logger) []headerBlock {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	cached, ok := c.files[key]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.blocks
+	}
+
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return cached.blocks
+	}
+	blocks, err := parseHeaders(key, data)
+	if err != nil {
+		logger.Printf("%s, keeping the previous headers", err)
+		blocks = cached.blocks
+	}
+	c.mu.Lock()
+	c.files[key] = cachedHeaders{size: info.Size(), modTime: info.ModTime(), blocks: blocks}
+	c.mu.Unlock()
+	return blocks
+}
+
+// apply sets the headers of the blocks matching the request path, from the
+// _headers at the root of each of the Dirs and FS in turn and then
+// HeadersFile. A later block overrides the headers of an earlier one, and
+// they all override Headers
+func (c *headersCache) apply(w http.ResponseWriter, r *http.Request) {
+	conf := configFor(r)
+	var blocks []headerBlock
+	for _, src := range conf.sources() {
+		blocks = append(blocks, c.blocks(src.fsys, headersName, src.path(headersName), conf.logger())...)
+	}
+	if conf.HeadersFile != "" {
+		dir, file := filepath.Split(conf.HeadersFile)
+		blocks = append(blocks, c.blocks(os.DirFS(filepath.Clean(dir)), file, conf.HeadersFile, conf.logger())...)
+	}
+	for _, block := range blocks {
+		if block.pattern.MatchString(r.URL.Path) {
+			for key, values := range block.headers {
+				w.Header()[key] = values
+			}
+		}
+	}
+}
+
+// siteFile reports whether the file called name in src is a headers,
+// redirects or aliases file, which aren't served
+func (c *Config) siteFile(src source, name string) bool {
+	if name == headersName || name == redirectsName {
+		return true
+	}
+	if src.dir == "" {
+		return false
+	}
+	for _, file := range []string{c.HeadersFile, c.RedirectsFile, c.Aliases} {
+		if abs, err := filepath.Abs(file); file != "" && err == nil && src.path(name) == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHeadersFiles parses the headers files there are at startup, so that
+// mistakes are reported with their line rather than headers going missing.
+// Those that can't be read are left to the other checks
+func (c *Config) checkHeadersFiles() error {
+	files := []string{}
+	for _, dir := range c.Dirs {
+		files = append(files, filepath.Join(dir, headersName))
+	}
+	if c.HeadersFile != "" {
+		files = append(files, c.HeadersFile)
+	}
+	var errs []error
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if _, err := parseHeaders(file, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
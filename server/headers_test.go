@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeadersFile(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		headersName: `# cache the assets forever
+/assets/*
+  Cache-Control: public, max-age=31536000, immutable
+
+/assets/:name/raw
+  Cache-Control: no-store
+
+/*
+  X-Frame-Options: DENY
+  Link: </a.css>; rel=preload
+  Link: </b.js>; rel=preload
+`,
+		"assets/app.js":   "app",
+		"assets/logo/raw": "raw",
+		"page.html":       "page",
+		"other.txt":       "other",
+		redirectsName:     "/old /new",
+	})
+	override := filepath.Join(t.TempDir(), "headers")
+	if err := os.WriteFile(override, []byte("/other.txt\n  X-Frame-Options: SAMEORIGIN\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf := testConfig(dir)
+	conf.HeadersFile = override
+	conf.Headers = http.Header{"X-Frame-Options": {"ALLOWALL"}, "X-Global": {"yes"}}
+	s := newTestServer(t, conf)
+
+	tests := []struct {
+		path, header string
+		want         string
+	}{
+		{"/assets/app.js", "Cache-Control", "public, max-age=31536000, immutable"},
+		// a later block wins
+		{"/assets/logo/raw", "Cache-Control", "no-store"},
+		{"/page.html", "Cache-Control", ""},
+		{"/page.html", "X-Frame-Options", "DENY"},
+		{"/page.html", "Link", "</a.css>; rel=preload, </b.js>; rel=preload"},
+		{"/page.html", "X-Global", "yes"},
+		// --headers-file comes after the _headers of the directories
+		{"/other.txt", "X-Frame-Options", "SAMEORIGIN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.header, func(t *testing.T) {
+			w := get(s, "GET", tt.path)
+			expect(t, w, 200, "")
+			if got := strings.Join(w.Header().Values(tt.header), ", "); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+	// the site's files aren't served themselves
+	expect(t, get(s, "GET", "/"+headersName), 404, "")
+	expect(t, get(s, "GET", "/"+redirectsName), 404, "")
+	if names := strings.Join(listing(t, s, "/").names()[0], ","); strings.Contains(names, "_") {
+		t.Errorf("listing = %s, want the site files left out", names)
+	}
+}
+
+func TestHeadersFileReloaded(t *testing.T) {
+	dir := writeTree(t, map[string]string{headersName: "/*\n  X-Version: 1\n", "a.txt": "a"})
+	var logs bytes.Buffer
+	conf := testConfig(dir)
+	conf.Logger = log.New(&logs, "", 0)
+	s := newTestServer(t, conf)
+	version := func() string { return get(s, "GET", "/a.txt").Header().Get("X-Version") }
+	if v := version(); v != "1" {
+		t.Fatalf("X-Version = %q, want 1", v)
+	}
+
+	write := func(contents string, age time.Duration) {
+		file := filepath.Join(dir, headersName)
+		if err := os.WriteFile(file, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(age)
+		os.Chtimes(file, modTime, modTime)
+	}
+	write("/*\n  X-Version: 2\n", time.Hour)
+	if v := version(); v != "2" {
+		t.Errorf("X-Version = %q after an edit, want 2", v)
+	}
+	// a mistake keeps the headers that worked
+	write("/*\n  not a header\n", 2*time.Hour)
+	if v := version(); v != "2" {
+		t.Errorf("X-Version = %q after a bad edit, want 2 still", v)
+	}
+	if !strings.Contains(logs.String(), "keeping the previous headers") {
+		t.Errorf("the bad edit wasn't logged:\n%s", logs.String())
+	}
+}
+
+func TestHeadersFileChecked(t *testing.T) {
+	dir := writeTree(t, map[string]string{headersName: "X-Before: path\n"})
+	_, err := New(testConfig(dir))
+	if err == nil || !strings.Contains(err.Error(), headersName+":1: header \"X-Before: path\" comes before any path") {
+		t.Errorf("New = %v, want the mistake reported with its line", err)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		data, err string
+	}{
+		{"/a\n  X-A: 1\n\n# comment\n/b\n", ""},
+		{"/a b\n", `f:1: path "/a b" can't contain spaces`},
+		{"\n\nX-A: 1\n", `f:3: header "X-A: 1" comes before any path`},
+		{"/a\nno colon\n", `f:2: expected a path or Name: value, got "no colon"`},
+		{"/a\nBad Name: 1\n", `f:2: expected a path or Name: value, got "Bad Name: 1"`},
+	}
+	for _, tt := range tests {
+		_, err := parseHeaders("f", []byte(tt.data))
+		switch {
+		case err != nil && err.Error() != tt.err:
+			t.Errorf("parseHeaders(%q) = %q, want %q", tt.data, err, tt.err)
+		case err == nil && tt.err != "":
+			t.Errorf("parseHeaders(%q) = nil, want %q", tt.data, tt.err)
+		}
+	}
+}
+
+func TestPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		match         bool
+		groups        map[string]string
+	}{
+		{"/a", "/a", true, nil},
+		{"/a", "/a/b", false, nil},
+		{"/a.b", "/aXb", false, nil},
+		{"/a/*", "/a/b/c", true, map[string]string{"splat": "b/c"}},
+		{"/a/*", "/a", false, nil},
+		{"/u/:id", "/u/42", true, map[string]string{"id": "42"}},
+		{"/u/:id", "/u/42/x", false, nil},
+		{"/u/:id/*", "/u/42/x/y", true, map[string]string{"id": "42", "splat": "x/y"}},
+		// only at the start of an element
+		{"/a:b", "/a:b", true, nil},
+	}
+	for _, tt := range tests {
+		re := pathPattern(tt.pattern)
+		m := re.FindStringSubmatch(tt.path)
+		if (m != nil) != tt.match {
+			t.Errorf("%s matching %s = %v, want %v", tt.pattern, tt.path, m != nil, tt.match)
+			continue
+		}
+		for name, want := range tt.groups {
+			if got := m[re.SubexpIndex(name)]; got != want {
+				t.Errorf("%s matching %s: %s = %q, want %q", tt.pattern, tt.path, name, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const hitsFlushInterval = 30 * time.Second
+
+// Hit is the download count of a single path
+type Hit struct {
+	Path  string `json:"path"`
+	Count int64  `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// hitCounter tracks successful file responses per request path, optionally
+// persisted to a JSON file so the counts survive restarts
+type hitCounter struct {
+	mu    sync.Mutex
+	hits  map[string]*Hit
+	file  string
+	dirty bool
+	log   *log.Logger
+	// saving is held while the file is written
+	saving sync.Mutex
+}
+
+// newHitCounter loads any counts previously saved to file and, if file is
+// set, starts saving them periodically until done is closed
+func newHitCounter(file string, logger *log.Logger, done <-chan struct{}) *hitCounter {
+	c := &hitCounter{hits: map[string]*Hit{}, file: file, log: logger}
+	if file == "" {
+		return c
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		c.log.Println(err)
+	}
+	if len(data) > 0 {
+		saved := []Hit{}
+		if err := json.Unmarshal(data, &saved); err != nil {
+			c.log.Printf("%s: %s", file, err)
+		}
+		for i := range saved {
+			c.hits[saved[i].Path] = &saved[i]
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(hitsFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.save()
+			}
+		}
+	}()
+	return c
+}
+
+// record counts the response in rec if it was a file that was fully
+// written, HEAD requests and aborted downloads don't count
+func (c *hitCounter) record(r *http.Request, rec *responseRecorder) {
+	status := rec.Status()
+	if rec.file == "" || rec.disconnected(r) || r.Method != http.MethodGet || status < 200 || status > 299 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hit, ok := c.hits[r.URL.Path]
+	if !ok {
+		hit = &Hit{Path: r.URL.Path}
+		c.hits[r.URL.Path] = hit
+	}
+	hit.Count++
+	hit.Bytes += rec.written
+	c.dirty = true
+}
+
+// list returns the counts for paths starting with prefix, most downloaded
+// first
+func (c *hitCounter) list(prefix string) []Hit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := []Hit{}
+	for path, hit := range c.hits {
+		if strings.HasPrefix(path, prefix) {
+			list = append(list, *hit)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// save writes the counts to the stats file if they have changed. The file is
+// written under a temporary name and renamed into place so a crash can't
+// leave it truncated. A save still running is waited for, so the last one on
+// Close doesn't return while the periodic one is writing
+func (c *hitCounter) save() {
+	if c.file == "" {
+		return
+	}
+	c.saving.Lock()
+	defer c.saving.Unlock()
+	c.mu.Lock()
+	dirty := c.dirty
+	c.dirty = false
+	c.mu.Unlock()
+	if !dirty {
+		return
+	}
+
+	data, err := json.MarshalIndent(c.list(""), "", "\t")
+	if err != nil {
+		c.log.Println(err)
+		return
+	}
+	temp, err := os.CreateTemp(filepath.Dir(c.file), ".serve-stats-*")
+	if err != nil {
+		c.log.Println(err)
+		return
+	}
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), c.file)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		c.log.Println(err)
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+	}
+}
+
+// ServeHTTP reports the counts at /_hits as JSON, ?prefix= limits them to
+// paths under a prefix
+func (c *hitCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(c.list(r.URL.Query().Get("prefix")))
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// hits decodes the JSON report at /_hits
+func hits(t *testing.T, s *Server, query string) []Hit {
+	t.Helper()
+	w := get(s, "GET", "/_hits"+query)
+	if w.Code != 200 {
+		t.Fatalf("GET /_hits = %d", w.Code)
+	}
+	list := []Hit{}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestHits(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "aaaa", "docs/b.txt": "bb"})
+	conf := testConfig(dir)
+	conf.TrackHits = true
+	s := newTestServer(t, conf)
+
+	get(s, "GET", "/a.txt")
+	get(s, "GET", "/a.txt")
+	get(s, "GET", "/docs/b.txt")
+	// HEAD, errors, listings and partial content don't count as downloads
+	get(s, "HEAD", "/a.txt")
+	get(s, "GET", "/missing.txt")
+	get(s, "GET", "/docs/")
+	get(s, "GET", "/a.txt", "If-Modified-Since", "Fri, 01 Jan 2100 00:00:00 GMT")
+
+	got := hits(t, s, "")
+	want := []Hit{{"/a.txt", 2, 8}, {"/docs/b.txt", 1, 2}}
+	if len(got) != len(want) {
+		t.Fatalf("hits = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("hit %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got := hits(t, s, "?prefix=/docs/"); len(got) != 1 || got[0].Path != "/docs/b.txt" {
+		t.Errorf("hits under /docs/ = %+v", got)
+	}
+}
+
+func TestHitsSkipAborted(t *testing.T) {
+	conf := testConfig(writeTree(t, map[string]string{"a.txt": "aaaa"}))
+	conf.TrackHits = true
+	s := newTestServer(t, conf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("GET", "/a.txt", nil).WithContext(ctx)
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got := hits(t, s, ""); len(got) != 0 {
+		t.Errorf("hits = %+v, want the aborted download not counted", got)
+	}
+}
+
+func TestHitsStatsFile(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "aaaa"})
+	conf := testConfig(dir)
+	conf.StatsFile = filepath.Join(t.TempDir(), "stats.json")
+
+	s := newTestServer(t, conf)
+	get(s, "GET", "/a.txt")
+	get(s, "GET", "/a.txt")
+	s.Close()
+
+	// the counts carry on from the file after a restart
+	s = newTestServer(t, conf)
+	get(s, "GET", "/a.txt")
+	if got := hits(t, s, ""); len(got) != 1 || got[0] != (Hit{"/a.txt", 3, 12}) {
+		t.Errorf("hits after a restart = %+v, want 3 downloads of /a.txt", got)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// h2cServer serves s on a new listener, returning its URL and a client that
+// only speaks cleartext HTTP/2, as curl --http2-prior-knowledge does
+func h2cServer(t *testing.T, s *Server) (string, *http.Client) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Serve(ctx, ln)
+	<-s.Ready()
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	t.Cleanup(transport.CloseIdleConnections)
+	return "http://" + ln.Addr().String(), &http.Client{Transport: transport}
+}
+
+func TestH2C(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a"})
+	conf := testConfig(dir)
+	conf.HTTP2 = true
+	url, client := h2cServer(t, newTestServer(t, conf))
+
+	resp, err := client.Get(url + "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.ProtoMajor != 2 {
+		t.Errorf("GET /a.txt = %d over %s, want 200 over HTTP/2", resp.StatusCode, resp.Proto)
+	}
+
+	// without HTTP2 the server only speaks HTTP/1.1
+	url, client = h2cServer(t, newTestServer(t, testConfig(dir)))
+	if resp, err := client.Get(url + "/a.txt"); err == nil {
+		resp.Body.Close()
+		t.Errorf("h2c was negotiated without HTTP2, %s", resp.Proto)
+	}
+}
+
+// TestH2CStreams checks that the responses that are streamed are flushed as
+// they're written over HTTP/2, rather than held until the stream ends
+func TestH2CStreams(t *testing.T) {
+	dir := writeTree(t, map[string]string{"page.html": "<body>v1</body>", "app.log": "started\n"})
+	conf := testConfig(dir)
+	conf.HTTP2 = true
+	conf.LiveReload = true
+	conf.Follow = true
+	url, client := h2cServer(t, newTestServer(t, conf))
+
+	stream := func(path string) *bufio.Reader {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		t.Cleanup(cancel)
+		req, _ := http.NewRequestWithContext(ctx, "GET", url+path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("GET %s over %s, want HTTP/2", path, resp.Proto)
+		}
+		return bufio.NewReader(resp.Body)
+	}
+	readLine := func(r *bufio.Reader, want string) {
+		t.Helper()
+		line, err := r.ReadString('\n')
+		if err != nil || line != want {
+			t.Errorf("read %q, %v, want %q", line, err, want)
+		}
+	}
+
+	followed := stream("/app.log?follow")
+	readLine(followed, "started\n")
+	f, err := os.OpenFile(filepath.Join(dir, "app.log"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("request\n"); err != nil {
+		t.Fatal(err)
+	}
+	readLine(followed, "request\n")
+
+	events := stream("/_events")
+	// the poller's first pass has to see the old file, to notice the change
+	time.Sleep(2 * livePollInterval)
+	if err := os.WriteFile(filepath.Join(dir, "page.html"), []byte("<body>version 2</body>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	readLine(events, "data: reload\n")
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ignoreFile is the name of the files listing what isn't served from their
+// directory and below, in .gitignore syntax
+const ignoreFile = ".serveignore"
+
+// ignoreRule is a line of an ignoreFile. The pattern is split into path
+// elements, those of a pattern without a / in front of or within it being
+// preceded by ** so it matches at any depth
+type ignoreRule struct {
+	elems   []string
+	negate  bool
+	dirOnly bool
+}
+
+// parseIgnore reads the rules in an ignoreFile as git does: blank lines and
+// those starting with # are skipped, ! re-includes what an earlier rule
+// excluded, a trailing / only matches directories and ** matches any number
+// of directories. A leading \ escapes a # or !
+func parseIgnore(data []byte) []ignoreRule {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// trailing spaces are dropped unless escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if rest, ok := strings.CutPrefix(line, "!"); ok {
+			line, rule.negate = rest, true
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if rest, ok := strings.CutSuffix(line, "/"); ok {
+			line, rule.dirOnly = rest, true
+		}
+		if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+		line = strings.TrimPrefix(line, "/")
+		if line == "" || line == "**/" {
+			continue
+		}
+		rule.elems = strings.Split(line, "/")
+		if _, err := path.Match(strings.ReplaceAll(line, "**", "*"), ""); err != nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// matchElems reports whether the path elements of name match those of a
+// pattern, where ** matches any number of elements and a trailing ** at
+// least one, so that dir/** is everything inside dir but not dir itself
+func matchElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			if len(rest) == 0 {
+				return len(name) > 0
+			}
+			for i := range len(name) + 1 {
+				if matchElems(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// cachedIgnore is the rules of an ignoreFile when it had a size and
+// modification time
+type cachedIgnore struct {
+	size    int64
+	modTime time.Time
+	rules   []ignoreRule
+}
+
+// ignoreCache holds the parsed ignoreFiles of the directories served, which
+// are read again when their size or modification time changes. Only the
+// files that exist are kept, so it grows no larger than the trees served
+type ignoreCache struct {
+	mu    sync.Mutex
+	files map[string]cachedIgnore
+}
+
+func newIgnoreCache() *ignoreCache {
+	return &ignoreCache{files: map[string]cachedIgnore{}}
+}
+
+// ignoresKey is the request context key of the Server's ignoreCache. Without
+// one, such as for serve share, ignoreFiles don't apply
+type ignoresKey struct{}
+
+// rules returns the rules of the ignoreFile in the directory dir of src, nil
+// if there is none
+func (c *ignoreCache) rules(src source, dir string) ([]ignoreRule, error) {
+	name := path.Join(dir, ignoreFile)
+	info, err := fs.Stat(src.fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := src.path(name)
+	c.mu.Lock()
+	cached, ok := c.files[key]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.rules, nil
+	}
+
+	data, err := fs.ReadFile(src.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	cached = cachedIgnore{size: info.Size(), modTime: info.ModTime(), rules: parseIgnore(data)}
+	c.mu.Lock()
+	c.files[key] = cached
+	c.mu.Unlock()
+	return cached.rules, nil
+}
+
+// ignoreMatcher is the rules of the ignoreFiles in a directory of a source
+// and the directories above it, read once to decide for each of its entries
+type ignoreMatcher struct {
+	// dir is the path elements of the directory, none for the root
+	dir []string
+	// rules[i] are the rules of the directory made of the first i elements
+	// of dir
+	rules [][]ignoreRule
+	// unreadable is set if one of the ignoreFiles can't be read
+	unreadable bool
+}
+
+// matcher reads the rules that apply to the entries of the directory called
+// dir in src
+func (c *ignoreCache) matcher(src source, dir string) *ignoreMatcher {
+	m := &ignoreMatcher{}
+	if dir != "." {
+		m.dir = strings.Split(dir, "/")
+	}
+	for i := range len(m.dir) + 1 {
+		rules, err := c.rules(src, path.Join(append([]string{"."}, m.dir[:i]...)...))
+		if err != nil {
+			m.unreadable = true
+			break
+		}
+		m.rules = append(m.rules, rules)
+	}
+	return m
+}
+
+// ignored reports whether the entry called name of the directory is
+// excluded by the ignoreFiles above it, which compose as .gitignore files
+// do: the rules of a deeper file come after those of the files above, the
+// last rule that matches decides, and nothing can be re-included from a
+// directory that is excluded. The ignoreFiles themselves are always
+// excluded, and so is everything below one that can't be read
+func (m *ignoreMatcher) ignored(name string, isDir bool) bool {
+	if m.unreadable || name == ignoreFile {
+		return true
+	}
+	elems := append(slices.Clip(m.dir), name)
+	for end := 1; end <= len(elems); end++ {
+		excluded := false
+		for dir, rules := range m.rules[:end] {
+			for _, rule := range rules {
+				if rule.dirOnly && end == len(elems) && !isDir {
+					continue
+				}
+				if matchElems(rule.elems, elems[dir:end]) {
+					excluded = !rule.negate
+				}
+			}
+		}
+		if excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored reports whether the file or directory called name in src is
+// excluded by the ignoreFiles of the directories above it. A listing uses
+// the matcher of its directory instead, rather than reading them for each
+// entry
+func (c *ignoreCache) ignored(src source, name string, isDir bool) bool {
+	if name == "." {
+		return false
+	}
+	return c.matcher(src, path.Dir(name)).ignored(path.Base(name), isDir)
+}
+
+// ignoredFile reports whether the file or directory called name in src is
+// excluded by an ignoreFile, or is a headers or redirects file, as served for
+// r
+func ignoredFile(r *http.Request, src source, name string, isDir bool) bool {
+	ignores, ok := r.Context().Value(ignoresKey{}).(*ignoreCache)
+	return ok && (ignores.ignored(src, name, isDir) || configFor(r).siteFile(src, name))
+}
+
+// dirIgnores returns the matcher for the entries of the directory called dir
+// in src as served for r, nil if ignoreFiles don't apply
+func dirIgnores(r *http.Request, src source, dir string) *ignoreMatcher {
+	ignores, ok := r.Context().Value(ignoresKey{}).(*ignoreCache)
+	if !ok {
+		return nil
+	}
+	return ignores.matcher(src, dir)
+}
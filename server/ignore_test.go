@@ -0,0 +1,139 @@
+package server
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingFS counts the ignoreFiles opened in an fs.FS
+type countingFS struct {
+	fs.FS
+	opens atomic.Int64
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	if path.Base(name) == ignoreFile {
+		c.opens.Add(1)
+	}
+	return c.FS.Open(name)
+}
+
+func TestServeIgnore(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		ignoreFile:          "*.log\nbuild/\n/secret.txt\n!keep.log\n",
+		"app.log":           "log",
+		"keep.log":          "kept",
+		"secret.txt":        "secret",
+		"build/out.js":      "built",
+		"sub/secret.txt":    "not the root one",
+		"sub/" + ignoreFile: "*.tmp\n!app.log\n",
+		"sub/a.tmp":         "tmp",
+		"sub/app.log":       "re-included",
+		"sub/deeper/b.tmp":  "tmp",
+		"sub/deeper/ok.txt": "ok",
+		"notbuild/build":    "a file named build",
+		"sub/deeper/c.txt":  "c",
+	})
+	s := newTestServer(t, testConfig(dir))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/app.log", 404},
+		{"/keep.log", 200},
+		{"/secret.txt", 404},
+		{"/build/out.js", 404},
+		{"/build/", 404},
+		{"/sub/secret.txt", 200},
+		{"/sub/a.tmp", 404},
+		{"/sub/app.log", 200},
+		{"/sub/deeper/b.tmp", 404},
+		{"/sub/deeper/ok.txt", 200},
+		{"/notbuild/build", 200},
+		{"/" + ignoreFile, 404},
+		{"/sub/" + ignoreFile, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			expect(t, get(s, "GET", tt.path), tt.status, "")
+		})
+	}
+
+	for urlPath, want := range map[string]string{
+		"/":            "keep.log,notbuild/,sub/",
+		"/sub/":        "app.log,deeper/,secret.txt",
+		"/sub/deeper/": "c.txt,ok.txt",
+	} {
+		names := listing(t, s, urlPath).names()[0]
+		if urlPath != "/" {
+			names = names[1:]
+		}
+		if got := strings.Join(names, ","); got != want {
+			t.Errorf("listing of %s = %s, want %s", urlPath, got, want)
+		}
+	}
+}
+
+func TestListingReadsIgnoreFilesOnce(t *testing.T) {
+	files := map[string]string{ignoreFile: "*.log\n", "a/b/" + ignoreFile: "*.tmp\n", "a/b/one/file.txt": ""}
+	for i := range 50 {
+		files["a/b/many/file"+strings.Repeat("x", i)+".txt"] = ""
+	}
+	counting := &countingFS{FS: os.DirFS(writeTree(t, files))}
+	conf := testConfig()
+	conf.FS = []FS{{Name: "fs", FS: counting}}
+	s := newTestServer(t, conf)
+
+	opens := func(urlPath string) int64 {
+		listing(t, s, urlPath)
+		before := counting.opens.Load()
+		if l := listing(t, s, urlPath); len(l.Dirs[0].Entries) < 2 {
+			t.Fatalf("listing of %s is empty", urlPath)
+		}
+		return counting.opens.Load() - before
+	}
+	// the rules are read once per directory above the listing, however many
+	// entries it has
+	if one, many := opens("/a/b/one/"), opens("/a/b/many/"); one != many {
+		t.Errorf("listing 1 entry opened ignore files %d times, 50 entries %d times", one, many)
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		ignoreFile:          "/top.txt\nsub/*.log\n",
+		"sub/" + ignoreFile: "!debug.log\n",
+	})
+	ignores := newIgnoreCache()
+	src := dirSource(dir)
+	root, sub := ignores.matcher(src, "."), ignores.matcher(src, "sub")
+
+	tests := []struct {
+		m     *ignoreMatcher
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{root, "top.txt", false, true},
+		{root, "other.txt", false, false},
+		{root, ignoreFile, false, true},
+		{sub, "top.txt", false, false},
+		{sub, "error.log", false, true},
+		{sub, "debug.log", false, false},
+		{sub, ignoreFile, false, true},
+	}
+	for _, tt := range tests {
+		if got := tt.m.ignored(tt.name, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%s, %q) = %v, want %v", strings.Join(tt.m.dir, "/"), tt.name, got, tt.want)
+		}
+		name := path.Join(append(append([]string{"."}, tt.m.dir...), tt.name)...)
+		if got := ignores.ignored(src, name, tt.isDir); got != tt.want {
+			t.Errorf("ignoreCache.ignored(%q) = %v, want %v", name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIndexPriority(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"with/index.html": "its own index",
+		"without/a.txt":   "a",
+		"mounted/a.txt":   "a",
+		"file.txt":        "a file",
+	})
+	spa := filepath.Join(t.TempDir(), "spa.html")
+	if err := os.WriteFile(spa, []byte("the spa"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const listed = "<title>Index of "
+
+	// the decision table in the README, and paths that aren't directories
+	tests := []struct {
+		priority string
+		path     string
+		want     string
+	}{
+		{"", "/with/", listed},
+		{"", "/without/", listed},
+		{"list", "/with/", listed},
+		{"list", "/without/", listed},
+		{"dir", "/with/", "its own index"},
+		{"dir", "/without/", listed},
+		{"global", "/with/", "the spa"},
+		{"global", "/without/", "the spa"},
+		// there's no --index for a mount
+		{"global", "/m/", listed},
+		{"dir", "/file.txt", "a file"},
+		{"global", "/file.txt", "a file"},
+		{"global", "/missing/", "the spa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.priority+" "+tt.path, func(t *testing.T) {
+			conf := testConfig(dir)
+			conf.Index = spa
+			conf.IndexPriority = tt.priority
+			conf.Mounts = MountList{{Prefix: "/m", Dir: filepath.Join(dir, "mounted")}}
+			s := newTestServer(t, conf)
+			w := get(s, "GET", tt.path, "Accept", "text/html")
+			if body := w.Body.String(); w.Code != 200 || tt.want == listed && !strings.Contains(body, listed) || tt.want != listed && body != tt.want {
+				t.Errorf("got %d %.60q, want %q", w.Code, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexPriorityNoList(t *testing.T) {
+	dir := writeTree(t, map[string]string{"with/index.html": "its own index", "without/a.txt": "a"})
+	spa := filepath.Join(t.TempDir(), "spa.html")
+	if err := os.WriteFile(spa, []byte("the spa"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, priority := range []string{"list", "dir", "global"} {
+		conf := testConfig(dir)
+		conf.NoList = true
+		conf.IndexPriority = priority
+		conf.Index = spa
+		s := newTestServer(t, conf)
+		// without listings a directory's index.html comes before the --index page
+		if w := get(s, "GET", "/with/"); w.Body.String() != "its own index" {
+			t.Errorf("%s: /with/ = %q, want its index.html", priority, w.Body.String())
+		}
+		if w := get(s, "GET", "/without/"); w.Body.String() != "the spa" {
+			t.Errorf("%s: /without/ = %q, want the --index page", priority, w.Body.String())
+		}
+	}
+}
+
+func TestValidateIndexPriority(t *testing.T) {
+	tests := []struct {
+		priority, index string
+		noList          bool
+		err, warning    string
+	}{
+		{"dir", "", false, "", ""},
+		{"global", "spa.html", false, "", ""},
+		{"first", "", false, "--index-priority", ""},
+		{"global", "", false, "", "--index-priority global has no effect without --index"},
+		{"dir", "", true, "", "--index-priority has no effect with --no-list, directories aren't listed"},
+		{"list", "", true, "", ""},
+	}
+	for _, tt := range tests {
+		dir := writeTree(t, map[string]string{"spa.html": "the spa"})
+		conf := testConfig(dir)
+		conf.IndexPriority = tt.priority
+		if tt.index != "" {
+			conf.Index = filepath.Join(dir, tt.index)
+		}
+		conf.NoList = tt.noList
+		warnings, err := conf.Validate()
+		switch {
+		case err != nil && (tt.err == "" || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Validate with %q = %v, want %q", tt.priority, err, tt.err)
+		case err == nil && tt.err != "":
+			t.Errorf("Validate with %q = nil, want %q", tt.priority, tt.err)
+		}
+		if tt.warning != "" && !slices.Contains(warnings, tt.warning) {
+			t.Errorf("Validate with %q warned %q, want %q", tt.priority, warnings, tt.warning)
+		}
+	}
+}
+
+func TestIndexFallbacks(t *testing.T) {
+	entries := writeTree(t, map[string]string{"app.html": "the app", "main.htm": "main"})
+	dir := writeTree(t, map[string]string{"a.txt": "a"})
+	join := func(names ...string) string {
+		for i, name := range names {
+			names[i] = filepath.Join(entries, name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	tests := []struct {
+		index string
+		want  string
+	}{
+		{join("app.html"), "the app"},
+		{join("missing.html", "app.html"), "the app"},
+		{join("missing.html", "main.htm", "app.html"), "main"},
+		{join("app.html", "main.htm"), "the app"},
+		{" " + join("missing.html") + " , " + join("main.htm") + ",", "main"},
+	}
+	for _, tt := range tests {
+		conf := testConfig(dir)
+		conf.Index = tt.index
+		s := newTestServer(t, conf)
+		w := get(s, "GET", "/some/route")
+		if w.Code != 200 || w.Body.String() != tt.want {
+			t.Errorf("--index %s: got %d %q, want %q", tt.index, w.Code, w.Body.String(), tt.want)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+			t.Errorf("--index %s: Content-Type = %q", tt.index, ct)
+		}
+		expect(t, get(s, "GET", "/a.txt"), 200, "a")
+	}
+}
+
+func TestIndexFallbackRemoved(t *testing.T) {
+	entries := writeTree(t, map[string]string{"first.html": "first", "second.html": "second"})
+	conf := testConfig(t.TempDir())
+	conf.Index = filepath.Join(entries, "first.html") + "," + filepath.Join(entries, "second.html")
+	s := newTestServer(t, conf)
+	expect(t, get(s, "GET", "/route"), 200, "first")
+
+	// the files are looked for on each request, not only at startup
+	os.Remove(filepath.Join(entries, "first.html"))
+	expect(t, get(s, "GET", "/route"), 200, "second")
+	os.Remove(filepath.Join(entries, "second.html"))
+	expect(t, get(s, "GET", "/route"), 404, "")
+}
+
+func TestValidateIndex(t *testing.T) {
+	entries := writeTree(t, map[string]string{"app.html": "the app", "dir/x": ""})
+	tests := []struct {
+		index string
+		err   string
+	}{
+		{"app.html", ""},
+		{"missing.html,app.html", ""},
+		{"missing.html", "no such file"},
+		{"missing.html,other.html", "no such file"},
+		{"dir,app.html", "is a directory"},
+	}
+	for _, tt := range tests {
+		names := strings.Split(tt.index, ",")
+		for i, name := range names {
+			names[i] = filepath.Join(entries, name)
+		}
+		conf := testConfig(t.TempDir())
+		conf.Index = strings.Join(names, ",")
+		_, err := conf.Validate()
+		switch {
+		case err != nil && (tt.err == "" || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Validate with --index %s = %v, want %q", tt.index, err, tt.err)
+		case err == nil && tt.err != "":
+			t.Errorf("Validate with --index %s = nil, want %q", tt.index, tt.err)
+		}
+	}
+}
+
+func TestIndexSources(t *testing.T) {
+	fsys := fstest.MapFS{"app.html": {Data: []byte("from fs")}, "spa/index.html": {Data: []byte("from the mount")}}
+	outside := writeTree(t, map[string]string{"secret.html": "secret"})
+	pages := writeTree(t, map[string]string{"hidden.html": "hidden", ignoreFile: "hidden.html\n"})
+	symlink(t, filepath.Join(outside, "secret.html"), pages, "linked.html")
+
+	tests := []struct {
+		name   string
+		index  string
+		status int
+		body   string
+	}{
+		{"FS", filepath.Join("embedded", "app.html"), 200, "from fs"},
+		{"mount FS", filepath.Join("mounted", "spa", "index.html"), 200, "from the mount"},
+		{"ignored", filepath.Join(pages, "hidden.html"), 404, ""},
+		{"outside", filepath.Join(pages, "linked.html"), 403, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := testConfig(t.TempDir())
+			conf.FS = []FS{{Name: "embedded", FS: fsys}}
+			conf.Mounts = MountList{{Prefix: "/m", Dir: "mounted", FS: fsys}}
+			conf.Index = tt.index
+			s := newTestServer(t, conf)
+			w := get(s, "GET", "/some/route")
+			if w.Code != tt.status || tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.status, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"cmp"
+	"io/fs"
+	"maps"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// langTag matches the language tags --lang-negotiate recognises in file
+// names, such as en, de-CH or zh-Hant
+var langTag = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// acceptedLanguages returns the language ranges of r's Accept-Language, most
+// preferred first, leaving out those with q=0
+func acceptedLanguages(r *http.Request) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var ranges []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, weighted{tag, q})
+		}
+	}
+	slices.SortStableFunc(ranges, func(a, b weighted) int {
+		return cmp.Compare(b.q, a.q)
+	})
+	tags := make([]string, len(ranges))
+	for i, lang := range ranges {
+		tags[i] = lang.tag
+	}
+	return tags
+}
+
+// matchLanguage returns the variant best matching the ranges, as by RFC 4647
+// lookup: de-ch matches de-ch, then de. * matches fallback, if it's a
+// variant, or the first one
+func matchLanguage(ranges []string, variants map[string]string, fallback string) (string, bool) {
+	for _, tag := range ranges {
+		if tag == "*" {
+			if _, ok := variants[fallback]; ok {
+				return fallback, true
+			}
+			for _, lang := range slices.Sorted(maps.Keys(variants)) {
+				return lang, true
+			}
+		}
+		for tag != "" {
+			if _, ok := variants[tag]; ok {
+				return tag, true
+			}
+			i := strings.LastIndexByte(tag, '-')
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return "", false
+}
+
+// languageVariants returns the files next to name that are variants of it
+// in a language, such as index.de.html for index.html, by lowercase tag
+func languageVariants(r *http.Request, src source, name string) map[string]string {
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	if ext == "" || stem == "" {
+		return nil
+	}
+	entries, err := fsCall(r, func() ([]fs.DirEntry, error) {
+		return fs.ReadDir(src.fsys, path.Clean("./"+dir))
+	}, nil)
+	if err != nil {
+		return nil
+	}
+	variants := map[string]string{}
+	for _, entry := range entries {
+		tag, ok := strings.CutPrefix(entry.Name(), stem+".")
+		if tag, ok = strings.CutSuffix(tag, ext); !ok || !langTag.MatchString(tag) || entry.IsDir() {
+			continue
+		}
+		variants[strings.ToLower(tag)] = path.Join(dir, entry.Name())
+	}
+	return variants
+}
+
+// tryLanguages serves the variant of name in the language r prefers, with
+// --lang-negotiate, or the DefaultLang one if it only accepts others. When
+// there are variants the response varies by Accept-Language even if none is
+// picked, as the file served instead could have been one of them
+func tryLanguages(w http.ResponseWriter, r *http.Request, src source, name string) bool {
+	conf := configFor(r)
+	if !conf.LangNegotiate {
+		return false
+	}
+	variants := languageVariants(r, src, name)
+	if len(variants) == 0 {
+		return false
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	fallback := strings.ToLower(conf.DefaultLang)
+	lang, ok := matchLanguage(acceptedLanguages(r), variants, fallback)
+	if !ok {
+		if lang, ok = fallback, variants[fallback] != ""; !ok {
+			return false
+		}
+	}
+	w.Header().Set("Content-Language", lang)
+	if served, _ := tryFile(w, r, src, variants[lang]); served {
+		return true
+	}
+	w.Header().Del("Content-Language")
+	return false
+}
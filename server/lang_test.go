@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLangNegotiate(t *testing.T) {
+	conf := testConfig(writeTree(t, map[string]string{
+		"index.html":       "default",
+		"index.de.html":    "deutsch",
+		"index.de-CH.html": "schweizerdeutsch",
+		"index.fr.html":    "français",
+		"about.en.html":    "about",
+		"about.fr.html":    "à propos",
+		"plain.html":       "plain",
+		"plain.de.html":    "nur deutsch",
+	}))
+	conf.LangNegotiate = true
+	conf.DefaultLang = "en"
+	conf.IndexPriority = "dir"
+	s := newTestServer(t, conf)
+
+	tests := []struct {
+		path, accept string
+		body, lang   string
+	}{
+		{"/", "de", "deutsch", "de"},
+		{"/", "de-CH, de;q=0.5", "schweizerdeutsch", "de-ch"},
+		// a more specific range falls back to its language
+		{"/", "de-AT", "deutsch", "de"},
+		{"/", "it, fr;q=0.8, de;q=0.9", "deutsch", "de"},
+		{"/", "fr;q=0, de;q=0.1", "deutsch", "de"},
+		// no en variant, so the index.html itself
+		{"/", "ja", "default", ""},
+		{"/", "", "default", ""},
+		// only variants, the default language stands in for the rest
+		{"/about.html", "fr-CA", "à propos", "fr"},
+		{"/about.html", "ja", "about", "en"},
+		{"/about.html", "*", "about", "en"},
+		// a file that exists is served as it is
+		{"/plain.html", "de", "plain", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.accept, func(t *testing.T) {
+			w := get(s, "GET", tt.path, "Accept-Language", tt.accept)
+			if w.Code != 200 || w.Body.String() != tt.body {
+				t.Errorf("GET = %d %q, want %q", w.Code, w.Body.String(), tt.body)
+			}
+			if got := w.Header().Get("Content-Language"); got != tt.lang {
+				t.Errorf("Content-Language = %q, want %q", got, tt.lang)
+			}
+			if tt.path != "/plain.html" && !slices.Contains(w.Header().Values("Vary"), "Accept-Language") {
+				t.Errorf("Vary = %q, want Accept-Language", w.Header().Values("Vary"))
+			}
+		})
+	}
+}
+
+func TestLangNegotiateOff(t *testing.T) {
+	conf := testConfig(writeTree(t, map[string]string{"index.html": "default", "index.de.html": "deutsch"}))
+	conf.IndexPriority = "dir"
+	s := newTestServer(t, conf)
+	w := get(s, "GET", "/", "Accept-Language", "de")
+	if w.Body.String() != "default" || w.Header().Get("Content-Language") != "" {
+		t.Errorf("GET / = %q in %q, want index.html without --lang-negotiate", w.Body.String(), w.Header().Get("Content-Language"))
+	}
+}
+
+func TestAcceptedLanguages(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"en", "en"},
+		{"de-CH, de;q=0.9, en;q=0.8", "de-ch,de,en"},
+		{"en;q=0.5, fr", "fr,en"},
+		{"en;q=0, fr;q=bad, de", "de"},
+		{"da, en-GB;q=0.8, en;q=0.8", "da,en-gb,en"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", tt.header)
+		if got := strings.Join(acceptedLanguages(r), ","); got != tt.want {
+			t.Errorf("acceptedLanguages(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
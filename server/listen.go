@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Listener is an address to serve on, with TLS using the configured
+// certificate if TLS is set
+type Listener struct {
+	Addr string
+	TLS  bool
+}
+
+// ListenerList is a flag.Value for --listen, which may be given more than
+// once to serve on several addresses:
+//
+//	--listen :8080 --listen :8443,tls
+type ListenerList []Listener
+
+func (l *ListenerList) String() string {
+	listeners := make([]string, len(*l))
+	for i, listener := range *l {
+		listeners[i] = listener.String()
+	}
+	return strings.Join(listeners, " ")
+}
+
+func (l *ListenerList) Set(value string) error {
+	addr, options, _ := strings.Cut(value, ",")
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("expected [host]:port[,tls]")
+	}
+	if !ValidPort(port) {
+		return fmt.Errorf("port %q must be a number from 1 to 65535", port)
+	}
+	listener := Listener{Addr: net.JoinHostPort(host, port)}
+	switch options {
+	case "":
+	case "tls":
+		listener.TLS = true
+	default:
+		return fmt.Errorf("unknown option %q, expected tls", options)
+	}
+	*l = append(*l, listener)
+	return nil
+}
+
+func (l Listener) String() string {
+	if l.TLS {
+		return l.Addr + ",tls"
+	}
+	return l.Addr
+}
+
+// Host and Port split the address, an empty host listens on every interface
+func (l Listener) Host() string {
+	host, _, _ := net.SplitHostPort(l.Addr)
+	return host
+}
+
+func (l Listener) Port() string {
+	_, port, _ := net.SplitHostPort(l.Addr)
+	return port
+}
+
+// Scheme is the URL scheme clients use to connect
+func (l Listener) Scheme() string {
+	if l.TLS {
+		return "https"
+	}
+	return "http"
+}
+
+// Listeners returns the addresses to serve on, Listen if it is set or
+// otherwise Host and Port
+func (c *Config) Listeners() []Listener {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []Listener{{Addr: net.JoinHostPort(c.Host, c.Port)}}
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// livePollInterval is how often the directories are checked for
+	// changes, a change is sent once a check finds nothing new
+	livePollInterval = 500 * time.Millisecond
+	// maxWatched is the number of files and directories checked, any more
+	// are ignored with a warning as checking them all would be too slow
+	maxWatched = 10000
+	// maxInjectBuffer is the size of the largest page liveScript is added
+	// to, a larger one is passed through as it is rather than held in memory
+	maxInjectBuffer = 4 << 20
+)
+
+// liveScript is added to HTML pages with LiveReload, reloading them when
+// /_events reports a change
+const liveScript = `<script>new EventSource("/_events").onmessage = function () { location.reload() }</script>`
+
+// liveReload tells browsers to reload when the directories served change.
+// It polls rather than using OS file notifications, so that it works the same
+// everywhere
+type liveReload struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+	// stopping is closed when the server starts shutting down, ending the
+	// event streams so they don't hold it up
+	stopping <-chan struct{}
+}
+
+// startLiveReload polls the directories returned by dirs until done is
+// closed
+func startLiveReload(dirs func() []string, logger *log.Logger, stopping, done <-chan struct{}) *liveReload {
+	l := &liveReload{
+		clients:  map[chan struct{}]struct{}{},
+		stopping: stopping,
+	}
+	go l.poll(dirs, logger, done)
+	return l
+}
+
+func (l *liveReload) poll(dirs func() []string, logger *log.Logger, done <-chan struct{}) {
+	ticker := time.NewTicker(livePollInterval)
+	defer ticker.Stop()
+
+	warned := false
+	last, _ := fingerprint(dirs())
+	pending := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		sum, truncated := fingerprint(dirs())
+		if truncated && !warned {
+			logger.Printf("WARN live reload: more than %d files, changes to the rest aren't noticed", maxWatched)
+			warned = true
+		}
+		// wait for the changes to settle, a build writes several files
+		if sum != last {
+			last = sum
+			pending = true
+			continue
+		}
+		if pending {
+			pending = false
+			l.broadcast()
+		}
+	}
+}
+
+// fingerprint hashes the names, sizes and modification times of the files
+// under dirs, skipping dot directories such as .git. truncated reports that
+// there were more than maxWatched
+func fingerprint(dirs []string) (sum uint64, truncated bool) {
+	h := fnv.New64a()
+	seen := 0
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if entry.IsDir() && name != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if seen++; seen > maxWatched {
+				truncated = true
+				return filepath.SkipAll
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00%d\x00", name, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+	}
+	return h.Sum64(), truncated
+}
+
+func (l *liveReload) broadcast() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client := range l.clients {
+		select {
+		case client <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ServeHTTP streams a server-sent event at /_events for each change
+func (l *liveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := make(chan struct{}, 1)
+	l.mu.Lock()
+	l.clients[client] = struct{}{}
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.clients, client)
+		l.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+	for {
+		select {
+		case <-client:
+			fmt.Fprint(w, "data: reload\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-l.stopping:
+			return
+		}
+	}
+}
+
+// wantsLiveReload reports whether the response to r should have liveScript
+// added, only browsers loading a page accept text/html
+func wantsLiveReload(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.URL.Path != "/_events" &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// liveReloadKey is the request context key set when liveScript is added to
+// the response
+type liveReloadKey struct{}
+
+// injectsLiveReload reports whether liveScript is being added to the response
+// to r, which then mustn't be compressed
+func injectsLiveReload(r *http.Request) bool {
+	inject, _ := r.Context().Value(liveReloadKey{}).(bool)
+	return inject
+}
+
+// reloadInjector buffers successful HTML responses so that liveScript can be
+// added before </body>, or at the end of pages without one, by finish. Other
+// responses, and those that are compressed, are passed straight through, as
+// are pages over maxInjectBuffer and those the handler flushes, which are
+// streamed and would otherwise be held until they end
+type reloadInjector struct {
+	http.ResponseWriter
+	wroteHeader bool
+	inject      bool
+	body        bytes.Buffer
+}
+
+func (w *reloadInjector) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") &&
+		w.Header().Get("Content-Encoding") == "" {
+		w.inject = true
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *reloadInjector) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.inject && w.body.Len()+len(b) > maxInjectBuffer {
+		w.passThrough()
+	}
+	if w.inject {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// passThrough stops buffering, writing what was buffered without liveScript
+func (w *reloadInjector) passThrough() {
+	w.inject = false
+	w.ResponseWriter.Write(w.body.Bytes())
+	w.body = bytes.Buffer{}
+}
+
+// FlushError passes a page that is being streamed through rather than
+// buffering it, so that it's sent as it's written
+func (w *reloadInjector) FlushError() error {
+	if w.inject {
+		w.passThrough()
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// ReadFrom passes responses that aren't being buffered straight through, so
+// that they keep the sendfile(2) fast path
+func (w *reloadInjector) ReadFrom(src io.Reader) (int64, error) {
+	if w.wroteHeader && !w.inject {
+		return io.Copy(w.ResponseWriter, src)
+	}
+	// hides ReadFrom so that io.Copy uses Write
+	return io.Copy(struct{ io.Writer }{w}, src)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *reloadInjector) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the buffered page with liveScript added. The injector wraps
+// the request's responseRecorder, so what it writes is counted as sent
+func (w *reloadInjector) finish() {
+	if !w.inject {
+		return
+	}
+	page := w.body.Bytes()
+	end := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if end < 0 {
+		end = len(page)
+	}
+	w.ResponseWriter.Write(page[:end])
+	w.ResponseWriter.Write([]byte(liveScript))
+	w.ResponseWriter.Write(page[end:])
+}
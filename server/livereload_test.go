@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// liveServer serves dir with LiveReload
+func liveServer(t *testing.T, dir string) *Server {
+	conf := testConfig(dir)
+	conf.LiveReload = true
+	return newTestServer(t, conf)
+}
+
+func TestLiveReloadInjects(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"page.html": "<html><body><p>page</p></BODY></html>",
+		"bare.html": "<p>no body</p>",
+		"style.css": "p {}",
+	})
+	s := liveServer(t, dir)
+
+	tests := []struct {
+		path, accept string
+		want         string
+	}{
+		{"/page.html", "text/html", "<p>page</p>" + liveScript + "</BODY></html>"},
+		{"/bare.html", "text/html", "<p>no body</p>" + liveScript},
+		// fetch and curl don't ask for HTML, they get the page as it is
+		{"/page.html", "*/*", "<html><body><p>page</p></BODY></html>"},
+		{"/style.css", "text/html", "p {}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.accept, func(t *testing.T) {
+			w := get(s, "GET", tt.path, "Accept", tt.accept)
+			if got := w.Body.String(); !strings.HasSuffix(got, tt.want) {
+				t.Errorf("body = %q, want it to end %q", got, tt.want)
+			}
+		})
+	}
+	if w := get(s, "GET", "/missing.html", "Accept", "text/html"); strings.Contains(w.Body.String(), liveScript) {
+		t.Error("the script was added to a 404")
+	}
+}
+
+func TestLiveReloadLargePage(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("x", maxInjectBuffer) + "</body></html>"
+	s := liveServer(t, writeTree(t, map[string]string{"large.html": page}))
+
+	w := get(s, "GET", "/large.html", "Accept", "text/html")
+	if w.Code != 200 || w.Body.String() != page {
+		t.Errorf("GET /large.html = %d with %d bytes, want the page as it is", w.Code, w.Body.Len())
+	}
+}
+
+func TestLiveReloadStreamedPage(t *testing.T) {
+	s := liveServer(t, t.TempDir())
+	w := httptest.NewRecorder()
+	// a page that is flushed is sent as it's written, without the script
+	s.Handle("/stream", http.HandlerFunc(func(pw http.ResponseWriter, r *http.Request) {
+		pw.Header().Set("Content-Type", "text/html")
+		io.WriteString(pw, "<body>first")
+		if err := http.NewResponseController(pw).Flush(); err != nil {
+			t.Errorf("Flush = %v", err)
+		}
+		if got := w.Body.String(); got != "<body>first" {
+			t.Errorf("sent %q once flushed, want <body>first", got)
+		}
+		io.WriteString(pw, " second</body>")
+	}))
+	r := httptest.NewRequest("GET", "/stream", nil)
+	r.Header.Set("Accept", "text/html")
+	s.ServeHTTP(w, r)
+	if got := w.Body.String(); got != "<body>first second</body>" {
+		t.Errorf("body = %q, want the page as it is", got)
+	}
+}
+
+func TestLiveReloadCountsScript(t *testing.T) {
+	dir := writeTree(t, map[string]string{"page.html": "<html><body>page</body></html>"})
+	s := liveServer(t, dir)
+
+	w := get(s, "GET", "/page.html", "Accept", "text/html")
+	if !strings.Contains(w.Body.String(), liveScript) {
+		t.Fatal("the script wasn't added")
+	}
+	if sent := s.stats.bytes.Value(); sent != int64(w.Body.Len()) {
+		t.Errorf("counted %d bytes served, want %d", sent, w.Body.Len())
+	}
+	records := recent(t, s, "")
+	if len(records) != 1 || records[0].Bytes != int64(w.Body.Len()) {
+		t.Errorf("recorded %+v, want the %d bytes sent with the script", records, w.Body.Len())
+	}
+}
+
+func TestLiveReloadEvents(t *testing.T) {
+	dir := writeTree(t, map[string]string{"page.html": "<body>v1</body>"})
+	ts := httptest.NewServer(liveServer(t, dir))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/_events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	// the poller's first pass has to see the old file, to notice the change
+	time.Sleep(2 * livePollInterval)
+	if err := os.WriteFile(filepath.Join(dir, "page.html"), []byte("<body>version 2</body>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("no event after the page changed: %v", err)
+	}
+	if line != "data: reload\n" {
+		t.Errorf("event = %q, want data: reload", line)
+	}
+}
+
+func TestLiveReloadEventsEndOnShutdown(t *testing.T) {
+	s := liveServer(t, t.TempDir())
+	done := make(chan struct{})
+	go func() {
+		get(s, "GET", "/_events")
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event stream didn't end when the server stopped")
+	}
+}
+
+// BenchmarkFingerprint checks a tree of nearly maxWatched files, about the
+// most each poll does
+func BenchmarkFingerprint(b *testing.B) {
+	files := map[string]string{}
+	for i := range maxWatched - 200 {
+		files[fmt.Sprintf("dir%02d/file%04d.html", i%100, i)] = ""
+	}
+	dir := writeTree(b, files)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, truncated := fingerprint([]string{dir}); truncated {
+			b.Fatal("the tree was truncated")
+		}
+	}
+}
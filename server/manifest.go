@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ManifestEntry is a file in /__manifest.json, Hash being the hex SHA-256 of
+// its contents
+type ManifestEntry struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// manifest reports the content hash of every file served, for build tools
+// that fingerprint URLs. Hashes come from the Server's hashCache, so only the
+// files that changed are read again
+type manifest struct {
+	mu      sync.Mutex
+	hashes  *hashCache
+	ignores *ignoreCache
+}
+
+func newManifest(hashes *hashCache, ignores *ignoreCache) *manifest {
+	return &manifest{hashes: hashes, ignores: ignores}
+}
+
+// build returns the entries for the files in Dirs, FS and the mounts without
+// auth or nolist by request path. A file hides the same path in the sources
+// after it, as when serving, and paths that are proxied, mocked, hidden by a
+// mount or by a .serveignore are left out, as are _headers and _redirects. Dot
+// directories are skipped, as they are by live reload, and so are dotfiles
+// with BlockDotfiles
+func (m *manifest) build(conf *Config) map[string]ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := map[string]ManifestEntry{}
+	seen := map[string]bool{}
+	hashed := map[string]bool{}
+	add := func(prefix string, src source) {
+		fs.WalkDir(src.fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if entry.IsDir() {
+				if name != "." && strings.HasPrefix(entry.Name(), ".") || m.ignores.ignored(src, name, true) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if conf.BlockDotfiles && strings.HasPrefix(entry.Name(), ".") || m.ignores.ignored(src, name, false) ||
+				conf.siteFile(src, name) {
+				return nil
+			}
+			if len(seen) >= maxWatched {
+				conf.logger().Printf("WARN manifest: more than %d files, the rest are left out", maxWatched)
+				return fs.SkipAll
+			}
+			urlPath := prefix + "/" + name
+			if seen[urlPath] || conf.CGI.matches(urlPath) || conf.Proxies.match(urlPath) != nil || conf.underMock(urlPath) ||
+				prefix == "" && conf.Mounts.match(urlPath) != nil {
+				return nil
+			}
+			seen[urlPath] = true
+			info, err := entry.Info()
+			if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+				if !src.contains(name) {
+					return nil
+				}
+				info, err = fs.Stat(src.fsys, name)
+			}
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			sum, err := m.hashes.get(src, name, info)
+			if err != nil {
+				return nil
+			}
+			hashed[src.path(name)] = true
+			entries[urlPath] = ManifestEntry{Hash: hex.EncodeToString(sum), Size: info.Size()}
+			return nil
+		})
+	}
+	for _, src := range conf.sources() {
+		add("", src)
+	}
+	for i := range conf.Mounts {
+		// the manifest is public, it mustn't list what a mount keeps back
+		if conf.Mounts[i].Auth != "" || conf.Mounts[i].NoList {
+			continue
+		}
+		add(path.Clean(conf.Mounts[i].Prefix), conf.Mounts[i].source())
+	}
+
+	// forget the files that have gone
+	m.hashes.forget(hashed)
+	return entries
+}
+
+// ServeHTTP reports the manifest at /__manifest.json
+func (m *manifest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(m.build(configFor(r)))
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+// manifestOf decodes /__manifest.json
+func manifestOf(t *testing.T, s *Server) map[string]ManifestEntry {
+	t.Helper()
+	w := get(s, "GET", "/__manifest.json")
+	if w.Code != 200 {
+		t.Fatalf("GET /__manifest.json = %d", w.Code)
+	}
+	var entries map[string]ManifestEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestManifest(t *testing.T) {
+	first := writeTree(t, map[string]string{"app.js": "first", ".git/HEAD": "ref", ignoreFile: "*.map\n", "app.js.map": "{}"})
+	second := writeTree(t, map[string]string{"app.js": "second", "style.css": "p {}"})
+	public := writeTree(t, map[string]string{"doc.txt": "doc"})
+	private := writeTree(t, map[string]string{"secret.txt": "secret"})
+	conf := testConfig(first, second)
+	conf.Manifest = true
+	conf.Mounts = MountList{
+		{Prefix: "/public", Dir: public},
+		{Prefix: "/private", Dir: private, Auth: "user:pass"},
+		{Prefix: "/unlisted", Dir: private, NoList: true},
+	}
+	s := newTestServer(t, conf)
+	entries := manifestOf(t, s)
+
+	sum := sha256.Sum256([]byte("first"))
+	if got := entries["/app.js"]; got.Hash != hex.EncodeToString(sum[:]) || got.Size != 5 {
+		t.Errorf("/app.js = %+v, want the hash of the file served", got)
+	}
+	for _, name := range []string{"/style.css", "/public/doc.txt"} {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+	for _, name := range []string{"/.git/HEAD", "/app.js.map", "/" + ignoreFile, "/private/secret.txt", "/unlisted/secret.txt"} {
+		if _, ok := entries[name]; ok {
+			t.Errorf("%s is in the manifest", name)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import "net/http"
+
+// staticMethods are the methods files and listings answer, sent in Allow
+const staticMethods = "GET, HEAD, OPTIONS"
+
+// traceMethod reports whether r is a TRACE, or Microsoft's TRACK, which echo
+// the request back and so can reveal cookies and authorization to scripts
+// (cross-site tracing). They are refused for every path, including proxied
+// ones
+func traceMethod(r *http.Request) bool {
+	return r.Method == http.MethodTrace || r.Method == "TRACK"
+}
+
+// tryMethod answers requests for files and listings with a method other than
+// GET and HEAD, returning false if the request should go on to be served.
+// OPTIONS is answered with the methods allowed, anything else is a 405.
+// Proxies, mocks and CGI scripts handle their own methods before this
+func tryMethod(w http.ResponseWriter, r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return false
+	case http.MethodOptions:
+		w.Header().Set("Allow", staticMethods)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", staticMethods)
+		respondError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestTraceRefused(t *testing.T) {
+	proxied := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+	conf := testConfig(writeTree(t, map[string]string{"a.txt": "a"}))
+	conf.Proxies = ProxyList{{Prefix: "/api", Target: target}}
+	conf.Mock = writeTree(t, map[string]string{"users.TRACE.json": "{}"})
+	conf.MockPrefix = "/mock/"
+	conf.TrackHits = true
+	s := newTestServer(t, conf)
+
+	for _, method := range []string{"TRACE", "TRACK"} {
+		for _, target := range []string{"/a.txt", "/", "/missing", "/api/echo", "/mock/users", "/_hits"} {
+			w := get(s, method, target, "Cookie", "session=secret")
+			expect(t, w, 405, "method not allowed")
+			if allow := w.Header().Get("Allow"); allow != staticMethods {
+				t.Errorf("%s %s: Allow = %q, want %q", method, target, allow, staticMethods)
+			}
+			if w.Header().Get("Content-Type") == "message/http" {
+				t.Errorf("%s %s echoed the request", method, target)
+			}
+		}
+	}
+	if proxied != 0 {
+		t.Errorf("%d TRACE requests reached the proxy's backend", proxied)
+	}
+}
+
+func TestStaticMethods(t *testing.T) {
+	s := newTestServer(t, testConfig(writeTree(t, map[string]string{"a.txt": "a"})))
+
+	tests := []struct {
+		method string
+		status int
+		body   string
+	}{
+		{"GET", 200, "a"},
+		{"HEAD", 200, ""},
+		{"OPTIONS", 204, ""},
+		{"POST", 405, "method not allowed"},
+		{"PUT", 405, "method not allowed"},
+		{"DELETE", 405, "method not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			w := get(s, tt.method, "/a.txt")
+			expect(t, w, tt.status, tt.body)
+			if allow := w.Header().Get("Allow"); tt.status != 200 && allow != staticMethods {
+				t.Errorf("Allow = %q, want %q", allow, staticMethods)
+			}
+		})
+	}
+}
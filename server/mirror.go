@@ -0,0 +1,360 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMirrorMaxSize is the size of the largest file kept in MirrorCache if
+// MirrorMaxSize isn't set
+const defaultMirrorMaxSize = 32 << 20
+
+// mirrorHeaders are the headers of a mirror's response passed on to the
+// client
+var mirrorHeaders = []string{
+	"Accept-Ranges", "Cache-Control", "Content-Disposition", "Content-Language",
+	"Content-Length", "Content-Range", "Content-Type", "ETag", "Expires", "Last-Modified",
+}
+
+// mirrorRequestHeaders are the headers of a request passed on to a mirror
+// when it isn't cached, so that it answers ranges and conditional requests
+// itself
+var mirrorRequestHeaders = []string{
+	"Accept", "Accept-Language", "If-Modified-Since", "If-None-Match", "If-Range", "Range",
+}
+
+// isMirror reports whether a directory to serve is the base URL of a remote
+// server to mirror
+func isMirror(dir string) bool {
+	return strings.HasPrefix(dir, "http://") || strings.HasPrefix(dir, "https://")
+}
+
+// mirrors returns the base URLs in Dirs, in order
+func (c *Config) mirrors() []*url.URL {
+	var mirrors []*url.URL
+	for _, dir := range c.Dirs {
+		if !isMirror(dir) {
+			continue
+		}
+		if base, err := url.Parse(dir); err == nil {
+			mirrors = append(mirrors, base)
+		}
+	}
+	return mirrors
+}
+
+// mirrorMaxSize returns MirrorMaxSize, defaultMirrorMaxSize if it's unset
+func (c *Config) mirrorMaxSize() int64 {
+	if c.MirrorMaxSize <= 0 {
+		return defaultMirrorMaxSize
+	}
+	return int64(c.MirrorMaxSize)
+}
+
+// mirrorMeta is kept next to a cached copy of a file from a mirror, with the
+// validators to check it's still current
+type mirrorMeta struct {
+	URL          string    `json:"url"`
+	ContentType  string    `json:"content_type"`
+	LastModified string    `json:"last_modified"`
+	ETag         string    `json:"etag"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// mirror fetches the files that aren't found in the Dirs and FS from the
+// URLs in Dirs
+type mirror struct {
+	client *http.Client
+}
+
+func newMirror() *mirror {
+	return &mirror{client: &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}}
+}
+
+// try serves the request path from the first mirror that has it, as the
+// lowest priority source. A mirror that fails or times out is skipped for the
+// next, the request only failing with a 502 if none of them could answer.
+// Directories are only served from local sources, as a mirror can't be listed
+func (m *mirror) try(w http.ResponseWriter, r *http.Request) bool {
+	conf := configFor(r)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || strings.HasSuffix(r.URL.Path, "/") {
+		return false
+	}
+	unavailable := false
+	for _, base := range conf.mirrors() {
+		served, err := m.fetch(w, r, base)
+		if served {
+			setServedSource(w, base.String())
+			return true
+		}
+		unavailable = unavailable || err != nil
+	}
+	if unavailable {
+		respondError(w, r, http.StatusBadGateway, "mirror unavailable")
+		return true
+	}
+	return false
+}
+
+// fetch serves the request from the mirror at base, returning false if
+// it's a 404 there, or with an error if the mirror can't be reached or
+// answers with a server error. With MirrorCache, a file that was fetched
+// before is revalidated with the mirror and served from the cache if it
+// hasn't changed, or with MirrorStale if the mirror can't be reached
+func (m *mirror) fetch(w http.ResponseWriter, r *http.Request, base *url.URL) (bool, error) {
+	conf := configFor(r)
+	target := *base
+	target.Path = strings.TrimSuffix(base.Path, "/") + r.URL.Path
+	target.RawPath, target.RawQuery = "", r.URL.RawQuery
+	key := target.String()
+
+	var cached *os.File
+	var meta mirrorMeta
+	if conf.MirrorCache != "" {
+		cached, meta = openMirrorCopy(conf.MirrorCache, key)
+		if cached != nil {
+			defer cached.Close()
+		}
+	}
+	method := r.Method
+	if conf.MirrorCache != "" {
+		// a GET is made even for a HEAD, so that the copy can be refreshed
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, key, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("User-Agent", "serve/"+Version)
+	switch {
+	case cached != nil:
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	case conf.MirrorCache == "":
+		for _, name := range mirrorRequestHeaders {
+			if value := r.Header.Get(name); value != "" {
+				req.Header.Set(name, value)
+			}
+		}
+	}
+
+	resp, err := m.do(r.Context(), req, conf.MirrorTimeout)
+	if err != nil || resp.StatusCode >= 500 {
+		if err == nil {
+			resp.Body.Close()
+			err = errors.New(resp.Status)
+		}
+		if cached != nil && conf.MirrorStale {
+			conf.logger().Printf("%s: %s, serving the cached copy", key, err)
+			serveMirrorCopy(w, r, key, cached, meta, true)
+			return true, nil
+		}
+		conf.logger().Printf("%s: %s", key, err)
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		if cached != nil {
+			removeMirrorCopy(conf.MirrorCache, key)
+		}
+		return false, nil
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		serveMirrorCopy(w, r, key, cached, meta, true)
+		return true, nil
+	case resp.StatusCode == http.StatusOK && conf.MirrorCache != "" &&
+		resp.ContentLength >= 0 && resp.ContentLength <= conf.mirrorMaxSize():
+		fresh, meta, err := saveMirrorCopy(conf.MirrorCache, key, resp)
+		if err != nil {
+			conf.logger().Printf("%s: caching: %s", key, err)
+			return false, err
+		}
+		defer fresh.Close()
+		serveMirrorCopy(w, r, key, fresh, meta, false)
+		return true, nil
+	}
+
+	// too large to cache, or a response the client asked for such as a 206
+	setServedFile(w, key)
+	if conf.Verbose {
+		conf.logger().Printf("%s ← %s", remoteAddr(r), key)
+	}
+	for _, name := range mirrorHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if r.Method != http.MethodHead {
+		io.Copy(w, resp.Body)
+	}
+	logDisconnect(w, r, resp.ContentLength)
+	return true, nil
+}
+
+// do sends req, giving up if the mirror takes longer than timeout to start
+// answering. The body can take as long as it needs, until ctx ends
+func (m *mirror) do(ctx context.Context, req *http.Request, timeout time.Duration) (*http.Response, error) {
+	reqCtx, cancel := context.WithCancel(ctx)
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, cancel)
+	}
+	resp, err := m.client.Do(req.WithContext(reqCtx))
+	if timer != nil && !timer.Stop() && err == nil {
+		// the headers came just as it timed out
+		resp.Body.Close()
+		err = context.Canceled
+	}
+	if err != nil {
+		timedOut := ctx.Err() == nil && reqCtx.Err() != nil
+		cancel()
+		if timedOut {
+			return nil, errors.New("timed out")
+		}
+		return nil, err
+	}
+	body := resp.Body
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, closerFunc(func() error {
+		defer cancel()
+		return body.Close()
+	})}
+	return resp, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// mirrorCopyName is where the copy of the file at key is kept in dir, its
+// mirrorMeta being at the same name with .json
+func mirrorCopyName(dir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16]))
+}
+
+// openMirrorCopy opens the cached copy of the file at key, nil if there is
+// none
+func openMirrorCopy(dir, key string) (*os.File, mirrorMeta) {
+	var meta mirrorMeta
+	name := mirrorCopyName(dir, key)
+	data, err := os.ReadFile(name + ".json")
+	if err != nil || json.Unmarshal(data, &meta) != nil || meta.URL != key {
+		return nil, meta
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, meta
+	}
+	return f, meta
+}
+
+// saveMirrorCopy writes the body of resp to the cache, returning the copy to
+// serve it from
+func saveMirrorCopy(dir, key string, resp *http.Response) (*os.File, mirrorMeta, error) {
+	meta := mirrorMeta{
+		URL:          key,
+		ContentType:  resp.Header.Get("Content-Type"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ETag:         resp.Header.Get("ETag"),
+		Fetched:      time.Now(),
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, meta, err
+	}
+	tmp, err := os.CreateTemp(dir, "fetch-*")
+	if err != nil {
+		return nil, meta, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return nil, meta, err
+	}
+	data, _ := json.Marshal(meta)
+	name := mirrorCopyName(dir, key)
+	if err := os.WriteFile(tmp.Name()+".json", data, 0o644); err != nil {
+		tmp.Close()
+		return nil, meta, err
+	}
+	defer os.Remove(tmp.Name() + ".json")
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		tmp.Close()
+		return nil, meta, err
+	}
+	// the copy is replaced before its meta, a reader seeing the old meta
+	// only revalidates it
+	if err := os.Rename(tmp.Name()+".json", name+".json"); err != nil {
+		tmp.Close()
+		return nil, meta, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, meta, err
+	}
+	return tmp, meta, nil
+}
+
+func removeMirrorCopy(dir, key string) {
+	name := mirrorCopyName(dir, key)
+	os.Remove(name + ".json")
+	os.Remove(name)
+}
+
+// serveMirrorCopy serves the copy of the file at key in the cache, which
+// answers ranges and conditional requests itself. cached is logged for a copy
+// that wasn't just fetched
+func serveMirrorCopy(w http.ResponseWriter, r *http.Request, key string, f *os.File, meta mirrorMeta, cached bool) {
+	conf := configFor(r)
+	setServedFile(w, key)
+	if conf.Verbose && cached {
+		conf.logger().Printf("%s ← %s (cached)", remoteAddr(r), key)
+	} else if conf.Verbose {
+		conf.logger().Printf("%s ← %s", remoteAddr(r), key)
+	}
+	if meta.ContentType != "" {
+		w.Header().Set("Content-Type", meta.ContentType)
+	}
+	if meta.ETag != "" {
+		w.Header().Set("ETag", meta.ETag)
+	}
+	modTime, _ := http.ParseTime(meta.LastModified)
+	http.ServeContent(w, r, path.Base(r.URL.Path), conf.MTime.of(modTime), f)
+	if stat, err := f.Stat(); err == nil {
+		logDisconnect(w, r, stat.Size())
+	}
+}
+
+// checkMirrors validates the URLs in Dirs
+func (c *Config) checkMirrors() error {
+	var errs []error
+	for _, dir := range c.Dirs {
+		if !isMirror(dir) {
+			continue
+		}
+		if base, err := url.Parse(dir); err != nil || base.Host == "" || base.RawQuery != "" || base.Fragment != "" {
+			errs = append(errs, errors.New("mirror "+strconv.Quote(dir)+" must be a URL such as https://example.com/base/, without a query"))
+		}
+	}
+	return errors.Join(errs...)
+}
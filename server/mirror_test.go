@@ -0,0 +1,291 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// origin is a server to mirror, serving files under /base/ with an ETag and
+// Last-Modified, or failing with status if it's set
+type origin struct {
+	*httptest.Server
+	mu       sync.Mutex
+	files    map[string]string
+	status   int
+	delay    time.Duration
+	requests []*http.Request
+}
+
+var originModTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newOrigin(t *testing.T, files map[string]string) *origin {
+	o := &origin{files: files}
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.mu.Lock()
+		o.requests = append(o.requests, r)
+		body, found := o.files[strings.TrimPrefix(r.URL.Path, "/base")]
+		status, delay := o.status, o.delay
+		o.mu.Unlock()
+		time.Sleep(delay)
+		switch {
+		case status != 0:
+			http.Error(w, "broken", status)
+		case !found:
+			http.NotFound(w, r)
+		default:
+			w.Header().Set("ETag", `"`+body+`"`)
+			w.Header().Set("X-Origin", "not passed on")
+			http.ServeContent(w, r, r.URL.Path, originModTime, strings.NewReader(body))
+		}
+	}))
+	t.Cleanup(o.Close)
+	return o
+}
+
+// set changes what the origin answers with
+func (o *origin) set(status int, files map[string]string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.status = status
+	if files != nil {
+		o.files = files
+	}
+}
+
+// last returns the last request the origin had, nil if it had none
+func (o *origin) last() *http.Request {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.requests) == 0 {
+		return nil
+	}
+	return o.requests[len(o.requests)-1]
+}
+
+func (o *origin) count() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.requests)
+}
+
+func TestMirror(t *testing.T) {
+	o := newOrigin(t, map[string]string{"/remote.txt": "remote", "/both.txt": "remote copy", "/dir/": "a remote page", "/q": "q"})
+	s := newTestServer(t, testConfig(writeTree(t, map[string]string{"both.txt": "local copy", "dir/a.txt": "a"}), o.URL+"/base/"))
+
+	w := get(s, "GET", "/remote.txt")
+	expect(t, w, 200, "remote")
+	for name, want := range map[string]string{
+		"Content-Type":  "text/plain; charset=utf-8",
+		"Last-Modified": originModTime.Format(http.TimeFormat),
+		"ETag":          `"remote"`,
+		"X-Origin":      "",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if ua := o.last().Header.Get("User-Agent"); ua != "serve/"+Version {
+		t.Errorf("User-Agent = %q", ua)
+	}
+
+	// the local sources come first, and directories aren't fetched
+	before := o.count()
+	expect(t, get(s, "GET", "/both.txt"), 200, "local copy")
+	if names := listing(t, s, "/dir/").names()[0]; len(names) != 2 || names[1] != "a.txt" {
+		t.Errorf("listing of /dir/ = %v", names)
+	}
+	if o.count() != before {
+		t.Errorf("the origin was asked for a file that's local, or a directory")
+	}
+
+	// ranges and revalidation are answered by the origin
+	w = get(s, "GET", "/remote.txt", "Range", "bytes=1-3")
+	expect(t, w, 206, "emo")
+	w = get(s, "GET", "/remote.txt", "If-None-Match", `"remote"`)
+	expect(t, w, 304, "")
+	w = get(s, "HEAD", "/remote.txt")
+	if w.Code != 200 || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "6" {
+		t.Errorf("HEAD = %d %q with Content-Length %q", w.Code, w.Body.String(), w.Header().Get("Content-Length"))
+	}
+	if last := o.last(); last.Method != "HEAD" {
+		t.Errorf("the origin got a %s for a HEAD", last.Method)
+	}
+	get(s, "GET", "/q?x=1")
+	if last := o.last(); last.URL.Path != "/base/q" || last.URL.RawQuery != "x=1" {
+		t.Errorf("the origin was asked for %s", last.URL)
+	}
+
+	expect(t, get(s, "GET", "/missing.txt"), 404, "")
+	expect(t, get(s, "POST", "/remote.txt"), 405, "")
+}
+
+func TestMirrorUnavailable(t *testing.T) {
+	var logs bytes.Buffer
+	o := newOrigin(t, map[string]string{"/a.txt": "a"})
+	o.set(http.StatusServiceUnavailable, nil)
+	conf := testConfig(t.TempDir(), o.URL+"/base/")
+	conf.Logger = log.New(&logs, "", 0)
+	s := newTestServer(t, conf)
+
+	expect(t, get(s, "GET", "/a.txt"), 502, "mirror unavailable")
+	if want := "/base/a.txt: 503 Service Unavailable"; !strings.Contains(logs.String(), want) {
+		t.Errorf("log has no %q:\n%s", want, logs.String())
+	}
+	o.Close()
+	expect(t, get(s, "GET", "/a.txt"), 502, "mirror unavailable")
+}
+
+func TestMirrorTimeout(t *testing.T) {
+	var logs bytes.Buffer
+	o := newOrigin(t, map[string]string{"/a.txt": "a"})
+	o.delay = 500 * time.Millisecond
+	conf := testConfig(t.TempDir(), o.URL+"/base/")
+	conf.MirrorTimeout = 50 * time.Millisecond
+	conf.Logger = log.New(&logs, "", 0)
+	s := newTestServer(t, conf)
+
+	expect(t, get(s, "GET", "/a.txt"), 502, "mirror unavailable")
+	if !strings.Contains(logs.String(), "/base/a.txt: timed out") {
+		t.Errorf("the timeout wasn't logged:\n%s", logs.String())
+	}
+}
+
+func TestMirrorFallthrough(t *testing.T) {
+	var logs bytes.Buffer
+	failing := newOrigin(t, map[string]string{"/a.txt": "first"})
+	failing.set(http.StatusServiceUnavailable, nil)
+	healthy := newOrigin(t, map[string]string{"/a.txt": "second"})
+	conf := testConfig(t.TempDir(), failing.URL+"/base/", healthy.URL+"/base/")
+	conf.MirrorTimeout = 50 * time.Millisecond
+	conf.Logger = log.New(&logs, "", 0)
+	s := newTestServer(t, conf)
+
+	// a server error from the first mirror falls through to the next
+	expect(t, get(s, "GET", "/a.txt"), 200, "second")
+	if failing.count() != 1 || healthy.count() != 1 {
+		t.Errorf("the mirrors got %d and %d requests, want 1 each", failing.count(), healthy.count())
+	}
+	if !strings.Contains(logs.String(), "/base/a.txt: 503 Service Unavailable") {
+		t.Errorf("the failure wasn't logged:\n%s", logs.String())
+	}
+
+	// as does a timeout
+	failing.set(0, nil)
+	failing.mu.Lock()
+	failing.delay = 500 * time.Millisecond
+	failing.mu.Unlock()
+	expect(t, get(s, "GET", "/a.txt"), 200, "second")
+
+	// a mirror that failed may have had the file, so it's not a 404 if the
+	// others don't
+	failing.set(http.StatusInternalServerError, nil)
+	healthy.set(0, map[string]string{})
+	expect(t, get(s, "GET", "/a.txt"), 502, "mirror unavailable")
+
+	// the request fails once every mirror has
+	healthy.set(http.StatusBadGateway, nil)
+	expect(t, get(s, "GET", "/a.txt"), 502, "mirror unavailable")
+}
+
+func TestMirrorCache(t *testing.T) {
+	o := newOrigin(t, map[string]string{"/a.txt": "version 1", "/large.txt": strings.Repeat("x", 100)})
+	conf := testConfig(t.TempDir(), o.URL+"/base/")
+	conf.MirrorCache = t.TempDir()
+	conf.MirrorMaxSize = 50
+	s := newTestServer(t, conf)
+
+	expect(t, get(s, "GET", "/a.txt"), 200, "version 1")
+	if inm := o.last().Header.Get("If-None-Match"); inm != "" {
+		t.Errorf("the first fetch sent If-None-Match %s", inm)
+	}
+	// the copy is revalidated with the validators it was fetched with
+	w := get(s, "GET", "/a.txt", "Range", "bytes=0-6")
+	expect(t, w, 206, "version")
+	last := o.last()
+	if last.Header.Get("If-None-Match") != `"version 1"` || last.Header.Get("If-Modified-Since") != originModTime.Format(http.TimeFormat) {
+		t.Errorf("revalidated with %q", last.Header)
+	}
+	if last.Header.Get("Range") != "" {
+		t.Error("the range was passed on, rather than served from the copy")
+	}
+	// a HEAD refreshes the copy too
+	if w := get(s, "HEAD", "/a.txt"); w.Code != 200 || o.last().Method != "GET" {
+		t.Errorf("HEAD = %d, the origin got a %s", w.Code, o.last().Method)
+	}
+
+	o.set(0, map[string]string{"/a.txt": "version 2", "/large.txt": strings.Repeat("x", 100)})
+	expect(t, get(s, "GET", "/a.txt"), 200, "version 2")
+
+	// a copy isn't served when the origin fails, without MirrorStale
+	o.set(http.StatusInternalServerError, nil)
+	expect(t, get(s, "GET", "/a.txt"), 502, "mirror unavailable")
+
+	// files over the limit are passed through without being kept
+	o.set(0, nil)
+	get(s, "GET", "/large.txt")
+	get(s, "GET", "/large.txt")
+	if inm := o.last().Header.Get("If-None-Match"); inm != "" {
+		t.Errorf("a file over --mirror-max-size was cached")
+	}
+
+	// one removed from the origin is removed from the cache
+	o.set(0, map[string]string{})
+	expect(t, get(s, "GET", "/a.txt"), 404, "")
+	if entries, _ := os.ReadDir(conf.MirrorCache); len(entries) != 0 {
+		t.Errorf("the cache still has %d files", len(entries))
+	}
+}
+
+func TestMirrorStale(t *testing.T) {
+	var logs bytes.Buffer
+	o := newOrigin(t, map[string]string{"/a.txt": "cached"})
+	conf := testConfig(t.TempDir(), o.URL+"/base/")
+	conf.MirrorCache = t.TempDir()
+	conf.MirrorStale = true
+	conf.Logger = log.New(&logs, "", 0)
+	s := newTestServer(t, conf)
+	expect(t, get(s, "GET", "/a.txt"), 200, "cached")
+
+	o.set(http.StatusBadGateway, nil)
+	w := get(s, "GET", "/a.txt")
+	expect(t, w, 200, "cached")
+	if w.Header().Get("ETag") != `"cached"` {
+		t.Errorf("ETag = %q, want the copy's", w.Header().Get("ETag"))
+	}
+	if !strings.Contains(logs.String(), "502 Bad Gateway, serving the cached copy") {
+		t.Errorf("serving the copy wasn't logged:\n%s", logs.String())
+	}
+	// offline
+	o.Close()
+	expect(t, get(s, "GET", "/a.txt"), 200, "cached")
+	// there's nothing stale to serve for a file that wasn't fetched
+	expect(t, get(s, "GET", "/other.txt"), 502, "mirror unavailable")
+}
+
+func TestCheckMirrors(t *testing.T) {
+	tests := []struct {
+		dir string
+		ok  bool
+	}{
+		{"https://example.com/base/", true},
+		{"http://127.0.0.1:8080", true},
+		{"https:///base/", false},
+		{"https://example.com/?q=1", false},
+		{"https://example.com/#top", false},
+	}
+	for _, tt := range tests {
+		conf := testConfig(tt.dir)
+		if err := conf.checkMirrors(); (err == nil) != tt.ok {
+			t.Errorf("checkMirrors(%q) = %v, want ok %v", tt.dir, err, tt.ok)
+		} else if err != nil && !strings.Contains(err.Error(), "must be a URL such as https://example.com/base/") {
+			t.Errorf("checkMirrors(%q) = %v", tt.dir, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxMisses is the number of paths the miss cache holds, it is emptied
+	// if it fills with paths that haven't expired
+	maxMisses = 1024
+	// missTTL is how long a miss is remembered, so that a file created
+	// meanwhile is found again shortly after
+	missTTL = 5 * time.Second
+)
+
+// missCache remembers request paths that weren't found in any directory, so
+// that with --index the routes of a single page app fall back to it without
+// probing every directory on each navigation
+type missCache struct {
+	mu     sync.Mutex
+	misses map[string]time.Time
+}
+
+func newMissCache() *missCache {
+	return &missCache{misses: map[string]time.Time{}}
+}
+
+// missed reports whether urlPath was recently not found
+func (c *missCache) missed(urlPath string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires, ok := c.misses[urlPath]
+	if ok && time.Now().After(expires) {
+		delete(c.misses, urlPath)
+		return false
+	}
+	return ok
+}
+
+// add records that urlPath wasn't found
+func (c *missCache) add(urlPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.misses) >= maxMisses {
+		for path, expires := range c.misses {
+			if now.After(expires) {
+				delete(c.misses, path)
+			}
+		}
+	}
+	if len(c.misses) >= maxMisses {
+		clear(c.misses)
+	}
+	c.misses[urlPath] = now.Add(missTTL)
+}
+
+// clear forgets every miss, for when the directories served change
+func (c *missCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.misses)
+}
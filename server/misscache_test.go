@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMissCache(t *testing.T) {
+	dir := writeTree(t, map[string]string{"index.html": "app"})
+	conf := testConfig(dir)
+	conf.Index = filepath.Join(dir, "index.html")
+	s := newTestServer(t, conf)
+
+	expect(t, get(s, "GET", "/app/route"), 200, "app")
+	if !s.misses.missed("/app/route") {
+		t.Error("/app/route wasn't remembered as missing")
+	}
+	expect(t, get(s, "GET", "/app/route"), 200, "app")
+
+	// replacing the config forgets the misses, as the directories may differ
+	if err := s.SetConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if s.misses.missed("/app/route") {
+		t.Error("SetConfig kept the misses")
+	}
+
+	conf.NoMissCache = true
+	if err := s.SetConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, get(s, "GET", "/other"), 200, "app")
+	if s.misses.missed("/other") {
+		t.Error("remembered /other with NoMissCache")
+	}
+}
+
+// BenchmarkIndexFallback navigates the routes of a single page app served
+// from many directories, where each route falls back to Index
+func BenchmarkIndexFallback(b *testing.B) {
+	var dirs []string
+	for i := range 20 {
+		dirs = append(dirs, writeTree(b, map[string]string{fmt.Sprintf("assets/%d.js", i): ""}))
+	}
+	index := filepath.Join(writeTree(b, map[string]string{"index.html": "app"}), "index.html")
+	routes := make([]string, 100)
+	for i := range routes {
+		routes[i] = fmt.Sprintf("/users/%d/settings", i)
+	}
+
+	for _, cached := range []bool{true, false} {
+		name := "cached"
+		if !cached {
+			name = "uncached"
+		}
+		b.Run(name, func(b *testing.B) {
+			conf := testConfig(dirs...)
+			conf.Index = index
+			conf.NoMissCache = !cached
+			s := newTestServer(b, conf)
+			b.ReportAllocs()
+			i := 0
+			for b.Loop() {
+				route := routes[i%len(routes)]
+				if w := get(s, "GET", route); w.Code != 200 {
+					b.Fatalf("GET %s = %d", route, w.Code)
+				}
+				i++
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"container/list"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMissing is the number of distinct 404 paths kept, the least recently
+// requested are evicted first
+const maxMissing = 1000
+
+var missingTmpl = template.Must(template.New("missing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<title>Not found</title>
+	<style>
+		body {
+			font-size: 14px;
+			font-family: consolas, "Liberation Mono", "DejaVu Sans Mono", Menlo, monospace;
+		}
+		td, th {
+			padding: 2px 12px 2px 0;
+			text-align: left;
+		}
+	</style>
+</head>
+<body>
+	<table>
+		<tr><th>Path</th><th>Hits</th><th>Last referer</th><th>Last seen</th></tr>
+	{{range .}}
+		<tr><td>{{.Path}}</td><td>{{.Hits}}</td><td>{{.Referer}}</td><td>{{.LastSeen.Format "2006-01-02 15:04:05"}}</td></tr>
+	{{end}}
+	</table>
+</body>
+`))
+
+// Missing is a path that was requested but not found
+type Missing struct {
+	Path     string    `json:"path"`
+	Hits     int64     `json:"hits"`
+	Referer  string    `json:"referer"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// missingTable is an LRU of 404 responses keyed by request path
+type missingTable struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newMissingTable() *missingTable {
+	return &missingTable{
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+func (t *missingTable) record(r *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elem, ok := t.entries[r.URL.Path]
+	if ok {
+		t.order.MoveToFront(elem)
+	} else {
+		elem = t.order.PushFront(&Missing{Path: r.URL.Path})
+		t.entries[r.URL.Path] = elem
+		if t.order.Len() > maxMissing {
+			oldest := t.order.Back()
+			t.order.Remove(oldest)
+			delete(t.entries, oldest.Value.(*Missing).Path)
+		}
+	}
+
+	entry := elem.Value.(*Missing)
+	entry.Hits++
+	entry.LastSeen = time.Now()
+	if referer := r.Referer(); referer != "" {
+		entry.Referer = referer
+	}
+}
+
+// list returns a copy of the entries, most recently seen first
+func (t *missingTable) list() []Missing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := make([]Missing, 0, t.order.Len())
+	for elem := t.order.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, *elem.Value.(*Missing))
+	}
+	return entries
+}
+
+func (t *missingTable) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.entries = map[string]*list.Element{}
+	t.order.Init()
+}
+
+// ServeHTTP reports the table at /_404s as HTML or, if requested by the
+// Accept header, JSON. DELETE clears it
+func (t *missingTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodDelete:
+		t.reset()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		respondError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.list())
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	missingTmpl.Execute(w, t.list())
+}
+
+// logMissing prints the table, for the shutdown summary
+func (t *missingTable) logMissing(logger *log.Logger) {
+	for _, entry := range t.list() {
+		logger.Printf("not found: %s (%d, last from %q)", entry.Path, entry.Hits, entry.Referer)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+// missingServer tracks the 404s of a server for dir
+func missingServer(t *testing.T, dir string) *Server {
+	conf := testConfig(dir)
+	conf.Track404s = true
+	return newTestServer(t, conf)
+}
+
+// missing decodes the JSON report at /_404s
+func missing(t *testing.T, s *Server) []Missing {
+	t.Helper()
+	w := get(s, "GET", "/_404s", "Accept", "application/json")
+	if w.Code != 200 {
+		t.Fatalf("GET /_404s = %d", w.Code)
+	}
+	var entries []Missing
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestMissingReport(t *testing.T) {
+	s := missingServer(t, writeTree(t, map[string]string{"a.txt": "a"}))
+	get(s, "GET", "/old.html", "Referer", "https://example.com/blog")
+	get(s, "GET", "/a.txt")
+	get(s, "GET", "/gone.png", "Referer", "https://example.com/first")
+	get(s, "GET", "/gone.png", "Referer", "https://example.com/second")
+	get(s, "GET", "/gone.png")
+
+	entries := missing(t, s)
+	if len(entries) != 2 {
+		t.Fatalf("report = %+v, want the 2 paths not found", entries)
+	}
+	gone, old := entries[0], entries[1]
+	if gone.Path != "/gone.png" || gone.Hits != 3 || gone.Referer != "https://example.com/second" {
+		t.Errorf("most recent = %+v, want /gone.png with 3 hits and the last referer", gone)
+	}
+	if old.Path != "/old.html" || old.Hits != 1 || old.Referer != "https://example.com/blog" {
+		t.Errorf("oldest = %+v, want /old.html from the blog", old)
+	}
+	if gone.LastSeen.Before(old.LastSeen) {
+		t.Error("last seen times are out of order")
+	}
+
+	w := get(s, "GET", "/_404s")
+	expect(t, w, 200, "<td>/gone.png</td><td>3</td>")
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestMissingReportMethods(t *testing.T) {
+	s := missingServer(t, t.TempDir())
+	get(s, "GET", "/missing")
+
+	w := get(s, "POST", "/_404s")
+	expect(t, w, 405, "")
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, DELETE" {
+		t.Errorf("Allow = %q", allow)
+	}
+	expect(t, get(s, "DELETE", "/_404s"), 204, "")
+	if entries := missing(t, s); len(entries) != 0 {
+		t.Errorf("report after DELETE = %+v, want it empty", entries)
+	}
+}
+
+func TestMissingReportEvicts(t *testing.T) {
+	s := missingServer(t, t.TempDir())
+	for i := range maxMissing + 10 {
+		get(s, "GET", fmt.Sprintf("/%d", i))
+		if i == 0 {
+			continue
+		}
+		// /0 is seen again each time, so it's never the least recent
+		get(s, "GET", "/0")
+	}
+
+	entries := missing(t, s)
+	if len(entries) != maxMissing {
+		t.Fatalf("report has %d paths, want %d", len(entries), maxMissing)
+	}
+	paths := map[string]bool{}
+	for _, entry := range entries {
+		paths[entry.Path] = true
+	}
+	if !paths["/0"] || paths["/1"] || !paths[fmt.Sprintf("/%d", maxMissing+9)] {
+		t.Error("evicted the wrong paths, want the least recently requested gone")
+	}
+}
+
+func TestMissingInSummary(t *testing.T) {
+	var logs bytes.Buffer
+	conf := testConfig(t.TempDir())
+	conf.Track404s = true
+	conf.Verbose = true
+	conf.Logger = log.New(&logs, "", 0)
+	s := newTestServer(t, conf)
+	get(s, "GET", "/missing.css", "Referer", "http://localhost/")
+	logs.Reset()
+	s.LogSummary()
+
+	if want := `not found: /missing.css (1, last from "http://localhost/")`; !strings.Contains(logs.String(), want) {
+		t.Errorf("summary has no %q:\n%s", want, logs.String())
+	}
+}
+
+func TestMissingDisabled(t *testing.T) {
+	s := newTestServer(t, testConfig(t.TempDir()))
+	expect(t, get(s, "GET", "/_404s"), 404, "")
+}
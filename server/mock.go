@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// mockFile is a fixture in the --mock directory, called
+// NAME.METHOD[.STATUS].json. A NAME of [param] matches any path element,
+// which the fixture can show with {{.param}}, escaped for a JSON string
+type mockFile struct {
+	file   string
+	name   string
+	method string
+	status int
+}
+
+// parseMockFile returns the fixture described by a file name, ok is false for
+// other files
+func parseMockFile(file string) (f mockFile, ok bool) {
+	base, found := strings.CutSuffix(file, ".json")
+	if !found {
+		return f, false
+	}
+	parts := strings.Split(base, ".")
+	f = mockFile{file: file, status: http.StatusOK}
+	if n := len(parts); n >= 3 && len(parts[n-1]) == 3 {
+		status, err := strconv.Atoi(parts[n-1])
+		if err != nil || status < 100 || status > 599 {
+			return f, false
+		}
+		f.status = status
+		parts = parts[:n-1]
+	}
+	n := len(parts)
+	if n < 2 || parts[n-1] == "" || strings.ToUpper(parts[n-1]) != parts[n-1] {
+		return f, false
+	}
+	f.method = parts[n-1]
+	f.name = strings.Join(parts[:n-1], ".")
+	return f, f.name != ""
+}
+
+// mockParam returns the name of the parameter a file or directory name
+// matches, if it is one
+func mockParam(name string) (string, bool) {
+	if len(name) > 2 && strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		return name[1 : len(name)-1], true
+	}
+	return "", false
+}
+
+// resolveMock returns the fixtures for the path elements under dir, with the
+// parameters they matched. A fixture or directory named after an element is
+// preferred to a [param] one, the path "" is index. Nothing is returned if no
+// fixtures match
+func resolveMock(dir string, elems []string, params map[string]string) ([]mockFile, map[string]string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil
+	}
+	if len(elems) == 1 {
+		var exact, matched []mockFile
+		param := ""
+		for _, entry := range entries {
+			f, ok := parseMockFile(entry.Name())
+			if !ok || entry.IsDir() {
+				continue
+			}
+			f.file = filepath.Join(dir, f.file)
+			switch p, isParam := mockParam(f.name); {
+			case f.name == elems[0]:
+				exact = append(exact, f)
+			case isParam && elems[0] != "index" && (param == "" || param == p):
+				param = p
+				matched = append(matched, f)
+			}
+		}
+		if len(exact) > 0 {
+			return exact, params
+		}
+		if len(matched) > 0 {
+			params = withParam(params, param, elems[0])
+			return matched, params
+		}
+		return nil, nil
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() == elems[0] {
+			if files, p := resolveMock(filepath.Join(dir, entry.Name()), elems[1:], params); files != nil {
+				return files, p
+			}
+		}
+	}
+	for _, entry := range entries {
+		if param, ok := mockParam(entry.Name()); ok && entry.IsDir() {
+			next := withParam(params, param, elems[0])
+			if files, p := resolveMock(filepath.Join(dir, entry.Name()), elems[1:], next); files != nil {
+				return files, p
+			}
+		}
+	}
+	return nil, nil
+}
+
+// jsonParams escapes the values of params to be shown inside a JSON string,
+// so that a path element can't add to or end the fixture's JSON
+func jsonParams(params map[string]string) map[string]string {
+	escaped := make(map[string]string, len(params))
+	for key, value := range params {
+		quoted, _ := json.Marshal(value)
+		escaped[key] = string(quoted[1 : len(quoted)-1])
+	}
+	return escaped
+}
+
+// withParam returns a copy of params with key set to value
+func withParam(params map[string]string, key, value string) map[string]string {
+	next := maps.Clone(params)
+	next[key] = value
+	return next
+}
+
+// underMock reports whether urlPath is under MockPrefix
+func (c *Config) underMock(urlPath string) bool {
+	prefix := c.mockPrefix()
+	return c.Mock != "" && (prefix == "/" || urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/"))
+}
+
+// mockPrefix returns MockPrefix, /api if it's empty
+func (c *Config) mockPrefix() string {
+	if c.MockPrefix == "" {
+		return "/api"
+	}
+	return strings.TrimSuffix(path.Clean(c.MockPrefix), "/")
+}
+
+// serveMock answers the request with a fixture from --mock, a request under
+// the prefix without one is a 404 unless MockFallthrough is set, in which
+// case false is returned. A fixture that exists for other methods only is a
+// 405 with the methods it has in Allow
+func serveMock(w http.ResponseWriter, r *http.Request) bool {
+	conf := configFor(r)
+	rel := strings.Trim(strings.TrimPrefix(path.Clean(r.URL.Path), conf.mockPrefix()), "/")
+	elems := []string{"index"}
+	if rel != "" {
+		elems = strings.Split(rel, "/")
+	}
+	for _, elem := range elems {
+		if strings.HasPrefix(elem, ".") {
+			elems = nil
+			break
+		}
+	}
+	var files []mockFile
+	var params map[string]string
+	if elems != nil {
+		files, params = resolveMock(conf.Mock, elems, map[string]string{})
+	}
+	if files == nil {
+		if conf.MockFallthrough {
+			return false
+		}
+		notFound(w, r)
+		return true
+	}
+
+	methods := []string{}
+	var fixture *mockFile
+	for i, f := range files {
+		if !slices.Contains(methods, f.method) {
+			methods = append(methods, f.method)
+		}
+		if fixture == nil && f.method == r.Method {
+			fixture = &files[i]
+		}
+	}
+	if fixture == nil && r.Method == http.MethodHead {
+		for i, f := range files {
+			if f.method == http.MethodGet {
+				fixture = &files[i]
+				break
+			}
+		}
+	}
+	if fixture == nil {
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		respondError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return true
+	}
+
+	content, err := os.ReadFile(fixture.file)
+	if err != nil {
+		conf.logger().Printf("mock %s: %s", fixture.file, err)
+		respondError(w, r, http.StatusInternalServerError, "reading fixture failed")
+		return true
+	}
+	tmpl, err := template.New(fixture.file).Option("missingkey=zero").Parse(string(content))
+	var body bytes.Buffer
+	if err == nil {
+		err = tmpl.Execute(&body, jsonParams(params))
+	}
+	if err != nil {
+		conf.logger().Printf("mock %s: %s", fixture.file, err)
+		respondError(w, r, http.StatusInternalServerError, "fixture template failed")
+		return true
+	}
+	setServedFile(w, fixture.file)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(fixture.status)
+	if r.Method != http.MethodHead {
+		w.Write(body.Bytes())
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+// mockServer serves fixtures from files under /api
+func mockServer(t *testing.T, files map[string]string) *Server {
+	conf := testConfig(writeTree(t, map[string]string{"page.txt": "page"}))
+	conf.Mock = writeTree(t, files)
+	return newTestServer(t, conf)
+}
+
+func TestMock(t *testing.T) {
+	s := mockServer(t, map[string]string{
+		"index.GET.json":       `{"index": true}`,
+		"users.GET.json":       `[]`,
+		"users.POST.201.json":  `{"created": true}`,
+		"users/[id].GET.json":  `{"id": "{{.id}}"}`,
+		"users/me.GET.json":    `{"me": true}`,
+		"broken.GET.json":      `{{.unclosed`,
+		"[org]/repos.GET.json": `{"org": "{{.org}}", "missing": "{{.missing}}"}`,
+	})
+
+	tests := []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{"GET", "/api", 200, `{"index": true}`},
+		{"GET", "/api/users", 200, `[]`},
+		{"POST", "/api/users", 201, `{"created": true}`},
+		{"GET", "/api/users/42", 200, `{"id": "42"}`},
+		{"GET", "/api/users/me", 200, `{"me": true}`},
+		{"GET", "/api/acme/repos", 200, `{"org": "acme", "missing": ""}`},
+		{"HEAD", "/api/users", 200, ""},
+		{"DELETE", "/api/users", 405, ""},
+		{"GET", "/api/nothing", 404, ""},
+		{"GET", "/api/.hidden", 404, ""},
+		{"GET", "/api/broken", 500, "fixture template failed"},
+		{"GET", "/page.txt", 200, "page"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := get(s, tt.method, tt.path)
+			expect(t, w, tt.status, tt.body)
+			if tt.status < 300 && tt.path != "/page.txt" && w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+	if allow := get(s, "DELETE", "/api/users").Header().Get("Allow"); allow != "GET, POST" {
+		t.Errorf("Allow = %q, want GET, POST", allow)
+	}
+	if w := get(s, "HEAD", "/api/users"); w.Body.Len() != 0 || w.Header().Get("Content-Length") != "2" {
+		t.Errorf("HEAD sent %q with Content-Length %s", w.Body.String(), w.Header().Get("Content-Length"))
+	}
+}
+
+func TestMockParamsAreEscaped(t *testing.T) {
+	s := mockServer(t, map[string]string{"users/[id].GET.json": `{"id": "{{.id}}", "admin": false}`})
+
+	for _, id := range []string{
+		`42", "admin": true, "x": "`,
+		`\`,
+		`"}`,
+		"line\nbreak",
+		`<script>`,
+	} {
+		t.Run(id, func(t *testing.T) {
+			w := get(s, "GET", "/api/users/"+url.PathEscape(id))
+			var user map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+				t.Fatalf("fixture isn't valid JSON: %v: %s", err, w.Body.String())
+			}
+			if user["id"] != id || user["admin"] != false || len(user) != 2 {
+				t.Errorf("fixture = %v, want id %q and nothing else changed", user, id)
+			}
+		})
+	}
+}
+
+func TestMockFallthrough(t *testing.T) {
+	conf := testConfig(writeTree(t, map[string]string{"api/static.json": "static"}))
+	conf.Mock = writeTree(t, map[string]string{"users.GET.json": "[]"})
+	conf.MockFallthrough = true
+	conf.MockPrefix = "/api/"
+	s := newTestServer(t, conf)
+
+	expect(t, get(s, "GET", "/api/users"), 200, "[]")
+	expect(t, get(s, "GET", "/api/static.json"), 200, "static")
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mount serves a directory under a URL prefix, with its own policy
+type Mount struct {
+	Prefix string
+	Dir    string
+	// FS is served instead of Dir if it is set, Dir is then only the name
+	// shown in listings
+	FS fs.FS
+	// Auth is the user:password required to access the mount, if any
+	Auth   string
+	NoList bool
+	// Cache is sent as the max-age of responses from the mount, if set
+	Cache time.Duration
+}
+
+// MountList is a flag.Value for --mount, which may be given more than once:
+//
+//	--mount /public=./pub --mount /private=./priv,auth=user:pass,nolist=true
+type MountList []Mount
+
+func (l *MountList) String() string {
+	mounts := make([]string, len(*l))
+	for i, m := range *l {
+		mounts[i] = m.String()
+	}
+	return strings.Join(mounts, " ")
+}
+
+func (l *MountList) Set(value string) error {
+	spec, options, _ := strings.Cut(value, ",")
+	prefix, dir, found := strings.Cut(spec, "=")
+	if !found || dir == "" || !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("expected /prefix=dir[,option=value...]")
+	}
+	m := Mount{Prefix: strings.TrimSuffix(path.Clean(prefix), "/"), Dir: dir}
+	if m.Prefix == "" {
+		return fmt.Errorf("mounting at / is the same as serving %s", dir)
+	}
+
+	for _, option := range strings.Split(options, ",") {
+		if option == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		var err error
+		switch key {
+		case "auth":
+			if !strings.Contains(value, ":") {
+				err = fmt.Errorf("expected user:password")
+			}
+			m.Auth = value
+		case "nolist":
+			m.NoList, err = strconv.ParseBool(value)
+		case "cache":
+			m.Cache, err = time.ParseDuration(value)
+		default:
+			err = fmt.Errorf("unknown option, expected auth, nolist or cache")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	*l = append(*l, m)
+	return nil
+}
+
+func (m Mount) String() string {
+	s := m.Prefix + "=" + m.Dir
+	if m.Auth != "" {
+		s += ",auth=" + m.Auth
+	}
+	if m.NoList {
+		s += ",nolist=true"
+	}
+	if m.Cache > 0 {
+		s += ",cache=" + m.Cache.String()
+	}
+	return s
+}
+
+func (m *Mount) source() source {
+	if m.FS != nil {
+		return fsSource(FS{Name: m.Dir, FS: m.FS})
+	}
+	return dirSource(m.Dir)
+}
+
+// match returns the mount with the longest prefix containing urlPath
+func (l MountList) match(urlPath string) *Mount {
+	var best *Mount
+	for i, m := range l {
+		if urlPath != m.Prefix && !strings.HasPrefix(urlPath, m.Prefix+"/") {
+			continue
+		}
+		if best == nil || len(m.Prefix) > len(best.Prefix) {
+			best = &l[i]
+		}
+	}
+	return best
+}
+
+// mountKey is the request context key of the mount being served
+type mountKey struct{}
+
+// mountPrefix returns the prefix of the mount a request is being served from,
+// so listings can link back to it
+func mountPrefix(r *http.Request) string {
+	if m, ok := r.Context().Value(mountKey{}).(*Mount); ok {
+		return m.Prefix
+	}
+	return ""
+}
+
+// authorized reports whether r has the mount's credentials, if it has any,
+// answering with a 401 if not
+func (m *Mount) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if m.Auth == "" {
+		return true
+	}
+	user, pass, _ := r.BasicAuth()
+	if subtle.ConstantTimeCompare([]byte(user+":"+pass), []byte(m.Auth)) != 1 {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", m.Prefix))
+		respondError(w, r, http.StatusUnauthorized, "unauthorized")
+		return false
+	}
+	return true
+}
+
+// mountAuth serves h only to clients with the credentials of a mount, when
+// any mount has them, for endpoints that report requests made to every mount
+func mountAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protected := false
+		user, pass, _ := r.BasicAuth()
+		for _, m := range configFor(r).Mounts {
+			if m.Auth == "" {
+				continue
+			}
+			if subtle.ConstantTimeCompare([]byte(user+":"+pass), []byte(m.Auth)) == 1 {
+				h.ServeHTTP(w, r)
+				return
+			}
+			protected = true
+		}
+		if protected {
+			w.Header().Set("WWW-Authenticate", `Basic realm="serve"`)
+			respondError(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serveMount serves a request under m.Prefix from m.Dir, applying the mount's
+// policy on top of the configuration. A link signed with ShareSecret is let
+// through without the credentials, an expired or forged one is a 403 unless
+// the request has them
+func serveMount(w http.ResponseWriter, r *http.Request, m *Mount) {
+	if m.Auth != "" {
+		signed, valid := signedLink(r)
+		_, _, hasAuth := r.BasicAuth()
+		switch {
+		case valid:
+		case signed && !hasAuth:
+			respondError(w, r, http.StatusForbidden, "link expired or invalid")
+			return
+		case !m.authorized(w, r):
+			return
+		}
+	}
+	if r.URL.Path == m.Prefix {
+		http.Redirect(w, r, m.Prefix+"/", http.StatusMovedPermanently)
+		return
+	}
+	if m.Cache > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(m.Cache.Seconds())))
+	}
+
+	conf := *configFor(r)
+	conf.NoList = conf.NoList || m.NoList
+	r = withConfig(r, &conf)
+	r = r.WithContext(context.WithValue(r.Context(), mountKey{}, m))
+	// WithContext doesn't copy the URL, which the caller still uses for
+	// logging
+	u := *r.URL
+	u.Path = strings.TrimPrefix(u.Path, m.Prefix)
+	r.URL = &u
+
+	sources := []source{m.source()}
+	if tryCGI(w, r, sources) || tryMethod(w, r) || tryIndexFirst(w, r, sources) || tryDirs(w, r, sources) ||
+		tryFiles(w, r, sources) || tryAutoIndex(w, r, sources) {
+		return
+	}
+	notFound(w, r)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMounts(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"root/r.txt":         "root",
+		"pub/p.txt":          "public",
+		"pub/sub/s.txt":      "sub",
+		"priv/secret.txt":    "private",
+		"nested/n.txt":       "nested",
+		"unlisted/dir/u.txt": "unlisted",
+		"cached/c.txt":       "cached",
+		"pub/nested/hidden":  "shadowed by the nested mount",
+	})
+	conf := testConfig(filepath.Join(dir, "root"))
+	for _, mount := range []string{
+		"/pub=" + filepath.Join(dir, "pub"),
+		"/pub/nested=" + filepath.Join(dir, "nested"),
+		"/priv=" + filepath.Join(dir, "priv") + ",auth=user:pass",
+		"/unlisted/=" + filepath.Join(dir, "unlisted") + ",nolist=true",
+		"/cached=" + filepath.Join(dir, "cached") + ",cache=1h",
+	} {
+		if err := conf.Mounts.Set(mount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf.Mounts = append(conf.Mounts, Mount{Prefix: "/fs", Dir: "embedded", FS: fstest.MapFS{"f.txt": {Data: []byte("from fs")}}})
+	s := newTestServer(t, conf)
+	auth := basicAuth("user", "pass")
+
+	tests := []struct {
+		path   string
+		auth   string
+		status int
+		body   string
+	}{
+		{"/r.txt", "", 200, "root"},
+		{"/pub/p.txt", "", 200, "public"},
+		{"/pub/sub/s.txt", "", 200, "sub"},
+		{"/pub/r.txt", "", 404, ""},
+		{"/p.txt", "", 404, ""},
+		// the longest prefix wins
+		{"/pub/nested/n.txt", "", 200, "nested"},
+		{"/pub/nested/hidden", "", 404, ""},
+		{"/priv/secret.txt", "", 401, "unauthorized"},
+		{"/priv/secret.txt", basicAuth("user", "wrong"), 401, "unauthorized"},
+		{"/priv/secret.txt", auth, 200, "private"},
+		// nor can .. step out of one mount into another
+		{"/pub/../priv/secret.txt", "", 400, "invalid path"},
+		{"/unlisted/dir/u.txt", "", 200, "unlisted"},
+		{"/unlisted/dir/", "", 404, ""},
+		{"/pub/sub/", "", 200, "s.txt"},
+		{"/fs/f.txt", "", 200, "from fs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var headers []string
+			if tt.auth != "" {
+				headers = []string{"Authorization", tt.auth}
+			}
+			expect(t, get(s, "GET", tt.path, headers...), tt.status, tt.body)
+		})
+	}
+
+	w := get(s, "GET", "/priv/secret.txt")
+	if got := w.Header().Get("WWW-Authenticate"); got != `Basic realm="/priv"` {
+		t.Errorf("WWW-Authenticate = %q", got)
+	}
+	w = get(s, "GET", "/pub")
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/pub/" {
+		t.Errorf("/pub = %d to %q, want a redirect to /pub/", w.Code, w.Header().Get("Location"))
+	}
+	if w := get(s, "GET", "/priv"); w.Code != 401 {
+		t.Errorf("/priv = %d, want 401 before the redirect", w.Code)
+	}
+	if got := get(s, "GET", "/cached/c.txt").Header().Get("Cache-Control"); got != "max-age=3600" {
+		t.Errorf("Cache-Control = %q, want max-age=3600", got)
+	}
+	if got := get(s, "GET", "/pub/p.txt").Header().Get("Cache-Control"); got != "" {
+		t.Errorf("Cache-Control = %q from a mount without cache", got)
+	}
+}
+
+func TestMountListSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Mount
+		err   string
+	}{
+		{"/public=./pub", Mount{Prefix: "/public", Dir: "./pub"}, ""},
+		{"/a/b/=dir", Mount{Prefix: "/a/b", Dir: "dir"}, ""},
+		{"/private=./priv,auth=user:pass,nolist=true", Mount{Prefix: "/private", Dir: "./priv", Auth: "user:pass", NoList: true}, ""},
+		{"/c=dir,cache=10m,", Mount{Prefix: "/c", Dir: "dir", Cache: 10 * time.Minute}, ""},
+		{"public=./pub", Mount{}, "expected /prefix=dir[,option=value...]"},
+		{"/public", Mount{}, "expected /prefix=dir[,option=value...]"},
+		{"/public=", Mount{}, "expected /prefix=dir[,option=value...]"},
+		{"/=dir", Mount{}, "mounting at / is the same as serving dir"},
+		{"/a=dir,auth=user", Mount{}, "auth: expected user:password"},
+		{"/a=dir,nolist=maybe", Mount{}, `nolist: strconv.ParseBool: parsing "maybe": invalid syntax`},
+		{"/a=dir,cache=long", Mount{}, `cache: time: invalid duration "long"`},
+		{"/a=dir,ro=true", Mount{}, "ro: unknown option, expected auth, nolist or cache"},
+	}
+	for _, tt := range tests {
+		var l MountList
+		err := l.Set(tt.value)
+		switch {
+		case err != nil && err.Error() != tt.err:
+			t.Errorf("Set(%q) = %q, want %q", tt.value, err, tt.err)
+		case err == nil && tt.err != "":
+			t.Errorf("Set(%q) = nil, want %q", tt.value, tt.err)
+		case err == nil && (len(l) != 1 || l[0] != tt.want):
+			t.Errorf("Set(%q) gave %+v, want %+v", tt.value, l, tt.want)
+		}
+	}
+
+	var l MountList
+	l.Set("/public=./pub")
+	l.Set("/private=./priv,nolist=true,auth=user:pass,cache=1h")
+	if got, want := l.String(), "/public=./pub /private=./priv,auth=user:pass,nolist=true,cache=1h0m0s"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
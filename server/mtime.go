@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModTime is a flag.Value for --mtime, the modification time files are sent
+// with. real sends each file's own, build=TIME sends TIME for every file, as
+// RFC 3339 or unix seconds, so mirrors built on different machines agree,
+// and none sends no Last-Modified and ignores If-Modified-Since:
+//
+//	--mtime build=2024-01-01T00:00:00Z
+type ModTime struct {
+	// Build is sent for every file if it isn't zero
+	Build time.Time
+	None  bool
+}
+
+func (m *ModTime) String() string {
+	switch {
+	case m.None:
+		return "none"
+	case !m.Build.IsZero():
+		return "build=" + m.Build.Format(time.RFC3339)
+	}
+	return "real"
+}
+
+func (m *ModTime) Set(value string) error {
+	switch value {
+	case "real":
+		*m = ModTime{}
+		return nil
+	case "none":
+		*m = ModTime{None: true}
+		return nil
+	}
+	build, ok := strings.CutPrefix(value, "build=")
+	if !ok {
+		return fmt.Errorf("%q is not real, build=TIME or none", value)
+	}
+	if t, err := time.Parse(time.RFC3339, build); err == nil {
+		*m = ModTime{Build: t}
+		return nil
+	}
+	if secs, err := strconv.ParseInt(build, 10, 64); err == nil && secs > 0 {
+		*m = ModTime{Build: time.Unix(secs, 0)}
+		return nil
+	}
+	return fmt.Errorf("build=%s: the time must be RFC 3339, such as 2024-01-01T00:00:00Z, or unix seconds", build)
+}
+
+// of returns the time to send for a file modified at t, the zero time for
+// none, which http.ServeContent takes to mean there is no Last-Modified
+func (m ModTime) of(t time.Time) time.Time {
+	switch {
+	case m.None:
+		return time.Time{}
+	case !m.Build.IsZero():
+		return m.Build
+	}
+	return t
+}
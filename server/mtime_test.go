@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModTime(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a"})
+	modified := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	build := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		mtime        ModTime
+		lastModified string
+		// the status of a request If-Modified-Since its Last-Modified
+		revalidated int
+	}{
+		{ModTime{}, modified.Format(http.TimeFormat), 304},
+		{ModTime{Build: build}, build.Format(http.TimeFormat), 304},
+		// without a time If-Modified-Since can't match, so it's ignored
+		{ModTime{None: true}, "", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mtime.String(), func(t *testing.T) {
+			conf := testConfig(dir)
+			conf.MTime = tt.mtime
+			s := newTestServer(t, conf)
+
+			w := get(s, "GET", "/a.txt")
+			expect(t, w, 200, "a")
+			if got := w.Header().Get("Last-Modified"); got != tt.lastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, tt.lastModified)
+			}
+			since := tt.lastModified
+			if since == "" {
+				since = build.Format(http.TimeFormat)
+			}
+			w = get(s, "GET", "/a.txt", "If-Modified-Since", since)
+			if w.Code != tt.revalidated {
+				t.Errorf("If-Modified-Since %s = %d, want %d", since, w.Code, tt.revalidated)
+			}
+			// an older copy is always sent the file
+			old := modified.Add(-time.Hour).Format(http.TimeFormat)
+			expect(t, get(s, "GET", "/a.txt", "If-Modified-Since", old), 200, "a")
+		})
+	}
+}
+
+func TestModTimeSet(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"real", "real"},
+		{"none", "none"},
+		{"build=2024-01-01T00:00:00Z", "build=2024-01-01T00:00:00Z"},
+		{"build=2024-01-01T02:00:00+02:00", "build=2024-01-01T02:00:00+02:00"},
+		{"build=1704067200", "build=" + time.Unix(1704067200, 0).Format(time.RFC3339)},
+	}
+	for _, tt := range tests {
+		var m ModTime
+		if err := m.Set(tt.value); err != nil || m.String() != tt.want {
+			t.Errorf("Set(%q) = %v, String %q, want %q", tt.value, err, m.String(), tt.want)
+		}
+	}
+	for _, value := range []string{"", "now", "build=", "build=yesterday", "build=0", "build=-5"} {
+		if err := new(ModTime).Set(value); err == nil {
+			t.Errorf("Set(%q) = nil, want an error", value)
+		}
+	}
+}
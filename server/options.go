@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Option changes a setting of the Config built by NewHandler, returning an
+// error if it's given a value that can't work
+type Option func(*Config) error
+
+// NewHandler returns a handler serving dirs, overlaid in order, with the
+// default configuration changed by opts. The Config is validated as by New, so
+// a mistake is returned here rather than discovered while serving:
+//
+//	h, err := server.NewHandler([]string{"public"},
+//		server.WithIndexFallback("public/index.html"),
+//		server.WithListings(false),
+//	)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.Handle("/app/", http.StripPrefix("/app", h))
+func NewHandler(dirs []string, opts ...Option) (http.Handler, error) {
+	cfg := DefaultConfig()
+	cfg.Dirs = dirs
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	s, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s.Handler(), nil
+}
+
+// WithIndexFallback serves file for every request that doesn't match a file,
+// as for single page apps that route in the browser. With several, the first
+// that exists is served
+func WithIndexFallback(files ...string) Option {
+	return func(c *Config) error {
+		if len(files) == 0 || slices.Contains(files, "") {
+			return errors.New("WithIndexFallback: no file given")
+		}
+		c.Index = strings.Join(files, ",")
+		return nil
+	}
+}
+
+// WithListings enables or disables directory listings, they are enabled by
+// default
+func WithListings(enabled bool) Option {
+	return func(c *Config) error {
+		c.NoList = !enabled
+		return nil
+	}
+}
+
+// WithLogger sends the server's logs to l rather than the standard logger
+func WithLogger(l *log.Logger) Option {
+	return func(c *Config) error {
+		if l == nil {
+			return errors.New("WithLogger: nil logger")
+		}
+		c.Logger = l
+		return nil
+	}
+}
+
+// WithHeaders adds h to every response, it may be given more than once
+func WithHeaders(h http.Header) Option {
+	return func(c *Config) error {
+		if c.Headers == nil {
+			c.Headers = http.Header{}
+		}
+		for key, values := range h {
+			for _, value := range values {
+				c.Headers.Add(key, value)
+			}
+		}
+		return nil
+	}
+}
+
+// WithFS serves fsys after the directories, listed as name
+func WithFS(name string, fsys fs.FS) Option {
+	return func(c *Config) error {
+		if fsys == nil {
+			return fmt.Errorf("WithFS: %q is nil", name)
+		}
+		c.FS = append(c.FS, FS{Name: name, FS: fsys})
+		return nil
+	}
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	otlpBatchSize     = 512
+	otlpFlushInterval = 5 * time.Second
+)
+
+// startTracing configures the OTLP exporter from the standard
+// OTEL_EXPORTER_OTLP_* environment variables, returning nil if tracing isn't
+// enabled. Spans are sent as JSON over HTTP, which every OTLP collector
+// accepts
+func startTracing(conf *Config) *spanExporter {
+	if !conf.Otel {
+		return nil
+	}
+	logger := conf.logger()
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			logger.Println("--otel: OTEL_EXPORTER_OTLP_ENDPOINT is not set, tracing disabled")
+			return nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol != "" && protocol != "http/json" {
+		logger.Printf("--otel: protocol %q is not supported, using http/json", protocol)
+	}
+
+	headers := http.Header{}
+	for _, pair := range splitHeader([]string{os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")}) {
+		if key, value, found := strings.Cut(pair, "="); found {
+			headers.Set(strings.TrimSpace(key), strings.TrimSpace(value))
+		}
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "serve"
+	}
+
+	tracer := &spanExporter{
+		endpoint: endpoint,
+		headers:  headers,
+		resource: otlpResource{Attributes: []otlpAttribute{stringAttribute("service.name", service)}},
+		spans:    make(chan otlpSpan, otlpBatchSize*4),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		log:      logger,
+	}
+	go tracer.run()
+	logger.Printf("exporting traces to: %s", endpoint)
+	return tracer
+}
+
+// record exports a span for a finished request, continuing the trace of an
+// incoming W3C traceparent header if there is one. route is the pattern or
+// prefix that matched the request, files served from Dirs have none
+func (e *spanExporter) record(r *http.Request, rec *responseRecorder, route string) {
+	traceID, parentID, ok := parseTraceparent(r.Header.Get("traceparent"))
+	if !ok {
+		traceID, parentID = randomHex(16), ""
+	}
+
+	status := rec.Status()
+	span := otlpSpan{
+		TraceID:      traceID,
+		SpanID:       randomHex(8),
+		ParentSpanID: parentID,
+		Name:         r.Method,
+		Kind:         2, // SPAN_KIND_SERVER
+		Start:        strconv.FormatInt(rec.start.UnixNano(), 10),
+		End:          strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes: []otlpAttribute{
+			stringAttribute("http.method", r.Method),
+			stringAttribute("http.client_ip", clientIP(r)),
+			intAttribute("http.status_code", int64(status)),
+			intAttribute("http.response_content_length", rec.written),
+		},
+	}
+	if route != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("http.route", route))
+	}
+	if rec.file != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("serve.file", rec.file))
+	}
+	if status >= 500 {
+		span.Status.Code = 2 // STATUS_CODE_ERROR
+	}
+	e.export(span)
+}
+
+// parseTraceparent returns the trace and parent span IDs of a version 00
+// traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(header string) (traceID, parentID string, ok bool) {
+	fields := strings.Split(strings.TrimSpace(header), "-")
+	if len(fields) < 4 || fields[0] != "00" {
+		return "", "", false
+	}
+	traceID, parentID = strings.ToLower(fields[1]), strings.ToLower(fields[2])
+	if !isHex(traceID, 32) || !isHex(parentID, 16) {
+		return "", "", false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false
+	}
+	return traceID, parentID, true
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// spanExporter batches spans and posts them to an OTLP/HTTP endpoint in the
+// background, dropping spans rather than blocking requests if it falls behind
+type spanExporter struct {
+	endpoint string
+	headers  http.Header
+	resource otlpResource
+	spans    chan otlpSpan
+	stop     chan struct{}
+	done     chan struct{}
+	log      *log.Logger
+}
+
+func (e *spanExporter) export(span otlpSpan) {
+	select {
+	case e.spans <- span:
+	default:
+	}
+}
+
+func (e *spanExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	batch := []otlpSpan{}
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) < otlpBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.stop:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			e.send(batch)
+			return
+		}
+		e.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// shutdown sends any spans still queued, giving up after timeout
+func (e *spanExporter) shutdown(timeout time.Duration) {
+	close(e.stop)
+	select {
+	case <-e.done:
+	case <-time.After(timeout):
+	}
+}
+
+func (e *spanExporter) send(spans []otlpSpan) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: e.resource,
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "serve", Version: Version},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		e.log.Println(err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		e.log.Println(err)
+		return
+	}
+	for key, values := range e.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		e.log.Printf("exporting traces: %s", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		e.log.Printf("exporting traces: %s", resp.Status)
+	}
+}
+
+// The OTLP JSON encoding of ExportTraceServiceRequest, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	otlpSpan struct {
+		TraceID      string          `json:"traceId"`
+		SpanID       string          `json:"spanId"`
+		ParentSpanID string          `json:"parentSpanId,omitempty"`
+		Name         string          `json:"name"`
+		Kind         int             `json:"kind"`
+		Start        string          `json:"startTimeUnixNano"`
+		End          string          `json:"endTimeUnixNano"`
+		Attributes   []otlpAttribute `json:"attributes"`
+		Status       struct {
+			Code int `json:"code,omitempty"`
+		} `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		String *string `json:"stringValue,omitempty"`
+		Int    *string `json:"intValue,omitempty"`
+	}
+)
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{String: &value}}
+}
+
+func intAttribute(key string, value int64) otlpAttribute {
+	s := strconv.FormatInt(value, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{Int: &s}}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// collectedSpan is a span as an OTLP/HTTP JSON collector receives it
+type collectedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Start        string `json:"startTimeUnixNano"`
+	End          string `json:"endTimeUnixNano"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			String *string `json:"stringValue"`
+			Int    *string `json:"intValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+// attributes returns the span's attributes, formatted as strings
+func (s collectedSpan) attributes() map[string]string {
+	attrs := map[string]string{}
+	for _, a := range s.Attributes {
+		switch {
+		case a.Value.String != nil:
+			attrs[a.Key] = *a.Value.String
+		case a.Value.Int != nil:
+			attrs[a.Key] = *a.Value.Int
+		}
+	}
+	return attrs
+}
+
+// collector is an OTLP/HTTP endpoint that keeps the spans posted to it
+type collector struct {
+	mu      sync.Mutex
+	spans   []collectedSpan
+	service string
+	headers http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string `json:"key"`
+					Value struct {
+						String string `json:"stringValue"`
+					} `json:"value"`
+				} `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Scope struct {
+					Name string `json:"name"`
+				} `json:"scope"`
+				Spans []collectedSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.headers = r.Header
+	for _, rs := range req.ResourceSpans {
+		for _, attr := range rs.Resource.Attributes {
+			if attr.Key == "service.name" {
+				c.service = attr.Value.String
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+func TestOtelSpans(t *testing.T) {
+	c := &collector{}
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", ts.URL+"/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key=secret")
+	t.Setenv("OTEL_SERVICE_NAME", "site")
+
+	dir := writeTree(t, map[string]string{"a.txt": "hello", "docs/b.txt": "docs"})
+	conf := testConfig(dir)
+	conf.Otel = true
+	conf.Mounts = MountList{{Prefix: "/docs", Dir: filepath.Join(dir, "docs")}}
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Handle("/api/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	get(s, "GET", "/a.txt", "traceparent", "00-"+traceID+"-"+parentID+"-01")
+	get(s, "GET", "/missing/1234.txt")
+	get(s, "GET", "/docs/b.txt")
+	get(s, "POST", "/api/users/42")
+	// closing sends the spans that are queued
+	s.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.service != "site" || c.headers.Get("X-Api-Key") != "secret" {
+		t.Errorf("service %q, X-Api-Key %q, want those of the environment", c.service, c.headers.Get("X-Api-Key"))
+	}
+	if len(c.spans) != 4 {
+		t.Fatalf("collected %d spans, want 4", len(c.spans))
+	}
+	tests := []struct {
+		name  string
+		attrs map[string]string
+		error bool
+	}{
+		{"GET", map[string]string{
+			"http.method":                  "GET",
+			"http.status_code":             "200",
+			"http.response_content_length": "5",
+			"http.client_ip":               "192.0.2.1",
+			"serve.file":                   filepath.Join(dir, "a.txt"),
+		}, false},
+		{"GET", map[string]string{"http.status_code": "404", "serve.file": ""}, false},
+		{"GET", map[string]string{"http.route": "/docs", "http.status_code": "200"}, false},
+		{"POST", map[string]string{"http.route": "/api/", "http.status_code": "503"}, true},
+	}
+	for i, tt := range tests {
+		span := c.spans[i]
+		attrs := span.attributes()
+		if span.Name != tt.name || span.Kind != 2 || span.Start == "" || span.End < span.Start {
+			t.Errorf("span %d = %+v", i, span)
+		}
+		if len(span.TraceID) != 32 || len(span.SpanID) != 16 {
+			t.Errorf("span %d: trace %q, span %q", i, span.TraceID, span.SpanID)
+		}
+		for key, want := range tt.attrs {
+			if attrs[key] != want {
+				t.Errorf("span %d: %s = %q, want %q", i, key, attrs[key], want)
+			}
+		}
+		if (span.Status.Code == 2) != tt.error {
+			t.Errorf("span %d: status code %d", i, span.Status.Code)
+		}
+	}
+	if span := c.spans[0]; span.TraceID != traceID || span.ParentSpanID != parentID {
+		t.Errorf("trace %q, parent %q, want the traceparent's", span.TraceID, span.ParentSpanID)
+	}
+	// a file served from Dirs matches no route, rather than each path being
+	// its own
+	if _, ok := c.spans[1].attributes()["http.route"]; ok {
+		t.Errorf("a missing path has http.route %q", c.spans[1].attributes()["http.route"])
+	}
+}
+
+func TestOtelDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	conf := testConfig()
+	if startTracing(&conf) != nil {
+		t.Error("traced without --otel")
+	}
+	conf.Otel = true
+	if startTracing(&conf) != nil {
+		t.Error("traced without an endpoint")
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header        string
+		trace, parent string
+		ok            bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-00", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", "", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", "", false},
+		{"00-4bf92f35-00f067aa0ba902b7-01", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		trace, parent, ok := parseTraceparent(tt.header)
+		if trace != tt.trace || parent != tt.parent || ok != tt.ok {
+			t.Errorf("parseTraceparent(%q) = %q, %q, %v", tt.header, trace, parent, ok)
+		}
+	}
+}
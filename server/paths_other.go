@@ -0,0 +1,23 @@
+//go:build !windows
+
+package server
+
+import (
+	"io/fs"
+	"os"
+)
+
+// foldPaths is set where the file system ignores case, so that rules matching
+// paths, such as --cgi, can't be bypassed by changing the case of a request
+const foldPaths = false
+
+// validOSPath reports whether urlPath names the file it appears to, which it
+// always does outside Windows
+func validOSPath(urlPath string) bool {
+	return true
+}
+
+// dirFS returns the file system of the directory dir
+func dirFS(dir string) fs.FS {
+	return os.DirFS(dir)
+}
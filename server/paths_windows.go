@@ -0,0 +1,73 @@
+package server
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// foldPaths is set where the file system ignores case, so that rules matching
+// paths, such as --cgi, can't be bypassed by changing the case of a request
+const foldPaths = true
+
+// validOSPath reports whether urlPath names the file it appears to on
+// Windows. Backslashes are separators and colons select drives or alternate
+// data streams, and names ending in dots or spaces are opened as the name
+// without them, so a request for "script.cgi." would open script.cgi without
+// matching the rules for it. Device names such as NUL and COM1, with any
+// extension, open the device rather than a file. They are all refused rather
+// than translated
+func validOSPath(urlPath string) bool {
+	if strings.ContainsAny(urlPath, `\:`) {
+		return false
+	}
+	for _, elem := range strings.Split(urlPath, "/") {
+		if elem != "." && elem != ".." && strings.TrimRight(elem, ". ") != elem || reservedName(elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedName reports whether elem is one of the device names Windows
+// reserves in every directory, which ignore case and anything after a dot
+func reservedName(elem string) bool {
+	base, _, _ := strings.Cut(elem, ".")
+	base = strings.ToUpper(strings.TrimRight(base, " "))
+	switch base {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+	if len(base) < 4 || base[:3] != "COM" && base[:3] != "LPT" {
+		return false
+	}
+	switch base[3:] {
+	case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "¹", "²", "³":
+		return true
+	}
+	return false
+}
+
+// dirFS returns the file system of the directory dir. Its files are opened by
+// their extended-length path, so that those nested deeper than MAX_PATH, 260
+// characters, can be served. Such paths aren't normalized by Windows, which
+// is safe as names are cleaned and checked with validOSPath before being
+// opened
+func dirFS(dir string) fs.FS {
+	return os.DirFS(extendedPath(dir))
+}
+
+// extendedPath returns the extended-length form of dir, \\?\C:\... or
+// \\?\UNC\server\share\... for shares. Paths already in a device form are
+// left as they are, as is dir if it has no absolute path
+func extendedPath(dir string) string {
+	abs, err := filepath.Abs(dir)
+	switch {
+	case err != nil || strings.HasPrefix(abs, `\\?\`) || strings.HasPrefix(abs, `\\.\`):
+		return dir
+	case strings.HasPrefix(abs, `\\`):
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidOSPath(t *testing.T) {
+	tests := []struct {
+		urlPath string
+		ok      bool
+	}{
+		{"/", true},
+		{"/sub/page.html", true},
+		{"/a.b/c", true},
+		{"/console.txt", true},
+		{"/nullable", true},
+		{"/com10", true},
+		{"/lpt", true},
+		// separators and drives
+		{`/sub\page.html`, false},
+		{`/\\server\share\file`, false},
+		{`/\\?\C:\Windows\win.ini`, false},
+		{"/C:/Windows/win.ini", false},
+		// alternate data streams
+		{"/page.html::$DATA", false},
+		{"/page.html:stream", false},
+		// trailing dots and spaces are dropped by Windows
+		{"/script.cgi.", false},
+		{"/script.cgi ", false},
+		{"/dir./file", false},
+		// device names, in any case and with any extension
+		{"/NUL", false},
+		{"/nul.txt", false},
+		{"/sub/con", false},
+		{"/Aux.tar.gz", false},
+		{"/prn", false},
+		{"/COM1", false},
+		{"/lpt9.log", false},
+		{"/com\u00b9", false},
+		{"/CONIN$", false},
+		{"/nul .txt", false},
+	}
+	for _, tt := range tests {
+		if got := validOSPath(tt.urlPath); got != tt.ok {
+			t.Errorf("validOSPath(%q) = %v, want %v", tt.urlPath, got, tt.ok)
+		}
+	}
+}
+
+func TestServeRefusesWindowsPaths(t *testing.T) {
+	dir := writeTree(t, map[string]string{"script.cgi": "#!/bin/sh", "page.txt": "page"})
+	s := newTestServer(t, testConfig(dir))
+
+	expect(t, get(s, "GET", "/page.txt"), 200, "page")
+	for _, target := range []string{"/page.txt.", "/page.txt%20", "/page.txt::$DATA", "/sub%5cpage.txt", "/nul", "/CON.txt"} {
+		if w := get(s, "GET", target); w.Code != 400 && w.Code != 404 {
+			t.Errorf("GET %s = %d, want it refused", target, w.Code)
+		}
+	}
+}
+
+func TestExtendedPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir, want string
+	}{
+		{`C:\site`, `\\?\C:\site`},
+		{`C:/site/public`, `\\?\C:\site\public`},
+		{`C:\site\..\other`, `\\?\C:\other`},
+		{`\\server\share\site`, `\\?\UNC\server\share\site`},
+		{`//server/share/site`, `\\?\UNC\server\share\site`},
+		{`\\?\C:\site`, `\\?\C:\site`},
+		{`\\?\UNC\server\share`, `\\?\UNC\server\share`},
+		{`\\.\C:\site`, `\\.\C:\site`},
+		{"site", `\\?\` + filepath.Join(wd, "site")},
+	}
+	for _, tt := range tests {
+		if got := extendedPath(tt.dir); got != tt.want {
+			t.Errorf("extendedPath(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestServeLongPaths(t *testing.T) {
+	elem := strings.Repeat("d", 50)
+	name := strings.Repeat(elem+"/", 6) + "file.txt"
+	dir := t.TempDir()
+	if err := os.MkdirAll(extendedPath(filepath.Join(dir, filepath.FromSlash(name), "..")), 0o755); err != nil {
+		t.Skipf("can't make a path over MAX_PATH: %v", err)
+	}
+	if err := os.WriteFile(extendedPath(filepath.Join(dir, filepath.FromSlash(name))), []byte("deep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, testConfig(dir))
+
+	expect(t, get(s, "GET", "/"+name), 200, "deep")
+	expect(t, get(s, "GET", "/"+strings.Repeat(elem+"/", 6)), 200, "file.txt")
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// precompressMinSize is the smallest file worth compressing, smaller
+	// ones barely shrink
+	precompressMinSize = 1024
+	// precompressGCInterval is how often variants of files that have
+	// changed or gone are removed from the cache
+	precompressGCInterval = 10 * time.Minute
+)
+
+// compressibleTypes are the prefixes of the content types that are
+// compressed, other types, such as images and archives, are compressed
+// already
+var compressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/wasm",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// compressible reports whether the file called name is worth compressing
+func compressible(name string, size int64) bool {
+	return size >= precompressMinSize && compressibleType(mime.TypeByExtension(path.Ext(name)))
+}
+
+// compressibleType reports whether responses of type ctype are worth
+// compressing
+func compressibleType(ctype string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(ctype, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether the client accepts gzip responses
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// precompressKey is the request context key of the Server's precompressor
+type precompressKey struct{}
+
+// precompressor keeps gzipped copies of the compressible files in a cache
+// directory, so that they are compressed once rather than for every request.
+// The cache mirrors the directories served, a directory named after the hash
+// of each one's path, and each copy records the size and modification time
+// of the file it was made from in its gzip comment so that stale copies are
+// noticed
+type precompressor struct {
+	dir string
+	log *log.Logger
+	// sources returns the directories on disk being served
+	sources func() []source
+
+	mu       sync.Mutex
+	inflight map[string]bool
+}
+
+// startPrecompress returns the precompressor for the cache in dir, removing
+// stale copies every precompressGCInterval until done is closed. With eager
+// the files served are compressed straight away rather than when first
+// requested
+func startPrecompress(dir string, eager bool, sources func() []source, logger *log.Logger, done <-chan struct{}) *precompressor {
+	p := &precompressor{
+		dir:      dir,
+		log:      logger,
+		sources:  sources,
+		inflight: map[string]bool{},
+	}
+	go func() {
+		if eager {
+			p.compressAll(done)
+		}
+		ticker := time.NewTicker(precompressGCInterval)
+		defer ticker.Stop()
+		for {
+			p.collect()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return p
+}
+
+// cacheDir returns the precompression cache directory of conf
+func (c *Config) cacheDir() (string, error) {
+	dir := c.CacheDir
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(userDir, "serve")
+	}
+	return filepath.Join(dir, "precompress"), nil
+}
+
+// sourceID names the cache directory of the directory dir
+func sourceID(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return hex.EncodeToString(sum[:8])
+}
+
+// variantKey identifies the version of a file a copy was made from
+func variantKey(stat fs.FileInfo) string {
+	return fmt.Sprintf("%d %d", stat.Size(), stat.ModTime().UnixNano())
+}
+
+// path returns where the copy of the file called name in src is cached
+func (p *precompressor) path(src source, name string) string {
+	return filepath.Join(p.dir, sourceID(src.dir), filepath.FromSlash(name)+".gz")
+}
+
+// openVariant opens the cached copy at file if it was made from the version
+// of the file with key, at the start of its compressed contents
+func openVariant(file, key string) (*os.File, bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, false
+	}
+	zr, err := gzip.NewReader(f)
+	if err == nil && zr.Comment == key {
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			return f, true
+		}
+	}
+	f.Close()
+	return nil, false
+}
+
+// variant returns the cached copy of the file called name in src. If there
+// isn't an up to date one it is made in the background, and nil is returned
+// so that this request is answered uncompressed. Only one copy of a file is
+// made at a time
+func (p *precompressor) variant(src source, name string, stat fs.FileInfo) *os.File {
+	file := p.path(src, name)
+	key := variantKey(stat)
+	if f, ok := openVariant(file, key); ok {
+		return f
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.inflight[file] {
+		p.inflight[file] = true
+		go func() {
+			if err := p.compress(src, name, file, key); err != nil {
+				p.log.Printf("precompress %s: %s", src.path(name), err)
+			}
+			p.mu.Lock()
+			delete(p.inflight, file)
+			p.mu.Unlock()
+		}()
+	}
+	return nil
+}
+
+// compress writes the copy of the file called name in src to file, under a
+// temporary name that is renamed into place once it is complete
+func (p *precompressor) compress(src source, name, file, key string) error {
+	in, err := src.fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(file), ".precompress-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	zw, _ := gzip.NewWriterLevel(temp, gzip.BestCompression)
+	zw.Comment = key
+	zw.Name = path.Base(name)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), file)
+	}
+	return err
+}
+
+// compressAll makes a copy of every compressible file served that doesn't
+// have an up to date one, skipping dot directories
+func (p *precompressor) compressAll(done <-chan struct{}) {
+	for _, src := range p.sources() {
+		fs.WalkDir(src.fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+			select {
+			case <-done:
+				return fs.SkipAll
+			default:
+			}
+			if err != nil {
+				return nil
+			}
+			if entry.IsDir() {
+				if name != "." && strings.HasPrefix(entry.Name(), ".") {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || !compressible(name, info.Size()) {
+				return nil
+			}
+			file, key := p.path(src, name), variantKey(info)
+			if f, ok := openVariant(file, key); ok {
+				f.Close()
+				return nil
+			}
+			if err := p.compress(src, name, file, key); err != nil {
+				p.log.Printf("precompress %s: %s", src.path(name), err)
+			}
+			return nil
+		})
+	}
+}
+
+// collect removes the copies of files in the directories served that have
+// changed or gone, along with temporary files left by a crash. The copies of
+// other directories are left for the servers that may be serving them
+func (p *precompressor) collect() {
+	sources := map[string]source{}
+	for _, src := range p.sources() {
+		sources[sourceID(src.dir)] = src
+	}
+	filepath.WalkDir(p.dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(p.dir, file)
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".precompress-") {
+			if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > time.Hour {
+				os.Remove(file)
+			}
+			return nil
+		}
+		id, name, _ := strings.Cut(filepath.ToSlash(rel), "/")
+		name, isCopy := strings.CutSuffix(name, ".gz")
+		src, served := sources[id]
+		if !served {
+			// another serve may be using the same cache for it
+			return nil
+		}
+		if !isCopy {
+			os.Remove(file)
+			return nil
+		}
+		stat, err := fs.Stat(src.fsys, name)
+		if err != nil {
+			os.Remove(file)
+			return nil
+		}
+		if f, ok := openVariant(file, variantKey(stat)); ok {
+			f.Close()
+		} else {
+			os.Remove(file)
+		}
+		return nil
+	})
+}
+
+// tryPrecompressed serves a gzipped copy of the file called name in src, if
+// the client accepts gzip and there is one. A name.gz next to the file is
+// used as it is, otherwise the copy comes from the --precompress cache. Pages
+// that have the live reload script added are sent uncompressed. The name.gz
+// goes through the same checks as a request for it, one that's ignored or
+// links outside of the directory is passed over
+func tryPrecompressed(w http.ResponseWriter, r *http.Request, src source, name string, stat fs.FileInfo) bool {
+	p, ok := r.Context().Value(precompressKey{}).(*precompressor)
+	if !ok || injectsLiveReload(r) || !compressible(name, stat.Size()) {
+		return false
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return false
+	}
+
+	var content io.ReadSeeker
+	modTime := stat.ModTime()
+	if sibling, err := openSibling(r, src, name+".gz"); err == nil {
+		defer sibling.Close()
+		siblingStat, err := sibling.Stat()
+		if seeker, ok := sibling.(io.ReadSeeker); ok && err == nil && siblingStat.Mode().IsRegular() {
+			content = seeker
+			if siblingStat.ModTime().After(modTime) {
+				modTime = siblingStat.ModTime()
+			}
+		}
+	}
+	if content == nil && src.dir != "" {
+		if f := p.variant(src, name, stat); f != nil {
+			defer f.Close()
+			content = f
+		}
+	}
+	if content == nil {
+		return false
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	http.ServeContent(w, r, stat.Name(), configFor(r).MTime.of(modTime), content)
+	return true
+}
+
+// openSibling opens the file called name in src that's served in place of
+// another, if it would be served for a request of its own
+func openSibling(r *http.Request, src source, name string) (fs.File, error) {
+	file, err := src.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if ignoredFile(r, src, name, false) || !src.contains(name) {
+		file.Close()
+		return nil, fs.ErrNotExist
+	}
+	return file, nil
+}
+
+// writeCompressed responds with status and body, of type ctype, gzipping it
+// on the fly when --precompress is set and the client accepts gzip. It's for
+// responses such as error pages that can't be cached as they're sent with
+// another status than 200
+func writeCompressed(w http.ResponseWriter, r *http.Request, status int, ctype string, body []byte) {
+	_, ok := r.Context().Value(precompressKey{}).(*precompressor)
+	w.Header().Set("Content-Type", ctype)
+	if !ok || injectsLiveReload(r) || len(body) < precompressMinSize || !compressibleType(ctype) {
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(status)
+	zw := gzip.NewWriter(w)
+	zw.Write(body)
+	zw.Close()
+}
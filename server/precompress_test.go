@@ -0,0 +1,243 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventually fails the test if cond doesn't become true within a few
+// seconds
+func eventually(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// gunzip returns the decompressed body of w
+func gunzip(t testing.TB, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("body isn't gzipped: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("body isn't gzipped: %v", err)
+	}
+	return string(body)
+}
+
+// precompressServer serves dir with Precompress, caching in a temporary
+// directory
+func precompressServer(t *testing.T, dir string) (*Server, Config) {
+	conf := testConfig(dir)
+	conf.Precompress = true
+	conf.CacheDir = t.TempDir()
+	return newTestServer(t, conf), conf
+}
+
+func TestPrecompressLazy(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("hello ", 500) + "</body></html>"
+	dir := writeTree(t, map[string]string{"page.html": page, "small.html": "small"})
+	s, _ := precompressServer(t, dir)
+
+	// the first request is answered uncompressed while the copy is made
+	w := get(s, "GET", "/page.html", "Accept-Encoding", "gzip")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != page {
+		t.Fatalf("first response encoding %q, want the page uncompressed", w.Header().Get("Content-Encoding"))
+	}
+	eventually(t, "the compressed copy", func() bool {
+		return get(s, "GET", "/page.html", "Accept-Encoding", "gzip").Header().Get("Content-Encoding") == "gzip"
+	})
+	w = get(s, "GET", "/page.html", "Accept-Encoding", "gzip")
+	if got := gunzip(t, w); got != page {
+		t.Errorf("decompressed page = %q, want %q", got, page)
+	}
+	if vary := w.Header().Get("Vary"); !strings.Contains(vary, "Accept-Encoding") {
+		t.Errorf("Vary = %q, want Accept-Encoding", vary)
+	}
+	if w := get(s, "GET", "/page.html"); w.Header().Get("Content-Encoding") != "" {
+		t.Error("the copy was sent to a client that doesn't accept gzip")
+	}
+	if w := get(s, "GET", "/small.html", "Accept-Encoding", "gzip"); w.Header().Get("Content-Encoding") != "" {
+		t.Error("a file under 1KiB was compressed")
+	}
+}
+
+func TestPrecompressStale(t *testing.T) {
+	dir := writeTree(t, map[string]string{"app.js": strings.Repeat("a", 2000)})
+	s, _ := precompressServer(t, dir)
+	eventually(t, "the compressed copy", func() bool {
+		return get(s, "GET", "/app.js", "Accept-Encoding", "gzip").Header().Get("Content-Encoding") == "gzip"
+	})
+
+	changed := strings.Repeat("b", 3000)
+	if err := os.WriteFile(filepath.Join(dir, "app.js"), []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := get(s, "GET", "/app.js", "Accept-Encoding", "gzip")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != changed {
+		t.Fatal("the stale copy was served after the file changed")
+	}
+	eventually(t, "the new copy", func() bool {
+		w := get(s, "GET", "/app.js", "Accept-Encoding", "gzip")
+		return w.Header().Get("Content-Encoding") == "gzip" && gunzip(t, w) == changed
+	})
+}
+
+func TestPrecompressCollect(t *testing.T) {
+	dir := writeTree(t, map[string]string{"app.js": strings.Repeat("a", 2000), "keep.js": strings.Repeat("k", 2000)})
+	s, _ := precompressServer(t, dir)
+	for _, name := range []string{"/app.js", "/keep.js"} {
+		eventually(t, "the compressed copy of "+name, func() bool {
+			return get(s, "GET", name, "Accept-Encoding", "gzip").Header().Get("Content-Encoding") == "gzip"
+		})
+	}
+	src := dirSource(dir)
+	gone, kept := s.precompress.path(src, "app.js"), s.precompress.path(src, "keep.js")
+
+	if err := os.Remove(filepath.Join(dir, "app.js")); err != nil {
+		t.Fatal(err)
+	}
+	s.precompress.collect()
+	if _, err := os.Stat(gone); !os.IsNotExist(err) {
+		t.Errorf("the copy of a removed file was kept: %v", err)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("the copy of a file still served was removed: %v", err)
+	}
+}
+
+func TestPrecompressIndexAndErrorPages(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("hello ", 500) + "</body></html>"
+	pages := writeTree(t, map[string]string{"app.html": page, "404.html": page})
+	conf := testConfig(t.TempDir())
+	conf.Precompress = true
+	conf.CacheDir = t.TempDir()
+	conf.Index = filepath.Join(pages, "app.html")
+	conf.ErrorPages = ErrorPageList{{404, filepath.Join(pages, "404.html")}}
+	s := newTestServer(t, conf)
+
+	// the index page is compressed the same way as files
+	eventually(t, "the compressed index page", func() bool {
+		return get(s, "GET", "/some/route", "Accept-Encoding", "gzip").Header().Get("Content-Encoding") == "gzip"
+	})
+	w := get(s, "GET", "/some/route", "Accept-Encoding", "gzip")
+	if got := gunzip(t, w); got != page {
+		t.Errorf("index page = %q, want %q", got, page)
+	}
+	if vary := w.Header().Get("Vary"); !strings.Contains(vary, "Accept-Encoding") {
+		t.Errorf("index page Vary = %q, want Accept-Encoding", vary)
+	}
+
+	// error pages are gzipped as they're sent
+	conf.Index = ""
+	s = newTestServer(t, conf)
+	w = get(s, "GET", "/missing", "Accept", "text/html", "Accept-Encoding", "gzip")
+	if w.Code != 404 || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("error page = %d with Content-Encoding %q, want a gzipped 404", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	if got := gunzip(t, w); got != page {
+		t.Errorf("error page = %q, want %q", got, page)
+	}
+	if vary := w.Header().Get("Vary"); !strings.Contains(vary, "Accept-Encoding") {
+		t.Errorf("error page Vary = %q, want Accept-Encoding", vary)
+	}
+	w = get(s, "GET", "/missing", "Accept", "text/html")
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Error("the error page was gzipped for a client that doesn't accept gzip")
+	}
+	expect(t, w, 404, page)
+}
+
+func TestPrecompressWithLiveReload(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("hello ", 500) + "</body></html>"
+	dir := writeTree(t, map[string]string{"page.html": page})
+	conf := testConfig(dir)
+	conf.Precompress = true
+	conf.CacheDir = t.TempDir()
+	conf.LiveReload = true
+	conf.Index = filepath.Join(dir, "page.html")
+	s := newTestServer(t, conf)
+	eventually(t, "the compressed copy", func() bool {
+		return get(s, "GET", "/page.html", "Accept-Encoding", "gzip").Header().Get("Content-Encoding") == "gzip"
+	})
+
+	for _, path := range []string{"/page.html", "/missing/route"} {
+		w := get(s, "GET", path, "Accept", "text/html", "Accept-Encoding", "gzip")
+		if encoding := w.Header().Get("Content-Encoding"); encoding != "" {
+			t.Errorf("%s: Content-Encoding = %q for a page with the live reload script", path, encoding)
+		}
+		if body := w.Body.String(); !strings.Contains(body, liveScript+"</body>") {
+			t.Errorf("%s: the live reload script wasn't added before </body>", path)
+		}
+	}
+}
+
+func TestReloadInjectorSkipsEncoded(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &reloadInjector{ResponseWriter: rec}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(200)
+	w.Write([]byte("\x1f\x8b compressed"))
+	w.finish()
+	if got := rec.Body.String(); got != "\x1f\x8b compressed" {
+		t.Errorf("body = %q, the compressed page was changed", got)
+	}
+}
+
+// gzipped returns s gzipped
+func gzipped(t testing.TB, s string) string {
+	t.Helper()
+	var b strings.Builder
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestPrecompressSibling(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("hello ", 500) + "</body></html>"
+	outside := writeTree(t, map[string]string{"secret.gz": gzipped(t, "outside secret")})
+	dir := writeTree(t, map[string]string{
+		"page.html":      page,
+		"page.html.gz":   gzipped(t, "sibling"),
+		"linked.html":    page,
+		"hidden.html":    page,
+		"hidden.html.gz": gzipped(t, "ignored sibling"),
+		ignoreFile:       "hidden.html.gz\n",
+	})
+	symlink(t, filepath.Join(outside, "secret.gz"), dir, "linked.html.gz")
+	s, _ := precompressServer(t, dir)
+
+	w := get(s, "GET", "/page.html", "Accept-Encoding", "gzip")
+	if got := gunzip(t, w); got != "sibling" {
+		t.Errorf("page.html = %q, want the page.html.gz next to it", got)
+	}
+	// the others are sent uncompressed, then from the cache once it's made
+	for _, path := range []string{"/linked.html", "/hidden.html"} {
+		if w := get(s, "GET", path, "Accept-Encoding", "gzip"); w.Body.String() != page {
+			t.Errorf("%s = %q, want the page itself", path, w.Body.String())
+		}
+		eventually(t, "the compressed copy of "+path, func() bool {
+			return get(s, "GET", path, "Accept-Encoding", "gzip").Header().Get("Content-Encoding") == "gzip"
+		})
+		if got := gunzip(t, get(s, "GET", path, "Accept-Encoding", "gzip")); got != page {
+			t.Errorf("%s = %q, want the page itself", path, got)
+		}
+	}
+}
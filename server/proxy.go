@@ -0,0 +1,193 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// PrefixList is a flag.Value holding a comma separated list of CIDRs or
+// single addresses. Passed without a value it trusts loopback addresses
+type PrefixList []netip.Prefix
+
+func (l *PrefixList) String() string {
+	prefixes := make([]string, len(*l))
+	for i, prefix := range *l {
+		prefixes[i] = prefix.String()
+	}
+	return strings.Join(prefixes, ",")
+}
+
+func (l *PrefixList) Set(value string) error {
+	switch value {
+	case "true":
+		*l = PrefixList{
+			netip.MustParsePrefix("127.0.0.0/8"),
+			netip.MustParsePrefix("::1/128"),
+		}
+		return nil
+	case "false":
+		*l = nil
+		return nil
+	}
+
+	*l = nil
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return err
+			}
+			*l = append(*l, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, prefix.Masked())
+	}
+	return nil
+}
+
+// IsBoolFlag allows --trust-proxy to be given without a list
+func (l *PrefixList) IsBoolFlag() bool { return true }
+
+func (l PrefixList) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range l {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that made the request, without
+// a port
+func clientIP(r *http.Request) string {
+	if addr, ok := forwardedFor(r); ok {
+		return addr.String()
+	}
+	return hostOnly(r.RemoteAddr)
+}
+
+// remoteAddr is the client address shown in logs, keeping the port for
+// direct connections
+func remoteAddr(r *http.Request) string {
+	if addr, ok := forwardedFor(r); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+// forwardedFor derives the client address from X-Forwarded-For, or failing
+// that Forwarded. The headers are ignored entirely unless the direct peer is
+// a trusted proxy, otherwise any client could spoof its address
+//
+// The chain is walked from the right, skipping trusted proxies, so the result
+// is the first hop that a trusted proxy vouches for
+func forwardedFor(r *http.Request) (netip.Addr, bool) {
+	trusted := configFor(r).TrustedProxies
+	peer, err := netip.ParseAddr(hostOnly(r.RemoteAddr))
+	if err != nil || !trusted.contains(peer) {
+		return netip.Addr{}, false
+	}
+
+	hops := splitHeader(r.Header.Values("X-Forwarded-For"))
+	if len(hops) == 0 {
+		hops = forwardedHops(r.Header.Values("Forwarded"))
+	}
+	if len(hops) == 0 {
+		return netip.Addr{}, false
+	}
+
+	client := peer.Unmap()
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hostOnly(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !trusted.contains(client) {
+			break
+		}
+	}
+	return client, true
+}
+
+// schemeOf returns the scheme the client used to reach the server, http or
+// https. From a trusted proxy that terminates TLS it's taken from
+// X-Forwarded-Proto, or failing that the proto= of Forwarded, which like
+// forwardedFor's headers are ignored from any other peer. The last value is
+// used, the one the trusted proxy itself added
+func schemeOf(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	peer, err := netip.ParseAddr(hostOnly(r.RemoteAddr))
+	if err != nil || !configFor(r).TrustedProxies.contains(peer) {
+		return scheme
+	}
+	protos := splitHeader(r.Header.Values("X-Forwarded-Proto"))
+	if len(protos) == 0 {
+		protos = forwardedParams(r.Header.Values("Forwarded"), "proto")
+	}
+	if len(protos) == 0 {
+		return scheme
+	}
+	switch proto := strings.ToLower(protos[len(protos)-1]); proto {
+	case "http", "https":
+		return proto
+	}
+	return scheme
+}
+
+// forwardedHops extracts the for= parameters of RFC 7239 Forwarded headers
+func forwardedHops(values []string) []string {
+	return forwardedParams(values, "for")
+}
+
+// forwardedParams extracts the parameters called name of RFC 7239 Forwarded
+// headers, in order
+func forwardedParams(values []string, name string) []string {
+	params := []string{}
+	for _, element := range splitHeader(values) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if found && strings.EqualFold(key, name) {
+				params = append(params, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return params
+}
+
+// splitHeader splits comma separated header values into their elements
+func splitHeader(values []string) []string {
+	fields := []string{}
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// hostOnly strips the port, brackets and zone from an address such as
+// 192.0.2.1:80, [2001:db8::1]:80 or fe80::1%eth0
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+	return addr
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForwardedFor(t *testing.T) {
+	var trusted PrefixList
+	if err := trusted.Set("10.0.0.0/8, 2001:db8::/32"); err != nil {
+		t.Fatal(err)
+	}
+	conf := testConfig()
+	conf.TrustedProxies = trusted
+
+	tests := []struct {
+		name      string
+		peer      string
+		headers   []string
+		client    string
+		forwarded bool
+	}{
+		{"direct", "192.0.2.1:4000", nil, "192.0.2.1", false},
+		{"one proxy", "10.0.0.1:4000", []string{"X-Forwarded-For", "192.0.2.1"}, "192.0.2.1", true},
+		{"chained proxies", "10.0.0.1:4000", []string{"X-Forwarded-For", "192.0.2.1, 10.1.1.1, 10.2.2.2"}, "192.0.2.1", true},
+		// the client can put anything on the left, only the hop the trusted
+		// proxies vouch for counts
+		{"spoofed by the client", "10.0.0.1:4000", []string{"X-Forwarded-For", "127.0.0.1, 198.51.100.9"}, "198.51.100.9", true},
+		{"untrusted peer", "192.0.2.1:4000", []string{"X-Forwarded-For", "127.0.0.1"}, "192.0.2.1", false},
+		{"untrusted peer Forwarded", "192.0.2.1:4000", []string{"Forwarded", "for=127.0.0.1"}, "192.0.2.1", false},
+		{"garbage hop", "10.0.0.1:4000", []string{"X-Forwarded-For", "unknown"}, "10.0.0.1", true},
+		{"only trusted hops", "10.0.0.1:4000", []string{"X-Forwarded-For", "10.9.9.9"}, "10.9.9.9", true},
+		{"IPv6 peer", "[2001:db8::1]:4000", []string{"X-Forwarded-For", "2001:db8:ffff::2, 2001:db9::7"}, "2001:db9::7", true},
+		{"IPv6 client with zone", "10.0.0.1:4000", []string{"X-Forwarded-For", "fe80::1%eth0"}, "fe80::1", true},
+		{"IPv4-mapped peer", "[::ffff:10.0.0.1]:4000", []string{"X-Forwarded-For", "192.0.2.1"}, "192.0.2.1", true},
+		{"Forwarded", "10.0.0.1:4000", []string{"Forwarded", `for=192.0.2.60;proto=http, for="[2001:db9::9]:4711"`}, "2001:db9::9", true},
+		{"X-Forwarded-For first", "10.0.0.1:4000", []string{"X-Forwarded-For", "192.0.2.1", "Forwarded", "for=192.0.2.2"}, "192.0.2.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.peer
+			for i := 0; i+1 < len(tt.headers); i += 2 {
+				r.Header.Set(tt.headers[i], tt.headers[i+1])
+			}
+			r = r.WithContext(context.WithValue(r.Context(), configKey{}, &conf))
+			addr, forwarded := forwardedFor(r)
+			if forwarded != tt.forwarded || forwarded && addr.String() != tt.client {
+				t.Errorf("forwardedFor = %v, %v, want %s, %v", addr, forwarded, tt.client, tt.forwarded)
+			}
+			if client := clientIP(r); client != tt.client {
+				t.Errorf("clientIP = %s, want %s", client, tt.client)
+			}
+		})
+	}
+}
+
+func TestSchemeOf(t *testing.T) {
+	var trusted PrefixList
+	if err := trusted.Set("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	conf := testConfig()
+	conf.TrustedProxies = trusted
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers []string
+		scheme  string
+	}{
+		{"direct", "192.0.2.1:4000", nil, "http"},
+		{"trusted proxy", "10.0.0.1:4000", []string{"X-Forwarded-Proto", "https"}, "https"},
+		{"trusted proxy upper case", "10.0.0.1:4000", []string{"X-Forwarded-Proto", "HTTPS"}, "https"},
+		{"trusted proxy Forwarded", "10.0.0.1:4000", []string{"Forwarded", "for=192.0.2.1;proto=https"}, "https"},
+		{"X-Forwarded-Proto first", "10.0.0.1:4000", []string{"X-Forwarded-Proto", "http", "Forwarded", "proto=https"}, "http"},
+		// the proxy's own value is the last, a client's comes before it
+		{"chained", "10.0.0.1:4000", []string{"X-Forwarded-Proto", "http, https"}, "https"},
+		{"untrusted peer", "192.0.2.1:4000", []string{"X-Forwarded-Proto", "https"}, "http"},
+		{"untrusted peer Forwarded", "192.0.2.1:4000", []string{"Forwarded", "proto=https"}, "http"},
+		{"unknown scheme", "10.0.0.1:4000", []string{"X-Forwarded-Proto", "gopher"}, "http"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.peer
+			for i := 0; i+1 < len(tt.headers); i += 2 {
+				r.Header.Set(tt.headers[i], tt.headers[i+1])
+			}
+			r = r.WithContext(context.WithValue(r.Context(), configKey{}, &conf))
+			if scheme := schemeOf(r); scheme != tt.scheme {
+				t.Errorf("schemeOf = %s, want %s", scheme, tt.scheme)
+			}
+			env := strings.Join(cgiEnv(r, "script", "/script", ""), "\n")
+			if https := strings.Contains(env, "HTTPS=on"); https != (tt.scheme == "https") {
+				t.Errorf("CGI environment has HTTPS=on %v, want it for %s", https, tt.scheme)
+			}
+		})
+	}
+
+	// a request made over TLS is https whatever the headers say
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-Proto", "ftp")
+	r = r.WithContext(context.WithValue(r.Context(), configKey{}, &conf))
+	if scheme := schemeOf(r); scheme != "https" {
+		t.Errorf("schemeOf a TLS request = %s, want https", scheme)
+	}
+}
+
+func TestPrefixListSet(t *testing.T) {
+	var l PrefixList
+	if err := l.Set("true"); err != nil || l.String() != "127.0.0.0/8,::1/128" {
+		t.Errorf("--trust-proxy without a list = %v, %v, want loopback", l.String(), err)
+	}
+	if err := l.Set("192.0.2.77/24,2001:db8::1"); err != nil || l.String() != "192.0.2.0/24,2001:db8::1/128" {
+		t.Errorf("Set = %v, %v, want masked prefixes", l.String(), err)
+	}
+	if err := l.Set("false"); err != nil || len(l) != 0 {
+		t.Errorf("--trust-proxy=false = %v, %v, want none", l.String(), err)
+	}
+	for _, value := range []string{"not an address", "10.0.0.0/33", "10.0.0.1,"} {
+		if err := l.Set(value); err == nil {
+			t.Errorf("--trust-proxy %q was accepted", value)
+		}
+	}
+}
+
+func TestTrustProxyRecorded(t *testing.T) {
+	conf := testConfig(writeTree(t, map[string]string{"a.txt": "a"}))
+	conf.TrustedProxies.Set("true")
+	s := newTestServer(t, conf)
+
+	for _, peer := range []string{"127.0.0.1:5000", "192.0.2.1:5000"} {
+		r := httptest.NewRequest("GET", "/a.txt", nil)
+		r.RemoteAddr = peer
+		r.Header.Set("X-Forwarded-For", "203.0.113.5")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	records := recent(t, s, "")
+	if len(records) != 2 || records[1].Remote != "203.0.113.5" || records[0].Remote != "192.0.2.1:5000" {
+		t.Errorf("recorded %+v, want the forwarded client from the proxy only", records)
+	}
+}
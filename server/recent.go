@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxRecordLength bounds the length of the path and user agent kept for each
+// recent request
+const maxRecordLength = 256
+
+var recentTmpl = template.Must(template.New("recent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="UTF-8">
+	<title>Recent requests</title>
+	<style>
+		body {
+			font-size: 14px;
+			font-family: consolas, "Liberation Mono", "DejaVu Sans Mono", Menlo, monospace;
+		}
+		td, th {
+			padding: 2px 12px 2px 0;
+			text-align: left;
+			white-space: nowrap;
+		}
+	</style>
+</head>
+<body>
+	<table>
+		<tr><th>Time</th><th>Remote</th><th>Method</th><th>Path</th><th>Status</th><th>Bytes</th><th>Duration</th><th>User agent</th></tr>
+	{{range .}}
+		<tr>
+			<td>{{.Time.Format "15:04:05.000"}}</td><td>{{.Remote}}</td><td>{{.Method}}</td><td>{{.Path}}</td>
+			<td>{{.Status}}</td><td>{{.Bytes}}</td><td>{{.Duration}}</td><td>{{.UserAgent}}</td>
+		</tr>
+	{{end}}
+	</table>
+</body>
+`))
+
+// RequestRecord is a completed request kept in the recent requests buffer
+type RequestRecord struct {
+	Time      time.Time     `json:"time"`
+	Remote    string        `json:"remote"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"duration"`
+	UserAgent string        `json:"user_agent"`
+}
+
+// requestRing is a fixed size ring buffer of the most recent requests
+type requestRing struct {
+	mu      sync.Mutex
+	records []RequestRecord
+	next    int
+	full    bool
+}
+
+func newRequestRing(size int) *requestRing {
+	return &requestRing{records: make([]RequestRecord, size)}
+}
+
+func (ring *requestRing) record(r *http.Request, rec *responseRecorder) {
+	record := RequestRecord{
+		Time:      rec.start,
+		Remote:    remoteAddr(r),
+		Method:    r.Method,
+		Path:      truncate(r.URL.Path, maxRecordLength),
+		Status:    rec.Status(),
+		Bytes:     rec.written,
+		Duration:  time.Since(rec.start),
+		UserAgent: truncate(r.UserAgent(), maxRecordLength),
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	ring.records[ring.next] = record
+	ring.next = (ring.next + 1) % len(ring.records)
+	if ring.next == 0 {
+		ring.full = true
+	}
+}
+
+// list returns the records, newest first, that match a status filter such
+// as 404 or 4xx
+func (ring *requestRing) list(status string) []RequestRecord {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	count := ring.next
+	if ring.full {
+		count = len(ring.records)
+	}
+	list := []RequestRecord{}
+	for i := 1; i <= count; i++ {
+		record := ring.records[(ring.next-i+len(ring.records))%len(ring.records)]
+		if matchStatus(status, record.Status) {
+			list = append(list, record)
+		}
+	}
+	return list
+}
+
+// matchStatus reports whether status matches filter, which may be empty,
+// an exact code or a class such as 5xx
+func matchStatus(filter string, status int) bool {
+	if filter == "" {
+		return true
+	}
+	code := strconv.Itoa(status)
+	if len(filter) != len(code) {
+		return false
+	}
+	for i := range filter {
+		if filter[i] != 'x' && filter[i] != 'X' && filter[i] != code[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP lists the recent requests at /_requests as HTML or, if requested
+// by the Accept header, JSON. ?status= filters them
+func (ring *requestRing) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	list := ring.list(r.URL.Query().Get("status"))
+	w.Header().Set("Cache-Control", "no-store")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	recentTmpl.Execute(w, list)
+}
+
+// truncate shortens s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+// basicAuth is the Authorization header value for user and pass
+func basicAuth(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+// recent decodes the JSON list at /_requests, with headers given as name,
+// value pairs
+func recent(t *testing.T, s *Server, query string, headers ...string) []RequestRecord {
+	t.Helper()
+	w := get(s, "GET", "/_requests"+query, append(headers, "Accept", "application/json")...)
+	if w.Code != 200 {
+		t.Fatalf("GET /_requests%s = %d, want 200", query, w.Code)
+	}
+	var list []RequestRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+// paths returns the path of each record
+func paths(list []RequestRecord) []string {
+	var paths []string
+	for _, record := range list {
+		paths = append(paths, record.Path)
+	}
+	return paths
+}
+
+func TestRecentRequestsEvicts(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a"})
+	conf := testConfig(dir)
+	conf.RecentRequests = 3
+	s := newTestServer(t, conf)
+
+	for _, path := range []string{"/a.txt", "/1", "/2", "/a.txt", "/3"} {
+		get(s, "GET", path)
+	}
+	list := recent(t, s, "")
+	if got := paths(list); len(got) != 3 || got[0] != "/3" || got[1] != "/a.txt" || got[2] != "/2" {
+		t.Fatalf("recent requests = %v, want the last 3 newest first", got)
+	}
+	if list[0].Status != 404 || list[1].Status != 200 || list[1].Bytes != 1 {
+		t.Errorf("records = %+v, want the status and size of each", list)
+	}
+	// listing the requests isn't itself recorded
+	if got := paths(recent(t, s, "")); got[0] != "/3" {
+		t.Errorf("newest request = %s after reading /_requests, want /3", got[0])
+	}
+}
+
+func TestRecentRequestsFilter(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a"})
+	s := newTestServer(t, testConfig(dir))
+	for _, path := range []string{"/a.txt", "/missing", "/a.txt"} {
+		get(s, "GET", path)
+	}
+
+	tests := []struct {
+		status string
+		want   int
+	}{
+		{"", 3},
+		{"200", 2},
+		{"4xx", 1},
+		{"4XX", 1},
+		{"5xx", 0},
+		{"40", 0},
+	}
+	for _, tt := range tests {
+		if got := recent(t, s, "?status="+tt.status); len(got) != tt.want {
+			t.Errorf("?status=%s listed %d requests, want %d", tt.status, len(got), tt.want)
+		}
+	}
+	if w := get(s, "GET", "/_requests"); w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want the HTML table by default", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestRecentRequestsDisabled(t *testing.T) {
+	conf := testConfig(t.TempDir())
+	conf.RecentRequests = 0
+	s := newTestServer(t, conf)
+	expect(t, get(s, "GET", "/_requests"), 404, "")
+}
+
+func TestReportsNeedMountAuth(t *testing.T) {
+	dir := writeTree(t, map[string]string{"public.txt": "public"})
+	private := writeTree(t, map[string]string{"secret.txt": "secret"})
+	conf := testConfig(dir)
+	conf.Track404s = true
+	conf.TrackHits = true
+	conf.Mounts = MountList{
+		{Prefix: "/open", Dir: dir},
+		{Prefix: "/private", Dir: private, Auth: "user:pass"},
+	}
+	s := newTestServer(t, conf)
+	get(s, "GET", "/private/secret.txt", "Authorization", basicAuth("user", "pass"))
+	get(s, "GET", "/private/missing.txt", "Authorization", basicAuth("user", "pass"))
+
+	for _, path := range []string{"/_requests", "/_hits", "/_404s"} {
+		t.Run(path, func(t *testing.T) {
+			w := get(s, "GET", path)
+			expect(t, w, 401, "")
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate challenge")
+			}
+			expect(t, get(s, "GET", path, "Authorization", basicAuth("user", "wrong")), 401, "")
+			expect(t, get(s, "GET", path, "Authorization", basicAuth("user", "pass")), 200, "/private/")
+		})
+	}
+}
+
+func TestReportsOpenWithoutMountAuth(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a"})
+	conf := testConfig(dir)
+	conf.Mounts = MountList{{Prefix: "/open", Dir: dir}}
+	s := newTestServer(t, conf)
+	get(s, "GET", "/open/a.txt")
+	expect(t, get(s, "GET", "/_requests"), 200, "/open/a.txt")
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"abcdef", 3, "abc"},
+		{"aé", 2, "a"},
+		{"é", 1, ""},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// trailingStatus matches the :STATUS that may end a --redirect rule
+var trailingStatus = regexp.MustCompile(`:(3\d\d)$`)
+
+// Redirect sends the paths matching From to To with Status. A * in From
+// matches anything, and a * in To is what it matched
+type Redirect struct {
+	From, To string
+	Status   int
+	pattern  *regexp.Regexp
+}
+
+// parseRedirect reads a rule as FROM=TO, optionally followed by :STATUS
+func parseRedirect(value string) (Redirect, error) {
+	from, to, found := strings.Cut(value, "=")
+	if !found || !strings.HasPrefix(from, "/") || to == "" {
+		return Redirect{}, fmt.Errorf("expected /path=destination, such as '/old/*=/new/*:301'")
+	}
+	rule := Redirect{From: from, To: to, Status: http.StatusFound}
+	if match := trailingStatus.FindStringSubmatch(to); match != nil {
+		rule.Status, _ = strconv.Atoi(match[1])
+		rule.To = strings.TrimSuffix(to, match[0])
+	}
+	switch rule.Status {
+	case 301, 302, 303, 307, 308:
+	default:
+		return Redirect{}, fmt.Errorf("status %d must be 301, 302, 303, 307 or 308", rule.Status)
+	}
+	rule.pattern = regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(from), `\*`, "(.*)") + "$")
+	return rule, nil
+}
+
+func (rule Redirect) String() string {
+	return rule.From + "=" + rule.To + ":" + strconv.Itoa(rule.Status)
+}
+
+// RedirectList is a flag.Value for --redirect, which may be given more than
+// once. The first rule that matches a path applies:
+//
+//	--redirect '/old-docs/*=https://docs.example.com/*:301'
+type RedirectList []Redirect
+
+func (l *RedirectList) String() string {
+	rules := make([]string, len(*l))
+	for i, rule := range *l {
+		rules[i] = rule.String()
+	}
+	return strings.Join(rules, " ")
+}
+
+func (l *RedirectList) Set(value string) error {
+	rule, err := parseRedirect(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, rule)
+	return nil
+}
+
+// readRedirectFile reads the rules in a --redirect-file, one a line as for
+// --redirect, skipping blank lines and those starting with #
+func readRedirectFile(name string) (RedirectList, error) {
+	if name == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var rules RedirectList
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := rules.Set(text); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// redirect answers a request whose path matches one of the rules with a
+// redirect to its destination, with the * filled in. The query is passed on
+// unless the destination has its own
+func (l RedirectList) redirect(w http.ResponseWriter, r *http.Request) bool {
+	for _, rule := range l {
+		match := rule.pattern.FindStringSubmatch(r.URL.Path)
+		if match == nil {
+			continue
+		}
+		parts := strings.Split(rule.To, "*")
+		to := parts[0]
+		for i, part := range parts[1:] {
+			if i+1 < len(match) {
+				splat := url.URL{Path: match[i+1]}
+				to += splat.EscapedPath()
+			}
+			to += part
+		}
+		if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+			to += "?" + r.URL.RawQuery
+		}
+		if conf := configFor(r); conf.Verbose {
+			conf.logger().Printf("%s ↪ %s → %s (%d)", remoteAddr(r), r.URL.Path, to, rule.Status)
+		}
+		http.Redirect(w, r, to, rule.Status)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	dir := writeTree(t, map[string]string{"old.txt": "still here", "new/a.txt": "a"})
+	file := filepath.Join(t.TempDir(), "redirects")
+	rules := "# from the file, after --redirect\n\n/old.txt=/first-wins\n  /from-file/*=/filed/*:307  \n"
+	if err := os.WriteFile(file, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf := testConfig(dir)
+	for _, rule := range []string{
+		"/old-docs/*=https://docs.example.com/*:301",
+		"/old.txt=/new/a.txt",
+		"/moved=/new/a.txt:308",
+		"/search=/find?source=old",
+		"/files/*/v/*=/archive/*/*",
+		"/rewritten/*=/nowhere",
+	} {
+		if err := conf.Redirects.Set(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf.RedirectFile = file
+	// redirects come before rewrites
+	conf.Rewrites.Set(`^/rewritten/=/new/`)
+	s := newTestServer(t, conf)
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/old-docs/guide/intro.html", 301, "https://docs.example.com/guide/intro.html"},
+		{"/old-docs/", 301, "https://docs.example.com/"},
+		{"/old-docs/a%20b?x=1", 301, "https://docs.example.com/a%20b?x=1"},
+		// the rule applies even though the file exists
+		{"/old.txt", 302, "/new/a.txt"},
+		{"/moved?page=2", 308, "/new/a.txt?page=2"},
+		{"/search?q=go", 302, "/find?source=old"},
+		{"/files/2024/v/3", 302, "/archive/2024/3"},
+		{"/from-file/x", 307, "/filed/x"},
+		{"/rewritten/a.txt", 302, "/nowhere"},
+		{"/new/a.txt", 200, ""},
+		{"/old-docs", 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := get(s, "GET", tt.path)
+			expect(t, w, tt.status, "")
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestRedirectLogged(t *testing.T) {
+	var logs bytes.Buffer
+	conf := testConfig(t.TempDir())
+	conf.Logger = log.New(&logs, "", 0)
+	conf.Verbose = true
+	conf.Redirects.Set("/a=/b:301")
+	s := newTestServer(t, conf)
+	get(s, "GET", "/a")
+	if !strings.Contains(logs.String(), "↪ /a → /b (301)") {
+		t.Errorf("the redirect wasn't logged:\n%s", logs.String())
+	}
+}
+
+func TestRedirectListSet(t *testing.T) {
+	const expected = "expected /path=destination, such as '/old/*=/new/*:301'"
+	tests := []struct {
+		value string
+		want  string
+		err   string
+	}{
+		{"/a=/b", "/a=/b:302", ""},
+		{"/a/*=https://example.com/*:301", "/a/*=https://example.com/*:301", ""},
+		{"/a=b:303", "/a=b:303", ""},
+		// only a redirect status is taken off the end
+		{"/a=/b:8080", "/a=/b:8080:302", ""},
+		{"/a=/b:304", "", "status 304 must be 301, 302, 303, 307 or 308"},
+		{"/a=/b:300", "", "status 300 must be 301, 302, 303, 307 or 308"},
+		{"a=/b", "", expected},
+		{"/a", "", expected},
+		{"/a=", "", expected},
+	}
+	for _, tt := range tests {
+		var l RedirectList
+		err := l.Set(tt.value)
+		switch {
+		case err != nil && err.Error() != tt.err:
+			t.Errorf("Set(%q) = %q, want %q", tt.value, err, tt.err)
+		case err == nil && tt.err != "":
+			t.Errorf("Set(%q) = nil, want %q", tt.value, tt.err)
+		case err == nil && l.String() != tt.want:
+			t.Errorf("Set(%q) gave %q, want %q", tt.value, l.String(), tt.want)
+		}
+	}
+}
+
+func TestRedirectFileChecked(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "redirects")
+	if err := os.WriteFile(file, []byte("/a=/b\n# fine\n/c=/d:304\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf := testConfig(t.TempDir())
+	conf.RedirectFile = file
+	_, err := New(conf)
+	if want := "--redirect-file: " + file + ":3: status 304 must be"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("New = %v, want %q", err, want)
+	}
+	conf.RedirectFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := New(conf); err == nil || !strings.Contains(err.Error(), "--redirect-file") {
+		t.Errorf("New with a missing --redirect-file = %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redirectRule is a line of a redirects file
+type redirectRule struct {
+	pattern *regexp.Regexp
+	to      string
+	status  int
+	// force applies the rule even where a file exists
+	force bool
+}
+
+// parseRedirects reads a redirects file as Netlify does: each line is a
+// path pattern, where it goes and optionally the status, 301 if it's left
+// out, and blank lines and those starting with # are skipped. A status of
+// 200 serves the destination in place of the path rather than redirecting,
+// and a ! after the status applies the rule even to paths that have a file.
+// name is used in errors, which give the line
+func parseRedirects(name string, data []byte) ([]redirectRule, error) {
+	var rules []redirectRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected FROM TO [STATUS], got %q", name, line, text)
+		}
+		if !strings.HasPrefix(fields[0], "/") {
+			return nil, fmt.Errorf("%s:%d: %q must be a path starting with /", name, line, fields[0])
+		}
+		rule := redirectRule{pattern: pathPattern(fields[0]), to: fields[1], status: http.StatusMovedPermanently}
+		if len(fields) == 3 {
+			status, force := strings.CutSuffix(fields[2], "!")
+			code, err := strconv.Atoi(status)
+			if err != nil || code != http.StatusOK && (code < 301 || code > 308 || code == 304 || code == 305 || code == 306) {
+				return nil, fmt.Errorf("%s:%d: status %q must be 200 or a redirect such as 301 or 302, optionally followed by !", name, line, fields[2])
+			}
+			rule.status, rule.force = code, force
+		}
+		target, err := url.Parse(rule.to)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("%s:%d: %q is not a URL: %s", name, line, rule.to, err)
+		case rule.status == http.StatusOK && (target.IsAbs() || !strings.HasPrefix(rule.to, "/")):
+			return nil, fmt.Errorf("%s:%d: a 200 rewrite needs a path starting with /, not %q", name, line, rule.to)
+		case !target.IsAbs() && !strings.HasPrefix(rule.to, "/"):
+			return nil, fmt.Errorf("%s:%d: %q must be a path starting with / or an absolute URL", name, line, rule.to)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// destination returns where the rule sends a path its pattern matched as
+// match, with :splat and the :placeholders filled in and the request's query
+// added to that of the destination
+func (rule redirectRule) destination(match []string, query string) string {
+	values := map[string]string{}
+	for i, name := range rule.pattern.SubexpNames() {
+		if name != "" {
+			values[name] = match[i]
+		}
+	}
+	to := rule.to
+	var filled strings.Builder
+	for i := 0; i < len(to); i++ {
+		name := placeholder.FindString(to[i:])
+		if value, ok := values[strings.TrimPrefix(name, ":")]; ok && name != "" {
+			filled.WriteString(value)
+			i += len(name) - 1
+			continue
+		}
+		filled.WriteByte(to[i])
+	}
+	to = filled.String()
+	if query == "" {
+		return to
+	}
+	if strings.Contains(to, "?") {
+		return to + "&" + query
+	}
+	return to + "?" + query
+}
+
+// cachedRedirects is the rules of a redirects file when it had a size and
+// modification time
+type cachedRedirects struct {
+	size    int64
+	modTime time.Time
+	rules   []redirectRule
+}
+
+// redirectsCache holds the parsed redirects files, which are read again when
+// their size or modification time changes. A file that no longer parses
+// keeps its last good rules, with the error logged once
+type redirectsCache struct {
+	mu    sync.Mutex
+	files map[string]cachedRedirects
+}
+
+func newRedirectsCache() *redirectsCache {
+	return &redirectsCache{files: map[string]cachedRedirects{}}
+}
+
+// rules returns the rules of the redirects file called name in fsys, key
+// identifying it in the cache
+func (c *redirectsCache) rules(fsys fs.FS, name, key string, logger *log.Logger) []redirectRule {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	cached, ok := c.files[key]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.rules
+	}
+
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return cached.rules
+	}
+	rules, err := parseRedirects(key, data)
+	if err != nil {
+		logger.Printf("%s, keeping the previous redirects", err)
+		rules = cached.rules
+	}
+	c.mu.Lock()
+	c.files[key] = cachedRedirects{size: info.Size(), modTime: info.ModTime(), rules: rules}
+	c.mu.Unlock()
+	return rules
+}
+
+// try applies the first rule matching the request path, from the _redirects
+// at the root of each of the Dirs and FS in turn and then RedirectsFile.
+// Unless forced, a rule only applies to paths that no source has a file or
+// directory at. A redirect is answered and handled is set, a 200 rewrite
+// returns the request for the destination to be served in its place
+func (c *redirectsCache) try(w http.ResponseWriter, r *http.Request) (rewritten *http.Request, handled bool) {
+	conf := configFor(r)
+	sources := conf.sources()
+	var rules []redirectRule
+	for _, src := range sources {
+		rules = append(rules, c.rules(src.fsys, redirectsName, src.path(redirectsName), conf.logger())...)
+	}
+	if conf.RedirectsFile != "" {
+		dir, file := filepath.Split(conf.RedirectsFile)
+		rules = append(rules, c.rules(os.DirFS(filepath.Clean(dir)), file, conf.RedirectsFile, conf.logger())...)
+	}
+	if len(rules) == 0 {
+		return r, false
+	}
+
+	exists := sync.OnceValue(func() bool {
+		for _, src := range sources {
+			if _, err := fs.Stat(src.fsys, fsName(r.URL.Path)); err == nil {
+				return true
+			}
+		}
+		return false
+	})
+	for _, rule := range rules {
+		match := rule.pattern.FindStringSubmatch(r.URL.Path)
+		if match == nil || !rule.force && exists() {
+			continue
+		}
+		to := rule.destination(match, r.URL.RawQuery)
+		if conf.Verbose {
+			conf.logger().Printf("%s ↪ %s → %s (%d)", remoteAddr(r), r.URL.Path, to, rule.status)
+		}
+		if rule.status != http.StatusOK {
+			http.Redirect(w, r, to, rule.status)
+			return r, true
+		}
+		target, err := url.Parse(to)
+		if err != nil || !validPath(target.Path) || conf.BlockDotfiles && dotPath(target.Path) {
+			respondError(w, r, http.StatusForbidden, "invalid rewrite")
+			return r, true
+		}
+		rewritten = r.Clone(r.Context())
+		rewritten.URL.Path, rewritten.URL.RawPath, rewritten.URL.RawQuery = target.Path, "", target.RawQuery
+		return rewritten, false
+	}
+	return r, false
+}
+
+// checkRedirectsFiles parses the redirects files there are at startup, as
+// checkHeadersFiles does the headers files
+func (c *Config) checkRedirectsFiles() error {
+	files := []string{}
+	for _, dir := range c.Dirs {
+		files = append(files, filepath.Join(dir, redirectsName))
+	}
+	if c.RedirectsFile != "" {
+		files = append(files, c.RedirectsFile)
+	}
+	var errs []error
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if _, err := parseRedirects(file, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
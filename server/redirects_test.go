@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedirectsFile(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		redirectsName: `# moved pages
+/old              /new
+/temp             /elsewhere 302
+/blog/:year/:slug /posts/:year-:slug
+/docs/*           /manual/:splat 308
+/search           /find?source=old
+/external         https://example.com/x
+/app/*            /app/index.html 200
+/exists.txt       /new
+/forced.txt       /new 301!
+/first            /one
+/first            /two
+/secret           /.env 200
+/escape           /../a 200
+`,
+		"app/index.html": "the app",
+		"app/real.js":    "real",
+		"exists.txt":     "exists",
+		"forced.txt":     "forced",
+		".env":           "SECRET",
+	})
+	other := filepath.Join(t.TempDir(), "redirects")
+	if err := os.WriteFile(other, []byte("/old /not-first\n/more /from-the-file 302\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf := testConfig(dir)
+	conf.RedirectsFile = other
+	conf.BlockDotfiles = true
+	s := newTestServer(t, conf)
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{"/old", 301, "/new", ""},
+		{"/temp", 302, "/elsewhere", ""},
+		{"/blog/2024/hello", 301, "/posts/2024-hello", ""},
+		{"/docs/a/b.html", 308, "/manual/a/b.html", ""},
+		{"/docs/a?page=2", 308, "/manual/a?page=2", ""},
+		{"/search?q=go", 301, "/find?source=old&q=go", ""},
+		{"/external", 301, "https://example.com/x", ""},
+		// a 200 serves the destination in place of the path
+		{"/app/settings/profile", 200, "", "the app"},
+		{"/app/real.js", 200, "", "real"},
+		// rules don't shadow files unless forced
+		{"/exists.txt", 200, "", "exists"},
+		{"/forced.txt", 301, "/new", ""},
+		{"/first", 301, "/one", ""},
+		{"/more", 302, "/from-the-file", ""},
+		{"/secret", 403, "", "invalid rewrite"},
+		{"/escape", 403, "", "invalid rewrite"},
+		{"/unmatched", 404, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := get(s, "GET", tt.path)
+			expect(t, w, tt.status, tt.body)
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestRedirectsFileReloaded(t *testing.T) {
+	dir := writeTree(t, map[string]string{redirectsName: "/old /v1\n"})
+	var logs bytes.Buffer
+	conf := testConfig(dir)
+	conf.Logger = log.New(&logs, "", 0)
+	s := newTestServer(t, conf)
+	location := func() string { return get(s, "GET", "/old").Header().Get("Location") }
+	if l := location(); l != "/v1" {
+		t.Fatalf("Location = %q, want /v1", l)
+	}
+
+	write := func(contents string, age time.Duration) {
+		file := filepath.Join(dir, redirectsName)
+		if err := os.WriteFile(file, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(age)
+		os.Chtimes(file, modTime, modTime)
+	}
+	write("/old /v2\n", time.Hour)
+	if l := location(); l != "/v2" {
+		t.Errorf("Location = %q after an edit, want /v2", l)
+	}
+	// a mistake keeps the rules that worked
+	write("/old\n", 2*time.Hour)
+	if l := location(); l != "/v2" {
+		t.Errorf("Location = %q after a bad edit, want /v2 still", l)
+	}
+	if !strings.Contains(logs.String(), "keeping the previous redirects") {
+		t.Errorf("the bad edit wasn't logged:\n%s", logs.String())
+	}
+}
+
+func TestRedirectsFileChecked(t *testing.T) {
+	dir := writeTree(t, map[string]string{redirectsName: "/a /b\n/c /d 404\n"})
+	_, err := New(testConfig(dir))
+	if err == nil || !strings.Contains(err.Error(), redirectsName+`:2: status "404" must be 200 or a redirect`) {
+		t.Errorf("New = %v, want the bad status reported with its line", err)
+	}
+}
+
+func TestParseRedirects(t *testing.T) {
+	tests := []struct {
+		data, err string
+	}{
+		{"# comment\n\n/a /b\n/c /d 302\n/e /f 200!\n/g https://example.com 307\n", ""},
+		{"/a\n", `f:1: expected FROM TO [STATUS], got "/a"`},
+		{"/a /b 301 extra\n", `f:1: expected FROM TO [STATUS], got "/a /b 301 extra"`},
+		{"a /b\n", `f:1: "a" must be a path starting with /`},
+		{"/a /b 304\n", `f:1: status "304" must be 200 or a redirect such as 301 or 302, optionally followed by !`},
+		{"/a /b moved\n", `f:1: status "moved" must be 200 or a redirect such as 301 or 302, optionally followed by !`},
+		{"/a https://example.com 200\n", `f:1: a 200 rewrite needs a path starting with /, not "https://example.com"`},
+		{"/a b\n", `f:1: "b" must be a path starting with / or an absolute URL`},
+	}
+	for _, tt := range tests {
+		_, err := parseRedirects("f", []byte(tt.data))
+		switch {
+		case err != nil && err.Error() != tt.err:
+			t.Errorf("parseRedirects(%q) = %q, want %q", tt.data, err, tt.err)
+		case err == nil && tt.err != "":
+			t.Errorf("parseRedirects(%q) = nil, want %q", tt.data, tt.err)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package server
+
+import "syscall"
+
+const reusePortSupported = true
+
+// reusePort sets SO_REUSEPORT on a listening socket, letting several
+// processes listen on the same port with the kernel spreading connections
+// between them
+func reusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,15 @@
+package server
+
+import (
+	"runtime"
+	"strings"
+)
+
+// soReusePort is SO_REUSEPORT, which the syscall package doesn't define for
+// every architecture. MIPS numbers socket options differently
+var soReusePort = func() int {
+	if strings.HasPrefix(runtime.GOARCH, "mips") {
+		return 0x200
+	}
+	return 0xf
+}()
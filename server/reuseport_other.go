@@ -0,0 +1,9 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package server
+
+import "syscall"
+
+const reusePortSupported = false
+
+var reusePort func(network, address string, c syscall.RawConn) error
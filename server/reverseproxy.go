@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Proxy forwards the requests under a URL prefix to another server, such as
+// the API backend of an app being developed
+type Proxy struct {
+	Prefix string
+	Target *url.URL
+	// Strip removes Prefix from the path before forwarding it
+	Strip bool
+}
+
+// ProxyList is a flag.Value for --proxy, which may be given more than once.
+// /prefix=URL forwards the path as it is, /prefix=>URL without the prefix:
+//
+//	--proxy /api=http://localhost:3000 --proxy /ws=>http://localhost:4000
+type ProxyList []Proxy
+
+func (l *ProxyList) String() string {
+	proxies := make([]string, len(*l))
+	for i, p := range *l {
+		proxies[i] = p.String()
+	}
+	return strings.Join(proxies, " ")
+}
+
+func (l *ProxyList) Set(value string) error {
+	prefix, target, found := strings.Cut(value, "=")
+	if !found || !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("expected /prefix=URL or /prefix=>URL")
+	}
+	p := Proxy{Prefix: strings.TrimSuffix(path.Clean(prefix), "/")}
+	if p.Prefix == "" {
+		return fmt.Errorf("proxying / would hide every file")
+	}
+	if rest, ok := strings.CutPrefix(target, ">"); ok {
+		p.Strip, target = true, rest
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an http:// or https:// URL", target)
+	}
+	p.Target = u
+	*l = append(*l, p)
+	return nil
+}
+
+func (p Proxy) String() string {
+	if p.Strip {
+		return p.Prefix + "=>" + p.Target.String()
+	}
+	return p.Prefix + "=" + p.Target.String()
+}
+
+// match returns the proxy with the longest prefix containing urlPath
+func (l ProxyList) match(urlPath string) *Proxy {
+	var best *Proxy
+	for i, p := range l {
+		if urlPath != p.Prefix && !strings.HasPrefix(urlPath, p.Prefix+"/") {
+			continue
+		}
+		if best == nil || len(p.Prefix) > len(best.Prefix) {
+			best = &l[i]
+		}
+	}
+	return best
+}
+
+// ServeHTTP forwards the request with X-Forwarded-For, -Host and -Proto set.
+// Upgrades such as WebSockets are passed through, a target that can't be
+// reached is a 502 Bad Gateway
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if p.Strip {
+				pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.In.URL.Path, p.Prefix), "/")
+				pr.Out.URL.RawPath = ""
+			}
+			pr.SetURL(p.Target)
+			pr.SetXForwarded()
+			// the backend's redirects need the scheme the client used,
+			// which a trusted proxy in front of us may have terminated
+			pr.Out.Header.Set("X-Forwarded-Proto", schemeOf(pr.In))
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			// ours are sent for --cors, the backend's would duplicate them
+			if w.Header().Get("Access-Control-Allow-Origin") != "" {
+				for key := range resp.Header {
+					if strings.HasPrefix(key, "Access-Control-") {
+						resp.Header.Del(key)
+					}
+				}
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			configFor(r).logger().Printf("proxy %s: %s", p.Target, err)
+			respondError(w, r, http.StatusBadGateway, "bad gateway")
+		},
+	}
+	// the backend's response says which server it's from
+	w.Header().Del("Server")
+	proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyServer serves dir and forwards the requests under /api to backend,
+// stripping the prefix if strip is set
+func proxyServer(t *testing.T, backend string, strip bool) *Server {
+	target, err := url.Parse(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := testConfig(writeTree(t, map[string]string{"page.txt": "page"}))
+	conf.Proxies = ProxyList{{Prefix: "/api", Target: target, Strip: strip}}
+	return newTestServer(t, conf)
+}
+
+func TestProxyHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend")
+		w.Header().Set("X-Seen", fmt.Sprintf("%s %s|%s|%s|%s", r.Method, r.URL.RequestURI(),
+			r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Forwarded-Host"), r.Header.Get("X-Forwarded-Proto")))
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		strip bool
+		path  string
+		seen  string
+	}{
+		{false, "/api/users?page=2", "POST /api/users?page=2|192.0.2.1|example.com|http"},
+		{true, "/api/users?page=2", "POST /users?page=2|192.0.2.1|example.com|http"},
+		{true, "/api", "POST /|192.0.2.1|example.com|http"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.strip, tt.path), func(t *testing.T) {
+			s := proxyServer(t, backend.URL, tt.strip)
+			r := httptest.NewRequest("POST", "http://example.com"+tt.path, strings.NewReader("sent"))
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			expect(t, w, 201, "sent")
+			if seen := w.Header().Get("X-Seen"); seen != tt.seen {
+				t.Errorf("backend saw %q, want %q", seen, tt.seen)
+			}
+			if server := w.Header().Get("Server"); server != "backend" {
+				t.Errorf("Server = %q, want the backend's", server)
+			}
+		})
+	}
+	// the scheme a TLS terminating proxy in front was reached by is passed on,
+	// but only from a trusted one
+	s := proxyServer(t, backend.URL, false)
+	for _, trusted := range []bool{false, true} {
+		conf := *s.conf.Load()
+		conf.TrustedProxies = nil
+		if trusted {
+			conf.TrustedProxies.Set("true")
+		}
+		if err := s.SetConfig(conf); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/api/", nil)
+		r.RemoteAddr = "127.0.0.1:4000"
+		r.Header.Set("X-Forwarded-Proto", "https")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		want := "http"
+		if trusted {
+			want = "https"
+		}
+		if seen := w.Header().Get("X-Seen"); !strings.HasSuffix(seen, "|"+want) {
+			t.Errorf("trusted %v: backend saw %q, want X-Forwarded-Proto %s", trusted, seen, want)
+		}
+	}
+	// other paths are still files, including ones that only share the prefix
+	expect(t, get(s, "GET", "/page.txt"), 200, "page")
+	expect(t, get(s, "GET", "/apis"), 404, "")
+}
+
+func TestProxyUnreachable(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+	s := proxyServer(t, backend.URL, false)
+	expect(t, get(s, "GET", "/api/users"), 502, "bad gateway")
+}
+
+func TestProxyStreams(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	defer backend.Close()
+	front := httptest.NewServer(proxyServer(t, backend.URL, false))
+	defer front.Close()
+	defer close(release)
+
+	resp, err := http.Get(front.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// the first event arrives while the backend is still writing
+	read := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		read <- line
+	}()
+	select {
+	case line := <-read:
+		if line != "data: first\n" {
+			t.Errorf("read %q, want the first event", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first event was held back until the response ended")
+	}
+}
+
+func TestProxyUpgrade(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade needed", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		fmt.Fprint(rw, "echo: "+line)
+		rw.Flush()
+	}))
+	defer backend.Close()
+	front := httptest.NewServer(proxyServer(t, backend.URL, false))
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "GET /api/socket HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	fmt.Fprint(conn, "hello\n")
+	if line, err := br.ReadString('\n'); err != nil || line != "echo: hello\n" {
+		t.Errorf("read %q, %v through the upgraded connection, want the echo", line, err)
+	}
+}
+
+func TestProxyListSet(t *testing.T) {
+	tests := []struct {
+		value, want, err string
+	}{
+		{"/api=http://localhost:3000", "/api=http://localhost:3000", ""},
+		{"/api/=>https://example.com/v1", "/api=>https://example.com/v1", ""},
+		{"api=http://localhost", "", "expected /prefix=URL or /prefix=>URL"},
+		{"/api", "", "expected /prefix=URL or /prefix=>URL"},
+		{"/=http://localhost", "", "proxying / would hide every file"},
+		{"/api=ftp://localhost", "", `"ftp://localhost" is not an http:// or https:// URL`},
+		{"/api=localhost:3000", "", `"localhost:3000" is not an http:// or https:// URL`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var l ProxyList
+			err := l.Set(tt.value)
+			switch {
+			case err != nil && err.Error() != tt.err:
+				t.Errorf("Set = %q, want %q", err, tt.err)
+			case err == nil && tt.err != "":
+				t.Errorf("Set = nil, want %q", tt.err)
+			case err == nil && l.String() != tt.want:
+				t.Errorf("String = %q, want %q", l.String(), tt.want)
+			}
+		})
+	}
+}
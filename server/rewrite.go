@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Rewrite serves the paths matching Pattern as Replacement, in which $1 or
+// ${name} are the pattern's groups as with regexp.Expand
+type Rewrite struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+func (rw Rewrite) String() string {
+	return rw.Pattern.String() + "=" + rw.Replacement
+}
+
+// RewriteList is a flag.Value for --rewrite, which may be given more than
+// once. Rules are tried in order and only the first that matches is applied:
+//
+//	--rewrite '^/v(\d+)/(.*)=/releases/v$1/$2'
+type RewriteList []Rewrite
+
+func (l *RewriteList) String() string {
+	rules := make([]string, len(*l))
+	for i, rw := range *l {
+		rules[i] = rw.String()
+	}
+	return strings.Join(rules, " ")
+}
+
+func (l *RewriteList) Set(value string) error {
+	pattern, replacement, found := strings.Cut(value, "=")
+	if !found || pattern == "" {
+		return fmt.Errorf("expected pattern=replacement, such as '^/v(\\d+)/(.*)=/releases/v$1/$2'")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("%q is not a regular expression: %s", pattern, err)
+	}
+	*l = append(*l, Rewrite{Pattern: re, Replacement: replacement})
+	return nil
+}
+
+// rewrite returns the request for the path the first matching rule rewrites
+// r's to, or r if none match. The client isn't told, and the path it's
+// rewritten to isn't rewritten again, so that rules can't loop
+func (l RewriteList) rewrite(r *http.Request) *http.Request {
+	for _, rw := range l {
+		if !rw.Pattern.MatchString(r.URL.Path) {
+			continue
+		}
+		rewritten := r.Clone(r.Context())
+		// the result is checked by validRequest like any other path
+		rewritten.URL.Path = rw.Pattern.ReplaceAllString(r.URL.Path, rw.Replacement)
+		if !strings.HasPrefix(rewritten.URL.Path, "/") {
+			rewritten.URL.Path = "/" + rewritten.URL.Path
+		}
+		rewritten.URL.RawPath = ""
+		if conf := configFor(r); conf.Verbose {
+			conf.logger().Printf("%s ↻ %s → %s", remoteAddr(r), r.URL.Path, rewritten.URL.Path)
+		}
+		return rewritten
+	}
+	return r
+}
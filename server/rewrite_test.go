@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+// rewriteServer serves files with the --rewrite rules
+func rewriteServer(t *testing.T, files map[string]string, logs *bytes.Buffer, rules ...string) *Server {
+	conf := testConfig(writeTree(t, files))
+	for _, rule := range rules {
+		if err := conf.Rewrites.Set(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if logs != nil {
+		conf.Logger = log.New(logs, "", 0)
+		conf.Verbose = true
+	}
+	return newTestServer(t, conf)
+}
+
+func TestRewrite(t *testing.T) {
+	s := rewriteServer(t, map[string]string{
+		"releases/v2/notes.txt": "v2 notes",
+		"docs/latest.txt":       "latest",
+		"a/x.txt":               "a",
+		"b/x.txt":               "b",
+		"plain.txt":             "plain",
+		"nested/nested/n.txt":   "twice",
+	}, nil,
+		`^/v(\d+)/(.*)=/releases/v$1/$2`,
+		`^/(?P<section>docs)/current$=/${section}/latest.txt`,
+		// each would undo the other, but only the first match is applied
+		`^/a/(.*)=/b/$1`,
+		`^/b/(.*)=/a/$1`,
+		// the result matches again, and isn't rewritten another time
+		`^/nested/(.*)=/nested/nested/$1`,
+	)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/v2/notes.txt", 200, "v2 notes"},
+		{"/v3/notes.txt", 404, ""},
+		{"/docs/current", 200, "latest"},
+		{"/a/x.txt", 200, "b"},
+		{"/b/x.txt", 200, "a"},
+		{"/nested/n.txt", 200, "twice"},
+		{"/plain.txt", 200, "plain"},
+		{"/releases/v2/notes.txt", 200, "v2 notes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := get(s, "GET", tt.path)
+			expect(t, w, tt.status, tt.body)
+			if location := w.Header().Get("Location"); location != "" {
+				t.Errorf("the client was redirected to %s", location)
+			}
+		})
+	}
+}
+
+func TestRewriteIsChecked(t *testing.T) {
+	dir := writeTree(t, map[string]string{"public/a.txt": "a", ".secret": "secret"})
+	conf := testConfig(dir + "/public")
+	conf.BlockDotfiles = true
+	for _, rule := range []string{`^/up/(.*)=/../$1`, `^/hidden$=/.secret`} {
+		if err := conf.Rewrites.Set(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTestServer(t, conf)
+	for _, path := range []string{"/up/.secret", "/hidden"} {
+		if w := get(s, "GET", path); w.Code == 200 || strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("%s = %d %q, a rewrite escaped the checks", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRewriteLogged(t *testing.T) {
+	var logs bytes.Buffer
+	s := rewriteServer(t, map[string]string{"new/a.txt": "a"}, &logs, `^/old/=/new/`)
+	get(s, "GET", "/old/a.txt")
+	if !strings.Contains(logs.String(), "/old/a.txt → /new/a.txt") {
+		t.Errorf("the rewrite wasn't logged:\n%s", logs.String())
+	}
+	logs.Reset()
+	get(s, "GET", "/new/a.txt")
+	if strings.Contains(logs.String(), "↻") {
+		t.Errorf("a path that didn't match was logged as rewritten:\n%s", logs.String())
+	}
+}
+
+func TestRewriteListSet(t *testing.T) {
+	tests := []struct {
+		value, err string
+	}{
+		{`^/v(\d+)/(.*)=/releases/v$1/$2`, ""},
+		{`^/a=`, ""},
+		{`^/a`, `expected pattern=replacement, such as '^/v(\d+)/(.*)=/releases/v$1/$2'`},
+		{`=/b`, `expected pattern=replacement, such as '^/v(\d+)/(.*)=/releases/v$1/$2'`},
+		{`^/(a=/b`, `"^/(a" is not a regular expression: error parsing regexp: missing closing ): ` + "`^/(a`"},
+	}
+	for _, tt := range tests {
+		var l RewriteList
+		err := l.Set(tt.value)
+		switch {
+		case err != nil && err.Error() != tt.err:
+			t.Errorf("Set(%q) = %q, want %q", tt.value, err, tt.err)
+		case err == nil && tt.err != "":
+			t.Errorf("Set(%q) = nil, want %q", tt.value, tt.err)
+		}
+	}
+	var l RewriteList
+	l.Set(`^/a=/b`)
+	l.Set(`^/c(.*)=/d$1`)
+	if got := l.String(); got != `^/a=/b ^/c(.*)=/d$1` {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// route is a handler served in place of files, see Server.Handle
+type route struct {
+	pattern string
+	handler http.Handler
+}
+
+// Handle serves handler for requests matching pattern, ahead of any file,
+// mount or share. A pattern ending in / matches every path under it, others
+// only match exactly, and the longest pattern matching a request wins
+//
+// The endpoints enabled by Track404s, TrackHits and RecentRequests are
+// registered here too, at /_404s, /_hits and /_requests. Handle returns an
+// error if pattern is already registered
+func (s *Server) Handle(pattern string, handler http.Handler) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("pattern %q must start with /", pattern)
+	}
+	if handler == nil {
+		return fmt.Errorf("pattern %q: nil handler", pattern)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.routes {
+		if existing.pattern == pattern {
+			return fmt.Errorf("pattern %q is already registered", pattern)
+		}
+	}
+	s.routes = append(s.routes, route{pattern, handler})
+	return nil
+}
+
+// route returns the handler registered for path and the pattern it was
+// registered with, or nil
+func (s *Server) route(path string) (pattern string, handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var best *route
+	for i, rt := range s.routes {
+		matches := path == rt.pattern ||
+			strings.HasSuffix(rt.pattern, "/") && strings.HasPrefix(path, rt.pattern)
+		if matches && (best == nil || len(rt.pattern) > len(best.pattern)) {
+			best = &s.routes[i]
+		}
+	}
+	if best == nil {
+		return "", nil
+	}
+	return best.pattern, best.handler
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	_ "net/http/pprof"
+	"strings"
+	"testing"
+)
+
+func TestHandle(t *testing.T) {
+	dir := writeTree(t, map[string]string{"api/a.txt": "file", "b.txt": "b"})
+	s := newTestServer(t, testConfig(dir))
+	text := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		})
+	}
+	if err := s.Handle("/api/", text("api")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Handle("/api/v2/", text("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Handle("/health", text("ok")); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, get(s, "GET", "/api/a.txt"), 200, "api")
+	expect(t, get(s, "GET", "/api/v2/x"), 200, "v2")
+	expect(t, get(s, "GET", "/health"), 200, "ok")
+	expect(t, get(s, "GET", "/health/x"), 404, "")
+	expect(t, get(s, "GET", "/b.txt"), 200, "b")
+
+	for _, pattern := range []string{"/api/", "health"} {
+		if err := s.Handle(pattern, text("")); err == nil {
+			t.Errorf("Handle(%q) succeeded", pattern)
+		}
+	}
+	if err := s.Handle("/nil", nil); err == nil {
+		t.Error("Handle accepted a nil handler")
+	}
+}
+
+// importing net/http/pprof registers /debug/pprof/ on http.DefaultServeMux,
+// which a Server must not serve
+func TestDefaultServeMuxUnused(t *testing.T) {
+	if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest("GET", "/debug/pprof/", nil)); !strings.HasSuffix(pattern, "/debug/pprof/") {
+		t.Fatalf("DefaultServeMux pattern = %q, want /debug/pprof/ registered by net/http/pprof", pattern)
+	}
+	s := newTestServer(t, testConfig(t.TempDir()))
+	expect(t, get(s, "GET", "/debug/pprof/"), 404, "")
+	expect(t, get(s.Handler(), "GET", "/debug/pprof/cmdline"), 404, "")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, ln)
+	<-s.Ready()
+	resp, err := http.Get("http://" + ln.Addr().String() + "/debug/pprof/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 404 || strings.Contains(string(body), "profile") {
+		t.Errorf("GET /debug/pprof/ on the listener = %d %q, want 404", resp.StatusCode, body)
+	}
+}
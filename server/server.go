@@ -0,0 +1,526 @@
+// Package server is the file server behind the serve command, serving the
+// union of several directories with listings. It can be embedded in other
+// programs, each Server carries its own configuration so several can run in
+// one process
+package server
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownTimeout is how long requests in flight are given to finish once
+// ListenAndServe's context is cancelled
+const shutdownTimeout = 5 * time.Second
+
+// Server serves files with the settings of a Config
+type Server struct {
+	// conf is the configuration new requests are served with. It is
+	// replaced as a whole by SetConfig, requests use the snapshot from
+	// configFor
+	conf atomic.Pointer[Config]
+
+	// stats holds the counters reported by LogSummary and Vars
+	stats *stats
+	// missing records requests that were not found, nil unless Track404s
+	// is set
+	missing *missingTable
+	// hits counts completed file downloads, nil unless TrackHits or
+	// StatsFile is set
+	hits *hitCounter
+	// recent holds the last RecentRequests requests, nil if it is 0
+	recent *requestRing
+	// tracer exports a span per request, nil unless Otel is set and an OTLP
+	// endpoint is configured
+	tracer *spanExporter
+	// share is the link Share is served at, nil unless it is set
+	share *sharedFile
+	// hashes are the SHA-256 of files for the manifest and DigestHeader
+	hashes *hashCache
+	// ignores are the parsed .serveignore files
+	ignores *ignoreCache
+	// headers are the parsed _headers files
+	headers *headersCache
+	// redirects are the parsed _redirects files
+	redirects *redirectsCache
+	// aliases is the parsed Aliases file
+	aliases *aliasesCache
+	// mirror fetches the files that aren't found from the URLs in Dirs
+	mirror *mirror
+	// transfers has a slot for each of the MaxLargeTransfers files over
+	// the threshold being sent, nil if there's no limit
+	transfers chan struct{}
+	// precompress caches gzipped files, nil unless Precompress is set
+	precompress *precompressor
+	// live reloads browsers when files change, nil unless LiveReload is set
+	live *liveReload
+	// misses are the paths recently not found, used with Index unless
+	// NoMissCache is set
+	misses *missCache
+	// archives are the zip and tar archives in Dirs
+	archives *archiveCache
+
+	mu     sync.Mutex
+	srv    *http.Server
+	routes []route
+	// stopping is closed when shutting down starts, for responses that
+	// stream indefinitely
+	stopping chan struct{}
+	stopOnce sync.Once
+	// ready is closed once the listeners are bound
+	ready     chan struct{}
+	readyOnce sync.Once
+	// done is closed by Close
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a Server for cfg, or an error if cfg isn't valid. Features that
+// are only set up once, such as tracing and the hit counter, are started from
+// cfg here
+func New(cfg Config) (*Server, error) {
+	if _, err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		stats:     newStats(),
+		hashes:    newHashCache(),
+		ignores:   newIgnoreCache(),
+		headers:   newHeadersCache(),
+		redirects: newRedirectsCache(),
+		aliases:   newAliasesCache(),
+		mirror:    newMirror(),
+		misses:    newMissCache(),
+		archives:  newArchiveCache(),
+		tracer:    startTracing(&cfg),
+		stopping:  make(chan struct{}),
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if cfg.Share != "" {
+		// the link is the share's only secret, so nothing that records the
+		// paths requested is served beside it
+		cfg.Track404s, cfg.TrackHits, cfg.StatsFile, cfg.RecentRequests = false, false, "", 0
+	}
+	if cfg.Track404s {
+		s.missing = newMissingTable()
+		s.Handle("/_404s", mountAuth(s.missing))
+	}
+	if cfg.TrackHits || len(cfg.StatsFile) > 0 {
+		s.hits = newHitCounter(cfg.StatsFile, cfg.logger(), s.done)
+		s.Handle("/_hits", mountAuth(s.hits))
+	}
+	if cfg.RecentRequests > 0 {
+		s.recent = newRequestRing(cfg.RecentRequests)
+		s.Handle("/_requests", mountAuth(s.recent))
+	}
+	if cfg.Manifest {
+		s.Handle("/__manifest.json", newManifest(s.hashes, s.ignores))
+	}
+	if cfg.ShareSecret != "" {
+		s.Handle("/_sign", linkSigner{})
+	}
+	if cfg.Precompress {
+		dir, _ := cfg.cacheDir()
+		s.precompress = startPrecompress(dir, cfg.PrecompressEager, s.diskSources, cfg.logger(), s.done)
+	}
+	if cfg.MaxLargeTransfers > 0 {
+		s.transfers = make(chan struct{}, cfg.MaxLargeTransfers)
+	}
+	if cfg.Share != "" {
+		s.share = newSharedFile(cfg.Share)
+	}
+	if cfg.LiveReload {
+		s.live = startLiveReload(s.watchedDirs, cfg.logger(), s.stopping, s.done)
+		s.Handle("/_events", s.live)
+	}
+	cfg.redirectFileRules, _ = readRedirectFile(cfg.RedirectFile)
+	cfg.archives = s.archives
+	s.conf.Store(&cfg)
+	return s, nil
+}
+
+// SetConfig replaces the configuration requests are served with, requests in
+// flight finish with the settings they started with. The settings read by New
+// and ListenAndServe, such as the address, keep their old values
+func (s *Server) SetConfig(cfg Config) error {
+	if _, err := cfg.Validate(); err != nil {
+		return err
+	}
+	cfg.redirectFileRules, _ = readRedirectFile(cfg.RedirectFile)
+	cfg.archives = s.archives
+	s.conf.Store(&cfg)
+	s.misses.clear()
+	s.archives.keep(cfg.Dirs)
+	return nil
+}
+
+// Handler returns the handler serving the files, for use with a server or mux
+// of your own
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+// ServeHTTP serves the files, a Server is itself the handler returned by
+// Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serveHTTP(w, r)
+}
+
+// ListenAndServe serves on each of the configured Listeners until ctx is
+// cancelled, then shuts them all down, giving requests in flight a few seconds
+// to finish. It returns nil once shut down by ctx or Close, or the first error
+// from any of the listeners
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	conf := s.conf.Load()
+	listeners := conf.Listeners()
+	lc := net.ListenConfig{KeepAlive: conf.TCPKeepAlive}
+	if conf.ReusePort && reusePortSupported {
+		lc.Control = reusePort
+	}
+	lns := make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		ln, err := lc.Listen(ctx, "tcp", l.Addr)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return err
+		}
+		lns = append(lns, ln)
+	}
+	return s.serve(ctx, lns, listeners)
+}
+
+// Serve serves plain HTTP on ln until ctx is cancelled, as ListenAndServe
+// does for the configured Listeners. ln is closed when it returns
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	return s.serve(ctx, []net.Listener{ln}, []Listener{{Addr: ln.Addr().String()}})
+}
+
+// serve serves on each of lns, TLS is used for those whose Listener has it
+// set
+func (s *Server) serve(ctx context.Context, lns []net.Listener, listeners []Listener) error {
+	conf := s.conf.Load()
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: conf.ReadHeaderTimeout,
+	}
+	srv.SetKeepAlivesEnabled(!conf.NoKeepAlive)
+	srv.RegisterOnShutdown(s.stop)
+	if conf.HTTP2 {
+		// HTTP/2 over TLS is negotiated with ALPN by default, cleartext
+		// HTTP/2 has to be enabled explicitly and is only used by clients
+		// with prior knowledge, e.g. curl --http2-prior-knowledge
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	s.mu.Lock()
+	s.srv = srv
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+			return
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			srv.Close()
+		}
+	}()
+
+	// one http.Server serves every listener, so that Shutdown drains them
+	// all
+	errs := make(chan error, len(lns))
+	for i, ln := range lns {
+		go func() {
+			if listeners[i].TLS {
+				errs <- srv.ServeTLS(ln, conf.CertFile, conf.KeyFile)
+			} else {
+				errs <- srv.Serve(ln)
+			}
+		}()
+	}
+	s.readyOnce.Do(func() { close(s.ready) })
+	err := <-errs
+	if !errors.Is(err, http.ErrServerClosed) {
+		s.Close()
+		return err
+	}
+	// Serve returns as soon as Shutdown is called, wait for the requests in
+	// flight
+	<-stopped
+	s.Close()
+	return nil
+}
+
+// Close stops the server immediately, without waiting for requests in
+// flight, then sends any queued traces and saves the hit counts
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.stop()
+		s.mu.Lock()
+		srv := s.srv
+		s.mu.Unlock()
+		if srv != nil {
+			err = srv.Close()
+		}
+		if s.tracer != nil {
+			s.tracer.shutdown(shutdownTimeout)
+		}
+		if s.hits != nil {
+			s.hits.save()
+		}
+		s.archives.keep(nil)
+	})
+	return err
+}
+
+// Ready returns a channel that is closed once ListenAndServe or Serve is
+// accepting connections, for running something against the server
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Done returns a channel that is closed once the server has been closed, for
+// stopping anything running alongside it
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// stop ends the responses that stream until the server stops
+func (s *Server) stop() {
+	s.stopOnce.Do(func() { close(s.stopping) })
+}
+
+// LogSummary logs a recap of the activity since the server started, along
+// with the requests that weren't found if Track404s and Verbose are set
+func (s *Server) LogSummary() {
+	conf := s.conf.Load()
+	s.stats.logSummary(conf.logger())
+	if s.missing != nil && conf.Verbose {
+		s.missing.logMissing(conf.logger())
+	}
+}
+
+// Vars returns the request counters as expvar variables, for publishing with
+// expvar.Publish
+func (s *Server) Vars() *expvar.Map {
+	classes := new(expvar.Map).Init()
+	for class := 1; class < len(s.stats.classes); class++ {
+		classes.Set(fmt.Sprintf("%dxx", class), &s.stats.classes[class])
+	}
+	vars := new(expvar.Map).Init()
+	vars.Set("requests", &s.stats.requests)
+	vars.Set("responses", classes)
+	vars.Set("bytes", &s.stats.bytes)
+	vars.Set("disconnects", &s.stats.disconnects)
+	vars.Set("clients", expvar.Func(func() any { return s.stats.distinctClients() }))
+	vars.Set("dirs", expvar.Func(func() any { return s.stats.usageByDir() }))
+	if s.hits != nil {
+		vars.Set("hits", expvar.Func(func() any { return s.hits.list("") }))
+	}
+	return vars
+}
+
+// watchedDirs returns the directories on disk LiveReload watches, and the
+// archives in Dirs
+func (s *Server) watchedDirs() []string {
+	conf := s.conf.Load()
+	dirs := slices.DeleteFunc(slices.Clone(conf.Dirs), isMirror)
+	for _, m := range conf.Mounts {
+		if m.FS == nil {
+			dirs = append(dirs, m.Dir)
+		}
+	}
+	return dirs
+}
+
+// diskSources returns the directories on disk being served, those in Dirs
+// and the mounts
+func (s *Server) diskSources() []source {
+	sources := []source{}
+	for _, dir := range s.watchedDirs() {
+		if !isArchive(dir) {
+			sources = append(sources, dirSource(dir))
+		}
+	}
+	return sources
+}
+
+// ShareLink returns the path Share is served at, which is random so that
+// only the people given the link can find it
+func (s *Server) ShareLink() string {
+	if s.share == nil {
+		return ""
+	}
+	return s.share.path
+}
+
+// Shared returns a channel that is closed once Share has been downloaded in
+// full
+func (s *Server) Shared() <-chan struct{} {
+	if s.share == nil {
+		return nil
+	}
+	return s.share.done
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	conf := s.conf.Load()
+	r = withConfig(r, conf)
+	r = r.WithContext(context.WithValue(r.Context(), ignoresKey{}, s.ignores))
+	if conf.Follow {
+		r = withFollow(r, s.stopping)
+	}
+	if s.precompress != nil {
+		r = r.WithContext(context.WithValue(r.Context(), precompressKey{}, s.precompress))
+	}
+	if conf.DigestHeader {
+		r = r.WithContext(context.WithValue(r.Context(), hashesKey{}, s.hashes))
+	}
+	if s.transfers != nil {
+		r = r.WithContext(context.WithValue(r.Context(), transfersKey{}, s.transfers))
+	}
+	rec := newResponseRecorder(w)
+	w = rec
+	// the page is added to inside the recorder, which counts what is sent
+	var injector *reloadInjector
+	if s.live != nil && wantsLiveReload(r) {
+		injector = &reloadInjector{ResponseWriter: w}
+		w = injector
+		r = r.WithContext(context.WithValue(r.Context(), liveReloadKey{}, true))
+	}
+	// r may be rewritten by _redirects, it is recorded as it was requested
+	requested := r
+	// the route, mount or proxy that answered, traced in place of the path
+	// which would give every file its own route
+	var matched string
+	defer func() {
+		if injector != nil && rec.err == nil {
+			injector.finish()
+		}
+		s.stats.record(requested, rec)
+		if !rec.disconnected(requested) {
+			warnSlow(requested, rec)
+		}
+		if s.tracer != nil {
+			s.tracer.record(requested, rec, matched)
+		}
+		if s.missing != nil && rec.Status() == http.StatusNotFound {
+			s.missing.record(requested)
+		}
+		if s.hits != nil {
+			s.hits.record(requested, rec)
+		}
+		if s.recent != nil && requested.URL.Path != "/_requests" {
+			s.recent.record(requested, rec)
+		}
+		if s.share != nil {
+			s.share.record(requested, rec)
+		}
+	}()
+
+	logRequest(r)
+	w.Header().Set("Server", serverHeader())
+	for key, values := range conf.Headers {
+		w.Header()[http.CanonicalHeaderKey(key)] = values
+	}
+	s.headers.apply(w, r)
+	if setCORS(w, r) {
+		return
+	}
+	if traceMethod(r) {
+		w.Header().Set("Allow", staticMethods)
+		respondError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if pattern, handler := s.route(r.URL.Path); handler != nil {
+		matched = pattern
+		handler.ServeHTTP(w, r)
+		return
+	}
+	// the endpoints above answer straight away, so that tools polling them
+	// aren't slowed down or failed
+	if !conf.Delay.wait(r) || injectFault(w, r) {
+		return
+	}
+	if conf.Redirects.redirect(w, r) || conf.redirectFileRules.redirect(w, r) {
+		return
+	}
+	r = conf.Rewrites.rewrite(r)
+	if !validRequest(r) {
+		respondError(w, r, http.StatusBadRequest, "invalid path")
+		return
+	}
+	if redirectCanonical(w, r) {
+		return
+	}
+	if conf.BlockDotfiles && dotPath(r.URL.Path) {
+		respondError(w, r, http.StatusForbidden, "forbidden")
+		return
+	}
+	if s.share != nil {
+		if !tryMethod(w, r) {
+			s.share.serve(w, r, conf.Share)
+		}
+		return
+	}
+	r, handled := s.redirects.try(w, r)
+	if handled {
+		return
+	}
+	if r, handled = s.aliases.try(w, r); handled {
+		return
+	}
+	if conf.underMock(r.URL.Path) && serveMock(w, r) {
+		return
+	}
+	if p := conf.Proxies.match(r.URL.Path); p != nil {
+		matched = p.Prefix
+		p.ServeHTTP(w, r)
+		return
+	}
+	if m := conf.Mounts.match(r.URL.Path); m != nil {
+		matched = m.Prefix
+		serveMount(w, r, m)
+		return
+	}
+	sources := conf.sources()
+	if tryCGI(w, r, sources) || tryMethod(w, r) || tryIndexFirst(w, r, sources) || tryDirs(w, r, sources) {
+		return
+	}
+	cacheMisses := len(conf.Index) > 0 && !conf.NoMissCache
+	if !cacheMisses || !s.misses.missed(r.URL.Path) {
+		if tryFiles(w, r, sources) || s.mirror.try(w, r) {
+			return
+		}
+		if cacheMisses {
+			s.misses.add(r.URL.Path)
+		}
+	}
+	if tryFavicon(w, r) || tryAutoIndex(w, r, sources) {
+		return
+	}
+	if len(conf.Index) > 0 && staticIndex(w, r) {
+		return
+	}
+	notFound(w, r)
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTree creates files in a new temporary directory, keyed by their slash
+// separated path, and returns the directory
+func writeTree(t testing.TB, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// testConfig is the default configuration serving dirs, without logs
+func testConfig(dirs ...string) Config {
+	conf := DefaultConfig()
+	conf.Dirs = dirs
+	conf.Logger = log.New(io.Discard, "", 0)
+	return conf
+}
+
+// newTestServer returns a Server for conf, which is closed when the test ends
+func newTestServer(t testing.TB, conf Config) *Server {
+	t.Helper()
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// get serves a request for target to h, with headers given as name, value
+// pairs
+func get(h http.Handler, method, target string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// expect fails the test if the response doesn't have status, or doesn't
+// contain body
+func expect(t testing.TB, w *httptest.ResponseRecorder, status int, body string) {
+	t.Helper()
+	if w.Code != status {
+		t.Errorf("status = %d, want %d, body %q", w.Code, status, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), body) {
+		t.Errorf("body = %q, want it to contain %q", w.Body.String(), body)
+	}
+}
+
+// listing decodes the JSON listing of urlPath
+func listing(t testing.TB, h http.Handler, urlPath string) Listing {
+	t.Helper()
+	w := get(h, "GET", urlPath, "Accept", "application/json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, want 200", urlPath, w.Code)
+	}
+	var l Listing
+	if err := json.Unmarshal(w.Body.Bytes(), &l); err != nil {
+		t.Fatalf("GET %s: %v", urlPath, err)
+	}
+	return l
+}
+
+// names returns the names of the entries of each directory of l
+func (l Listing) names() [][]string {
+	var names [][]string
+	for _, dir := range l.Dirs {
+		var dirNames []string
+		for _, entry := range dir.Entries {
+			dirNames = append(dirNames, entry.Name)
+		}
+		names = append(names, dirNames)
+	}
+	return names
+}
+
+func TestServeOverlay(t *testing.T) {
+	first := writeTree(t, map[string]string{"a.txt": "first a", "sub/index.html": "first index"})
+	second := writeTree(t, map[string]string{"a.txt": "second a", "b.txt": "second b"})
+	s := newTestServer(t, testConfig(first, second))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/a.txt", 200, "first a"},
+		{"/b.txt", 200, "second b"},
+		{"/sub", 200, "first index"},
+		{"/sub/", 200, "index.html"},
+		{"/missing.txt", 404, ""},
+		{"/../a.txt", 400, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			expect(t, get(s, "GET", tt.path), tt.status, tt.body)
+		})
+	}
+}
+
+func TestServeListsEachDir(t *testing.T) {
+	first := writeTree(t, map[string]string{"a.txt": ""})
+	second := writeTree(t, map[string]string{"b.txt": "", "c/d.txt": ""})
+	s := newTestServer(t, testConfig(first, second))
+
+	got := listing(t, s, "/").names()
+	if len(got) != 2 || strings.Join(got[0], ",") != "a.txt" || strings.Join(got[1], ",") != "b.txt,c/" {
+		t.Errorf("listing of / = %v, want [[a.txt] [b.txt c/]]", got)
+	}
+	if w := get(s, "GET", "/"); !strings.Contains(w.Body.String(), "<title>Index of /</title>") {
+		t.Errorf("HTML listing has no title: %q", w.Body.String())
+	}
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a"})
+	listed := newTestServer(t, testConfig(dir))
+	conf := testConfig(dir)
+	conf.NoList = true
+	unlisted := newTestServer(t, conf)
+
+	expect(t, get(listed, "GET", "/"), 200, "a.txt")
+	expect(t, get(unlisted, "GET", "/"), 404, "")
+	expect(t, get(unlisted, "GET", "/a.txt"), 200, "a")
+}
+
+func TestSetConfig(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a"})
+	s := newTestServer(t, testConfig(dir))
+	expect(t, get(s, "GET", "/"), 200, "a.txt")
+
+	conf := testConfig(dir)
+	conf.NoList = true
+	if err := s.SetConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, get(s, "GET", "/"), 404, "")
+
+	conf.Port = "80808"
+	if err := s.SetConfig(conf); err == nil {
+		t.Error("SetConfig accepted port 80808")
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	conf := testConfig(t.TempDir())
+	conf.MaxEntries = -1
+	if _, err := New(conf); err == nil || !strings.Contains(err.Error(), "--max-entries") {
+		t.Errorf("New with MaxEntries -1 = %v, want an error naming --max-entries", err)
+	}
+}
+
+func TestHandlerIsServer(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a"})
+	s := newTestServer(t, testConfig(dir))
+	expect(t, get(s.Handler(), "HEAD", "/a.txt"), 200, "")
+}
+
+func TestServeListener(t *testing.T) {
+	s := newTestServer(t, testConfig(writeTree(t, map[string]string{"a.txt": "a"})))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, ln) }()
+	<-s.Ready()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(body) != "a" {
+		t.Errorf("GET /a.txt = %d %q", resp.StatusCode, body)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve = %v, want nil once ctx is cancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after ctx was cancelled")
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Error("Done wasn't closed after Serve returned")
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// sharedFile is a file or directory served at a random path, so that only the
+// person given the link can find it
+type sharedFile struct {
+	path string
+	done chan struct{}
+	once sync.Once
+}
+
+// newSharedFile picks the link for name, directories are shared as a zip
+// archive
+func newSharedFile(name string) *sharedFile {
+	base := filepath.Base(name)
+	if abs, err := filepath.Abs(name); err == nil {
+		base = filepath.Base(abs)
+	}
+	if stat, err := os.Stat(name); err == nil && stat.IsDir() {
+		base += ".zip"
+	}
+	return &sharedFile{
+		path: "/" + randomHex(16) + "/" + base,
+		done: make(chan struct{}),
+	}
+}
+
+// record stops sharing after the first complete download
+func (s *sharedFile) record(r *http.Request, rec *responseRecorder) {
+	if r.URL.Path != s.path || r.Method != http.MethodGet || rec.Status() != http.StatusOK ||
+		rec.file == "" || rec.disconnected(r) {
+		return
+	}
+	s.once.Do(func() { close(s.done) })
+}
+
+// serve serves the shared file name at its link, and nothing else
+func (s *sharedFile) serve(w http.ResponseWriter, r *http.Request, name string) {
+	if r.URL.Path != s.path {
+		notFound(w, r)
+		return
+	}
+	stat, err := os.Stat(name)
+	switch {
+	case err != nil:
+		notFound(w, r)
+	case stat.IsDir():
+		if err := serveZip(w, r, name); err != nil {
+			configFor(r).logger().Printf("zip %s: %s", name, err)
+			abortResponse(w, err)
+		}
+	case !tryLocalFile(w, r, name):
+		notFound(w, r)
+	}
+}
+
+// serveZip streams the regular files in dir as a zip archive. The archive is
+// written as it's read, so there's no Content-Length and errors can only be
+// reported by cutting the response short. The walk stops when the request's
+// context is cancelled
+func serveZip(w http.ResponseWriter, r *http.Request, dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	setServedFile(w, abs)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filepath.Base(abs) + ".zip",
+	}))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	archive := zip.NewWriter(w)
+	err = filepath.WalkDir(abs, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		// stop walking as soon as the client goes away or the server shuts
+		// down, rather than when the next write fails
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(abs, name)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		header.Method = zip.Deflate
+
+		dst, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(dst, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
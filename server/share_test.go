@@ -0,0 +1,170 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// shareServer shares name, returning the server and its link
+func shareServer(t *testing.T, name string) (*Server, string) {
+	conf := testConfig()
+	conf.Share = name
+	s := newTestServer(t, conf)
+	return s, s.ShareLink()
+}
+
+// shared reports whether the share of s has been downloaded
+func shared(s *Server) bool {
+	select {
+	case <-s.Shared():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestShareLink(t *testing.T) {
+	file := filepath.Join(writeTree(t, map[string]string{"report.pdf": "pdf"}), "report.pdf")
+	s, link := shareServer(t, file)
+	_, other := shareServer(t, file)
+
+	dir, base := path.Split(link)
+	if base != "report.pdf" || len(dir) != len("/")+32+len("/") {
+		t.Errorf("link = %q, want /<32 hex digits>/report.pdf", link)
+	}
+	if link == other {
+		t.Errorf("two shares of the same file have the same link %q", link)
+	}
+	for _, guess := range []string{"/report.pdf", "/", dir, strings.ToUpper(link), other} {
+		expect(t, get(s, "GET", guess), 404, "")
+	}
+	if shared(s) {
+		t.Fatal("shared before the link was requested")
+	}
+}
+
+func TestShareEndsAfterDownload(t *testing.T) {
+	file := filepath.Join(writeTree(t, map[string]string{"notes.txt": "0123456789"}), "notes.txt")
+	s, link := shareServer(t, file)
+
+	// looking and partial downloads don't use up the link
+	expect(t, get(s, "HEAD", link), 200, "")
+	expect(t, get(s, "GET", link, "Range", "bytes=0-4"), 206, "01234")
+	if shared(s) {
+		t.Fatal("a HEAD or partial download ended the share")
+	}
+	expect(t, get(s, "GET", link), 200, "0123456789")
+	if !shared(s) {
+		t.Error("the share didn't end after a complete download")
+	}
+}
+
+// the reports would give the link away to anyone who can reach the server
+func TestShareHasNoReports(t *testing.T) {
+	file := filepath.Join(writeTree(t, map[string]string{"notes.txt": "notes"}), "notes.txt")
+	conf := testConfig()
+	conf.Share = file
+	conf.Track404s, conf.TrackHits, conf.RecentRequests = true, true, 500
+	s := newTestServer(t, conf)
+	link := s.ShareLink()
+
+	expect(t, get(s, "HEAD", link), 200, "")
+	expect(t, get(s, "GET", "/missing"), 404, "")
+	for _, report := range []string{"/_requests", "/_hits", "/_404s"} {
+		w := get(s, "GET", report)
+		if w.Code != 404 || strings.Contains(w.Body.String(), link) {
+			t.Errorf("GET %s = %d %q, want a 404", report, w.Code, w.Body)
+		}
+	}
+}
+
+func TestShareDirectory(t *testing.T) {
+	dir := writeTree(t, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	s, link := shareServer(t, dir)
+	if want := filepath.Base(dir) + ".zip"; path.Base(link) != want {
+		t.Errorf("link = %q, want it to end %s", link, want)
+	}
+
+	w := get(s, "GET", link)
+	expect(t, w, 200, "")
+	if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(data)
+	}
+	if len(files) != 2 || files["a.txt"] != "a" || files["sub/b.txt"] != "b" {
+		t.Errorf("archive has %v, want a.txt and sub/b.txt", files)
+	}
+	if !shared(s) {
+		t.Error("the share didn't end after the archive was downloaded")
+	}
+}
+
+// cancellingWriter cancels the request once the response has started
+type cancellingWriter struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestServeZipStopsWhenCancelled(t *testing.T) {
+	files := map[string]string{}
+	for i := range 100 {
+		data := make([]byte, 8<<10)
+		rand.Read(data)
+		files[fmt.Sprintf("%03d.bin", i)] = string(data)
+	}
+	dir := writeTree(t, files)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancellingWriter{httptest.NewRecorder(), cancel}
+	r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	if err := serveZip(w, r, dir); !errors.Is(err, context.Canceled) {
+		t.Fatalf("serveZip = %v, want it to stop with context.Canceled", err)
+	}
+	// the walk stops at the next file, not after archiving the rest
+	archived := bytes.Count(w.Body.Bytes(), []byte(".bin"))
+	if archived == 0 || archived > 10 {
+		t.Errorf("archived %d of 100 files after the request was cancelled", archived)
+	}
+}
+
+func TestShareZipHead(t *testing.T) {
+	s, link := shareServer(t, writeTree(t, map[string]string{"a.txt": "a"}))
+	w := get(s, http.MethodHead, link)
+	expect(t, w, 200, "")
+	if w.Body.Len() != 0 || w.Header().Get("Content-Disposition") == "" {
+		t.Errorf("HEAD sent %d bytes, Content-Disposition %q", w.Body.Len(), w.Header().Get("Content-Disposition"))
+	}
+	if shared(s) {
+		t.Error("HEAD ended the share")
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+)
+
+const (
+	// signedLinkTTL is how long a link from /_sign lasts without ?ttl
+	signedLinkTTL = 24 * time.Hour
+	// signedLinkSkew is how long after it expires a link is still accepted,
+	// for servers sharing a secret whose clocks disagree
+	signedLinkSkew = time.Minute
+)
+
+// linkSignature returns the signature of a link to urlPath that expires at
+// the unix time exp
+func linkSignature(secret, urlPath string, exp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%d", urlPath, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignLink returns urlPath with the ?exp and ?sig of a link that can be
+// downloaded without a mount's credentials until expires. It is empty
+// unless ShareSecret is set
+func (s *Server) SignLink(urlPath string, expires time.Time) string {
+	return signLink(s.conf.Load().ShareSecret, urlPath, expires)
+}
+
+func signLink(secret, urlPath string, expires time.Time) string {
+	if secret == "" {
+		return ""
+	}
+	urlPath = path.Clean("/" + urlPath)
+	exp := expires.Unix()
+	query := url.Values{
+		"exp": {strconv.FormatInt(exp, 10)},
+		"sig": {linkSignature(secret, urlPath, exp)},
+	}
+	return (&url.URL{Path: urlPath, RawQuery: query.Encode()}).String()
+}
+
+// signedLink reports whether r has ?exp and ?sig, and if so whether they
+// are a valid signature for its path that hasn't expired
+func signedLink(r *http.Request) (signed, valid bool) {
+	query := r.URL.Query()
+	if !query.Has("exp") && !query.Has("sig") {
+		return false, false
+	}
+	secret := configFor(r).ShareSecret
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if secret == "" || err != nil || time.Now().After(time.Unix(exp, 0).Add(signedLinkSkew)) {
+		return true, false
+	}
+	// hmac.Equal takes the same time however much of the signature matches
+	want := linkSignature(secret, r.URL.Path, exp)
+	return true, hmac.Equal([]byte(query.Get("sig")), []byte(want))
+}
+
+// linkSigner serves /_sign?path=PATH&ttl=DURATION, which answers with a link
+// to PATH that lasts for ttl, 24h by default. Signing a path under a mount
+// with auth takes its credentials, a signed link can't be used to sign
+// another
+type linkSigner struct{}
+
+func (linkSigner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conf := configFor(r)
+	if conf.ShareSecret == "" {
+		notFound(w, r)
+		return
+	}
+	if tryMethod(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	urlPath := path.Clean("/" + query.Get("path"))
+	if query.Get("path") == "" || !validPath(urlPath) {
+		respondError(w, r, http.StatusBadRequest, "?path must be the path of a file")
+		return
+	}
+	ttl := signedLinkTTL
+	if value := query.Get("ttl"); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil || ttl <= 0 {
+			respondError(w, r, http.StatusBadRequest, "?ttl must be a positive duration such as 30m")
+			return
+		}
+	}
+	if m := conf.Mounts.match(urlPath); m != nil && !m.authorized(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	link, _ := url.Parse(signLink(conf.ShareSecret, urlPath, time.Now().Add(ttl)))
+	link.Scheme, link.Host = schemeOf(r), r.Host
+	fmt.Fprintln(w, link)
+}
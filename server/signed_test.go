@@ -0,0 +1,152 @@
+package server
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedServer serves /priv with auth and /pub without, signing links with
+// secret
+func signedServer(t *testing.T) *Server {
+	dir := writeTree(t, map[string]string{"priv/a.txt": "private", "priv/b.txt": "other", "pub/c.txt": "public"})
+	conf := testConfig(t.TempDir())
+	conf.ShareSecret = "secret"
+	conf.Mounts = MountList{
+		{Prefix: "/priv", Dir: dir + "/priv", Auth: "user:pass"},
+		{Prefix: "/pub", Dir: dir + "/pub"},
+	}
+	return newTestServer(t, conf)
+}
+
+func TestSignedLink(t *testing.T) {
+	s := signedServer(t)
+	now := time.Now()
+	link := s.SignLink("/priv/a.txt", now.Add(time.Hour))
+	valid, _ := url.Parse(link)
+	exp, sig := valid.Query().Get("exp"), valid.Query().Get("sig")
+	other, _ := url.Parse(s.SignLink("/priv/b.txt", now.Add(time.Hour)))
+
+	tests := []struct {
+		name   string
+		target string
+		auth   bool
+		status int
+	}{
+		{"valid", link, false, 200},
+		{"no signature", "/priv/a.txt", false, 401},
+		{"credentials", "/priv/a.txt", true, 200},
+		{"within the skew", s.SignLink("/priv/a.txt", now.Add(-signedLinkSkew/2)), false, 200},
+		{"expired", s.SignLink("/priv/a.txt", now.Add(-2*signedLinkSkew)), false, 403},
+		{"forged", "/priv/a.txt?exp=" + exp + "&sig=" + strings.Repeat("A", len(sig)), false, 403},
+		{"another path's", "/priv/a.txt?" + other.RawQuery, false, 403},
+		{"later exp", "/priv/a.txt?exp=" + strconv.FormatInt(now.Add(48*time.Hour).Unix(), 10) + "&sig=" + sig, false, 403},
+		{"bad exp", "/priv/a.txt?exp=soon&sig=" + sig, false, 403},
+		{"only sig", "/priv/a.txt?sig=" + sig, false, 403},
+		{"other secret", signLink("other", "/priv/a.txt", now.Add(time.Hour)), false, 403},
+		// credentials are still accepted with a bad link
+		{"expired with credentials", s.SignLink("/priv/a.txt", now.Add(-time.Hour)), true, 200},
+		{"public", "/pub/c.txt?exp=1&sig=x", false, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.auth {
+				headers = []string{"Authorization", basicAuth("user", "pass")}
+			}
+			w := get(s, "GET", tt.target, headers...)
+			expect(t, w, tt.status, "")
+			if tt.status == 403 && !strings.Contains(w.Body.String(), "link expired or invalid") {
+				t.Errorf("body = %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSignLink(t *testing.T) {
+	expires := time.Unix(1700000000, 0)
+	link := signLink("secret", "priv/../priv/a.txt", expires)
+	if !strings.HasPrefix(link, "/priv/a.txt?exp=1700000000&sig=") {
+		t.Errorf("signLink = %q, want the cleaned path with exp and sig", link)
+	}
+	if link != signLink("secret", "/priv/a.txt", expires) || link == signLink("secret", "/priv/a.txt", expires.Add(time.Second)) {
+		t.Error("the signature doesn't depend on the path and expiry alone")
+	}
+	conf := testConfig(t.TempDir())
+	if link := newTestServer(t, conf).SignLink("/a.txt", expires); link != "" {
+		t.Errorf("SignLink without a ShareSecret = %q", link)
+	}
+}
+
+func TestSignEndpoint(t *testing.T) {
+	s := signedServer(t)
+	auth := basicAuth("user", "pass")
+
+	w := get(s, "GET", "/_sign?path=/priv/a.txt&ttl=30m", "Authorization", auth)
+	expect(t, w, 200, "")
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control = %q", w.Header().Get("Cache-Control"))
+	}
+	link, err := url.Parse(strings.TrimSpace(w.Body.String()))
+	if err != nil || link.Scheme != "http" || link.Host != "example.com" || link.Path != "/priv/a.txt" {
+		t.Fatalf("link = %q, %v", w.Body.String(), err)
+	}
+	exp, _ := strconv.ParseInt(link.Query().Get("exp"), 10, 64)
+	if ttl := time.Until(time.Unix(exp, 0)); ttl < 29*time.Minute || ttl > 31*time.Minute {
+		t.Errorf("the link lasts %s, want 30m", ttl)
+	}
+	expect(t, get(s, "GET", link.RequestURI()), 200, "private")
+
+	tests := []struct {
+		target string
+		auth   bool
+		status int
+		body   string
+	}{
+		// signing a path under a mount with auth takes its credentials
+		{"/_sign?path=/priv/a.txt", false, 401, "unauthorized"},
+		{"/_sign?path=/priv/a.txt&" + link.RawQuery, false, 401, "unauthorized"},
+		{"/_sign?path=/pub/c.txt", false, 200, "/pub/c.txt?exp="},
+		{"/_sign", true, 400, "?path must be the path of a file"},
+		{"/_sign?path=/priv/a.txt&ttl=-1m", true, 400, "?ttl must be a positive duration such as 30m"},
+		{"/_sign?path=/priv/a.txt&ttl=soon", true, 400, "?ttl must be a positive duration such as 30m"},
+	}
+	for _, tt := range tests {
+		var headers []string
+		if tt.auth {
+			headers = []string{"Authorization", auth}
+		}
+		expect(t, get(s, "GET", tt.target, headers...), tt.status, tt.body)
+	}
+	if w := get(s, "POST", "/_sign?path=/pub/c.txt"); w.Code != 405 {
+		t.Errorf("POST = %d, want 405", w.Code)
+	}
+
+	// a link is https behind a trusted proxy that terminates TLS, whatever an
+	// untrusted client claims
+	for _, trusted := range []bool{false, true} {
+		trusting := *s.conf.Load()
+		trusting.TrustedProxies = nil
+		if trusted {
+			// httptest's requests come from 192.0.2.1
+			trusting.TrustedProxies.Set("192.0.2.1")
+		}
+		if err := s.SetConfig(trusting); err != nil {
+			t.Fatal(err)
+		}
+		w := get(s, "GET", "/_sign?path=/pub/c.txt", "X-Forwarded-Proto", "https")
+		want := "http://example.com/pub/c.txt?"
+		if trusted {
+			want = "https://example.com/pub/c.txt?"
+		}
+		if !strings.HasPrefix(w.Body.String(), want) {
+			t.Errorf("trusted %v: link = %q, want %s...", trusted, w.Body.String(), want)
+		}
+	}
+
+	// there's no /_sign without a secret
+	conf := testConfig(t.TempDir())
+	expect(t, get(newTestServer(t, conf), "GET", "/_sign?path=/a.txt"), 404, "")
+}
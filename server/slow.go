@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// warnSlow logs requests that took longer than --slow-threshold, splitting
+// the time into waiting for the first byte and transferring the body
+//
+// With --slow-ttfb only the time to first byte is compared, so a large
+// download over a slow connection isn't reported if it started promptly
+func warnSlow(r *http.Request, rec *responseRecorder) {
+	conf := configFor(r)
+	if conf.SlowThreshold <= 0 {
+		return
+	}
+	end := time.Now()
+	total := end.Sub(rec.start)
+	ttfb := total
+	if !rec.firstByte.IsZero() {
+		ttfb = rec.firstByte.Sub(rec.start)
+	}
+
+	elapsed := total
+	if conf.SlowTTFB {
+		elapsed = ttfb
+	}
+	if elapsed <= conf.SlowThreshold {
+		return
+	}
+	conf.logger().Printf(
+		"WARN slow request: %s %s took %s (first byte %s, transfer %s, %s)",
+		r.Method, r.URL.Path, total, ttfb, total-ttfb, formatBytes(rec.written),
+	)
+}
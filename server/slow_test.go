@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowServer serves /slow-start, which waits before answering, and
+// /slow-body, which answers at once but takes as long to send its body,
+// logging to the returned buffer
+func slowServer(t *testing.T, ttfb bool) (*Server, *bytes.Buffer) {
+	const delay = 60 * time.Millisecond
+	var logged bytes.Buffer
+	conf := testConfig(t.TempDir())
+	conf.Logger = log.New(&logged, "", 0)
+	conf.SlowThreshold = delay / 2
+	conf.SlowTTFB = ttfb
+	s := newTestServer(t, conf)
+	s.Handle("/slow-start", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte("late"))
+	}))
+	s.Handle("/slow-body", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunk := bytes.Repeat([]byte("x"), 64<<10)
+		for range 4 {
+			w.Write(chunk)
+			time.Sleep(delay / 4)
+		}
+	}))
+	s.Handle("/fast", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	return s, &logged
+}
+
+func TestWarnSlow(t *testing.T) {
+	tests := []struct {
+		ttfb   bool
+		path   string
+		warned bool
+	}{
+		{false, "/slow-start", true},
+		{false, "/slow-body", true},
+		{false, "/fast", false},
+		// a large download that started promptly isn't slow by its first byte
+		{true, "/slow-start", true},
+		{true, "/slow-body", false},
+		{true, "/fast", false},
+	}
+	for _, tt := range tests {
+		s, logged := slowServer(t, tt.ttfb)
+		get(s, "GET", tt.path)
+		if warned := strings.Contains(logged.String(), "WARN slow request: GET "+tt.path); warned != tt.warned {
+			t.Errorf("ttfb %v, %s: warned %v, want %v, logged %q", tt.ttfb, tt.path, warned, tt.warned, logged.String())
+		}
+	}
+
+	s, logged := slowServer(t, false)
+	get(s, "GET", "/slow-start")
+	if line := logged.String(); !strings.Contains(line, "(first byte ") || !strings.Contains(line, ", 4 B)") {
+		t.Errorf("logged %q, want the time to the first byte and the size", line)
+	}
+	s, logged = slowServer(t, false)
+	get(s, "GET", "/slow-body")
+	if line := logged.String(); !strings.Contains(line, ", 256.0 KiB)") {
+		t.Errorf("logged %q, want the size of the body", line)
+	}
+}
+
+func TestWarnSlowDisabled(t *testing.T) {
+	s, logged := slowServer(t, false)
+	conf := *s.conf.Load()
+	conf.SlowThreshold = 0
+	if err := s.SetConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	get(s, "GET", "/slow-start")
+	if logged.Len() > 0 {
+		t.Errorf("logged %q without --slow-threshold", logged.String())
+	}
+}
+
+func TestWarnSlowDisconnected(t *testing.T) {
+	s, logged := slowServer(t, false)
+	// the client gave up, the wait is theirs rather than the server's
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/slow-start", nil).WithContext(ctx)
+	cancel()
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	if strings.Contains(logged.String(), "slow request") {
+		t.Errorf("logged %q for a client that disconnected", logged.String())
+	}
+}
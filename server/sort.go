@@ -0,0 +1,102 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sortKeys are the orders a listing can be sorted in with ?sort=, name being
+// the order ReadDir returns
+var sortKeys = []string{"name", "size", "time"}
+
+// SortLink is a heading of a listing, linking to it sorted by Key. The active
+// one links to the reverse of the current order
+type SortLink struct {
+	Key     string
+	Href    string
+	Active  bool
+	Reverse bool
+}
+
+// listingSort returns the order requested by ?sort= and ?reverse=1
+func listingSort(r *http.Request) (key string, reverse bool) {
+	query := r.URL.Query()
+	key = query.Get("sort")
+	if !slices.Contains(sortKeys, key) {
+		key = "name"
+	}
+	return key, query.Get("reverse") == "1"
+}
+
+// listQuery returns the query string links in a listing carry, so that a
+// forced listing stays forced, and the order and ?format=json are kept
+// while browsing
+func listQuery(r *http.Request, key string, reverse bool) string {
+	params := []string{}
+	if forceList(r) {
+		params = append(params, "list")
+	}
+	if key != "name" {
+		params = append(params, "sort="+key)
+	}
+	if reverse {
+		params = append(params, "reverse=1")
+	}
+	if r.URL.Query().Get("format") == "json" {
+		params = append(params, "format=json")
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	return "?" + strings.Join(params, "&")
+}
+
+// sortLinks returns the headings of a listing, each linking to the same
+// directory
+func sortLinks(r *http.Request) []SortLink {
+	current, reverse := listingSort(r)
+	links := make([]SortLink, len(sortKeys))
+	for i, key := range sortKeys {
+		active := key == current
+		href := listQuery(r, key, active && !reverse)
+		if href == "" {
+			href = "./"
+		}
+		links[i] = SortLink{Key: key, Href: href, Active: active, Reverse: active && reverse}
+	}
+	return links
+}
+
+// sortEntries orders entries by key. Sizes and times need a stat of every
+// entry, so name order, which needs none, is the default. Directories come
+// before files by size, and entries that vanish before they are stat'd sort
+// as empty and old
+func sortEntries[E fs.DirEntry](entries []E, key string, reverse bool) {
+	if key != "name" {
+		sizes := make(map[string]int64, len(entries))
+		times := make(map[string]time.Time, len(entries))
+		for _, entry := range entries {
+			sizes[entry.Name()] = -1
+			if info, err := entry.Info(); err == nil {
+				if !info.IsDir() {
+					sizes[entry.Name()] = info.Size()
+				}
+				times[entry.Name()] = info.ModTime()
+			}
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			a, b := entries[i].Name(), entries[j].Name()
+			if key == "size" {
+				return sizes[a] < sizes[b]
+			}
+			return times[a].Before(times[b])
+		})
+	}
+	if reverse {
+		slices.Reverse(entries)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FS is a file system to serve alongside the directories on disk, such as an
+// embed.FS. Name is shown in place of a directory in listings
+type FS struct {
+	Name string
+	FS   fs.FS
+}
+
+// source is a tree of files that requests are served from, either a directory
+// on disk or an fs.FS. Names within it are slash separated and relative to its
+// root as with fs.FS, so they can never refer to a file outside of it
+type source struct {
+	// name is shown in listings
+	name string
+	// dir is the directory on disk, empty for an fs.FS
+	dir  string
+	fsys fs.FS
+}
+
+func dirSource(dir string) source {
+	return source{name: filepath.ToSlash(dir), dir: dir, fsys: dirFS(dir)}
+}
+
+func fsSource(f FS) source {
+	return source{name: f.Name, fsys: f.FS}
+}
+
+// sources returns the trees served at the root, Dirs followed by FS. A zip
+// or tar archive in Dirs is served as the tree inside it, the URLs in Dirs
+// are left to the mirror
+func (c *Config) sources() []source {
+	sources := make([]source, 0, len(c.Dirs)+len(c.FS))
+	for _, dir := range c.Dirs {
+		if isMirror(dir) {
+			continue
+		}
+		if isArchive(dir) {
+			sources = append(sources, c.archives.source(dir))
+			continue
+		}
+		sources = append(sources, dirSource(dir))
+	}
+	for _, f := range c.FS {
+		sources = append(sources, fsSource(f))
+	}
+	return sources
+}
+
+// requestSources returns the trees the request is served from, those of its
+// mount if it has one
+func requestSources(r *http.Request) []source {
+	if m, ok := r.Context().Value(mountKey{}).(*Mount); ok {
+		return []source{m.source()}
+	}
+	return configFor(r).sources()
+}
+
+// path describes the file called name, for logs and the served file recorded
+// with the response. Files on disk are given by their absolute path
+func (s source) path(name string) string {
+	if s.dir == "" {
+		return path.Join(s.name, name)
+	}
+	abs, err := filepath.Abs(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return filepath.Join(s.dir, filepath.FromSlash(name))
+	}
+	return abs
+}
+
+// contains reports whether the file called name is inside the directory on
+// disk once symlinks are resolved, os.DirFS follows links that point anywhere.
+// A name that doesn't exist is contained, as there is nothing to serve, and
+// fs.FS trees can't refer outside themselves
+func (s source) contains(name string) bool {
+	if s.dir == "" {
+		return true
+	}
+	root, err := filepath.EvalSymlinks(s.dir)
+	if err != nil {
+		return false
+	}
+	target, err := filepath.EvalSymlinks(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return false
+	}
+	if target, err = filepath.Abs(target); err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// forbidden responds to a request that resolved outside of the directory it
+// was served from
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	configFor(r).logger().Printf("path escapes the served directory: %s", r.URL.Path)
+	respondError(w, r, http.StatusForbidden, "forbidden")
+}
+
+// fsName converts a request path to the name of a file in a source
+func fsName(urlPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
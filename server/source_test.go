@@ -0,0 +1,124 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// symlink links name in dir to target, skipping the test where links can't
+// be made
+func symlink(t *testing.T, target, dir, name string) {
+	t.Helper()
+	if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+		t.Skipf("can't make symlinks: %v", err)
+	}
+}
+
+func TestSymlinkEscape(t *testing.T) {
+	outside := writeTree(t, map[string]string{"secret.txt": "secret", "sub/secret.txt": "secret"})
+	dir := writeTree(t, map[string]string{"page.txt": "page", "inner/file.txt": "inner"})
+	symlink(t, filepath.Join(outside, "secret.txt"), dir, "escape.txt")
+	symlink(t, filepath.Join(outside, "sub"), dir, "escape")
+	symlink(t, "page.txt", dir, "alias.txt")
+	symlink(t, "inner", dir, "alias")
+	s := newTestServer(t, testConfig(dir))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/escape.txt", 403, "forbidden"},
+		{"/escape/", 403, "forbidden"},
+		{"/escape/secret.txt", 403, "forbidden"},
+		{"/alias.txt", 200, "page"},
+		{"/alias/file.txt", 200, "inner"},
+		{"/alias/", 200, "file.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			expect(t, get(s, "GET", tt.path), tt.status, tt.body)
+		})
+	}
+
+	// the link is still listed, only following it is refused
+	expect(t, get(s, "GET", "/"), 200, "escape.txt")
+}
+
+func TestSymlinkEscapeMerged(t *testing.T) {
+	outside := writeTree(t, map[string]string{"secret.txt": "secret"})
+	first := writeTree(t, map[string]string{"a.txt": "a"})
+	second := t.TempDir()
+	symlink(t, outside, second, "docs")
+	conf := testConfig(first, second)
+	conf.MergeListings = true
+	s := newTestServer(t, conf)
+
+	expect(t, get(s, "GET", "/docs/"), 403, "forbidden")
+	expect(t, get(s, "GET", "/docs/secret.txt"), 403, "forbidden")
+	expect(t, get(s, "GET", "/"), 200, "a.txt")
+}
+
+func TestServedDirIsSymlink(t *testing.T) {
+	real := writeTree(t, map[string]string{"a.txt": "a"})
+	dir := t.TempDir()
+	symlink(t, real, dir, "site")
+	s := newTestServer(t, testConfig(filepath.Join(dir, "site")))
+
+	expect(t, get(s, "GET", "/a.txt"), 200, "a")
+	expect(t, get(s, "GET", "/"), 200, "a.txt")
+}
+
+func TestEncodedTraversal(t *testing.T) {
+	parent := writeTree(t, map[string]string{"secret.txt": "secret", "site/page.txt": "page"})
+	s := newTestServer(t, testConfig(filepath.Join(parent, "site")))
+
+	for _, target := range []string{
+		"/../secret.txt",
+		"/%2e%2e/secret.txt",
+		"/%2E%2E%2Fsecret.txt",
+		"/sub/..%2f..%2fsecret.txt",
+		"/%5c..%5csecret.txt",
+	} {
+		t.Run(target, func(t *testing.T) {
+			w := get(s, "GET", target)
+			if w.Code != 400 && w.Code != 403 && w.Code != 404 {
+				t.Errorf("status = %d, want the request refused", w.Code)
+			}
+			if w.Body.String() == "secret" {
+				t.Error("served the file outside the directory")
+			}
+		})
+	}
+}
+
+func TestSourceContains(t *testing.T) {
+	outside := writeTree(t, map[string]string{"secret.txt": ""})
+	dir := writeTree(t, map[string]string{"a.txt": "", "sub/b.txt": ""})
+	symlink(t, outside, dir, "out")
+	symlink(t, "sub", dir, "in")
+	src := dirSource(dir)
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{".", true},
+		{"a.txt", true},
+		{"sub/b.txt", true},
+		{"in/b.txt", true},
+		{"missing.txt", true},
+		{"out", false},
+		{"out/secret.txt", false},
+		{"out/missing.txt", true},
+	}
+	for _, tt := range tests {
+		if got := src.contains(tt.name); got != tt.want {
+			t.Errorf("contains(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if !fsSource(FS{Name: "fs", FS: os.DirFS(outside)}).contains("secret.txt") {
+		t.Error("an fs.FS doesn't contain its own files")
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSSIDepth is how deeply includes may nest, which stops a page that
+	// includes itself
+	maxSSIDepth = 8
+	// maxSSISize is the largest page processed or produced, which stops a
+	// page that includes itself several times from growing exponentially
+	maxSSISize = 10 << 20
+	// ssiError replaces a directive that failed, as Apache does
+	ssiError = "[an error occurred while processing this directive]"
+	// ssiTimeFormat is Apache's default timefmt
+	ssiTimeFormat = "Monday, 02-Jan-2006 15:04:05 MST"
+)
+
+var (
+	ssiDirective = regexp.MustCompile(`<!--#([a-z]+)((?:\s+[a-z]+=(?:"[^"]*"|'[^']*'))*)\s*-->`)
+	ssiAttr      = regexp.MustCompile(`([a-z]+)=(?:"([^"]*)"|'([^']*)')`)
+)
+
+// ssiExtensions returns the extensions of the files processed with SSI
+func (c *Config) ssiExtensions() []string {
+	if c.SSIExt == "" {
+		return []string{".shtml"}
+	}
+	exts := strings.Split(c.SSIExt, ",")
+	for i, ext := range exts {
+		exts[i] = strings.TrimSpace(ext)
+	}
+	return exts
+}
+
+// usesSSI reports whether the file called name is processed with SSI
+func (c *Config) usesSSI(name string) bool {
+	if !c.SSI {
+		return false
+	}
+	ext := path.Ext(name)
+	for _, e := range c.ssiExtensions() {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// ssiPage is a page being processed, includes are looked up in the same
+// sources as the page itself
+type ssiPage struct {
+	r       *http.Request
+	sources []source
+	// uri is DOCUMENT_URI, the path of the page requested rather than of
+	// an included file
+	uri  string
+	size int
+	// logged are the failures already logged, a page that includes itself
+	// fails the same way many times
+	logged map[string]bool
+}
+
+// serveSSI processes and sends the file called name. The response depends on
+// the files included, so it has no Last-Modified and conditional requests
+// always get the whole page
+func serveSSI(w http.ResponseWriter, r *http.Request, name string, stat fs.FileInfo, file io.Reader) {
+	content, err := io.ReadAll(io.LimitReader(file, maxSSISize+1))
+	if err != nil {
+		configFor(r).logger().Printf("ssi %s: %s", name, err)
+		respondError(w, r, http.StatusInternalServerError, "reading file failed")
+		return
+	}
+	if len(content) > maxSSISize {
+		respondError(w, r, http.StatusInternalServerError, "page is too large for SSI")
+		return
+	}
+	page := &ssiPage{
+		r:       r,
+		sources: requestSources(r),
+		uri:     mountPrefix(r) + r.URL.Path,
+		logged:  map[string]bool{},
+	}
+	var out bytes.Buffer
+	page.process(&out, content, name, stat.ModTime(), 0)
+
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	http.ServeContent(w, r, stat.Name(), time.Time{}, bytes.NewReader(out.Bytes()))
+}
+
+// process writes content to out with its directives replaced. name and
+// modTime are those of the file content is from, depth is how many includes
+// deep it is
+func (p *ssiPage) process(out *bytes.Buffer, content []byte, name string, modTime time.Time, depth int) {
+	last := 0
+	for _, match := range ssiDirective.FindAllSubmatchIndex(content, -1) {
+		out.Write(content[last:match[0]])
+		last = match[1]
+
+		directive := string(content[match[2]:match[3]])
+		attrs := map[string]string{}
+		for _, attr := range ssiAttr.FindAllSubmatch(content[match[4]:match[5]], -1) {
+			attrs[string(attr[1])] = string(attr[2]) + string(attr[3])
+		}
+		switch directive {
+		case "include":
+			p.include(out, attrs, name, depth)
+		case "echo":
+			out.WriteString(template.HTMLEscapeString(p.variable(attrs["var"], modTime)))
+		default:
+			// left for whatever else reads the page, as Apache does with
+			// directives it doesn't know
+			out.Write(content[match[0]:match[1]])
+		}
+	}
+	out.Write(content[last:])
+}
+
+// variable returns the value of an echo var
+func (p *ssiPage) variable(name string, modTime time.Time) string {
+	switch name {
+	case "DOCUMENT_URI":
+		return p.uri
+	case "DATE_LOCAL":
+		return time.Now().Format(ssiTimeFormat)
+	case "LAST_MODIFIED":
+		if modTime = configFor(p.r).MTime.of(modTime); !modTime.IsZero() {
+			return modTime.Format(ssiTimeFormat)
+		}
+	}
+	return "(none)"
+}
+
+// include writes the file given by a virtual or file attribute, processing it
+// too if it has an SSI extension. virtual is a URL path, relative to the
+// including file's directory unless it starts with /. file is a path relative
+// to that directory that can't go above it
+func (p *ssiPage) include(out *bytes.Buffer, attrs map[string]string, name string, depth int) {
+	conf := configFor(p.r)
+	dir := path.Dir("/" + name)
+	var target string
+	if virtual, ok := attrs["virtual"]; ok {
+		virtual, _, _ = strings.Cut(virtual, "?")
+		if strings.HasPrefix(virtual, "/") && !validPath(virtual) {
+			p.fail(out, name, virtual, errors.New("invalid path"))
+			return
+		}
+		target = path.Join(dir, virtual)
+	} else if file, ok := attrs["file"]; ok {
+		if strings.HasPrefix(file, "/") || !validPath(file) {
+			p.fail(out, name, file, errors.New("file must be relative and can't contain .."))
+			return
+		}
+		target = path.Join(dir, file)
+	} else {
+		p.fail(out, name, "", errors.New("include needs virtual or file"))
+		return
+	}
+	if conf.BlockDotfiles && dotPath(target) {
+		p.fail(out, name, target, errors.New("dotfiles are blocked"))
+		return
+	}
+	if !validOSPath(target) {
+		p.fail(out, name, target, errors.New("invalid path"))
+		return
+	}
+	if depth >= maxSSIDepth {
+		p.fail(out, name, target, fmt.Errorf("includes nested more than %d deep", maxSSIDepth))
+		return
+	}
+	if conf.CGI.matches(target) {
+		p.fail(out, name, target, errors.New("can't include a CGI script"))
+		return
+	}
+
+	included := fsName(target)
+	content, modTime, err := p.read(included)
+	if err == nil && p.size+len(content) > maxSSISize {
+		err = fmt.Errorf("page would be larger than %d bytes", maxSSISize)
+	}
+	if err != nil {
+		p.fail(out, name, target, err)
+		return
+	}
+	p.size += len(content)
+	if conf.usesSSI(included) {
+		p.process(out, content, included, modTime, depth+1)
+	} else {
+		out.Write(content)
+	}
+}
+
+// read returns the file called name from the first source that has it
+func (p *ssiPage) read(name string) ([]byte, time.Time, error) {
+	type file struct {
+		content []byte
+		modTime time.Time
+	}
+	for _, src := range p.sources {
+		f, err := fsCall(p.r, func() (file, error) {
+			stat, err := fs.Stat(src.fsys, name)
+			if err != nil {
+				return file{}, err
+			}
+			if ignoredFile(p.r, src, name, stat.IsDir()) {
+				return file{}, fs.ErrNotExist
+			}
+			if !src.contains(name) {
+				return file{}, errors.New("outside the served directory")
+			}
+			if !stat.Mode().IsRegular() {
+				return file{}, errors.New("not a file")
+			}
+			content, err := fs.ReadFile(src.fsys, name)
+			return file{content, stat.ModTime()}, err
+		}, nil)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return f.content, f.modTime, err
+	}
+	return nil, time.Time{}, fs.ErrNotExist
+}
+
+func (p *ssiPage) fail(out *bytes.Buffer, name, target string, err error) {
+	out.WriteString(ssiError)
+	message := fmt.Sprintf("ssi %s: include %q: %s", name, target, err)
+	if !p.logged[message] {
+		p.logged[message] = true
+		configFor(p.r).logger().Print(message)
+	}
+}
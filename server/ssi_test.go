@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+// ssiServer serves files with SSI on, logging to logs if it isn't nil
+func ssiServer(t *testing.T, files map[string]string, logs *bytes.Buffer) *Server {
+	conf := testConfig(writeTree(t, files))
+	conf.SSI = true
+	if logs != nil {
+		conf.Logger = log.New(logs, "", 0)
+	}
+	return newTestServer(t, conf)
+}
+
+func TestSSI(t *testing.T) {
+	s := ssiServer(t, map[string]string{
+		"index.shtml":        `<!--#include virtual="/parts/header.shtml" -->body<!--#include file="parts/footer.html" -->`,
+		"parts/header.shtml": `[header <!--#include virtual="nav.html" -->]`,
+		"parts/nav.html":     `nav <!--#include virtual="/never.html" -->`,
+		"parts/footer.html":  `[footer]`,
+		"missing.shtml":      `a<!--#include virtual="/nothing.html" -->b`,
+		"escape.shtml":       `<!--#include file="../etc/passwd" --><!--#include file="/abs.html" -->`,
+		"noattr.shtml":       `<!--#include -->`,
+		"echo.shtml":         `<!--#echo var="DOCUMENT_URI" --> <!--#echo var="NOPE" -->`,
+		"unknown.shtml":      `<!--#exec cmd="ls" -->`,
+		"plain.html":         `<!--#include virtual="/parts/footer.html" -->`,
+		"sub/rel.shtml":      `<!--#include virtual="../parts/footer.html" -->`,
+		"<b>.shtml":          `<!--#echo var="DOCUMENT_URI" -->`,
+	}, nil)
+
+	tests := []struct {
+		path, body string
+	}{
+		// nav.html isn't processed, its directive is sent as it is
+		{"/index.shtml", `[header nav <!--#include virtual="/never.html" -->]body[footer]`},
+		{"/missing.shtml", "a" + ssiError + "b"},
+		{"/escape.shtml", ssiError + ssiError},
+		{"/noattr.shtml", ssiError},
+		{"/echo.shtml", "/echo.shtml (none)"},
+		{"/unknown.shtml", `<!--#exec cmd="ls" -->`},
+		{"/plain.html", `<!--#include virtual="/parts/footer.html" -->`},
+		{"/sub/rel.shtml", "[footer]"},
+		{"/%3Cb%3E.shtml", "/&lt;b&gt;.shtml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := get(s, "GET", tt.path)
+			if w.Code != 200 || w.Body.String() != tt.body {
+				t.Errorf("GET %s = %d %q, want %q", tt.path, w.Code, w.Body.String(), tt.body)
+			}
+		})
+	}
+	w := get(s, "GET", "/index.shtml")
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if lm := w.Header().Get("Last-Modified"); lm != "" {
+		t.Errorf("Last-Modified = %q, want none as the includes can change", lm)
+	}
+}
+
+func TestSSIRecursion(t *testing.T) {
+	var logs bytes.Buffer
+	s := ssiServer(t, map[string]string{
+		"self.shtml": `x<!--#include virtual="self.shtml" -->`,
+		"a.shtml":    `a<!--#include virtual="b.shtml" -->`,
+		"b.shtml":    `b<!--#include virtual="a.shtml" -->`,
+	}, &logs)
+
+	want := strings.Repeat("x", maxSSIDepth+1) + ssiError
+	if w := get(s, "GET", "/self.shtml"); w.Body.String() != want {
+		t.Errorf("self include = %q, want %q", w.Body.String(), want)
+	}
+	want = strings.Repeat("ab", (maxSSIDepth+1)/2) + "a" + ssiError
+	if w := get(s, "GET", "/a.shtml"); w.Body.String() != want {
+		t.Errorf("mutual include = %q, want %q", w.Body.String(), want)
+	}
+	// the same failure is only logged once per page
+	if n := strings.Count(logs.String(), "nested more than"); n != 2 {
+		t.Errorf("logged the depth failure %d times, want once a page:\n%s", n, logs.String())
+	}
+}
+
+func TestSSIBomb(t *testing.T) {
+	// each level doubles the page, it stops at the size limit rather than
+	// growing to 2^maxSSIDepth times the chunk
+	chunk := strings.Repeat("0123456789abcdef", 1<<16)
+	s := ssiServer(t, map[string]string{
+		"bomb.shtml": `<!--#include virtual="chunk.txt" --><!--#include virtual="bomb.shtml" --><!--#include virtual="bomb.shtml" -->`,
+		"chunk.txt":  chunk,
+	}, nil)
+
+	w := get(s, "GET", "/bomb.shtml")
+	if w.Code != 200 {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if w.Body.Len() > maxSSISize+len(chunk) {
+		t.Errorf("page is %d bytes, want at most about %d", w.Body.Len(), maxSSISize)
+	}
+	if !strings.Contains(w.Body.String(), ssiError) {
+		t.Error("the includes past the limit didn't fail")
+	}
+}
+
+func TestSSIExtensions(t *testing.T) {
+	conf := testConfig(writeTree(t, map[string]string{
+		"a.html":  `<!--#echo var="DOCUMENT_URI" -->`,
+		"b.shtml": `<!--#echo var="DOCUMENT_URI" -->`,
+	}))
+	conf.SSI = true
+	conf.SSIExt = ".html, .HTM"
+	s := newTestServer(t, conf)
+
+	expect(t, get(s, "GET", "/a.html"), 200, "/a.html")
+	expect(t, get(s, "GET", "/b.shtml"), 200, "<!--#echo")
+	if !conf.usesSSI("page.htm") || conf.usesSSI("page.txt") {
+		t.Error("usesSSI doesn't match the extensions case insensitively")
+	}
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTracked bounds the number of distinct paths and clients remembered for
+// the shutdown summary, so a public instance being scanned can't grow them
+// without limit
+const maxTracked = 10000
+
+// stats aggregates the requests handled over the lifetime of the server. The
+// totals are expvar values so they can be published without being counted
+// twice
+type stats struct {
+	start    time.Time
+	requests expvar.Int
+	classes  [6]expvar.Int
+	bytes    expvar.Int
+	// disconnects counts responses cut short by the client going away
+	disconnects expvar.Int
+
+	mu      sync.Mutex
+	paths   map[string]int64
+	clients map[string]struct{}
+	// dirs counts the requests answered from each served directory
+	dirs map[string]*dirUsage
+}
+
+// dirUsage is the number of requests answered from a served directory and
+// the bytes sent for them
+type dirUsage struct {
+	Requests int64 `json:"requests"`
+	Bytes    int64 `json:"bytes"`
+}
+
+func newStats() *stats {
+	return &stats{
+		start:   time.Now(),
+		paths:   map[string]int64{},
+		clients: map[string]struct{}{},
+		dirs:    map[string]*dirUsage{},
+	}
+}
+
+// record adds a completed request to the totals
+func (s *stats) record(r *http.Request, rec *responseRecorder) {
+	client := clientIP(r)
+
+	s.requests.Add(1)
+	if class := rec.Status() / 100; class > 0 && class < len(s.classes) {
+		s.classes[class].Add(1)
+	}
+	s.bytes.Add(rec.written)
+	if rec.disconnected(r) {
+		s.disconnects.Add(1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.paths[r.URL.Path]; ok || len(s.paths) < maxTracked {
+		s.paths[r.URL.Path]++
+	}
+	if len(s.clients) < maxTracked {
+		s.clients[client] = struct{}{}
+	}
+	if rec.source != "" {
+		usage := s.dirs[rec.source]
+		if usage == nil {
+			usage = &dirUsage{}
+			s.dirs[rec.source] = usage
+		}
+		usage.Requests++
+		usage.Bytes += rec.written
+	}
+}
+
+// pathCount is the number of requests made for a single path
+type pathCount struct {
+	Path  string
+	Count int64
+}
+
+// distinctClients is the number of client addresses seen, saturating at
+// maxTracked
+func (s *stats) distinctClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// usageByDir returns the requests and bytes served from each directory
+func (s *stats) usageByDir() map[string]dirUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := make(map[string]dirUsage, len(s.dirs))
+	for dir, u := range s.dirs {
+		usage[dir] = *u
+	}
+	return usage
+}
+
+// topPaths returns the n most requested paths, most requested first
+func (s *stats) topPaths(n int) []pathCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make([]pathCount, 0, len(s.paths))
+	for path, count := range s.paths {
+		counts = append(counts, pathCount{path, count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Path < counts[j].Path
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// logSummary prints a recap of the activity since the server started
+func (s *stats) logSummary(logger *log.Logger) {
+	top := s.topPaths(5)
+	usage := s.usageByDir()
+	dirs := make([]string, 0, len(usage))
+	for dir := range usage {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	classes := make([]string, 0, len(s.classes))
+	for class := 1; class < len(s.classes); class++ {
+		classes = append(classes, fmt.Sprintf("%dxx: %d", class, s.classes[class].Value()))
+	}
+	clients := fmt.Sprint(len(s.clients))
+	if len(s.clients) >= maxTracked {
+		clients += "+"
+	}
+
+	logger.Printf("requests: %d (%s)", s.requests.Value(), strings.Join(classes, ", "))
+	logger.Printf("served: %s", formatBytes(s.bytes.Value()))
+	if disconnects := s.disconnects.Value(); disconnects > 0 {
+		logger.Printf("disconnected: %d", disconnects)
+	}
+	for i, path := range top {
+		logger.Printf("top path %d: %s (%d)", i+1, path.Path, path.Count)
+	}
+	if len(dirs) > 1 {
+		for _, dir := range dirs {
+			logger.Printf("dir %s: %d requests, %s", dir, usage[dir].Requests, formatBytes(usage[dir].Bytes))
+		}
+	}
+	logger.Printf("clients: %s", clients)
+	logger.Printf("uptime: %s", time.Since(s.start).Round(time.Second))
+}
+
+// formatBytes returns a human readable size, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// responseRecorder wraps a ResponseWriter to capture the status code, the
+// number of body bytes written and when the first of them was, along with
+// the local file that was served if any and the first write error
+type responseRecorder struct {
+	http.ResponseWriter
+	status    int
+	written   int64
+	err       error
+	start     time.Time
+	firstByte time.Time
+	file      string
+	// source is the served directory that answered, for --expvar
+	source string
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, start: time.Now()}
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.firstByte.IsZero() {
+		rec.firstByte = time.Now()
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.written += int64(n)
+	if err != nil && rec.err == nil {
+		rec.err = err
+	}
+	return n, err
+}
+
+// ReadFrom hands the copy to the underlying writer, so that files sent by
+// http.ServeContent reach net/http's ReadFrom and go out with sendfile(2)
+// rather than through a buffer. The first byte is counted as written when the
+// copy starts
+func (rec *responseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.firstByte.IsZero() {
+		rec.firstByte = time.Now()
+	}
+	n, err := io.Copy(rec.ResponseWriter, src)
+	rec.written += n
+	if err != nil && rec.err == nil {
+		rec.err = err
+	}
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// disconnected reports whether the client went away before the response was
+// complete, either making a write fail or cancelling the request
+func (rec *responseRecorder) disconnected(r *http.Request) bool {
+	return rec.err != nil || r.Context().Err() != nil
+}
+
+// recorderOf returns the responseRecorder w is or wraps, such as a page
+// having liveScript added, nil if there is none
+func recorderOf(w http.ResponseWriter) *responseRecorder {
+	for {
+		switch v := w.(type) {
+		case *responseRecorder:
+			return v
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// logDisconnect notes a download of size bytes that the client aborted. This
+// is routine for video and flaky connections, so it's only logged with
+// --verbose
+func logDisconnect(w http.ResponseWriter, r *http.Request, size int64) {
+	conf := configFor(r)
+	rec := recorderOf(w)
+	if rec == nil || !conf.Verbose || !rec.disconnected(r) {
+		return
+	}
+	conf.logger().Printf("%s ✕ client disconnected after %s of %s", remoteAddr(r), formatBytes(rec.written), formatBytes(size))
+}
+
+// abortResponse marks the response to w as failed, so that it isn't counted
+// as complete, and aborts the connection so the client knows it was cut short
+func abortResponse(w http.ResponseWriter, err error) {
+	if rec := recorderOf(w); rec != nil && rec.err == nil {
+		rec.err = err
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// setServedFile records the local path of the file served in response to w
+func setServedFile(w http.ResponseWriter, file string) {
+	if rec := recorderOf(w); rec != nil {
+		rec.file = file
+	}
+}
+
+// setServedSource records the served directory that answered w, which its
+// bytes are counted against
+func setServedSource(w http.ResponseWriter, name string) {
+	if rec := recorderOf(w); rec != nil {
+		rec.source = name
+	}
+}
+
+// Status returns the status code sent, defaulting to 200 if the handler
+// never wrote anything
+func (rec *responseRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogSummary(t *testing.T) {
+	first := writeTree(t, map[string]string{"a.txt": "aaaa", "b.txt": "bb"})
+	second := writeTree(t, map[string]string{"c.txt": "c"})
+	var logs bytes.Buffer
+	conf := testConfig(first, second)
+	conf.Logger = log.New(&logs, "", 0)
+	s := newTestServer(t, conf)
+
+	for _, path := range []string{"/a.txt", "/a.txt", "/a.txt", "/b.txt", "/c.txt", "/missing", "/missing"} {
+		get(s, "GET", path)
+	}
+	get(s, "GET", "/a.txt", "X-Forwarded-For", "198.51.100.7")
+	logs.Reset()
+	s.LogSummary()
+	summary := logs.String()
+
+	for _, want := range []string{
+		"requests: 8 (1xx: 0, 2xx: 6, 3xx: 0, 4xx: 2, 5xx: 0)\n",
+		"top path 1: /a.txt (4)\n",
+		"top path 2: /missing (2)\n",
+		"top path 3: /b.txt (1)\n",
+		"top path 4: /c.txt (1)\n",
+		"dir " + first + ": 5 requests, 18 B\n",
+		"dir " + second + ": 1 requests, 1 B\n",
+		// without --trust-proxy the forwarded address is the same client
+		"clients: 1\n",
+		"uptime: ",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary has no %q:\n%s", want, summary)
+		}
+	}
+	if strings.Contains(summary, "disconnected") {
+		t.Errorf("summary reports disconnects there weren't:\n%s", summary)
+	}
+}
+
+func TestLogSummaryOneDir(t *testing.T) {
+	var logs bytes.Buffer
+	conf := testConfig(writeTree(t, map[string]string{"a.txt": "a"}))
+	conf.Logger = log.New(&logs, "", 0)
+	s := newTestServer(t, conf)
+	get(s, "GET", "/a.txt")
+	logs.Reset()
+	s.LogSummary()
+
+	if strings.Contains(logs.String(), "dir ") {
+		t.Errorf("a single directory is broken down:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "served: 1 B\n") {
+		t.Errorf("summary has no bytes served:\n%s", logs.String())
+	}
+}
+
+func TestSummaryBounded(t *testing.T) {
+	var logs bytes.Buffer
+	conf := testConfig(t.TempDir())
+	conf.Logger = log.New(&logs, "", 0)
+	s := newTestServer(t, conf)
+	for i := range maxTracked + 50 {
+		r := httptest.NewRequest("GET", fmt.Sprintf("/%d", i), nil)
+		r.RemoteAddr = fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if paths, clients := len(s.stats.paths), s.stats.distinctClients(); paths != maxTracked || clients != maxTracked {
+		t.Errorf("tracked %d paths and %d clients, want %d of each", paths, clients, maxTracked)
+	}
+	logs.Reset()
+	s.LogSummary()
+	if want := fmt.Sprintf("clients: %d+\n", maxTracked); !strings.Contains(logs.String(), want) {
+		t.Errorf("summary has no %q:\n%s", want, logs.String())
+	}
+}
+
+func TestVars(t *testing.T) {
+	conf := testConfig(writeTree(t, map[string]string{"a.txt": "aaaa"}))
+	conf.TrackHits = true
+	s := newTestServer(t, conf)
+	vars := s.Vars()
+	get(s, "GET", "/a.txt")
+	get(s, "GET", "/a.txt")
+	get(s, "GET", "/missing")
+
+	var got struct {
+		Requests  int64            `json:"requests"`
+		Responses map[string]int64 `json:"responses"`
+		Bytes     int64            `json:"bytes"`
+		Clients   int              `json:"clients"`
+		Hits      []Hit            `json:"hits"`
+	}
+	if err := json.Unmarshal([]byte(vars.String()), &got); err != nil {
+		t.Fatalf("%v: %s", err, vars.String())
+	}
+	// the variables are live, not a copy taken when Vars was called
+	if got.Requests != 3 || got.Responses["2xx"] != 2 || got.Responses["4xx"] != 1 {
+		t.Errorf("requests = %d, responses = %v, want 3 with 2 2xx and 1 4xx", got.Requests, got.Responses)
+	}
+	if got.Bytes != 8+int64(len("404 page not found\n")) || got.Clients != 1 {
+		t.Errorf("bytes = %d, clients = %d", got.Bytes, got.Clients)
+	}
+	if len(got.Hits) != 1 || got.Hits[0] != (Hit{"/a.txt", 2, 8}) {
+		t.Errorf("hits = %+v", got.Hits)
+	}
+	for _, class := range []string{"1xx", "3xx", "5xx"} {
+		if n, ok := got.Responses[class]; !ok || n != 0 {
+			t.Errorf("responses[%s] = %d, %v, want 0", class, n, ok)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 40, "3.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
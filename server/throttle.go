@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// throttleChunks is how many writes a second of throttled output is split
+// into, so that progress is steady rather than in bursts
+const throttleChunks = 20
+
+// Rate is a flag.Value for --throttle, in bytes a second. It takes bits a
+// second as bps, kbps, Mbps or Gbps, which are powers of 1000, or bytes as
+// B, KB, MB or GB, powers of 1024, either optionally followed by /s:
+//
+//	--throttle 256kbps
+//	--throttle 1.5MB/s
+type Rate int64
+
+func (rate *Rate) String() string {
+	if *rate == 0 {
+		return ""
+	}
+	return strings.ReplaceAll(formatBytes(int64(*rate)), " ", "") + "/s"
+}
+
+func (rate *Rate) Set(value string) error {
+	number := strings.TrimSuffix(strings.TrimSpace(value), "/s")
+	lower := strings.ToLower(number)
+	multiplier := 1.0
+	for _, unit := range []struct {
+		suffix string
+		size   float64
+	}{
+		{"gbps", 1e9 / 8}, {"mbps", 1e6 / 8}, {"kbps", 1e3 / 8}, {"bps", 1.0 / 8},
+		{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"b", 1},
+	} {
+		if strings.HasSuffix(lower, unit.suffix) {
+			number, multiplier = number[:len(number)-len(unit.suffix)], unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	bytes := n * multiplier
+	if err != nil || !(bytes >= 1 && bytes <= 1<<40) {
+		return fmt.Errorf("%q is not a rate such as 256kbps, 10Mbps or 500KB/s", value)
+	}
+	*rate = Rate(bytes)
+	return nil
+}
+
+// throttledWriter sends a response body no faster than rate, to try out
+// download progress and buffering on a slow connection. It isn't a rate
+// limit, each response is throttled on its own
+type throttledWriter struct {
+	http.ResponseWriter
+	r     *http.Request
+	rate  int64
+	start time.Time
+	sent  int64
+}
+
+// throttle returns w slowed down to --throttle, or w if it isn't set
+func throttle(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	rate := int64(configFor(r).Throttle)
+	if rate <= 0 {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, r: r, rate: rate}
+}
+
+// Write sends p a chunk at a time, flushing each one and then sleeping until
+// the bytes sent so far are due at the rate, or the request is cancelled
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	chunk := max(int(t.rate/throttleChunks), 1)
+	written := 0
+	for len(p) > 0 {
+		n, err := t.ResponseWriter.Write(p[:min(chunk, len(p))])
+		written += n
+		t.sent += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		http.NewResponseController(t.ResponseWriter).Flush()
+
+		due := t.start.Add(time.Duration(float64(t.sent) / float64(t.rate) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-t.r.Context().Done():
+				timer.Stop()
+				return written, t.r.Context().Err()
+			}
+		}
+	}
+	return written, nil
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (t *throttledWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
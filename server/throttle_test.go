@@ -0,0 +1,34 @@
+package server
+
+import "testing"
+
+func TestRateSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Rate
+	}{
+		{"4096", 4096},
+		{"512B/s", 512},
+		{"500KB/s", 500 << 10},
+		{"500kb", 500 << 10},
+		{"1.5MB/s", 3 << 19},
+		{"2GB/s", 2 << 30},
+		{"256kbps", 32000},
+		{"10Mbps", 1250000},
+		{"1Gbps/s", 125000000},
+		{"800bps", 100},
+		{" 64 KB/s ", 64 << 10},
+	}
+	for _, tt := range tests {
+		var rate Rate
+		if err := rate.Set(tt.value); err != nil || rate != tt.want {
+			t.Errorf("Set(%q) = %d, %v, want %d", tt.value, rate, err, tt.want)
+		}
+	}
+	for _, value := range []string{"", "fast", "KB/s", "-1KB/s", "0", "4bps", "2TB/s", "10Mbit", "NaN"} {
+		var rate Rate
+		if err := rate.Set(value); err == nil {
+			t.Errorf("Set(%q) = %d, want an error", value, rate)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultLargeThreshold is the size over which a file is a large
+	// transfer if LargeThreshold isn't set
+	defaultLargeThreshold = 100 << 20
+	// largeTransferRetry is sent as the Retry-After of a request turned away
+	// because every large transfer slot was taken, in seconds
+	largeTransferRetry = "10"
+)
+
+// ByteSize is a flag.Value for sizes such as --large-threshold, a number of
+// bytes with an optional K, M, G or T suffix, all powers of 1024, which may
+// be followed by B or iB:
+//
+//	--large-threshold 512MiB
+type ByteSize int64
+
+func (b *ByteSize) String() string {
+	if *b == 0 {
+		return ""
+	}
+	return strings.ReplaceAll(formatBytes(int64(*b)), " ", "")
+}
+
+func (b *ByteSize) Set(value string) error {
+	number := strings.TrimSpace(value)
+	upper := strings.ToUpper(number)
+	upper = strings.TrimSuffix(strings.TrimSuffix(upper, "B"), "I")
+	multiplier := int64(1)
+	if i := strings.IndexAny(upper, "KMGT"); i >= 0 && i == len(upper)-1 {
+		multiplier = 1 << (10 * (strings.IndexByte("KMGT", upper[i]) + 1))
+		upper = upper[:i]
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || !(n >= 0 && n*float64(multiplier) <= 1<<62) {
+		return fmt.Errorf("%q is not a size such as 4096, 512K or 1.5GiB", value)
+	}
+	*b = ByteSize(n * float64(multiplier))
+	return nil
+}
+
+// transfersKey is the request context key of the Server's large transfer
+// slots
+type transfersKey struct{}
+
+// largeThreshold returns LargeThreshold, defaultLargeThreshold if it's unset
+func (c *Config) largeThreshold() int64 {
+	if c.LargeThreshold <= 0 {
+		return defaultLargeThreshold
+	}
+	return int64(c.LargeThreshold)
+}
+
+// acquireTransfer takes one of the MaxLargeTransfers slots for sending a file
+// of size bytes, if it is larger than LargeThreshold, so that a few clients
+// downloading huge files in parallel can't starve everyone else. When every
+// slot is taken it waits up to LargeTransferWait for one, then answers with
+// a 503 and returns false. The release func must be called once the response
+// is done, which is also when the client has gone as writes then fail
+func acquireTransfer(w http.ResponseWriter, r *http.Request, size int64) (release func(), ok bool) {
+	slots, _ := r.Context().Value(transfersKey{}).(chan struct{})
+	conf := configFor(r)
+	if slots == nil || r.Method == http.MethodHead || size <= conf.largeThreshold() {
+		return func() {}, true
+	}
+	release = func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	default:
+	}
+	if conf.LargeTransferWait > 0 {
+		timer := time.NewTimer(conf.LargeTransferWait)
+		defer timer.Stop()
+		select {
+		case slots <- struct{}{}:
+			return release, true
+		case <-r.Context().Done():
+			return nil, false
+		case <-timer.C:
+		}
+	}
+	w.Header().Set("Retry-After", largeTransferRetry)
+	respondError(w, r, http.StatusServiceUnavailable, "too many large downloads, try again later")
+	return nil, false
+}
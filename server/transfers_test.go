@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockedWriter holds up a response at its first write until unblocked
+type blockedWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	unblock chan struct{}
+}
+
+func newBlockedWriter() *blockedWriter {
+	return &blockedWriter{httptest.NewRecorder(), make(chan struct{}), make(chan struct{})}
+}
+
+func (w *blockedWriter) Write(p []byte) (int, error) {
+	select {
+	case <-w.writing:
+	default:
+		close(w.writing)
+		<-w.unblock
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+// startLargeTransfer starts a download of target from s that stays in
+// progress until the returned func is called, which waits for it to end
+func startLargeTransfer(t *testing.T, s *Server, target string) (finish func() *httptest.ResponseRecorder) {
+	w := newBlockedWriter()
+	done := make(chan struct{})
+	go func() {
+		s.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		close(done)
+	}()
+	select {
+	case <-w.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the download didn't start")
+	}
+	return func() *httptest.ResponseRecorder {
+		close(w.unblock)
+		<-done
+		return w.ResponseRecorder
+	}
+}
+
+// transferServer allows one download over 1 KiB at a time
+func transferServer(t *testing.T, wait time.Duration) *Server {
+	conf := testConfig(writeTree(t, map[string]string{
+		"large.bin":  strings.Repeat("x", 4096),
+		"large2.bin": strings.Repeat("y", 4096),
+		"small.txt":  "small",
+	}))
+	conf.MaxLargeTransfers = 1
+	conf.LargeThreshold = 1024
+	conf.LargeTransferWait = wait
+	return newTestServer(t, conf)
+}
+
+func TestLargeTransfersRejected(t *testing.T) {
+	s := transferServer(t, 0)
+	finish := startLargeTransfer(t, s, "/large.bin")
+
+	w := get(s, "GET", "/large2.bin")
+	expect(t, w, 503, "too many large downloads")
+	if retry := w.Header().Get("Retry-After"); retry != largeTransferRetry {
+		t.Errorf("Retry-After = %q, want %s", retry, largeTransferRetry)
+	}
+	// small files and HEAD requests don't need a slot
+	expect(t, get(s, "GET", "/small.txt"), 200, "small")
+	expect(t, get(s, "HEAD", "/large2.bin"), 200, "")
+
+	if w := finish(); w.Code != 200 || w.Body.Len() != 4096 {
+		t.Errorf("first download = %d with %d bytes", w.Code, w.Body.Len())
+	}
+	// the slot is free again once it's done
+	expect(t, get(s, "GET", "/large2.bin"), 200, "yyyy")
+}
+
+func TestLargeTransfersWait(t *testing.T) {
+	s := transferServer(t, 5*time.Second)
+	finish := startLargeTransfer(t, s, "/large.bin")
+
+	waited := make(chan *httptest.ResponseRecorder)
+	go func() { waited <- get(s, "GET", "/large2.bin") }()
+	select {
+	case w := <-waited:
+		t.Fatalf("second download answered %d while the slot was taken", w.Code)
+	case <-time.After(100 * time.Millisecond):
+	}
+	finish()
+	select {
+	case w := <-waited:
+		expect(t, w, 200, "yyyy")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the waiting download didn't get the freed slot")
+	}
+}
+
+func TestByteSizeSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  ByteSize
+	}{
+		{"4096", 4096},
+		{"512K", 512 << 10},
+		{"512kb", 512 << 10},
+		{"1.5GiB", 3 << 29},
+		{"100MiB", 100 << 20},
+		{"2T", 2 << 40},
+		{" 10 M", 10 << 20},
+	}
+	for _, tt := range tests {
+		var b ByteSize
+		if err := b.Set(tt.value); err != nil || b != tt.want {
+			t.Errorf("Set(%q) = %d, %v, want %d", tt.value, b, err, tt.want)
+		}
+	}
+	for _, value := range []string{"", "lots", "-1", "5P", "1KK", "NaN"} {
+		var b ByteSize
+		if err := b.Set(value); err == nil {
+			t.Errorf("Set(%q) = %d, want an error", value, b)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+)
+
+// TypeOverride serves the files whose names match Pattern as Type
+type TypeOverride struct {
+	Pattern string
+	Type    string
+}
+
+// TypeList is a flag.Value for --type, which may be given more than once.
+// Patterns are path.Match patterns for file names, the first that matches
+// wins:
+//
+//	--type Dockerfile=text/plain --type '*.lock=application/json'
+type TypeList []TypeOverride
+
+func (l *TypeList) String() string {
+	types := make([]string, len(*l))
+	for i, t := range *l {
+		types[i] = t.Pattern + "=" + t.Type
+	}
+	return strings.Join(types, " ")
+}
+
+func (l *TypeList) Set(value string) error {
+	pattern, ctype, found := strings.Cut(value, "=")
+	if !found || pattern == "" || ctype == "" {
+		return fmt.Errorf("expected pattern=type, such as Dockerfile=text/plain")
+	}
+	if _, err := path.Match(pattern, ""); err != nil || strings.Contains(pattern, "/") {
+		return fmt.Errorf("%q is not a file name pattern such as *.lock", pattern)
+	}
+	if _, _, err := mime.ParseMediaType(ctype); err != nil {
+		return fmt.Errorf("%q is not a content type: %s", ctype, err)
+	}
+	*l = append(*l, TypeOverride{Pattern: pattern, Type: ctype})
+	return nil
+}
+
+// match returns the type of the first override matching the file called
+// name
+func (l TypeList) match(name string) (string, bool) {
+	for _, t := range l {
+		if ok, _ := path.Match(t.Pattern, name); ok {
+			return t.Type, true
+		}
+	}
+	return "", false
+}
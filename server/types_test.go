@@ -0,0 +1,105 @@
+package server
+
+import "testing"
+
+func TestNoSniff(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"blob":       "<html><script>alert(1)</script></html>",
+		"data.xyzzy": "<html>unknown extension</html>",
+		"page.html":  "<html>page</html>",
+		"notes.txt":  "notes",
+	})
+	sniffed := newTestServer(t, testConfig(dir))
+	conf := testConfig(dir)
+	conf.NoSniff = true
+	s := newTestServer(t, conf)
+
+	// without --no-sniff Go detects HTML from the first bytes
+	if ctype := get(sniffed, "GET", "/blob").Header().Get("Content-Type"); ctype != "text/html; charset=utf-8" {
+		t.Fatalf("sniffed Content-Type = %q, want the HTML Go detects", ctype)
+	}
+	tests := []struct {
+		path, ctype string
+	}{
+		{"/blob", "application/octet-stream"},
+		{"/data.xyzzy", "application/octet-stream"},
+		{"/page.html", "text/html; charset=utf-8"},
+		{"/notes.txt", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			for _, method := range []string{"GET", "HEAD"} {
+				w := get(s, method, tt.path)
+				if ctype := w.Header().Get("Content-Type"); ctype != tt.ctype {
+					t.Errorf("%s Content-Type = %q, want %q", method, ctype, tt.ctype)
+				}
+				if nosniff := w.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+					t.Errorf("%s X-Content-Type-Options = %q, want nosniff", method, nosniff)
+				}
+			}
+		})
+	}
+	if w := get(sniffed, "GET", "/blob"); w.Header().Get("X-Content-Type-Options") != "" {
+		t.Error("nosniff sent without --no-sniff")
+	}
+}
+
+func TestContentTypeOverrides(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"Dockerfile":        "FROM scratch",
+		"LICENSE":           "<html>not really</html>",
+		"package.json.lock": `{"lock": true}`,
+		"yarn.lock":         "# yarn",
+		"data.xyzzy":        "<html>unknown</html>",
+		"page.html":         "<html></html>",
+	})
+	conf := testConfig(dir)
+	conf.DefaultType = "text/plain; charset=utf-8"
+	for _, value := range []string{"Dockerfile=text/x-dockerfile", "*.json.lock=application/json", "*.lock=text/plain"} {
+		if err := conf.Types.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTestServer(t, conf)
+
+	tests := []struct {
+		path, ctype string
+	}{
+		// a pattern wins over --default-type and the extension
+		{"/Dockerfile", "text/x-dockerfile"},
+		{"/package.json.lock", "application/json"},
+		{"/yarn.lock", "text/plain"},
+		// --default-type is only for names without an extension
+		{"/LICENSE", "text/plain; charset=utf-8"},
+		{"/data.xyzzy", "text/html; charset=utf-8"},
+		{"/page.html", "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		if ctype := get(s, "GET", tt.path).Header().Get("Content-Type"); ctype != tt.ctype {
+			t.Errorf("%s Content-Type = %q, want %q", tt.path, ctype, tt.ctype)
+		}
+	}
+}
+
+func TestTypeListSet(t *testing.T) {
+	for _, value := range []string{"", "Dockerfile", "=text/plain", "*.lock=", "[=text/plain", "dir/*.lock=text/plain", "*.lock=not a type;"} {
+		var l TypeList
+		if err := l.Set(value); err == nil {
+			t.Errorf("--type %q was accepted", value)
+		}
+	}
+	var l TypeList
+	l.Set("*.lock=application/json")
+	l.Set("Makefile=text/plain")
+	if got := l.String(); got != "*.lock=application/json Makefile=text/plain" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestValidateDefaultType(t *testing.T) {
+	conf := testConfig(t.TempDir())
+	conf.DefaultType = "text/"
+	if _, err := conf.Validate(); err == nil {
+		t.Error("--default-type text/ was accepted")
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// The build serve was made from, set at build time with -ldflags
+// "-X github.com/Alexendoo/serve/server.Version=..." etc, see compile.sh. When
+// unset they are read from the build info embedded by the go tool, if any
+var (
+	Version = "HEAD"
+	Commit  = ""
+	Date    = ""
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if Version == "HEAD" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && Commit == "":
+			Commit = setting.Value
+		case setting.Key == "vcs.time" && Date == "":
+			Date = setting.Value
+		}
+	}
+}
+
+// ShortCommit returns the abbreviated commit serve was built from
+func ShortCommit() string {
+	if Commit == "" {
+		return "unknown"
+	}
+	if len(Commit) > 12 {
+		return Commit[:12]
+	}
+	return Commit
+}
+
+// serverHeader is the value of the Server response header
+func serverHeader() string {
+	return fmt.Sprintf("serve/%s (%s; %s)", Version, ShortCommit(), runtime.Version())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// sharing is set by serve share, getFlags then takes its argument as the file
+// to share rather than directories to serve
+var sharing bool
+
+var errShareArgs = errors.New("share takes a single FILE to share")
+
+// share serves conf.Share at a random link until it has been downloaded once
+// or --ttl has passed
+func share(conf *config) {
+	srv := newServer(conf)
+	listener := conf.Listeners()[0]
+	link := url.URL{
+		Scheme: listener.Scheme(),
+		Host:   listener.Addr,
+		Path:   srv.ShareLink(),
+	}
+	fmt.Println(link.String())
+	if conf.Copy {
+		copyToClipboard(link.String())
+	}
+	if conf.QR {
+		code, err := encodeQR([]byte(link.String()))
+		if err != nil {
+			log.Printf("--qr: %s", err)
+		} else {
+			code.print(os.Stdout)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := srv.ListenAndServe(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	var expired <-chan time.Time
+	if conf.TTL > 0 {
+		expired = time.After(conf.TTL)
+	}
+	select {
+	case <-srv.Shared():
+		log.Println("downloaded, no longer sharing")
+	case <-expired:
+		log.Printf("not downloaded within %s, no longer sharing", conf.TTL)
+	case <-ctx.Done():
+	}
+
+	// let the download that completed the share finish cleanly
+	stop()
+	<-done
+	stopped(srv)
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+const syslogSupported = false
+
+func openSyslog(addr string) error {
+	return fmt.Errorf("--syslog is not supported on %s, use --log-file instead", runtime.GOOS)
+}
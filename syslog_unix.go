@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"log"
+	"log/syslog"
+	"strings"
+)
+
+const syslogSupported = true
+
+// openSyslog sends the log to the local syslog daemon, or to addr if it is
+// set, as [udp://|tcp://]host:port
+func openSyslog(addr string) error {
+	network := ""
+	if addr != "" {
+		network = "udp"
+		if scheme, rest, found := strings.Cut(addr, "://"); found {
+			network, addr = scheme, rest
+		}
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "serve")
+	if err != nil {
+		return err
+	}
+	log.SetOutput(w)
+	// syslog timestamps every message itself
+	log.SetFlags(0)
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Alexendoo/serve/server"
+)
+
+// listenURLs returns the URLs the listener can be reached at. local is set if
+// it's reachable from this machine through localhost, network if it's bound
+// to an address other machines can use
+func listenURLs(l server.Listener) (local, network string) {
+	url := func(host string) string {
+		return l.Scheme() + "://" + net.JoinHostPort(host, l.Port())
+	}
+	switch host := l.Host(); {
+	case host == "" || host == "0.0.0.0" || host == "::":
+		local = url("localhost")
+		if lan := lanAddress(); lan != "localhost" {
+			network = url(lan)
+		}
+	case host == "localhost" || isLoopback(host):
+		local = url(host)
+	default:
+		network = url(host)
+	}
+	return local, network
+}
+
+func isLoopback(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+// lanAddress returns an IPv4 address that other machines on the local
+// network can reach this one at, preferring private addresses. It falls back
+// to localhost
+func lanAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "localhost"
+	}
+	fallback := "localhost"
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP.To4()
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip.IsPrivate() {
+			return ip.String()
+		}
+		if fallback == "localhost" {
+			fallback = ip.String()
+		}
+	}
+	return fallback
+}
+
+// clipboardTimeout bounds how long copying to the clipboard may take, some
+// tools wait for a display that isn't there
+const clipboardTimeout = 5 * time.Second
+
+// clipboardCommand returns the command that copies its stdin to the
+// clipboard on goos, or nil if there is none. getenv and lookPath are
+// os.Getenv and exec.LookPath
+func clipboardCommand(goos string, getenv func(string) string, lookPath func(string) (string, error)) []string {
+	candidates := [][]string{}
+	switch goos {
+	case "darwin":
+		candidates = append(candidates, []string{"pbcopy"})
+	case "windows":
+		candidates = append(candidates, []string{"clip.exe"})
+	default:
+		if getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append(candidates, []string{"wl-copy"})
+		}
+		if getenv("DISPLAY") != "" {
+			candidates = append(candidates,
+				[]string{"xclip", "-selection", "clipboard"},
+				[]string{"xsel", "--clipboard", "--input"},
+			)
+		}
+		// WSL can reach the Windows clipboard
+		candidates = append(candidates, []string{"clip.exe"})
+	}
+	for _, command := range candidates {
+		if _, err := lookPath(command[0]); err == nil {
+			return command
+		}
+	}
+	return nil
+}
+
+// copyToClipboard puts text on the clipboard in the background, logging if
+// it can't
+func copyToClipboard(text string) {
+	command := clipboardCommand(runtime.GOOS, os.Getenv, exec.LookPath)
+	if command == nil {
+		log.Println("--copy: no clipboard tool found, install wl-copy, xclip or xsel")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), clipboardTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("--copy: %s: %s %s", command[0], err, strings.TrimSpace(stderr.String()))
+			return
+		}
+		log.Printf("copied %s to the clipboard", text)
+	}()
+}
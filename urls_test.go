@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestClipboardCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		goos      string
+		env       map[string]string
+		installed []string
+		want      string
+	}{
+		{"macOS", "darwin", nil, []string{"pbcopy"}, "pbcopy"},
+		{"Windows", "windows", nil, []string{"clip.exe"}, "clip.exe"},
+		{"Wayland", "linux", map[string]string{"WAYLAND_DISPLAY": "wayland-0", "DISPLAY": ":0"}, []string{"wl-copy", "xclip"}, "wl-copy"},
+		{"XWayland without wl-copy", "linux", map[string]string{"WAYLAND_DISPLAY": "wayland-0", "DISPLAY": ":0"}, []string{"xclip"}, "xclip -selection clipboard"},
+		{"X11", "linux", map[string]string{"DISPLAY": ":0"}, []string{"xclip", "xsel"}, "xclip -selection clipboard"},
+		{"X11 with xsel", "freebsd", map[string]string{"DISPLAY": ":0"}, []string{"xsel"}, "xsel --clipboard --input"},
+		// wl-copy can't be used without a Wayland session to copy to
+		{"no display", "linux", nil, []string{"wl-copy", "xclip"}, ""},
+		{"WSL", "linux", nil, []string{"clip.exe"}, "clip.exe"},
+		{"nothing installed", "linux", map[string]string{"DISPLAY": ":0"}, nil, ""},
+		{"macOS without pbcopy", "darwin", nil, []string{"xclip"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(name string) string { return tt.env[name] }
+			lookPath := func(file string) (string, error) {
+				if slices.Contains(tt.installed, file) {
+					return "/usr/bin/" + file, nil
+				}
+				return "", errors.New("not found")
+			}
+			if got := strings.Join(clipboardCommand(tt.goos, getenv, lookPath), " "); got != tt.want {
+				t.Errorf("clipboardCommand = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
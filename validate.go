@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Alexendoo/serve/server"
+)
+
+// validate checks the configuration after it has been merged from the
+// environment, config file and flags, so that typos fail at startup rather
+// than as confusing behaviour later. The server's own settings are checked by
+// server.Config.Validate, this adds the ones only the command uses
+func (c *config) validate() (warnings []string, err error) {
+	warnings, err = c.Config.Validate()
+	errs := []error{err}
+
+	// the server only checks how the host is spelled. It's looked up here,
+	// once the rest is valid, rather than whenever the config is reloaded
+	if c.Host != "" && net.ParseIP(c.Host) == nil && err == nil {
+		if _, lookupErr := net.LookupHost(c.Host); lookupErr != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for flag --host: not an IP address or a host that resolves", c.Host))
+		}
+	}
+	if c.ExpvarPort != "" && !server.ValidPort(c.ExpvarPort) {
+		errs = append(errs, fmt.Errorf("invalid value %q for flag --expvar: must be a number from 1 to 65535", c.ExpvarPort))
+	}
+	if c.ExpvarPort != "" && c.ExpvarPort == c.Port {
+		errs = append(errs, errors.New("--expvar can't be used with --port with the same port"))
+	}
+	useSyslog := c.Syslog || c.SyslogAddr != ""
+	if c.Daemon && c.LogFile == "" && !useSyslog {
+		errs = append(errs, errors.New("--daemon needs --log-file or --syslog, the log can't go to the terminal once detached"))
+	}
+	if useSyslog && !syslogSupported {
+		errs = append(errs, fmt.Errorf("--syslog is not supported on %s, use --log-file instead", runtime.GOOS))
+	}
+	if c.WatchConfig && c.ConfigFile == "" {
+		warnings = append(warnings, "--watch-config found no config file to watch, pass --config or add a serve.toml")
+	}
+	if c.LAN && c.Host == "localhost" && len(c.Listen) == 0 {
+		warnings = append(warnings, "--lan found no network address, serving on localhost only")
+	}
+	// a shared link is random, and is meant for other machines. A mount's
+	// auth doesn't make up for TLS, its password is sent with every request
+	if c.Share == "" {
+		exposed := "anyone who can connect can read the files served"
+		if slices.ContainsFunc(c.Mounts, func(m server.Mount) bool { return m.Auth != "" }) {
+			exposed = "anyone who can connect can read the files outside mounts with auth, and the mount passwords are sent unencrypted"
+		}
+		for _, l := range c.Listeners() {
+			if host := l.Host(); !l.TLS && host != "localhost" && !isLoopback(host) {
+				warnings = append(warnings, fmt.Sprintf("%s is reachable from the network without TLS, %s", l.Addr, exposed))
+			}
+		}
+	}
+	for _, conflict := range commandConflicts {
+		if conflict.applies(c) {
+			errs = append(errs, fmt.Errorf("--%s %s", conflict.flag, conflict.reason))
+		}
+	}
+	if c.Share != "" && !c.Zip {
+		if stat, err := os.Stat(c.Share); err == nil && stat.IsDir() {
+			errs = append(errs, fmt.Errorf("can't share %q: it's a directory, pass --zip to share it as an archive", c.Share))
+		}
+	}
+	return warnings, errors.Join(errs...)
+}
+
+// commandConflicts are the command's settings that can't be used in the mode
+// it's running in, alongside the server's own conflicts
+var commandConflicts = []struct {
+	flag    string
+	reason  string
+	applies func(c *config) bool
+}{
+	{"exec", "can't be used with --daemon", func(c *config) bool { return c.Exec != "" && c.Daemon }},
+	{"lan", "can't be used with --host or --listen", func(c *config) bool {
+		return c.LAN && (len(c.Listen) > 0 || c.Host != lanAddress())
+	}},
+	{"ttl", "only applies to serve share", func(c *config) bool { return c.TTL > 0 && c.Share == "" }},
+	{"zip", "only applies to serve share", func(c *config) bool { return c.Zip && c.Share == "" }},
+	{"watch-config", "doesn't apply to serve share", func(c *config) bool { return c.WatchConfig && c.Share != "" }},
+	{"qr", "only applies to serve share", func(c *config) bool { return c.QR && c.Share == "" }},
+	{"syslog", "can't be used with --log-file", func(c *config) bool {
+		return (c.Syslog || c.SyslogAddr != "") && c.LogFile != ""
+	}},
+}
+
+// printConfig writes the effective configuration for --check as a config
+// file, so it can be saved and used with --config
+func printConfig(w io.Writer, conf *config, flags *flag.FlagSet) {
+	quoted := make([]string, len(conf.Dirs))
+	for i, dir := range conf.Dirs {
+		quoted[i] = strconv.Quote(dir)
+	}
+	fmt.Fprintf(w, "dirs = [%s]\n", strings.Join(quoted, ", "))
+
+	for _, opt := range options {
+		if opt.long == "check" || opt.long == "config" || opt.long == "version" {
+			continue
+		}
+		f := flags.Lookup(opt.long)
+		value := f.Value.String()
+		switch opt.long {
+		case "mount":
+			value = tomlList(conf.Mounts)
+		case "listen":
+			value = tomlList(conf.Listen)
+		case "error-page":
+			value = tomlList(conf.ErrorPages)
+		case "proxy":
+			value = tomlList(conf.Proxies)
+		case "rewrite":
+			value = tomlList(conf.Rewrites)
+		case "redirect":
+			value = tomlList(conf.Redirects)
+		}
+		switch {
+		case isBoolFlag(f) && value == "":
+			// --trust-proxy without any proxies
+			value = "false"
+		case isBoolFlag(f) && value != "true" && value != "false",
+			opt.arg != "" && opt.arg != "number" && opt.long != "mount" && opt.long != "listen" && opt.long != "error-page" && opt.long != "proxy":
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(w, "%s = %s\n", opt.long, value)
+	}
+}
+
+// tomlList formats the values of a repeatable flag as a TOML array
+func tomlList[T fmt.Stringer](items []T) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = strconv.Quote(item.String())
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLooksUpHost(t *testing.T) {
+	conf := newConfig()
+	conf.Dirs = []string{t.TempDir()}
+	conf.Host = "no-such-host.invalid"
+	if _, err := conf.validate(); err == nil || !strings.Contains(err.Error(), "host that resolves") {
+		t.Errorf("validate with an unresolvable --host = %v, want it refused", err)
+	}
+
+	conf.Host = "127.0.0.1"
+	if _, err := conf.validate(); err != nil {
+		t.Errorf("validate with --host 127.0.0.1 = %v", err)
+	}
+}
+
+func TestValidateWarnsWithoutTLS(t *testing.T) {
+	conf := newConfig()
+	conf.Dirs = []string{t.TempDir()}
+	conf.Host = "0.0.0.0"
+	warnings, err := conf.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "without TLS") || strings.Contains(warnings[0], "password") {
+		t.Errorf("warnings = %q, want one about TLS", warnings)
+	}
+
+	// basic auth sends the password in the clear, so a mount with it is warned
+	// about rather than taken to be protected
+	if err := conf.Mounts.Set("/private=" + t.TempDir() + ",auth=user:secret"); err != nil {
+		t.Fatal(err)
+	}
+	warnings, err = conf.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "without TLS") || !strings.Contains(warnings[0], "passwords are sent unencrypted") {
+		t.Errorf("warnings = %q, want one about TLS mentioning the mount passwords", warnings)
+	}
+
+	conf.Host = "127.0.0.1"
+	if warnings, _ := conf.validate(); len(warnings) != 0 {
+		t.Errorf("warnings = %q on localhost", warnings)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+
+	"github.com/Alexendoo/serve/server"
+)
+
+// errVersion is returned by parseFlags when --version is passed
+var errVersion = errors.New("version requested")
+
+// versionInfo describes the build, as printed by --version
+func versionInfo() string {
+	built := server.Date
+	if built == "" {
+		built = "unknown"
+	}
+	return fmt.Sprintf(
+		"serve %s\ncommit: %s\nbuilt: %s\ngo: %s\nplatform: %s/%s\n",
+		server.Version, server.ShortCommit(), built, runtime.Version(), runtime.GOOS, runtime.GOARCH,
+	)
+}